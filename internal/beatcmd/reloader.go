@@ -84,6 +84,14 @@ type Runner interface {
 	Run(context.Context) error
 }
 
+// ConfigUpdater may be implemented by a Runner which can apply some
+// configuration changes in place, without being replaced.
+type ConfigUpdater interface {
+	// UpdateConfig applies the full, raw, configuration to the running
+	// Runner, returning false if it cannot be applied in place.
+	UpdateConfig(*config.C) (bool, error)
+}
+
 // NewReloader returns a new Reloader which creates Runners using the provided
 // beat.Info and NewRunnerFunc.
 func NewReloader(info beat.Info, registry *reload.Registry, newRunner NewRunnerFunc, meterProvider metric.MeterProvider, metricGatherer *apmotel.Gatherer, tracerProvider trace.TracerProvider, beatMonitoring beatmonitoring.Monitoring, reporter status.StatusReporter) (*Reloader, error) {
@@ -267,6 +275,20 @@ func (r *Reloader) reload(inputConfig, outputConfig, apmTracingConfig *config.C)
 	if err != nil {
 		return err
 	}
+
+	// Update the existing runner in place if possible, falling back to
+	// replacing it. If the configuration is invalid, creating the new
+	// runner below will report the error.
+	if updater, ok := r.runner.(ConfigUpdater); ok {
+		updated, err := updater.UpdateConfig(mergedConfig)
+		if err != nil {
+			r.logger.With(logp.Error(err)).Warn("failed to update runner config, replacing runner")
+		} else if updated {
+			r.logger.Info("updated runner config without replacing runner")
+			return nil
+		}
+	}
+
 	// Create a new runner. We separate creation from starting to
 	// allow the runner to perform initialisations that must run
 	// synchronously.
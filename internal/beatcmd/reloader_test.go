@@ -194,6 +194,71 @@ func TestReloaderNewRunnerParams(t *testing.T) {
 	assert.Equal(t, config.MustNewConfigFrom(`{"revision": 1, "input": 123, "output.console.enabled": true, "instrumentation.enabled":true, "instrumentation.environment":"test"}`), args.Config)
 }
 
+func TestReloaderConfigUpdater(t *testing.T) {
+	registry := reload.NewRegistry()
+
+	created := make(chan struct{}, 2)
+	updates := make(chan *config.C, 1)
+	reloader, err := NewReloader(beat.Info{
+		Logger: logptest.NewTestingLogger(t, ""),
+	}, registry, func(args RunnerParams) (Runner, error) {
+		created <- struct{}{}
+		return updatableRunner{
+			runnerFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			update: func(cfg *config.C) (bool, error) {
+				if inPlace, _ := cfg.Bool("in_place", -1); !inPlace {
+					return false, nil
+				}
+				updates <- cfg
+				return true, nil
+			},
+		}, nil
+	}, nil, nil, nil, beatmonitoring.NewMonitoring(), nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error { return reloader.Run(ctx) })
+	defer func() { assert.NoError(t, g.Wait()) }()
+	defer cancel()
+
+	require.NoError(t, registry.GetInputList().Reload([]*reload.ConfigWithMeta{{
+		Config: config.MustNewConfigFrom(`{"revision": 1}`),
+	}}))
+	require.NoError(t, registry.GetReloadableOutput().Reload(&reload.ConfigWithMeta{
+		Config: config.MustNewConfigFrom(`{"console.enabled": true}`),
+	}))
+	expectEvent(t, created, "runner should have been created")
+
+	// The runner applies the configuration in place.
+	require.NoError(t, registry.GetInputList().Reload([]*reload.ConfigWithMeta{{
+		Config: config.MustNewConfigFrom(`{"revision": 2, "in_place": true}`),
+	}}))
+	cfg := <-updates
+	revision, err := cfg.Int("revision", -1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), revision)
+	expectNoEvent(t, created, "runner should not have been replaced")
+
+	// The runner cannot apply the configuration in place, so is replaced.
+	require.NoError(t, registry.GetInputList().Reload([]*reload.ConfigWithMeta{{
+		Config: config.MustNewConfigFrom(`{"revision": 3}`),
+	}}))
+	expectEvent(t, created, "runner should have been replaced")
+}
+
+type updatableRunner struct {
+	runnerFunc
+	update func(*config.C) (bool, error)
+}
+
+func (r updatableRunner) UpdateConfig(cfg *config.C) (bool, error) {
+	return r.update(cfg)
+}
+
 func expectNoEvent(t testing.TB, ch <-chan struct{}, message string) {
 	select {
 	case <-ch:
@@ -18,9 +18,14 @@
 package beatcmd
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
 
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/metric/noop"
+	"google.golang.org/protobuf/encoding/protojson"
 
 	"github.com/elastic/elastic-agent-libs/paths"
 
@@ -29,6 +34,8 @@ import (
 	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/testing"
 
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/internal/beater"
 	beaterconfig "github.com/elastic/apm-server/internal/beater/config"
 )
 
@@ -39,6 +46,7 @@ func genTestCmd(beatParams BeatParams) *cobra.Command {
 	}
 	exportCmd.AddCommand(testConfigCommand)
 	exportCmd.AddCommand(newTestOutputCommand(beatParams))
+	exportCmd.AddCommand(newTestProcessorsCommand())
 	return exportCmd
 }
 
@@ -91,3 +99,69 @@ func newTestOutputCommand(beatParams BeatParams) *cobra.Command {
 		},
 	}
 }
+
+func newTestProcessorsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "processors",
+		Short: "Test event processing against events read from stdin",
+		Long: "Reads events from stdin as newline-delimited protobuf JSON, applies the " +
+			"configured GeoIP and user agent enrichment, event processors, rules, and " +
+			"redaction, and writes the remaining events to stdout.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, _, _, err := LoadConfig()
+			if err != nil {
+				return err
+			}
+			apmServerConfig, err := beaterconfig.NewConfig(cfg.APMServer, nil, logp.NewLogger(""))
+			if err != nil {
+				return err
+			}
+			chained, _, err := beater.NewEventProcessingChain(apmServerConfig, noop.NewMeterProvider(), logp.NewLogger(""))
+			if err != nil {
+				return err
+			}
+			in, out, err := processEvents(cmd.Context(), chained, cmd.InOrStdin(), cmd.OutOrStdout())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "%d events read, %d events dropped\n", in, in-out)
+			return nil
+		},
+	}
+}
+
+// processEvents reads newline-delimited protobuf JSON events from r, processes
+// them with processor, and writes the remaining events to w in the same format.
+// processEvents returns the number of events read and written.
+func processEvents(ctx context.Context, processor modelpb.BatchProcessor, r io.Reader, w io.Writer) (int, int, error) {
+	var batch modelpb.Batch
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 10*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		event := &modelpb.APMEvent{}
+		if err := protojson.Unmarshal(scanner.Bytes(), event); err != nil {
+			return 0, 0, fmt.Errorf("error decoding event on line %d: %w", line, err)
+		}
+		batch = append(batch, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	n := len(batch)
+	if err := processor.ProcessBatch(ctx, &batch); err != nil {
+		return 0, 0, err
+	}
+	for _, event := range batch {
+		data, err := protojson.Marshal(event)
+		if err != nil {
+			return 0, 0, err
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", data); err != nil {
+			return 0, 0, err
+		}
+	}
+	return n, len(batch), nil
+}
@@ -0,0 +1,60 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beatcmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestProcessorsCommand(t *testing.T) {
+	initCfgfile(t, `
+apm-server:
+  event_processors:
+    - when.url_path: "^/health"
+      drop_event: true
+    - set_labels:
+        team: payments
+  event_rules:
+    - name: drop_admin
+      when: "event.url.path.startsWith('/admin')"
+      drop_event: true
+`)
+	var stdout, stderr bytes.Buffer
+	cmd := newTestProcessorsCommand()
+	cmd.SetArgs([]string{})
+	cmd.SetIn(strings.NewReader(`{"url": {"path": "/health"}}
+
+{"url": {"path": "/users"}}
+{"url": {"path": "/admin/users"}}
+`))
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	require.NoError(t, cmd.ExecuteContext(context.Background()))
+	assert.JSONEq(t, `{"url": {"path": "/users"}, "labels": {"team": {"value": "payments"}}}`, stdout.String())
+	assert.Equal(t, "3 events read, 2 events dropped\n", stderr.String())
+
+	cmd.SetIn(strings.NewReader(`{"url": "/health"}`))
+	err := cmd.ExecuteContext(context.Background())
+	assert.ErrorContains(t, err, "error decoding event on line 1")
+}
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
@@ -112,6 +113,7 @@ func writeStreamResult(c *request.Context, streamResult elasticapm.Result, strea
 	id := request.IDResponseValidAccepted
 	jsonResult := jsonResult{Accepted: streamResult.Accepted}
 	var errorMessages []string
+	var retryAfter time.Duration
 
 	if n := len(streamResult.Errors); n > 0 {
 		if streamErr != nil {
@@ -126,6 +128,9 @@ func writeStreamResult(c *request.Context, streamResult elasticapm.Result, strea
 		errStatusCode := errStatusCode(errID)
 		jsonResult.Errors = append(jsonResult.Errors, jsonErr)
 		errorMessages = append(errorMessages, jsonErr.Message)
+		if d, ok := ratelimit.RetryAfter(err); ok {
+			retryAfter = max(retryAfter, d)
+		}
 		if errStatusCode > statusCode {
 			statusCode = errStatusCode
 			id = errID
@@ -142,6 +147,9 @@ func writeStreamResult(c *request.Context, streamResult elasticapm.Result, strea
 	if len(errorMessages) > 0 {
 		err = errors.New(strings.Join(errorMessages, ", "))
 	}
+	if id == request.IDResponseErrorsRateLimit && retryAfter > 0 {
+		c.ResponseWriter.Header().Set(headers.RetryAfter, ratelimit.FormatRetryAfter(retryAfter))
+	}
 	writeResult(c, id, statusCode, &jsonResult, err)
 }
 
@@ -29,6 +29,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -43,6 +44,7 @@ import (
 	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/beater/headers"
 	"github.com/elastic/apm-server/internal/beater/monitoringtest"
+	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/beater/request"
 	"github.com/elastic/apm-server/internal/model/modelprocessor"
 	"github.com/elastic/apm-server/internal/publish"
@@ -222,6 +224,26 @@ func TestIntakeHandlerContentType(t *testing.T) {
 	}
 }
 
+func TestIntakeHandlerQuotaExceeded(t *testing.T) {
+	tc := testcaseIntakeHandler{
+		path: "errors.ndjson",
+		batchProcessor: modelpb.ProcessBatchFunc(func(context.Context, *modelpb.Batch) error {
+			return &ratelimit.QuotaExceededError{
+				Kind:       ratelimit.QuotaAPIKeyID,
+				Key:        "key_id",
+				RetryAfter: 1500 * time.Millisecond,
+			}
+		}),
+	}
+	tc.setup(t)
+	h := Handler(metricnoop.NewMeterProvider(), tracenoop.NewTracerProvider(), tc.processor, emptyRequestMetadata, tc.batchProcessor)
+	h(tc.c)
+
+	assert.Equal(t, request.IDResponseErrorsRateLimit, tc.c.Result.ID)
+	assert.Equal(t, http.StatusTooManyRequests, tc.w.Code)
+	assert.Equal(t, "2", tc.w.Header().Get(headers.RetryAfter))
+}
+
 type testcaseIntakeHandler struct {
 	c              *request.Context
 	w              *httptest.ResponseRecorder
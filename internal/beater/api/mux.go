@@ -18,6 +18,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	httppprof "net/http/pprof"
@@ -39,14 +40,17 @@ import (
 	"github.com/elastic/apm-server/internal/beater/api/config/agent"
 	"github.com/elastic/apm-server/internal/beater/api/intake"
 	"github.com/elastic/apm-server/internal/beater/api/root"
+	"github.com/elastic/apm-server/internal/beater/audit"
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/loadshed"
 	"github.com/elastic/apm-server/internal/beater/middleware"
 	"github.com/elastic/apm-server/internal/beater/otlp"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/beater/request"
 	"github.com/elastic/apm-server/internal/logs"
 	srvmodelprocessor "github.com/elastic/apm-server/internal/model/modelprocessor"
+	"github.com/elastic/apm-server/internal/netutil"
 	"github.com/elastic/apm-server/internal/sourcemap"
 	"github.com/elastic/apm-server/internal/version"
 )
@@ -85,9 +89,12 @@ func NewMux(
 	beaterConfig *config.Config,
 	batchProcessor modelpb.BatchProcessor,
 	authenticator *auth.Authenticator,
+	auditor *audit.Auditor,
+	loadShedding *loadshed.Controller,
 	fetcher agentcfg.Fetcher,
 	ratelimitStore *ratelimit.Store,
 	sourcemapFetcher sourcemap.Fetcher,
+	adminHandlers map[string]http.Handler,
 	publishReady func() bool,
 	semaphore input.Semaphore,
 	meterProvider metric.MeterProvider,
@@ -95,13 +102,25 @@ func NewMux(
 	logger *logp.Logger,
 	statsRegistry *monitoring.Registry,
 ) (*http.ServeMux, error) {
-	pool := request.NewContextPool()
+	trustedProxies, err := netutil.ParseTrustedProxies(beaterConfig.TrustedProxies, beaterConfig.TrustedProxiesHeader)
+	if err != nil {
+		return nil, err
+	}
+	pool := request.NewContextPool(trustedProxies)
 	logger = logger.Named(logs.Handler)
 	router := http.NewServeMux()
 
+	ipFilters, err := newIPFilters(beaterConfig.IPFilter)
+	if err != nil {
+		return nil, err
+	}
+
 	builder := routeBuilder{
 		cfg:              beaterConfig,
+		ipFilters:        ipFilters,
 		authenticator:    authenticator,
+		auditor:          auditor,
+		loadShedding:     loadShedding,
 		batchProcessor:   batchProcessor,
 		ratelimitStore:   ratelimitStore,
 		sourcemapFetcher: sourcemapFetcher,
@@ -132,9 +151,9 @@ func NewMux(
 		{IntakeRUMPath, rumIntakeHandler},
 		{IntakeRUMV3Path, rumIntakeHandler},
 		{IntakePath, builder.backendIntakeHandler("apm-server.server.", meterProvider, traceProvider)},
-		{OTLPTracesIntakePath, builder.otlpHandler(otlpHandlers.HandleTraces, "apm-server.otlp.http.traces.", meterProvider, traceProvider)},
-		{OTLPMetricsIntakePath, builder.otlpHandler(otlpHandlers.HandleMetrics, "apm-server.otlp.http.metrics.", meterProvider, traceProvider)},
-		{OTLPLogsIntakePath, builder.otlpHandler(otlpHandlers.HandleLogs, "apm-server.otlp.http.logs.", meterProvider, traceProvider)},
+		{OTLPTracesIntakePath, builder.otlpHandler(otlpHandlers.HandleTraces, loadshed.PriorityHigh, "apm-server.otlp.http.traces.", meterProvider, traceProvider)},
+		{OTLPMetricsIntakePath, builder.otlpHandler(otlpHandlers.HandleMetrics, loadshed.PriorityMedium, "apm-server.otlp.http.metrics.", meterProvider, traceProvider)},
+		{OTLPLogsIntakePath, builder.otlpHandler(otlpHandlers.HandleLogs, loadshed.PriorityMedium, "apm-server.otlp.http.logs.", meterProvider, traceProvider)},
 	}
 
 	for _, route := range routeMap {
@@ -145,6 +164,14 @@ func NewMux(
 		logger.Infof("Path %s added to request handler", route.path)
 		router.Handle(route.path, pool.HTTPHandler(h))
 	}
+	for path, handler := range adminHandlers {
+		h, err := builder.adminHandler(handler, meterProvider, traceProvider)()
+		if err != nil {
+			return nil, err
+		}
+		logger.Infof("Path %s added to request handler", path)
+		router.Handle(path, pool.HTTPHandler(h))
+	}
 	if beaterConfig.Expvar.Enabled {
 		path := beaterConfig.Expvar.URL
 		logger.Infof("Path %s added to request handler", path)
@@ -165,7 +192,10 @@ func NewMux(
 
 type routeBuilder struct {
 	cfg              *config.Config
+	ipFilters        ipFilters
 	authenticator    *auth.Authenticator
+	auditor          *audit.Auditor
+	loadShedding     *loadshed.Controller
 	batchProcessor   modelpb.BatchProcessor
 	ratelimitStore   *ratelimit.Store
 	sourcemapFetcher sourcemap.Fetcher
@@ -177,16 +207,41 @@ type routeBuilder struct {
 func (r *routeBuilder) backendIntakeHandler(metricsPrefix string, mp metric.MeterProvider, tp trace.TracerProvider) func() (request.Handler, error) {
 	return func() (request.Handler, error) {
 		h := intake.Handler(mp, tp, r.intakeProcessor, backendRequestMetadataFunc(r.cfg), r.batchProcessor)
-		return middleware.Wrap(h, backendMiddleware(r.cfg, r.authenticator, r.ratelimitStore, metricsPrefix, mp, tp, r.logger)...)
+		mw := backendMiddleware(r.cfg, r.authenticator, r.auditor, r.ratelimitStore, r.ipFilters.backend, r.loadShedding, loadshed.PriorityHigh, metricsPrefix, mp, tp, r.logger)
+		return middleware.Wrap(h, mw...)
 	}
 }
 
-func (r *routeBuilder) otlpHandler(handler http.HandlerFunc, metricsPrefix string, mp metric.MeterProvider, tp trace.TracerProvider) func() (request.Handler, error) {
+func (r *routeBuilder) otlpHandler(handler http.HandlerFunc, priority loadshed.Priority, metricsPrefix string, mp metric.MeterProvider, tp trace.TracerProvider) func() (request.Handler, error) {
 	return func() (request.Handler, error) {
 		h := func(c *request.Context) {
 			handler(c.ResponseWriter, c.Request)
 		}
-		return middleware.Wrap(h, backendMiddleware(r.cfg, r.authenticator, r.ratelimitStore, metricsPrefix, mp, tp, r.logger)...)
+		mw := backendMiddleware(r.cfg, r.authenticator, r.auditor, r.ratelimitStore, r.ipFilters.otlp, r.loadShedding, priority, metricsPrefix, mp, tp, r.logger)
+		return middleware.Wrap(h, mw...)
+	}
+}
+
+// adminHandler returns a request.Handler for an administrative API, which
+// requires clients to be authenticated and authorized for auth.ActionAdmin.
+func (r *routeBuilder) adminHandler(handler http.Handler, mp metric.MeterProvider, tp trace.TracerProvider) func() (request.Handler, error) {
+	return func() (request.Handler, error) {
+		h := func(c *request.Context) {
+			if err := auth.Authorize(c.Request.Context(), auth.ActionAdmin, auth.Resource{}); err != nil {
+				if errors.Is(err, auth.ErrUnauthorized) {
+					id := request.IDResponseErrorsForbidden
+					status := request.MapResultIDToStatus[id]
+					c.Result.Set(id, status.Code, err.Error(), nil, nil)
+				} else {
+					c.Result.SetDefault(request.IDResponseErrorsServiceUnavailable)
+					c.Result.Err = err
+				}
+				c.WriteResult()
+				return
+			}
+			handler.ServeHTTP(c.ResponseWriter, c.Request)
+		}
+		return middleware.Wrap(h, adminMiddleware(r.cfg, r.authenticator, r.auditor, r.ipFilters.backend, mp, tp, r.logger)...)
 	}
 }
 
@@ -221,7 +276,8 @@ func (r *routeBuilder) rumIntakeHandler(mp metric.MeterProvider, tp trace.Tracer
 		}
 		batchProcessors = append(batchProcessors, r.batchProcessor) // r.batchProcessor always goes last
 		h := intake.Handler(mp, tp, r.intakeProcessor, rumRequestMetadataFunc(r.cfg), batchProcessors)
-		return middleware.Wrap(h, rumMiddleware(r.cfg, r.authenticator, r.ratelimitStore, "apm-server.server.", mp, tp, r.logger)...)
+		mw := rumMiddleware(r.cfg, r.authenticator, r.auditor, r.ratelimitStore, r.ipFilters.rum, r.loadShedding, loadshed.PriorityLow, "apm-server.server.", mp, tp, r.logger)
+		return middleware.Wrap(h, mw...)
 	}
 }
 
@@ -231,39 +287,73 @@ func (r *routeBuilder) rootHandler(publishReady func() bool, mp metric.MeterProv
 			Version:      version.VersionWithQualifier(),
 			PublishReady: publishReady,
 		})
-		return middleware.Wrap(h, rootMiddleware(r.cfg, r.authenticator, mp, tp, r.logger)...)
+		return middleware.Wrap(h, rootMiddleware(r.cfg, r.authenticator, r.auditor, r.ipFilters.root, mp, tp, r.logger)...)
 	}
 }
 
 func (r *routeBuilder) backendAgentConfigHandler(f agentcfg.Fetcher, mp metric.MeterProvider, tp trace.TracerProvider) func() (request.Handler, error) {
 	return func() (request.Handler, error) {
-		return agentConfigHandler(r.cfg, r.authenticator, r.ratelimitStore, backendMiddleware, f, mp, tp, r.logger)
+		return agentConfigHandler(r.cfg, r.authenticator, r.auditor, r.ratelimitStore, r.ipFilters.agentConfig, backendMiddleware, f, mp, tp, r.logger)
 	}
 }
 
 func (r *routeBuilder) rumAgentConfigHandler(f agentcfg.Fetcher, mp metric.MeterProvider, tp trace.TracerProvider) func() (request.Handler, error) {
 	return func() (request.Handler, error) {
-		return agentConfigHandler(r.cfg, r.authenticator, r.ratelimitStore, rumMiddleware, f, mp, tp, r.logger)
+		return agentConfigHandler(r.cfg, r.authenticator, r.auditor, r.ratelimitStore, r.ipFilters.rum, rumMiddleware, f, mp, tp, r.logger)
 	}
 }
 
-type middlewareFunc func(*config.Config, *auth.Authenticator, *ratelimit.Store, string, metric.MeterProvider, trace.TracerProvider, *logp.Logger) []middleware.Middleware
+type middlewareFunc func(*config.Config, *auth.Authenticator, *audit.Auditor, *ratelimit.Store, *netutil.IPFilter, *loadshed.Controller, loadshed.Priority, string, metric.MeterProvider, trace.TracerProvider, *logp.Logger) []middleware.Middleware
 
 func agentConfigHandler(
 	cfg *config.Config,
 	authenticator *auth.Authenticator,
+	auditor *audit.Auditor,
 	ratelimitStore *ratelimit.Store,
+	ipFilter *netutil.IPFilter,
 	middlewareFunc middlewareFunc,
 	f agentcfg.Fetcher,
 	mp metric.MeterProvider,
 	tp trace.TracerProvider,
 	logger *logp.Logger,
 ) (request.Handler, error) {
-	mw := middlewareFunc(cfg, authenticator, ratelimitStore, "apm-server.acm.", mp, tp, logger)
+	// Agent configuration requests are not shed.
+	mw := middlewareFunc(cfg, authenticator, auditor, ratelimitStore, ipFilter, nil, 0, "apm-server.acm.", mp, tp, logger)
 	h := agent.NewHandler(f, cfg.AgentConfig.Cache.Expiration, cfg.DefaultServiceEnvironment, cfg.AgentAuth.Anonymous.AllowAgent)
 	return middleware.Wrap(h, mw...)
 }
 
+// ipFilters holds the IP filters for each group of routes.
+// A nil filter allows all client IPs.
+type ipFilters struct {
+	backend     *netutil.IPFilter
+	rum         *netutil.IPFilter
+	otlp        *netutil.IPFilter
+	agentConfig *netutil.IPFilter
+	root        *netutil.IPFilter
+}
+
+func newIPFilters(cfg config.IPFilterConfig) (ipFilters, error) {
+	var filters ipFilters
+	for _, f := range []struct {
+		rules  config.IPFilterRules
+		filter **netutil.IPFilter
+	}{
+		{cfg.Backend, &filters.backend},
+		{cfg.RUM, &filters.rum},
+		{cfg.OTLP, &filters.otlp},
+		{cfg.AgentConfig, &filters.agentConfig},
+		{cfg.Root, &filters.root},
+	} {
+		filter, err := f.rules.Filter()
+		if err != nil {
+			return ipFilters{}, err
+		}
+		*f.filter = filter
+	}
+	return filters, nil
+}
+
 func apmMiddleware(mp metric.MeterProvider, tp trace.TracerProvider, metricsPrefix string, logger *logp.Logger) []middleware.Middleware {
 	return []middleware.Middleware{
 		middleware.TracingMiddleware(tp),
@@ -273,33 +363,52 @@ func apmMiddleware(mp metric.MeterProvider, tp trace.TracerProvider, metricsPref
 	}
 }
 
-func backendMiddleware(cfg *config.Config, authenticator *auth.Authenticator, ratelimitStore *ratelimit.Store, metricsPrefix string, mp metric.MeterProvider, tp trace.TracerProvider, logger *logp.Logger) []middleware.Middleware {
+// backendMiddleware returns the middleware for backend intake routes.
+//
+// Load shedding is applied before authentication, so that overloaded servers
+// reject requests without performing API Key lookups.
+func backendMiddleware(cfg *config.Config, authenticator *auth.Authenticator, auditor *audit.Auditor, ratelimitStore *ratelimit.Store, ipFilter *netutil.IPFilter, loadShedding *loadshed.Controller, priority loadshed.Priority, metricsPrefix string, mp metric.MeterProvider, tp trace.TracerProvider, logger *logp.Logger) []middleware.Middleware {
 	backendMiddleware := append(apmMiddleware(mp, tp, metricsPrefix, logger),
+		middleware.IPFilterMiddleware(ipFilter),
 		middleware.ResponseHeadersMiddleware(cfg.ResponseHeaders),
-		middleware.AuthMiddleware(authenticator, true),
+		middleware.LoadSheddingMiddleware(loadShedding, priority),
+		middleware.AuthMiddleware(authenticator, true, auditor),
 		middleware.AnonymousRateLimitMiddleware(ratelimitStore),
 	)
 	return backendMiddleware
 }
 
-func rumMiddleware(cfg *config.Config, authenticator *auth.Authenticator, ratelimitStore *ratelimit.Store, metricsPrefix string, mp metric.MeterProvider, tp trace.TracerProvider, logger *logp.Logger) []middleware.Middleware {
+// rumMiddleware returns the middleware for RUM routes. As for backendMiddleware,
+// load shedding is applied before authentication.
+func rumMiddleware(cfg *config.Config, authenticator *auth.Authenticator, auditor *audit.Auditor, ratelimitStore *ratelimit.Store, ipFilter *netutil.IPFilter, loadShedding *loadshed.Controller, priority loadshed.Priority, metricsPrefix string, mp metric.MeterProvider, tp trace.TracerProvider, logger *logp.Logger) []middleware.Middleware {
 	msg := "RUM endpoint is disabled. " +
 		"Configure the `apm-server.rum` section in apm-server.yml to enable ingestion of RUM events. " +
 		"If you are not using the RUM agent, you can safely ignore this error."
 	rumMiddleware := append(apmMiddleware(mp, tp, metricsPrefix, logger),
+		middleware.IPFilterMiddleware(ipFilter),
 		middleware.ResponseHeadersMiddleware(cfg.ResponseHeaders),
 		middleware.ResponseHeadersMiddleware(cfg.RumConfig.ResponseHeaders),
 		middleware.CORSMiddleware(cfg.RumConfig.AllowOrigins, cfg.RumConfig.AllowHeaders),
-		middleware.AuthMiddleware(authenticator, true),
+		middleware.LoadSheddingMiddleware(loadShedding, priority),
+		middleware.AuthMiddleware(authenticator, true, auditor),
 		middleware.AnonymousRateLimitMiddleware(ratelimitStore),
 	)
 	return append(rumMiddleware, middleware.KillSwitchMiddleware(cfg.RumConfig.Enabled, msg))
 }
 
-func rootMiddleware(cfg *config.Config, authenticator *auth.Authenticator, mp metric.MeterProvider, tp trace.TracerProvider, logger *logp.Logger) []middleware.Middleware {
+func rootMiddleware(cfg *config.Config, authenticator *auth.Authenticator, auditor *audit.Auditor, ipFilter *netutil.IPFilter, mp metric.MeterProvider, tp trace.TracerProvider, logger *logp.Logger) []middleware.Middleware {
 	return append(apmMiddleware(mp, tp, "apm-server.root.", logger),
+		middleware.IPFilterMiddleware(ipFilter),
+		middleware.ResponseHeadersMiddleware(cfg.ResponseHeaders),
+		middleware.AuthMiddleware(authenticator, false, auditor),
+	)
+}
+
+func adminMiddleware(cfg *config.Config, authenticator *auth.Authenticator, auditor *audit.Auditor, ipFilter *netutil.IPFilter, mp metric.MeterProvider, tp trace.TracerProvider, logger *logp.Logger) []middleware.Middleware {
+	return append(apmMiddleware(mp, tp, "apm-server.admin.", logger),
+		middleware.IPFilterMiddleware(ipFilter),
 		middleware.ResponseHeadersMiddleware(cfg.ResponseHeaders),
-		middleware.AuthMiddleware(authenticator, false),
+		middleware.AuthMiddleware(authenticator, true, auditor),
 	)
 }
 
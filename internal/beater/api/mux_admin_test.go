@@ -0,0 +1,103 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestAdminHandler_AuthorizationMiddleware(t *testing.T) {
+	const path = "/admin/test"
+	cfg := config.DefaultConfig()
+	cfg.AgentAuth.SecretToken = "1234"
+	cfg.AgentAuth.Anonymous.Enabled = true
+	cfg.AgentAuth.SecretTokens = []config.SecretTokenAgentAuth{{
+		Name:         "ingest",
+		Value:        "5678",
+		AllowActions: []string{"event_ingest"},
+	}, {
+		Name:  "unrestricted",
+		Value: "abcd",
+	}, {
+		Name:         "admin",
+		Value:        "efgh",
+		AllowActions: []string{"admin"},
+	}}
+	_, mux, err := muxBuilder{
+		Logger: logptest.NewTestingLogger(t, ""),
+		AdminHandlers: map[string]http.Handler{
+			path: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("admin"))
+			}),
+		},
+	}.build(cfg)
+	require.NoError(t, err)
+
+	for name, test := range map[string]struct {
+		authorization string
+		expectCode    int
+	}{
+		"No auth":             {expectCode: http.StatusForbidden},
+		"Invalid auth":        {authorization: "Bearer 4321", expectCode: http.StatusUnauthorized},
+		"Unauthorized":        {authorization: "Bearer 5678", expectCode: http.StatusForbidden},
+		"Legacy secret token": {authorization: "Bearer 1234", expectCode: http.StatusForbidden},
+		"Unrestricted":        {authorization: "Bearer abcd", expectCode: http.StatusForbidden},
+		"Authorized":          {authorization: "Bearer efgh", expectCode: http.StatusOK},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			if test.authorization != "" {
+				req.Header.Set(headers.Authorization, test.authorization)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			assert.Equal(t, test.expectCode, rec.Code)
+			if test.expectCode == http.StatusOK {
+				assert.Equal(t, "admin", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminHandler_NoAuthConfigured(t *testing.T) {
+	const path = "/admin/test"
+	_, mux, err := muxBuilder{
+		Logger: logptest.NewTestingLogger(t, ""),
+		AdminHandlers: map[string]http.Handler{
+			path: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("admin"))
+			}),
+		},
+	}.build(config.DefaultConfig())
+	require.NoError(t, err)
+
+	// Admin APIs are never accessible without auth.
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
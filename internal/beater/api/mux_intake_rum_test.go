@@ -45,7 +45,7 @@ func TestOPTIONS(t *testing.T) {
 
 	cfg := cfgEnabledRUM()
 	cfg.RumConfig.AllowOrigins = []string{"*"}
-	authenticator, _ := auth.NewAuthenticator(cfg.AgentAuth, tracenoop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))
+	authenticator, _ := auth.NewAuthenticator(cfg.AgentAuth, tracenoop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
 
 	lastMiddleware := func(h request.Handler) (request.Handler, error) {
 		return func(c *request.Context) {
@@ -59,7 +59,7 @@ func TestOPTIONS(t *testing.T) {
 			requestTaken <- struct{}{}
 			<-done
 		},
-		append([]middleware.Middleware{lastMiddleware}, rumMiddleware(cfg, authenticator, ratelimitStore, "", metricnoop.NewMeterProvider(), tracenoop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))...)...)
+		append([]middleware.Middleware{lastMiddleware}, rumMiddleware(cfg, authenticator, nil, ratelimitStore, nil, nil, 0, "", metricnoop.NewMeterProvider(), tracenoop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))...)...)
 
 	// use this to block the single allowed concurrent requests
 	go func() {
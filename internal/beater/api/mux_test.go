@@ -38,6 +38,7 @@ import (
 	"github.com/elastic/apm-server/internal/agentcfg"
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/loadshed"
 	"github.com/elastic/apm-server/internal/beater/monitoringtest"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/beater/request"
@@ -80,6 +81,69 @@ func TestRUMRequestMetadata(t *testing.T) {
 	assert.Equal(t, &modelpb.UserAgent{Original: c.UserAgent}, event.UserAgent)
 }
 
+func TestMuxIPFilter(t *testing.T) {
+	// httptest.NewRequest uses the remote address 192.0.2.1.
+	cfg := config.DefaultConfig()
+	cfg.RumConfig.Enabled = true
+	cfg.IPFilter.Backend.Allow = []string{"10.0.0.0/8"}
+	cfg.IPFilter.OTLP.Deny = []string{"192.0.2.0/24"}
+	h, reader := newTestMux(t, cfg)
+
+	for path, denied := range map[string]bool{
+		IntakePath:           true,
+		OTLPTracesIntakePath: true,
+		IntakeRUMPath:        false,
+		AgentConfigPath:      false,
+		RootPath:             false,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if denied {
+			assert.Equal(t, http.StatusForbidden, rec.Code, path)
+			assert.Contains(t, rec.Body.String(), "client IP not allowed", path)
+		} else {
+			assert.NotContains(t, rec.Body.String(), "client IP not allowed", path)
+		}
+	}
+
+	monitoringtest.ExpectContainOtelMetrics(t, reader, map[string]any{
+		"apm-server.server." + string(request.IDResponseErrorsIPDenied):           1,
+		"apm-server.otlp.http.traces." + string(request.IDResponseErrorsIPDenied): 1,
+	})
+}
+
+func TestMuxLoadSheddingBeforeAuth(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RumConfig.Enabled = true
+	cfg.AgentAuth.SecretToken = "abc123"
+
+	queue := loadshed.NewQueue()
+	queue.SetCapacity(1)
+	queue.Add(1)
+	controller, err := loadshed.NewController(config.LoadSheddingConfig{
+		QueueThreshold: 0.5,
+		RetryAfter:     time.Second,
+	}, queue, nil, 0, sdkmetric.NewMeterProvider())
+	require.NoError(t, err)
+	controller.Update()
+
+	_, h, err := muxBuilder{
+		Logger:       logptest.NewTestingLogger(t, ""),
+		LoadShedding: controller,
+	}.build(cfg)
+	require.NoError(t, err)
+
+	// Requests are shed before they are authenticated, so unauthenticated
+	// requests are rejected as overloaded rather than unauthorized.
+	for _, path := range []string{IntakePath, OTLPTracesIntakePath, IntakeRUMPath} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code, path)
+		assert.Contains(t, rec.Body.String(), "server overloaded", path)
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"), path)
+	}
+}
+
 func requestToMuxerWithPattern(t *testing.T, cfg *config.Config, pattern string) (*httptest.ResponseRecorder, error) {
 	r := httptest.NewRequest(http.MethodPost, pattern, nil)
 	return requestToMuxer(t, cfg, r)
@@ -159,6 +223,8 @@ func newTestMux(t *testing.T, cfg *config.Config) (http.Handler, sdkmetric.Reade
 
 type muxBuilder struct {
 	SourcemapFetcher sourcemap.Fetcher
+	AdminHandlers    map[string]http.Handler
+	LoadShedding     *loadshed.Controller
 	Managed          bool
 	Logger           *logp.Logger
 }
@@ -173,14 +239,17 @@ func (m muxBuilder) build(cfg *config.Config) (sdkmetric.Reader, http.Handler, e
 
 	nopBatchProcessor := modelpb.ProcessBatchFunc(func(context.Context, *modelpb.Batch) error { return nil })
 	ratelimitStore, _ := ratelimit.NewStore(1000, 1000, 1000)
-	authenticator, _ := auth.NewAuthenticator(cfg.AgentAuth, noop.NewTracerProvider(), m.Logger)
+	authenticator, _ := auth.NewAuthenticator(cfg.AgentAuth, noop.NewTracerProvider(), mp, m.Logger)
 	r, err := NewMux(
 		cfg,
 		nopBatchProcessor,
 		authenticator,
+		nil,
+		m.LoadShedding,
 		agentcfg.NewEmptyFetcher(),
 		ratelimitStore,
 		m.SourcemapFetcher,
+		m.AdminHandlers,
 		func() bool { return true },
 		semaphore.NewWeighted(1),
 		mp,
@@ -0,0 +1,302 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package audit provides an audit trail of authentication outcomes,
+// authorization denials, and per-credential ingested event counts.
+package audit
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// queueSize is the maximum number of audit events that may be queued
+// for writing. Events recorded while the queue is full are dropped.
+const queueSize = 1024
+
+// Action identifies the kind of an audit event.
+type Action string
+
+const (
+	// ActionAuthentication identifies audit events recording
+	// the outcome of authenticating a request.
+	ActionAuthentication Action = "authentication"
+
+	// ActionAuthorization identifies audit events recording
+	// an authorization denial.
+	ActionAuthorization Action = "authorization"
+
+	// ActionIngest identifies audit events recording the number
+	// of events ingested for a credential over an interval.
+	ActionIngest Action = "ingest"
+)
+
+// Outcome holds the outcome of an audited action.
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+	OutcomeUnknown Outcome = "unknown"
+)
+
+// Request holds details of the audited request.
+type Request struct {
+	// Route holds the HTTP path or gRPC method of the request.
+	Route string
+
+	// SourceIP holds the client IP of the request.
+	SourceIP netip.Addr
+}
+
+// Credential identifies the credential used to authenticate a request.
+type Credential struct {
+	Method          auth.Method
+	APIKeyID        string
+	Username        string
+	SecretTokenName string
+}
+
+// CredentialFromDetails returns the Credential for the given
+// authentication details.
+func CredentialFromDetails(details auth.AuthenticationDetails) Credential {
+	credential := Credential{Method: details.Method}
+	if details.APIKey != nil {
+		credential.APIKeyID = details.APIKey.ID
+		credential.Username = details.APIKey.Username
+	}
+	if details.SecretToken != nil {
+		credential.SecretTokenName = details.SecretToken.Name
+	}
+	return credential
+}
+
+// Event holds an audit event.
+type Event struct {
+	Timestamp  time.Time
+	Action     Action
+	Outcome    Outcome
+	Reason     string
+	Request    Request
+	Credential Credential
+
+	// AuthorizedAction and Resource hold the action and resource
+	// for which authorization was denied, for ActionAuthorization.
+	AuthorizedAction auth.Action
+	Resource         auth.Resource
+
+	// Events holds the number of events ingested between
+	// Start and End, for ActionIngest.
+	Events int64
+	Start  time.Time
+	End    time.Time
+}
+
+// Auditor records audit events, and writes them to an Output.
+//
+// Events are queued and written asynchronously by Run, so that auditing
+// does not block request handling. A nil *Auditor records nothing.
+type Auditor struct {
+	output   Output
+	interval time.Duration
+	logger   *logp.Logger
+	now      func() time.Time
+	queue    chan Event
+	dropped  metric.Int64Counter
+
+	mu         sync.Mutex
+	counts     map[Credential]int64
+	countStart time.Time
+}
+
+// NewAuditor returns a new Auditor which writes audit events to output,
+// recording per-credential ingested event counts every interval.
+func NewAuditor(output Output, interval time.Duration, mp metric.MeterProvider, logger *logp.Logger) (*Auditor, error) {
+	meter := mp.Meter("github.com/elastic/apm-server/internal/beater/audit")
+	dropped, err := meter.Int64Counter("apm-server.audit.events.dropped")
+	if err != nil {
+		return nil, err
+	}
+	return &Auditor{
+		output:     output,
+		interval:   interval,
+		logger:     logger,
+		now:        time.Now,
+		queue:      make(chan Event, queueSize),
+		dropped:    dropped,
+		counts:     make(map[Credential]int64),
+		countStart: time.Now(),
+	}, nil
+}
+
+// Authentication records the outcome of authenticating req, given the
+// details and error returned by auth.Authenticator.Authenticate.
+func (a *Auditor) Authentication(req Request, details auth.AuthenticationDetails, err error) {
+	if a == nil {
+		return
+	}
+	event := Event{
+		Action:     ActionAuthentication,
+		Outcome:    OutcomeSuccess,
+		Request:    req,
+		Credential: CredentialFromDetails(details),
+	}
+	if err != nil {
+		event.Outcome = OutcomeUnknown
+		if errors.Is(err, auth.ErrAuthFailed) {
+			event.Outcome = OutcomeFailure
+		}
+		event.Reason = err.Error()
+	}
+	a.record(event)
+}
+
+// Authorizer returns an auth.Authorizer which wraps authz, recording
+// authorization denials for req.
+func (a *Auditor) Authorizer(req Request, details auth.AuthenticationDetails, authz auth.Authorizer) auth.Authorizer {
+	if a == nil || authz == nil {
+		return authz
+	}
+	return &authorizer{
+		auditor:    a,
+		authz:      authz,
+		req:        req,
+		credential: CredentialFromDetails(details),
+	}
+}
+
+// EventsIngested records n events ingested for the credential identified
+// by details. Counts are aggregated, and recorded every interval.
+func (a *Auditor) EventsIngested(details auth.AuthenticationDetails, n int) {
+	if a == nil || n == 0 {
+		return
+	}
+	credential := CredentialFromDetails(details)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.counts[credential] += int64(n)
+}
+
+// Run writes recorded audit events to the output until ctx is cancelled,
+// recording per-credential ingested event counts every interval. When ctx
+// is cancelled, Run writes any queued events and counts, and closes the output.
+func (a *Auditor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Use a background context to write the final events,
+			// as ctx is already cancelled.
+			ctx := context.Background()
+			a.write(ctx, append(a.drain(nil), a.takeCounts()...))
+			return a.output.Close(ctx)
+		case event := <-a.queue:
+			a.write(ctx, a.drain([]Event{event}))
+		case <-ticker.C:
+			a.write(ctx, a.takeCounts())
+		}
+	}
+}
+
+func (a *Auditor) record(event Event) {
+	event.Timestamp = a.now()
+	select {
+	case a.queue <- event:
+	default:
+		a.dropped.Add(context.Background(), 1)
+	}
+}
+
+// drain appends all queued events to events, and returns the result.
+func (a *Auditor) drain(events []Event) []Event {
+	for {
+		select {
+		case event := <-a.queue:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// takeCounts returns ActionIngest events for the ingested event counts
+// since the last call, and resets the counts.
+func (a *Auditor) takeCounts() []Event {
+	a.mu.Lock()
+	counts := a.counts
+	start := a.countStart
+	end := a.now()
+	a.counts = make(map[Credential]int64, len(counts))
+	a.countStart = end
+	a.mu.Unlock()
+
+	events := make([]Event, 0, len(counts))
+	for credential, n := range counts {
+		events = append(events, Event{
+			Timestamp:  end,
+			Action:     ActionIngest,
+			Outcome:    OutcomeSuccess,
+			Credential: credential,
+			Events:     n,
+			Start:      start,
+			End:        end,
+		})
+	}
+	return events
+}
+
+func (a *Auditor) write(ctx context.Context, events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	if err := a.output.Write(ctx, events); err != nil {
+		a.logger.With(logp.Error(err)).Errorf("failed to write %d audit events", len(events))
+	}
+}
+
+// authorizer implements auth.Authorizer, recording authorization denials.
+type authorizer struct {
+	auditor    *Auditor
+	authz      auth.Authorizer
+	req        Request
+	credential Credential
+}
+
+func (a *authorizer) Authorize(ctx context.Context, action auth.Action, resource auth.Resource) error {
+	err := a.authz.Authorize(ctx, action, resource)
+	if errors.Is(err, auth.ErrUnauthorized) {
+		a.auditor.record(Event{
+			Action:           ActionAuthorization,
+			Outcome:          OutcomeFailure,
+			Reason:           err.Error(),
+			Request:          a.req,
+			Credential:       a.credential,
+			AuthorizedAction: action,
+			Resource:         resource,
+		})
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestAuditor(t *testing.T) {
+	output := &recordingOutput{}
+	auditor, err := NewAuditor(output, time.Hour, metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	now := time.Unix(1, 0).UTC()
+	auditor.now = func() time.Time { return now }
+	auditor.countStart = now
+
+	req := Request{Route: "/intake/v2/events", SourceIP: netip.MustParseAddr("192.0.2.1")}
+	apiKeyDetails := auth.AuthenticationDetails{
+		Method: auth.MethodAPIKey,
+		APIKey: &auth.APIKeyAuthenticationDetails{ID: "key_id", Username: "user"},
+	}
+	tokenDetails := auth.AuthenticationDetails{
+		Method:      auth.MethodSecretToken,
+		SecretToken: &auth.SecretTokenAuthenticationDetails{Name: "token"},
+	}
+	apiKeyCredential := Credential{Method: auth.MethodAPIKey, APIKeyID: "key_id", Username: "user"}
+	tokenCredential := Credential{Method: auth.MethodSecretToken, SecretTokenName: "token"}
+
+	auditor.Authentication(req, apiKeyDetails, nil)
+	auditor.Authentication(req, auth.AuthenticationDetails{}, auth.ErrAuthFailed)
+	auditor.Authentication(req, auth.AuthenticationDetails{}, errors.New("connection refused"))
+
+	authz := auditor.Authorizer(req, tokenDetails, denyService{"denied"})
+	assert.NoError(t, authz.Authorize(context.Background(), auth.ActionEventIngest, auth.Resource{ServiceName: "allowed"}))
+	err = authz.Authorize(context.Background(), auth.ActionEventIngest, auth.Resource{AgentName: "go", ServiceName: "denied"})
+	assert.ErrorIs(t, err, auth.ErrUnauthorized)
+
+	auditor.EventsIngested(apiKeyDetails, 2)
+	auditor.EventsIngested(apiKeyDetails, 3)
+	auditor.EventsIngested(tokenDetails, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	now = now.Add(time.Minute)
+	require.NoError(t, auditor.Run(ctx))
+	assert.True(t, output.closed)
+
+	require.Len(t, output.events, 6)
+	assert.Equal(t, []Event{{
+		Timestamp:  time.Unix(1, 0).UTC(),
+		Action:     ActionAuthentication,
+		Outcome:    OutcomeSuccess,
+		Request:    req,
+		Credential: apiKeyCredential,
+	}, {
+		Timestamp: time.Unix(1, 0).UTC(),
+		Action:    ActionAuthentication,
+		Outcome:   OutcomeFailure,
+		Reason:    "authentication failed",
+		Request:   req,
+	}, {
+		Timestamp: time.Unix(1, 0).UTC(),
+		Action:    ActionAuthentication,
+		Outcome:   OutcomeUnknown,
+		Reason:    "connection refused",
+		Request:   req,
+	}, {
+		Timestamp:        time.Unix(1, 0).UTC(),
+		Action:           ActionAuthorization,
+		Outcome:          OutcomeFailure,
+		Reason:           `unauthorized: service "denied" denied`,
+		Request:          req,
+		Credential:       tokenCredential,
+		AuthorizedAction: auth.ActionEventIngest,
+		Resource:         auth.Resource{AgentName: "go", ServiceName: "denied"},
+	}}, output.events[:4])
+	assert.ElementsMatch(t, []Event{{
+		Timestamp:  now,
+		Action:     ActionIngest,
+		Outcome:    OutcomeSuccess,
+		Credential: apiKeyCredential,
+		Events:     5,
+		Start:      time.Unix(1, 0).UTC(),
+		End:        now,
+	}, {
+		Timestamp:  now,
+		Action:     ActionIngest,
+		Outcome:    OutcomeSuccess,
+		Credential: tokenCredential,
+		Events:     1,
+		Start:      time.Unix(1, 0).UTC(),
+		End:        now,
+	}}, output.events[4:])
+}
+
+func TestAuditorQueueFull(t *testing.T) {
+	output := &recordingOutput{}
+	auditor, err := NewAuditor(output, time.Hour, metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	for i := 0; i < queueSize+10; i++ {
+		auditor.Authentication(Request{}, auth.AuthenticationDetails{Method: auth.MethodNone}, nil)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, auditor.Run(ctx))
+	assert.Len(t, output.events, queueSize)
+}
+
+func TestAuditorNil(t *testing.T) {
+	var auditor *Auditor
+	auditor.Authentication(Request{}, auth.AuthenticationDetails{}, nil)
+	auditor.EventsIngested(auth.AuthenticationDetails{}, 1)
+	authz := denyService{"denied"}
+	assert.Equal(t, authz, auditor.Authorizer(Request{}, auth.AuthenticationDetails{}, authz))
+}
+
+type recordingOutput struct {
+	mu     sync.Mutex
+	events []Event
+	closed bool
+}
+
+func (o *recordingOutput) Write(ctx context.Context, events []Event) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, events...)
+	return nil
+}
+
+func (o *recordingOutput) Close(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	return nil
+}
+
+type denyService struct {
+	service string
+}
+
+func (d denyService) Authorize(ctx context.Context, action auth.Action, resource auth.Resource) error {
+	if resource.ServiceName == d.service {
+		return fmt.Errorf("%w: service %q denied", auth.ErrUnauthorized, d.service)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/elastic-agent-libs/file"
+)
+
+// Output writes audit events.
+type Output interface {
+	// Write writes events to the output.
+	Write(ctx context.Context, events []Event) error
+
+	// Close flushes any buffered events and closes the output.
+	Close(ctx context.Context) error
+}
+
+// FileOutput is an Output which writes audit events to a local file
+// as newline-delimited JSON, rotating the file when it reaches a size.
+type FileOutput struct {
+	rotator *file.Rotator
+}
+
+// NewFileOutput returns a new FileOutput writing to files named with the
+// prefix path, followed by the date and ".ndjson". The file is rotated when
+// it would exceed maxSize bytes, and maxBackups rotated files are kept.
+func NewFileOutput(path string, maxSize uint64, maxBackups uint) (*FileOutput, error) {
+	rotator, err := file.NewFileRotator(path,
+		file.MaxSizeBytes(uint(maxSize)),
+		file.MaxBackups(maxBackups),
+		file.Permissions(os.FileMode(0600)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &FileOutput{rotator: rotator}, nil
+}
+
+// Write writes events to the file.
+func (o *FileOutput) Write(ctx context.Context, events []Event) error {
+	var errs []error
+	for _, event := range events {
+		data, err := json.Marshal(newDocument(event))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// Each event is written separately, so that
+		// rotation does not split events across files.
+		if _, err := o.rotator.Write(append(data, '\n')); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the file.
+func (o *FileOutput) Close(ctx context.Context) error {
+	return o.rotator.Close()
+}
+
+// Appender provides an interface for indexing documents, satisfied by
+// *docappender.Appender.
+type Appender interface {
+	Add(ctx context.Context, index string, document io.WriterTo) error
+	Close(ctx context.Context) error
+}
+
+// DataStreamOutput is an Output which indexes audit events into
+// the logs-apm.audit-<namespace> data stream.
+type DataStreamOutput struct {
+	appender  Appender
+	namespace string
+}
+
+// NewDataStreamOutput returns a new DataStreamOutput which indexes
+// audit events using appender, into the data stream with namespace.
+func NewDataStreamOutput(appender Appender, namespace string) *DataStreamOutput {
+	return &DataStreamOutput{appender: appender, namespace: namespace}
+}
+
+// Write indexes events into the audit data stream.
+func (o *DataStreamOutput) Write(ctx context.Context, events []Event) error {
+	index := dataStreamType + "-" + dataStreamDataset + "-" + o.namespace
+	var errs []error
+	for _, event := range events {
+		doc := newDocument(event)
+		doc.DataStream = &documentDataStream{
+			Type:      dataStreamType,
+			Dataset:   dataStreamDataset,
+			Namespace: o.namespace,
+		}
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(doc); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := o.appender.Add(ctx, index, &buf); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close flushes any buffered documents and closes the appender.
+func (o *DataStreamOutput) Close(ctx context.Context) error {
+	return o.appender.Close(ctx)
+}
+
+const (
+	dataStreamType    = "logs"
+	dataStreamDataset = "apm.audit"
+)
+
+// document holds the ECS document for an audit event.
+type document struct {
+	Timestamp   time.Time           `json:"@timestamp"`
+	DataStream  *documentDataStream `json:"data_stream,omitempty"`
+	Event       documentEvent       `json:"event"`
+	URL         *documentURL        `json:"url,omitempty"`
+	Source      *documentSource     `json:"source,omitempty"`
+	Auth        documentAuth        `json:"auth"`
+	User        *documentName       `json:"user,omitempty"`
+	APIKey      *documentID         `json:"api_key,omitempty"`
+	SecretToken *documentName       `json:"secret_token,omitempty"`
+	Agent       *documentName       `json:"agent,omitempty"`
+	Service     *documentName       `json:"service,omitempty"`
+}
+
+type documentDataStream struct {
+	Type      string `json:"type"`
+	Dataset   string `json:"dataset"`
+	Namespace string `json:"namespace"`
+}
+
+type documentEvent struct {
+	Kind    string     `json:"kind"`
+	Action  Action     `json:"action"`
+	Outcome Outcome    `json:"outcome"`
+	Reason  string     `json:"reason,omitempty"`
+	Count   int64      `json:"count,omitempty"`
+	Start   *time.Time `json:"start,omitempty"`
+	End     *time.Time `json:"end,omitempty"`
+}
+
+type documentURL struct {
+	Path string `json:"path"`
+}
+
+type documentSource struct {
+	IP string `json:"ip"`
+}
+
+type documentAuth struct {
+	Method string `json:"method,omitempty"`
+	Action string `json:"action,omitempty"`
+}
+
+type documentName struct {
+	Name string `json:"name"`
+}
+
+type documentID struct {
+	ID string `json:"id"`
+}
+
+func newDocument(event Event) *document {
+	doc := &document{
+		Timestamp: event.Timestamp,
+		Event: documentEvent{
+			Kind:    "event",
+			Action:  event.Action,
+			Outcome: event.Outcome,
+			Reason:  event.Reason,
+			Count:   event.Events,
+		},
+		Auth: documentAuth{
+			Method: string(event.Credential.Method),
+			Action: string(event.AuthorizedAction),
+		},
+	}
+	if event.Credential.Method == auth.MethodAnonymous {
+		// Failed authentication attempts have no method; all
+		// other events with no method are for anonymous clients.
+		if event.Action != ActionAuthentication || event.Outcome == OutcomeSuccess {
+			doc.Auth.Method = "anonymous"
+		}
+	}
+	if !event.Start.IsZero() {
+		doc.Event.Start = &event.Start
+		doc.Event.End = &event.End
+	}
+	if event.Request.Route != "" {
+		doc.URL = &documentURL{Path: event.Request.Route}
+	}
+	if event.Request.SourceIP.IsValid() {
+		doc.Source = &documentSource{IP: event.Request.SourceIP.String()}
+	}
+	if event.Credential.Username != "" {
+		doc.User = &documentName{Name: event.Credential.Username}
+	}
+	if event.Credential.APIKeyID != "" {
+		doc.APIKey = &documentID{ID: event.Credential.APIKeyID}
+	}
+	if event.Credential.SecretTokenName != "" {
+		doc.SecretToken = &documentName{Name: event.Credential.SecretTokenName}
+	}
+	if event.Resource.AgentName != "" {
+		doc.Agent = &documentName{Name: event.Resource.AgentName}
+	}
+	if event.Resource.ServiceName != "" {
+		doc.Service = &documentName{Name: event.Resource.ServiceName}
+	}
+	return doc
+}
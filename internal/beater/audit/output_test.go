@@ -0,0 +1,120 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+)
+
+var testEvents = []Event{{
+	Timestamp: time.Unix(1, 0).UTC(),
+	Action:    ActionAuthentication,
+	Outcome:   OutcomeFailure,
+	Reason:    "authentication failed",
+	Request:   Request{Route: "/intake/v2/events", SourceIP: netip.MustParseAddr("192.0.2.1")},
+}, {
+	Timestamp:        time.Unix(2, 0).UTC(),
+	Action:           ActionAuthorization,
+	Outcome:          OutcomeFailure,
+	Reason:           "unauthorized",
+	Request:          Request{Route: "/config/v1/agents"},
+	Credential:       Credential{Method: auth.MethodAPIKey, APIKeyID: "key_id", Username: "user"},
+	AuthorizedAction: auth.ActionAgentConfig,
+	Resource:         auth.Resource{ServiceName: "opbeans"},
+}, {
+	Timestamp:  time.Unix(60, 0).UTC(),
+	Action:     ActionIngest,
+	Outcome:    OutcomeSuccess,
+	Credential: Credential{Method: auth.MethodAnonymous},
+	Events:     10,
+	Start:      time.Unix(0, 0).UTC(),
+	End:        time.Unix(60, 0).UTC(),
+}}
+
+var testDocuments = []string{
+	`{"@timestamp":"1970-01-01T00:00:01Z","event":{"kind":"event","action":"authentication","outcome":"failure","reason":"authentication failed"},"url":{"path":"/intake/v2/events"},"source":{"ip":"192.0.2.1"},"auth":{}}`,
+	`{"@timestamp":"1970-01-01T00:00:02Z","event":{"kind":"event","action":"authorization","outcome":"failure","reason":"unauthorized"},"url":{"path":"/config/v1/agents"},"auth":{"method":"api_key","action":"agent_config"},"user":{"name":"user"},"api_key":{"id":"key_id"},"service":{"name":"opbeans"}}`,
+	`{"@timestamp":"1970-01-01T00:01:00Z","event":{"kind":"event","action":"ingest","outcome":"success","count":10,"start":"1970-01-01T00:00:00Z","end":"1970-01-01T00:01:00Z"},"auth":{"method":"anonymous"}}`,
+}
+
+func TestFileOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit")
+	output, err := NewFileOutput(path, 1024*1024, 1)
+	require.NoError(t, err)
+	require.NoError(t, output.Write(context.Background(), testEvents))
+	require.NoError(t, output.Close(context.Background()))
+
+	files, err := filepath.Glob(path + "-*.ndjson")
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join(testDocuments, "\n")+"\n", string(data))
+}
+
+func TestDataStreamOutput(t *testing.T) {
+	appender := &recordingAppender{}
+	output := NewDataStreamOutput(appender, "testing")
+	require.NoError(t, output.Write(context.Background(), testEvents[:1]))
+	require.NoError(t, output.Close(context.Background()))
+	assert.True(t, appender.closed)
+	assert.Equal(t, []string{"logs-apm.audit-testing"}, appender.indices)
+	require.Len(t, appender.documents, 1)
+	assert.JSONEq(t, `{
+		"@timestamp": "1970-01-01T00:00:01Z",
+		"data_stream": {"type": "logs", "dataset": "apm.audit", "namespace": "testing"},
+		"event": {"kind": "event", "action": "authentication", "outcome": "failure", "reason": "authentication failed"},
+		"url": {"path": "/intake/v2/events"},
+		"source": {"ip": "192.0.2.1"},
+		"auth": {}
+	}`, appender.documents[0])
+}
+
+type recordingAppender struct {
+	indices   []string
+	documents []string
+	closed    bool
+}
+
+func (a *recordingAppender) Add(ctx context.Context, index string, document io.WriterTo) error {
+	var buf bytes.Buffer
+	if _, err := document.WriteTo(&buf); err != nil {
+		return err
+	}
+	a.indices = append(a.indices, index)
+	a.documents = append(a.documents, buf.String())
+	return nil
+}
+
+func (a *recordingAppender) Close(ctx context.Context) error {
+	a.closed = true
+	return nil
+}
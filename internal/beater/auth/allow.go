@@ -19,12 +19,21 @@ package auth
 
 import (
 	"context"
+	"fmt"
 )
 
-// allowAuth implements the Authorizer interface.
-type allowAuth struct{}
+// nonAdminAuth implements the Authorizer interface, authorizing all
+// actions other than ActionAdmin.
+type nonAdminAuth struct {
+	// reason describes why ActionAdmin is not authorized.
+	reason string
+}
 
-// Authorize always returns nil, indicating the request is authorized.
-func (allowAuth) Authorize(context.Context, Action, Resource) error {
+// Authorize returns nil, indicating the request is authorized,
+// unless action is ActionAdmin.
+func (a nonAdminAuth) Authorize(_ context.Context, action Action, _ Resource) error {
+	if action == ActionAdmin {
+		return fmt.Errorf("%w: %s", ErrUnauthorized, a.reason)
+	}
 	return nil
 }
@@ -24,9 +24,13 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func TestAllowAuth(t *testing.T) {
-	handler := allowAuth{}
+func TestNonAdminAuth(t *testing.T) {
+	handler := nonAdminAuth{reason: "reason"}
 
-	err := handler.Authorize(context.Background(), "", Resource{})
+	err := handler.Authorize(context.Background(), ActionEventIngest, Resource{})
 	assert.NoError(t, err)
+
+	err = handler.Authorize(context.Background(), ActionAdmin, Resource{})
+	assert.EqualError(t, err, "unauthorized: reason")
+	assert.ErrorIs(t, err, ErrUnauthorized)
 }
@@ -25,6 +25,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
 	"go.opentelemetry.io/otel/trace/noop"
 
 	"github.com/elastic/apm-server/internal/beater/auth"
@@ -47,6 +48,13 @@ func TestAnonymousAuthorizer(t *testing.T) {
 			resource:     auth.Resource{AgentName: "iOS/swift", ServiceName: "opbeans-ios"},
 			expectErr:    fmt.Errorf(`%w: anonymous access not permitted for sourcemap uploads`, auth.ErrUnauthorized),
 		},
+		"deny_admin": {
+			allowAgent:   nil,
+			allowService: nil,
+			action:       auth.ActionAdmin,
+			resource:     auth.Resource{},
+			expectErr:    fmt.Errorf(`%w: anonymous access not permitted for admin APIs`, auth.ErrUnauthorized),
+		},
 		"deny_unknown_action": {
 			allowAgent:   nil,
 			allowService: nil,
@@ -134,7 +142,7 @@ func getAnonymousAuthorizer(t testing.TB, cfg config.AnonymousAgentAuth) auth.Au
 	authenticator, err := auth.NewAuthenticator(config.AgentAuth{
 		SecretToken: "whatever", // required to enable anonymous auth
 		Anonymous:   cfg,
-	}, noop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))
+	}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
 	require.NoError(t, err)
 	_, authorizer, err := authenticator.Authenticate(context.Background(), "", "")
 	require.NoError(t, err)
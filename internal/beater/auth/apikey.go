@@ -55,6 +55,10 @@ var (
 	// PrivilegeSourcemapWrite identifies the Elasticsearch API Key privilege
 	// required for authorizing source map uploads.
 	PrivilegeSourcemapWrite = es.NewPrivilege("sourcemap", "sourcemap:write")
+
+	// PrivilegeServerAdmin identifies the Elasticsearch API Key privilege
+	// required for authorizing use of administrative APIs.
+	PrivilegeServerAdmin = es.NewPrivilege("serverAdmin", "server:admin")
 )
 
 // AllPrivilegeActions returns all Elasticsearch privilege actions used by APM Server.
@@ -63,6 +67,7 @@ func AllPrivilegeActions() []es.PrivilegeAction {
 		PrivilegeAgentConfigRead.Action,
 		PrivilegeEventWrite.Action,
 		PrivilegeSourcemapWrite.Action,
+		PrivilegeServerAdmin.Action,
 	}
 }
 
@@ -79,16 +84,25 @@ func newApikeyAuth(client *es.Client, cache *privilegesCache) *apikeyAuth {
 	return &apikeyAuth{client, cache}
 }
 
-func (a *apikeyAuth) authenticate(ctx context.Context, credentials string) (*APIKeyAuthenticationDetails, *apikeyAuthorizer, error) {
+// parseAPIKeyCredentials decodes base64(ID:APIKey) credentials,
+// returning the API Key ID and secret.
+func parseAPIKeyCredentials(credentials string) (id string, key []byte, _ error) {
 	decoded, err := base64.StdEncoding.DecodeString(credentials)
 	if err != nil {
-		return nil, nil, fmt.Errorf("%w: improperly encoded ApiKey credentials: expected base64(ID:APIKey): %s", ErrAuthFailed, err)
+		return "", nil, fmt.Errorf("%w: improperly encoded ApiKey credentials: expected base64(ID:APIKey): %s", ErrAuthFailed, err)
 	}
 	colon := bytes.IndexByte(decoded, ':')
 	if colon == -1 {
-		return nil, nil, fmt.Errorf("%w: improperly formatted ApiKey credentials: expected base64(ID:APIKey)", ErrAuthFailed)
+		return "", nil, fmt.Errorf("%w: improperly formatted ApiKey credentials: expected base64(ID:APIKey)", ErrAuthFailed)
+	}
+	return string(decoded[:colon]), decoded[colon+1:], nil
+}
+
+func (a *apikeyAuth) authenticate(ctx context.Context, credentials string) (*APIKeyAuthenticationDetails, *apikeyAuthorizer, error) {
+	id, _, err := parseAPIKeyCredentials(credentials)
+	if err != nil {
+		return nil, nil, err
 	}
-	id := string(decoded[:colon])
 
 	// Check that the user has any privileges for the internal resource.
 	response, err := a.hasPrivileges(ctx, id, credentials, ResourceInternal)
@@ -168,6 +182,8 @@ func (a *apikeyAuthorizer) Authorize(ctx context.Context, action Action, _ Resou
 		apikeyPrivilegeAction = PrivilegeEventWrite.Action
 	case ActionSourcemapUpload:
 		apikeyPrivilegeAction = PrivilegeSourcemapWrite.Action
+	case ActionAdmin:
+		apikeyPrivilegeAction = PrivilegeServerAdmin.Action
 	default:
 		return fmt.Errorf("unknown action %q", action)
 	}
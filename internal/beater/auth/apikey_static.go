@@ -0,0 +1,90 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	es "github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+// staticAPIKeyAuth authenticates clients using locally defined API Keys,
+// without communicating with Elasticsearch. Only the SHA-256 hash of each
+// API Key secret is held in memory.
+type staticAPIKeyAuth struct {
+	keys map[string]staticAPIKey
+}
+
+type staticAPIKey struct {
+	hash     []byte
+	username string
+
+	// permissions holds the API Key's privileges for ResourceInternal,
+	// matching the permissions obtained for Elasticsearch API Keys.
+	permissions es.Permissions
+}
+
+func newStaticAPIKeyAuth(keys []config.StaticAPIKeyAgentAuth) (*staticAPIKeyAuth, error) {
+	a := &staticAPIKeyAuth{keys: make(map[string]staticAPIKey, len(keys))}
+	for _, key := range keys {
+		if _, ok := a.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate static API Key ID %q", key.ID)
+		}
+		hash, err := hex.DecodeString(key.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hash for static API Key %q: %w", key.ID, err)
+		}
+		permissions := make(es.Permissions)
+		for _, action := range AllPrivilegeActions() {
+			permissions[action] = false
+		}
+		for _, privilege := range key.Privileges {
+			permissions[es.PrivilegeAction(privilege)] = true
+		}
+		a.keys[key.ID] = staticAPIKey{
+			hash:        hash,
+			username:    key.Username,
+			permissions: permissions,
+		}
+	}
+	return a, nil
+}
+
+// authenticate authenticates credentials against the static API Keys.
+// If the API Key ID is not known, authenticate returns false and no error,
+// so that the caller may fall back to another authentication method.
+func (a *staticAPIKeyAuth) authenticate(credentials string) (*APIKeyAuthenticationDetails, *apikeyAuthorizer, bool, error) {
+	id, secret, err := parseAPIKeyCredentials(credentials)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	key, ok := a.keys[id]
+	if !ok {
+		return nil, nil, false, nil
+	}
+	hash := sha256.Sum256(secret)
+	if subtle.ConstantTimeCompare(hash[:], key.hash) != 1 {
+		return nil, nil, true, ErrAuthFailed
+	}
+	details := &APIKeyAuthenticationDetails{ID: id, Username: key.username}
+	return details, &apikeyAuthorizer{key.permissions}, true, nil
+}
@@ -0,0 +1,135 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func staticAPIKeyHash(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestAuthenticatorStaticAPIKey(t *testing.T) {
+	authenticator, err := NewAuthenticator(config.AgentAuth{
+		StaticAPIKeys: []config.StaticAPIKeyAgentAuth{{
+			ID:         "static_id",
+			Hash:       staticAPIKeyHash("key_value"),
+			Username:   "static_username",
+			Privileges: []string{"event:write"},
+		}},
+	}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("static_id:key_value"))
+	details, authz, err := authenticator.Authenticate(context.Background(), headers.APIKey, credentials)
+	require.NoError(t, err)
+	assert.Equal(t, AuthenticationDetails{
+		Method: MethodAPIKey,
+		APIKey: &APIKeyAuthenticationDetails{ID: "static_id", Username: "static_username"},
+	}, details)
+
+	assert.NoError(t, authz.Authorize(context.Background(), ActionEventIngest, Resource{}))
+	err = authz.Authorize(context.Background(), ActionAgentConfig, Resource{})
+	assert.EqualError(t, err, `unauthorized: API Key not permitted action "config_agent:read"`)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+
+	for _, credentials := range []string{
+		base64.StdEncoding.EncodeToString([]byte("static_id:wrong_value")),
+		base64.StdEncoding.EncodeToString([]byte("unknown_id:key_value")),
+	} {
+		details, authz, err = authenticator.Authenticate(context.Background(), headers.APIKey, credentials)
+		assert.Equal(t, ErrAuthFailed, err)
+		assert.Zero(t, details)
+		assert.Nil(t, authz)
+	}
+
+	details, authz, err = authenticator.Authenticate(context.Background(), headers.APIKey, "invalid_base64")
+	assert.True(t, errors.Is(err, ErrAuthFailed))
+	assert.Zero(t, details)
+	assert.Nil(t, authz)
+}
+
+func TestAuthenticatorStaticAPIKeyFallback(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Write([]byte(`{
+			"username": "es_username",
+			"application": {"apm": {"-": {"config_agent:read": true, "event:write": true, "sourcemap:write": false}}}
+		}`))
+	}))
+	defer srv.Close()
+
+	esConfig := elasticsearch.DefaultConfig()
+	esConfig.Hosts = elasticsearch.Hosts{srv.URL}
+	authenticator, err := NewAuthenticator(config.AgentAuth{
+		APIKey: config.APIKeyAgentAuth{Enabled: true, LimitPerMin: 100, ESConfig: esConfig},
+		StaticAPIKeys: []config.StaticAPIKeyAgentAuth{{
+			ID:         "static_id",
+			Hash:       staticAPIKeyHash("key_value"),
+			Privileges: []string{"event:write", "config_agent:read"},
+		}},
+	}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+
+	// Static API Keys are authenticated without Elasticsearch.
+	credentials := base64.StdEncoding.EncodeToString([]byte("static_id:key_value"))
+	details, _, err := authenticator.Authenticate(context.Background(), headers.APIKey, credentials)
+	require.NoError(t, err)
+	assert.Equal(t, "static_id", details.APIKey.ID)
+	assert.Zero(t, requests)
+
+	// A static API Key with the wrong secret does not fall back to Elasticsearch.
+	credentials = base64.StdEncoding.EncodeToString([]byte("static_id:wrong_value"))
+	_, _, err = authenticator.Authenticate(context.Background(), headers.APIKey, credentials)
+	assert.Equal(t, ErrAuthFailed, err)
+	assert.Zero(t, requests)
+
+	// Unknown API Key IDs fall back to Elasticsearch.
+	credentials = base64.StdEncoding.EncodeToString([]byte("es_id:key_value"))
+	details, _, err = authenticator.Authenticate(context.Background(), headers.APIKey, credentials)
+	require.NoError(t, err)
+	assert.Equal(t, &APIKeyAuthenticationDetails{ID: "es_id", Username: "es_username"}, details.APIKey)
+	assert.Equal(t, 1, requests)
+}
+
+func TestNewStaticAPIKeyAuthDuplicateID(t *testing.T) {
+	key := config.StaticAPIKeyAgentAuth{ID: "id", Hash: staticAPIKeyHash("value"), Privileges: []string{"event:write"}}
+	_, err := newStaticAPIKeyAuth([]config.StaticAPIKeyAgentAuth{key, key})
+	assert.EqualError(t, err, `duplicate static API Key ID "id"`)
+}
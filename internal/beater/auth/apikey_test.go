@@ -27,6 +27,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
 	"go.opentelemetry.io/otel/trace/noop"
 
 	"github.com/elastic/apm-server/internal/beater/config"
@@ -53,7 +54,7 @@ func TestAPIKeyAuthorizer(t *testing.T) {
 	esConfig := elasticsearch.DefaultConfig()
 	esConfig.Hosts = elasticsearch.Hosts{srv.URL}
 	apikeyAuthConfig := config.APIKeyAgentAuth{Enabled: true, LimitPerMin: 1, ESConfig: esConfig}
-	authenticator, err := NewAuthenticator(config.AgentAuth{APIKey: apikeyAuthConfig}, noop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))
+	authenticator, err := NewAuthenticator(config.AgentAuth{APIKey: apikeyAuthConfig}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
 	require.NoError(t, err)
 
 	credentials := base64.StdEncoding.EncodeToString([]byte("valid_id:key_value"))
@@ -70,6 +71,9 @@ func TestAPIKeyAuthorizer(t *testing.T) {
 	assert.EqualError(t, err, `unauthorized: API Key not permitted action "sourcemap:write"`)
 	assert.True(t, errors.Is(err, ErrUnauthorized))
 
+	err = authz.Authorize(context.Background(), ActionAdmin, Resource{})
+	assert.EqualError(t, err, `unauthorized: API Key not permitted action "server:admin"`)
+
 	err = authz.Authorize(context.Background(), "unknown", Resource{})
 	assert.EqualError(t, err, `unknown action "unknown"`)
 }
@@ -19,11 +19,11 @@ package auth
 
 import (
 	"context"
-	"crypto/subtle"
 	"errors"
 	"fmt"
 	"time"
 
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
 
 	"github.com/elastic/apm-server/internal/beater/config"
@@ -43,12 +43,14 @@ const (
 	// access when the server has other auth methods defined.
 	MethodNone Method = "none"
 
-	// MethodAPIKey identifies the auth method using Elasticsearch API Keys.
-	// Clients that authenticate with an API Key may have restricted privileges.
+	// MethodAPIKey identifies the auth method using API Keys, either defined in
+	// Elasticsearch or statically in the server configuration. Clients that
+	// authenticate with an API Key may have restricted privileges.
 	MethodAPIKey Method = "api_key"
 
 	// MethodSecretToken identifies the auth methd using a shared secret token.
-	// Clients with this secret token have unrestricted privileges.
+	// Clients with the unnamed secret token have unrestricted privileges,
+	// while named secret tokens may have restricted privileges.
 	MethodSecretToken Method = "secret_token"
 
 	// MethodAnonymous identifies the anonymous access auth method.
@@ -68,6 +70,10 @@ const (
 
 	// ActionSourcemapUpload is an Action describing an attempt to upload a source map.
 	ActionSourcemapUpload Action = "sourcemap"
+
+	// ActionAdmin is an Action describing an attempt to use an
+	// administrative API, such as the tail-sampling admin API.
+	ActionAdmin Action = "admin"
 )
 
 const (
@@ -87,12 +93,16 @@ var errAuthMissing = fmt.Errorf("%w: missing or improperly formatted Authorizati
 // to provide a reason, and checked using `errors.Is`.
 var ErrUnauthorized = errors.New("unauthorized")
 
+// noAuthAuthorizer is the Authorizer for clients when no auth is configured.
+var noAuthAuthorizer = nonAdminAuth{reason: "admin APIs require auth to be configured"}
+
 // Authenticator authenticates clients.
 type Authenticator struct {
-	secretToken string
+	secretToken *secretTokenAuth
 
-	apikey    *apikeyAuth
-	anonymous *anonymousAuth
+	apikey       *apikeyAuth
+	staticAPIKey *staticAPIKeyAuth
+	anonymous    *anonymousAuth
 }
 
 // Authorizer provides an interface for authorizing an action and resource.
@@ -130,6 +140,11 @@ type AuthenticationDetails struct {
 	// APIKey holds authentication details related to API Key auth.
 	// This will be set when Method is MethodAPIKey.
 	APIKey *APIKeyAuthenticationDetails
+
+	// SecretToken holds authentication details related to secret token auth.
+	// This will be set when Method is MethodSecretToken and the client used
+	// a named secret token.
+	SecretToken *SecretTokenAuthenticationDetails
 }
 
 // APIKeyAuthenticationDetails holds API Key related authentication details.
@@ -141,10 +156,23 @@ type APIKeyAuthenticationDetails struct {
 	Username string
 }
 
+// SecretTokenAuthenticationDetails holds named secret token related authentication details.
+type SecretTokenAuthenticationDetails struct {
+	// Name holds the name of the secret token.
+	Name string
+}
+
 // NewAuthenticator creates an Authenticator with config, authenticating
 // clients with one of the allowed methods.
-func NewAuthenticator(cfg config.AgentAuth, tp trace.TracerProvider, logger *logp.Logger) (*Authenticator, error) {
-	b := Authenticator{secretToken: cfg.SecretToken}
+func NewAuthenticator(cfg config.AgentAuth, tp trace.TracerProvider, mp metric.MeterProvider, logger *logp.Logger) (*Authenticator, error) {
+	var b Authenticator
+	if cfg.SecretTokenEnabled() {
+		secretToken, err := newSecretTokenAuth(cfg, mp, logger)
+		if err != nil {
+			return nil, err
+		}
+		b.secretToken = secretToken
+	}
 	if cfg.APIKey.Enabled {
 		// Do not use apm-server's credentials for API Key requests;
 		// we should only use API Key credentials provided by clients
@@ -167,6 +195,13 @@ func NewAuthenticator(cfg config.AgentAuth, tp trace.TracerProvider, logger *log
 		}
 		b.apikey = newApikeyAuth(client, cache)
 	}
+	if len(cfg.StaticAPIKeys) != 0 {
+		staticAPIKey, err := newStaticAPIKeyAuth(cfg.StaticAPIKeys)
+		if err != nil {
+			return nil, err
+		}
+		b.staticAPIKey = staticAPIKey
+	}
 	if cfg.Anonymous.Enabled {
 		b.anonymous = newAnonymousAuth(cfg.Anonymous.AllowAgent, cfg.Anonymous.AllowService)
 	}
@@ -182,9 +217,10 @@ func NewAuthenticator(cfg config.AgentAuth, tp trace.TracerProvider, logger *log
 // may be returned, for example because the server cannot communicate with external
 // systems.
 func (a *Authenticator) Authenticate(ctx context.Context, kind string, token string) (AuthenticationDetails, Authorizer, error) {
-	if a.apikey == nil && a.secretToken == "" {
-		// No auth required, let everyone through.
-		return AuthenticationDetails{Method: MethodNone}, allowAuth{}, nil
+	if a.apikey == nil && a.staticAPIKey == nil && a.secretToken == nil {
+		// No auth required, let everyone through, except
+		// for admin APIs which always require auth.
+		return AuthenticationDetails{Method: MethodNone}, noAuthAuthorizer, nil
 	}
 	switch kind {
 	case "":
@@ -193,6 +229,15 @@ func (a *Authenticator) Authenticate(ctx context.Context, kind string, token str
 		}
 		return AuthenticationDetails{}, nil, errAuthMissing
 	case headers.APIKey:
+		if a.staticAPIKey != nil {
+			details, authz, ok, err := a.staticAPIKey.authenticate(token)
+			if err != nil {
+				return AuthenticationDetails{}, nil, err
+			}
+			if ok {
+				return AuthenticationDetails{Method: MethodAPIKey, APIKey: details}, authz, nil
+			}
+		}
 		if a.apikey != nil {
 			details, authz, err := a.apikey.authenticate(ctx, token)
 			if err != nil {
@@ -201,8 +246,16 @@ func (a *Authenticator) Authenticate(ctx context.Context, kind string, token str
 			return AuthenticationDetails{Method: MethodAPIKey, APIKey: details}, authz, nil
 		}
 	case headers.Bearer:
-		if a.secretToken != "" && subtle.ConstantTimeCompare([]byte(a.secretToken), []byte(token)) == 1 {
-			return AuthenticationDetails{Method: MethodSecretToken}, allowAuth{}, nil
+		if a.secretToken != nil {
+			name, authz, err := a.secretToken.authenticate(ctx, token)
+			if err != nil {
+				return AuthenticationDetails{}, nil, err
+			}
+			details := AuthenticationDetails{Method: MethodSecretToken}
+			if name != "" {
+				details.SecretToken = &SecretTokenAuthenticationDetails{Name: name}
+			}
+			return details, authz, nil
 		}
 	default:
 		return AuthenticationDetails{}, nil, fmt.Errorf(
@@ -31,6 +31,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace/noop"
 
@@ -41,15 +42,20 @@ import (
 )
 
 func TestAuthenticatorNone(t *testing.T) {
-	authenticator, err := NewAuthenticator(config.AgentAuth{}, noop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))
+	authenticator, err := NewAuthenticator(config.AgentAuth{}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
 	require.NoError(t, err)
 
-	// If the server has no configured auth methods, all requests are allowed.
+	// If the server has no configured auth methods, all requests are allowed,
+	// except for admin APIs.
 	for _, kind := range []string{"", headers.APIKey, headers.Bearer} {
 		details, authz, err := authenticator.Authenticate(context.Background(), kind, "")
 		require.NoError(t, err)
 		assert.Equal(t, AuthenticationDetails{Method: MethodNone}, details)
-		assert.Equal(t, allowAuth{}, authz)
+		assert.Equal(t, noAuthAuthorizer, authz)
+		assert.NoError(t, authz.Authorize(context.Background(), ActionEventIngest, Resource{}))
+		err = authz.Authorize(context.Background(), ActionAdmin, Resource{})
+		assert.EqualError(t, err, "unauthorized: admin APIs require auth to be configured")
+		assert.True(t, errors.Is(err, ErrUnauthorized))
 	}
 }
 
@@ -59,7 +65,7 @@ func TestAuthenticatorAuthRequired(t *testing.T) {
 		APIKey: config.APIKeyAgentAuth{Enabled: true, ESConfig: elasticsearch.DefaultConfig()},
 	}
 	for _, cfg := range []config.AgentAuth{withSecretToken, withAPIKey} {
-		authenticator, err := NewAuthenticator(cfg, noop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))
+		authenticator, err := NewAuthenticator(cfg, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
 		require.NoError(t, err)
 
 		details, authz, err := authenticator.Authenticate(context.Background(), "", "")
@@ -79,7 +85,7 @@ func TestAuthenticatorAuthRequired(t *testing.T) {
 }
 
 func TestAuthenticatorSecretToken(t *testing.T) {
-	authenticator, err := NewAuthenticator(config.AgentAuth{SecretToken: "valid"}, noop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))
+	authenticator, err := NewAuthenticator(config.AgentAuth{SecretToken: "valid"}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
 	require.NoError(t, err)
 
 	details, authz, err := authenticator.Authenticate(context.Background(), headers.Bearer, "invalid")
@@ -90,7 +96,10 @@ func TestAuthenticatorSecretToken(t *testing.T) {
 	details, authz, err = authenticator.Authenticate(context.Background(), headers.Bearer, "valid")
 	assert.NoError(t, err)
 	assert.Equal(t, AuthenticationDetails{Method: MethodSecretToken}, details)
-	assert.Equal(t, allowAuth{}, authz)
+	assert.NoError(t, authz.Authorize(context.Background(), ActionEventIngest, Resource{}))
+	err = authz.Authorize(context.Background(), ActionAdmin, Resource{})
+	assert.EqualError(t, err, "unauthorized: secret token not permitted for admin APIs")
+	assert.True(t, errors.Is(err, ErrUnauthorized))
 }
 
 func TestAuthenticatorAPIKey(t *testing.T) {
@@ -117,7 +126,7 @@ func TestAuthenticatorAPIKey(t *testing.T) {
 	esConfig.Hosts = elasticsearch.Hosts{srv.URL}
 	authenticator, err := NewAuthenticator(config.AgentAuth{
 		APIKey: config.APIKeyAgentAuth{Enabled: true, LimitPerMin: 100, ESConfig: esConfig},
-	}, noop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))
+	}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
 	require.NoError(t, err)
 
 	credentials := base64.StdEncoding.EncodeToString([]byte("id_value:key_value"))
@@ -137,7 +146,7 @@ func TestAuthenticatorAPIKey(t *testing.T) {
 	}}, authz)
 
 	assert.Equal(t, "/_security/user/_has_privileges", requestURLPath)
-	assert.Equal(t, `{"application":[{"application":"apm","privileges":["config_agent:read","event:write","sourcemap:write"],"resources":["-"]}]}`, string(requestBody))
+	assert.Equal(t, `{"application":[{"application":"apm","privileges":["config_agent:read","event:write","sourcemap:write","server:admin"],"resources":["-"]}]}`, string(requestBody))
 	assert.Equal(t, "ApiKey "+credentials, requestAuthorizationHeader)
 }
 
@@ -148,7 +157,7 @@ func TestAuthenticatorAPIKeyErrors(t *testing.T) {
 	esConfig.Backoff.Max = time.Nanosecond
 	authenticator, err := NewAuthenticator(config.AgentAuth{
 		APIKey: config.APIKeyAgentAuth{Enabled: true, LimitPerMin: 100, ESConfig: esConfig},
-	}, noop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))
+	}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
 	require.NoError(t, err)
 
 	// Make sure that we can't auth with an empty secret token if secret token auth is not configured, but API Key auth is.
@@ -188,7 +197,7 @@ func TestAuthenticatorAPIKeyErrors(t *testing.T) {
 	esConfig.Hosts = elasticsearch.Hosts{srv.URL}
 	authenticator, err = NewAuthenticator(config.AgentAuth{
 		APIKey: config.APIKeyAgentAuth{Enabled: true, LimitPerMin: 2, ESConfig: esConfig},
-	}, noop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))
+	}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
 	require.NoError(t, err)
 	details, authz, err = authenticator.Authenticate(context.Background(), headers.APIKey, credentials)
 	assert.Equal(t, ErrAuthFailed, err)
@@ -208,7 +217,7 @@ func TestAuthenticatorAPIKeyErrors(t *testing.T) {
 	esConfig.Hosts = elasticsearch.Hosts{srv.URL}
 	authenticator, err = NewAuthenticator(config.AgentAuth{
 		APIKey: config.APIKeyAgentAuth{Enabled: true, LimitPerMin: 100, ESConfig: esConfig},
-	}, noop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))
+	}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
 	require.NoError(t, err)
 	details, authz, err = authenticator.Authenticate(context.Background(), headers.APIKey, credentials)
 	assert.Equal(t, ErrAuthFailed, err)
@@ -249,7 +258,7 @@ func TestAuthenticatorAPIKeyCache(t *testing.T) {
 	esConfig := elasticsearch.DefaultConfig()
 	esConfig.Hosts = elasticsearch.Hosts{srv.URL}
 	apikeyAuthConfig := config.APIKeyAgentAuth{Enabled: true, LimitPerMin: 2, ESConfig: esConfig}
-	authenticator, err := NewAuthenticator(config.AgentAuth{APIKey: apikeyAuthConfig}, tp, logptest.NewTestingLogger(t, ""))
+	authenticator, err := NewAuthenticator(config.AgentAuth{APIKey: apikeyAuthConfig}, tp, metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
 	require.NoError(t, err)
 
 	for i := 0; i < apikeyAuthConfig.LimitPerMin+1; i++ {
@@ -282,17 +291,17 @@ func TestAuthenticatorAnonymous(t *testing.T) {
 	// Anonymous access is only effective when some other auth method is enabled.
 	authenticator, err := NewAuthenticator(config.AgentAuth{
 		Anonymous: config.AnonymousAgentAuth{Enabled: true},
-	}, noop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))
+	}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
 	require.NoError(t, err)
 	details, authz, err := authenticator.Authenticate(context.Background(), "", "")
 	assert.NoError(t, err)
 	assert.Equal(t, AuthenticationDetails{Method: MethodNone}, details)
-	assert.Equal(t, allowAuth{}, authz)
+	assert.Equal(t, noAuthAuthorizer, authz)
 
 	authenticator, err = NewAuthenticator(config.AgentAuth{
 		SecretToken: "secret_token",
 		Anonymous:   config.AnonymousAgentAuth{Enabled: true},
-	}, noop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))
+	}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
 	require.NoError(t, err)
 	details, authz, err = authenticator.Authenticate(context.Background(), "", "")
 	assert.NoError(t, err)
@@ -49,3 +49,17 @@ func Authorize(ctx context.Context, action Action, resource Resource) error {
 	}
 	return auth.Authorize(ctx, action, resource)
 }
+
+type authenticationDetailsKey struct{}
+
+// ContextWithAuthenticationDetails returns a copy of parent associated with details.
+func ContextWithAuthenticationDetails(parent context.Context, details AuthenticationDetails) context.Context {
+	return context.WithValue(parent, authenticationDetailsKey{}, details)
+}
+
+// AuthenticationDetailsFromContext returns the AuthenticationDetails stored in ctx,
+// if any, and a boolean indicating whether they were found.
+func AuthenticationDetailsFromContext(ctx context.Context) (AuthenticationDetails, bool) {
+	details, ok := ctx.Value(authenticationDetailsKey{}).(AuthenticationDetails)
+	return details, ok
+}
@@ -0,0 +1,265 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// secretTokensFileCheckInterval is the minimum interval between
+// checks for modifications to the secret tokens file.
+const secretTokensFileCheckInterval = 10 * time.Second
+
+// secretTokenAuth authenticates clients with one of a set of secret tokens.
+//
+// The legacy unnamed secret token, if configured, is included in the set
+// with an empty name and privileges for all actions other than ActionAdmin.
+// Named tokens may be restricted by action, agent, and service, and may have
+// an expiry. Secret tokens are only authorized for ActionAdmin if "admin" is
+// explicitly listed in the token's allowed actions.
+type secretTokenAuth struct {
+	logger        *logp.Logger
+	authenticated metric.Int64Counter
+	static        []secretToken
+	now           func() time.Time
+
+	file          string
+	fileMu        sync.Mutex
+	fileModTime   time.Time
+	fileLastCheck time.Time
+
+	// tokens holds the static tokens combined with those loaded
+	// from file, and is replaced atomically when the file changes.
+	tokens atomic.Pointer[[]secretToken]
+}
+
+type secretToken struct {
+	name       string
+	value      []byte
+	expires    time.Time
+	authorizer Authorizer
+}
+
+func newSecretTokenAuth(cfg config.AgentAuth, mp metric.MeterProvider, logger *logp.Logger) (*secretTokenAuth, error) {
+	meter := mp.Meter("github.com/elastic/apm-server/internal/beater/auth")
+	authenticated, err := meter.Int64Counter("apm-server.auth.secret_token.authenticated")
+	if err != nil {
+		return nil, err
+	}
+	a := &secretTokenAuth{
+		logger:        logger,
+		authenticated: authenticated,
+		file:          cfg.SecretTokensFile,
+		now:           time.Now,
+	}
+	if cfg.SecretToken != "" {
+		a.static = append(a.static, secretToken{
+			value:      []byte(cfg.SecretToken),
+			authorizer: nonAdminAuth{reason: "secret token not permitted for admin APIs"},
+		})
+	}
+	for _, tokenConfig := range cfg.SecretTokens {
+		a.static = append(a.static, newSecretToken(tokenConfig))
+	}
+	if a.file == "" {
+		if err := a.setTokens(nil); err != nil {
+			return nil, err
+		}
+		return a, nil
+	}
+	if err := a.loadFile(); err != nil {
+		return nil, fmt.Errorf("error loading secret tokens file: %w", err)
+	}
+	return a, nil
+}
+
+func newSecretToken(cfg config.SecretTokenAgentAuth) secretToken {
+	token := secretToken{
+		name:       cfg.Name,
+		value:      []byte(cfg.Value),
+		expires:    cfg.ExpiresParsed,
+		authorizer: nonAdminAuth{reason: fmt.Sprintf("secret token %q not permitted for admin APIs", cfg.Name)},
+	}
+	if len(cfg.AllowActions) != 0 || len(cfg.AllowAgent) != 0 || len(cfg.AllowService) != 0 {
+		token.authorizer = newSecretTokenAuthorizer(cfg)
+	}
+	return token
+}
+
+// setTokens combines the static tokens with fileTokens, and atomically
+// replaces the token set. An error is returned if token names are not
+// unique, in which case the existing token set is left unmodified.
+func (a *secretTokenAuth) setTokens(fileTokens []config.SecretTokenAgentAuth) error {
+	tokens := make([]secretToken, 0, len(a.static)+len(fileTokens))
+	tokens = append(tokens, a.static...)
+	for _, tokenConfig := range fileTokens {
+		tokens = append(tokens, newSecretToken(tokenConfig))
+	}
+	names := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		if token.name == "" {
+			continue
+		}
+		if names[token.name] {
+			return fmt.Errorf("duplicate secret token name %q", token.name)
+		}
+		names[token.name] = true
+	}
+	a.tokens.Store(&tokens)
+	return nil
+}
+
+// loadFile loads tokens from the secret tokens file, if it has been
+// modified since it was last loaded.
+func (a *secretTokenAuth) loadFile() error {
+	info, err := os.Stat(a.file)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(a.fileModTime) {
+		return nil
+	}
+	fileTokens, err := config.LoadSecretTokensFile(a.file)
+	if err != nil {
+		return err
+	}
+	if err := a.setTokens(fileTokens); err != nil {
+		return err
+	}
+	a.fileModTime = info.ModTime()
+	a.logger.Infof("loaded %d secret tokens from %s", len(fileTokens), a.file)
+	return nil
+}
+
+// maybeReloadFile reloads the secret tokens file if it has not been checked
+// within secretTokensFileCheckInterval. Errors are logged, and the previously
+// loaded tokens remain in effect.
+func (a *secretTokenAuth) maybeReloadFile() {
+	if a.file == "" {
+		return
+	}
+	if !a.fileMu.TryLock() {
+		// Another goroutine is checking the file.
+		return
+	}
+	defer a.fileMu.Unlock()
+	now := a.now()
+	if now.Sub(a.fileLastCheck) < secretTokensFileCheckInterval {
+		return
+	}
+	a.fileLastCheck = now
+	if err := a.loadFile(); err != nil {
+		a.logger.With(logp.Error(err)).Errorf("failed to reload secret tokens from %s", a.file)
+	}
+}
+
+// authenticate checks token against each of the secret tokens, returning the
+// matching token's name and Authorizer. The token name is empty for the legacy
+// unnamed secret token.
+func (a *secretTokenAuth) authenticate(ctx context.Context, token string) (string, Authorizer, error) {
+	a.maybeReloadFile()
+	now := a.now()
+	var match *secretToken
+	tokens := *a.tokens.Load()
+	for i := range tokens {
+		// Compare against all tokens to avoid leaking timing information.
+		if subtle.ConstantTimeCompare(tokens[i].value, []byte(token)) == 1 && match == nil {
+			match = &tokens[i]
+		}
+	}
+	if match == nil {
+		return "", nil, ErrAuthFailed
+	}
+	if !match.expires.IsZero() && !now.Before(match.expires) {
+		return "", nil, fmt.Errorf("%w: secret token %q expired", ErrAuthFailed, match.name)
+	}
+	if match.name != "" {
+		a.authenticated.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("secret_token.name", match.name),
+		))
+	}
+	return match.name, match.authorizer, nil
+}
+
+// secretTokenAuthorizer implements the Authorizer interface, restricting
+// the actions, agents, and services permitted for a named secret token.
+type secretTokenAuthorizer struct {
+	name            string
+	allowedActions  map[Action]bool
+	allowedAgents   map[string]bool
+	allowedServices map[string]bool
+}
+
+func newSecretTokenAuthorizer(cfg config.SecretTokenAgentAuth) *secretTokenAuthorizer {
+	a := &secretTokenAuthorizer{
+		name:            cfg.Name,
+		allowedActions:  make(map[Action]bool),
+		allowedAgents:   make(map[string]bool),
+		allowedServices: make(map[string]bool),
+	}
+	for _, action := range cfg.AllowActions {
+		a.allowedActions[Action(action)] = true
+	}
+	for _, name := range cfg.AllowAgent {
+		a.allowedAgents[name] = true
+	}
+	for _, name := range cfg.AllowService {
+		a.allowedServices[name] = true
+	}
+	return a
+}
+
+// Authorize checks if the secret token is authorized for the given action and resource.
+func (a *secretTokenAuthorizer) Authorize(ctx context.Context, action Action, resource Resource) error {
+	switch action {
+	case ActionAgentConfig, ActionEventIngest, ActionSourcemapUpload, ActionAdmin:
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+	// Admin APIs must be explicitly allowed.
+	if (len(a.allowedActions) != 0 || action == ActionAdmin) && !a.allowedActions[action] {
+		return fmt.Errorf("%w: secret token %q not permitted action %q", ErrUnauthorized, a.name, action)
+	}
+	if len(a.allowedServices) != 0 && !a.allowedServices[resource.ServiceName] {
+		return fmt.Errorf(
+			"%w: secret token %q not permitted for service %q",
+			ErrUnauthorized, a.name, resource.ServiceName,
+		)
+	}
+	// Agent config queries do not provide an agent name,
+	// so agent restrictions apply only to event ingestion.
+	if action == ActionEventIngest && len(a.allowedAgents) != 0 && !a.allowedAgents[resource.AgentName] {
+		return fmt.Errorf(
+			"%w: secret token %q not permitted for agent %q",
+			ErrUnauthorized, a.name, resource.AgentName,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestAuthenticatorNamedSecretTokens(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	authenticator, err := NewAuthenticator(config.AgentAuth{
+		SecretToken: "legacy",
+		SecretTokens: []config.SecretTokenAgentAuth{
+			{Name: "old", Value: "old_value", ExpiresParsed: time.Now().Add(-time.Minute)},
+			{Name: "new", Value: "new_value", ExpiresParsed: time.Now().Add(time.Hour)},
+			{Name: "restricted", Value: "restricted_value", AllowActions: []string{"event_ingest"}},
+			{Name: "admin", Value: "admin_value", AllowActions: []string{"admin"}},
+			{Name: "service", Value: "service_value", AllowService: []string{"opbeans"}},
+		},
+	}, noop.NewTracerProvider(), mp, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+
+	details, authz, err := authenticator.Authenticate(context.Background(), headers.Bearer, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, AuthenticationDetails{Method: MethodSecretToken}, details)
+	assert.Equal(t, nonAdminAuth{reason: "secret token not permitted for admin APIs"}, authz)
+
+	details, authz, err = authenticator.Authenticate(context.Background(), headers.Bearer, "new_value")
+	require.NoError(t, err)
+	assert.Equal(t, AuthenticationDetails{
+		Method:      MethodSecretToken,
+		SecretToken: &SecretTokenAuthenticationDetails{Name: "new"},
+	}, details)
+	assert.NoError(t, authz.Authorize(context.Background(), ActionAgentConfig, Resource{}))
+	err = authz.Authorize(context.Background(), ActionAdmin, Resource{})
+	assert.EqualError(t, err, `unauthorized: secret token "new" not permitted for admin APIs`)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+
+	details, authz, err = authenticator.Authenticate(context.Background(), headers.Bearer, "old_value")
+	assert.EqualError(t, err, `authentication failed: secret token "old" expired`)
+	assert.True(t, errors.Is(err, ErrAuthFailed))
+	assert.Zero(t, details)
+	assert.Nil(t, authz)
+
+	details, authz, err = authenticator.Authenticate(context.Background(), headers.Bearer, "restricted_value")
+	require.NoError(t, err)
+	assert.Equal(t, "restricted", details.SecretToken.Name)
+	assert.NoError(t, authz.Authorize(context.Background(), ActionEventIngest, Resource{}))
+	err = authz.Authorize(context.Background(), ActionAgentConfig, Resource{})
+	assert.EqualError(t, err, `unauthorized: secret token "restricted" not permitted action "agent_config"`)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+	err = authz.Authorize(context.Background(), ActionAdmin, Resource{})
+	assert.EqualError(t, err, `unauthorized: secret token "restricted" not permitted action "admin"`)
+
+	// Secret tokens are only authorized for admin APIs when explicitly allowed.
+	_, authz, err = authenticator.Authenticate(context.Background(), headers.Bearer, "admin_value")
+	require.NoError(t, err)
+	assert.NoError(t, authz.Authorize(context.Background(), ActionAdmin, Resource{}))
+	assert.Error(t, authz.Authorize(context.Background(), ActionEventIngest, Resource{}))
+
+	_, authz, err = authenticator.Authenticate(context.Background(), headers.Bearer, "service_value")
+	require.NoError(t, err)
+	assert.NoError(t, authz.Authorize(context.Background(), ActionEventIngest, Resource{ServiceName: "opbeans"}))
+	err = authz.Authorize(context.Background(), ActionAdmin, Resource{})
+	assert.EqualError(t, err, `unauthorized: secret token "service" not permitted action "admin"`)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	require.Len(t, rm.ScopeMetrics[0].Metrics, 1)
+	metric := rm.ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, "apm-server.auth.secret_token.authenticated", metric.Name)
+	counts := make(map[string]int64)
+	for _, dp := range metric.Data.(metricdata.Sum[int64]).DataPoints {
+		name, _ := dp.Attributes.Value("secret_token.name")
+		counts[name.AsString()] = dp.Value
+	}
+	assert.Equal(t, map[string]int64{"new": 1, "restricted": 1, "admin": 1, "service": 1}, counts)
+}
+
+func TestAuthenticatorSecretTokensDuplicateName(t *testing.T) {
+	_, err := NewAuthenticator(config.AgentAuth{
+		SecretTokens: []config.SecretTokenAgentAuth{
+			{Name: "name", Value: "value1"},
+			{Name: "name", Value: "value2"},
+		},
+	}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
+	assert.EqualError(t, err, `duplicate secret token name "name"`)
+}
+
+func TestAuthenticatorSecretTokensFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret_tokens.yml")
+	writeFile := func(content string, modTime time.Time) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	writeFile(`secret_tokens: [{name: one, value: value1}]`, time.Unix(1, 0))
+
+	authenticator, err := NewAuthenticator(config.AgentAuth{
+		SecretTokens:     []config.SecretTokenAgentAuth{{Name: "static", Value: "static_value"}},
+		SecretTokensFile: path,
+	}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	now := time.Now()
+	authenticator.secretToken.now = func() time.Time { return now }
+
+	authenticate := func(token string) (string, error) {
+		details, _, err := authenticator.Authenticate(context.Background(), headers.Bearer, token)
+		if err != nil {
+			return "", err
+		}
+		return details.SecretToken.Name, nil
+	}
+	name, err := authenticate("value1")
+	require.NoError(t, err)
+	assert.Equal(t, "one", name)
+
+	// Rotate the token in the file. The file is not checked again
+	// until secretTokensFileCheckInterval has elapsed.
+	writeFile(`secret_tokens: [{name: one, value: value1}, {name: two, value: value2}]`, time.Unix(2, 0))
+	_, err = authenticate("value2")
+	assert.Equal(t, ErrAuthFailed, err)
+
+	now = now.Add(secretTokensFileCheckInterval)
+	name, err = authenticate("value2")
+	require.NoError(t, err)
+	assert.Equal(t, "two", name)
+	name, err = authenticate("static_value")
+	require.NoError(t, err)
+	assert.Equal(t, "static", name)
+
+	// Invalid file contents are ignored, and the previous tokens remain in effect.
+	writeFile(`secret_tokens: [{name: static, value: value3}]`, time.Unix(3, 0))
+	now = now.Add(secretTokensFileCheckInterval)
+	_, err = authenticate("value3")
+	assert.Equal(t, ErrAuthFailed, err)
+	name, err = authenticate("value2")
+	require.NoError(t, err)
+	assert.Equal(t, "two", name)
+}
+
+func TestSecretTokenAuthorizer(t *testing.T) {
+	authz := newSecretTokenAuthorizer(config.SecretTokenAgentAuth{
+		Name:         "name",
+		AllowAgent:   []string{"java"},
+		AllowService: []string{"opbeans"},
+	})
+	for _, action := range []Action{ActionEventIngest, ActionAgentConfig, ActionSourcemapUpload} {
+		assert.NoError(t, authz.Authorize(context.Background(), action, Resource{AgentName: "java", ServiceName: "opbeans"}))
+		err := authz.Authorize(context.Background(), action, Resource{AgentName: "java", ServiceName: "other"})
+		assert.EqualError(t, err, `unauthorized: secret token "name" not permitted for service "other"`)
+	}
+
+	// Agent restrictions only apply to event ingestion.
+	err := authz.Authorize(context.Background(), ActionEventIngest, Resource{AgentName: "go", ServiceName: "opbeans"})
+	assert.EqualError(t, err, `unauthorized: secret token "name" not permitted for agent "go"`)
+	assert.NoError(t, authz.Authorize(context.Background(), ActionAgentConfig, Resource{ServiceName: "opbeans"}))
+
+	err = authz.Authorize(context.Background(), "unknown", Resource{})
+	assert.EqualError(t, err, `unknown action "unknown"`)
+}
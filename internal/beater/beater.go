@@ -26,8 +26,10 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"reflect"
 	"runtime"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/cespare/xxhash/v2"
@@ -61,18 +63,22 @@ import (
 	"github.com/elastic/go-docappender/v2"
 	"github.com/elastic/go-ucfg"
 
+	"github.com/elastic/apm-data/input"
 	"github.com/elastic/apm-data/model/modelpb"
 	"github.com/elastic/apm-data/model/modelprocessor"
 
 	"github.com/elastic/apm-server/internal/agentcfg"
+	"github.com/elastic/apm-server/internal/beater/audit"
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/beater/interceptors"
+	"github.com/elastic/apm-server/internal/beater/loadshed"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/elasticsearch"
 	"github.com/elastic/apm-server/internal/fips140"
 	"github.com/elastic/apm-server/internal/kibana"
 	srvmodelprocessor "github.com/elastic/apm-server/internal/model/modelprocessor"
+	"github.com/elastic/apm-server/internal/netutil"
 	"github.com/elastic/apm-server/internal/publish"
 	"github.com/elastic/apm-server/internal/sourcemap"
 	"github.com/elastic/apm-server/internal/version"
@@ -95,6 +101,13 @@ type Runner struct {
 	beatMonitoring beatmonitoring.Monitoring
 	listener       net.Listener
 	statusReporter status.StatusReporter
+
+	// mu protects the fields below, which are used by UpdateConfig.
+	mu sync.Mutex
+	// currentConfig holds the raw configuration most recently applied,
+	// either by NewRunner or by UpdateConfig.
+	currentConfig          *agentconfig.C
+	updateSamplingPolicies func(config.TailSamplingConfig) error
 }
 
 // RunnerParams holds parameters for NewRunner.
@@ -140,28 +153,10 @@ func NewRunner(args RunnerParams) (*Runner, error) {
 	// Close the default tracer since it's not used.
 	apm.DefaultTracer().Close()
 
-	var unpackedConfig struct {
-		APMServer  *agentconfig.C        `config:"apm-server"`
-		Output     agentconfig.Namespace `config:"output"`
-		DataStream struct {
-			Namespace string `config:"namespace"`
-		} `config:"data_stream"`
-	}
-	if err := args.Config.Unpack(&unpackedConfig); err != nil {
-		return nil, err
-	}
-
-	var elasticsearchOutputConfig *agentconfig.C
-	if unpackedConfig.Output.Name() == "elasticsearch" {
-		elasticsearchOutputConfig = unpackedConfig.Output.Config()
-	}
-	cfg, err := config.NewConfig(unpackedConfig.APMServer, elasticsearchOutputConfig, args.Logger)
+	cfg, outputConfig, elasticsearchOutputConfig, err := newConfig(args.Config, args.Logger)
 	if err != nil {
 		return nil, err
 	}
-	if unpackedConfig.DataStream.Namespace != "" {
-		cfg.DataStreams.Namespace = unpackedConfig.DataStream.Namespace
-	}
 
 	// We start the listener in the constructor, before Run is invoked,
 	// to ensure zero downtime while any existing Runner is stopped.
@@ -176,8 +171,9 @@ func NewRunner(args RunnerParams) (*Runner, error) {
 		rawConfig:  args.Config,
 
 		config:                    cfg,
-		outputConfig:              unpackedConfig.Output,
+		outputConfig:              outputConfig,
 		elasticsearchOutputConfig: elasticsearchOutputConfig,
+		currentConfig:             args.Config,
 
 		tracerProvider: args.TracerProvider,
 		meterProvider:  args.MeterProvider,
@@ -188,6 +184,137 @@ func NewRunner(args RunnerParams) (*Runner, error) {
 	}, nil
 }
 
+// autosizeAggregation sets the aggregation limits which are not explicitly
+// configured, i.e. which are <= 0, based on the memory limit.
+func autosizeAggregation(cfg *config.AggregationConfig, memLimitGB float64, memWatcher *memoryLimitWatcher, logger *logp.Logger) {
+	if cfg.MaxServices <= 0 {
+		cfg.MaxServices = linearScaledValue(1_000, memLimitGB, 0)
+		logger.Infof("Aggregation.MaxServices set to %d based on %0.1fgb of memory",
+			cfg.MaxServices, memLimitGB,
+		)
+		memWatcher.setSetting("aggregation.max_services", cfg.MaxServices)
+	}
+
+	if cfg.ServiceTransactions.MaxGroups <= 0 {
+		cfg.ServiceTransactions.MaxGroups = linearScaledValue(1_000, memLimitGB, 0)
+		logger.Infof("Aggregation.ServiceTransactions.MaxGroups for service aggregation set to %d based on %0.1fgb of memory",
+			cfg.ServiceTransactions.MaxGroups, memLimitGB,
+		)
+		memWatcher.setSetting("aggregation.service_transactions.max_groups", cfg.ServiceTransactions.MaxGroups)
+	}
+
+	if cfg.Transactions.MaxGroups <= 0 {
+		cfg.Transactions.MaxGroups = linearScaledValue(5_000, memLimitGB, 0)
+		logger.Infof("Aggregation.Transactions.MaxGroups set to %d based on %0.1fgb of memory",
+			cfg.Transactions.MaxGroups, memLimitGB,
+		)
+		memWatcher.setSetting("aggregation.transactions.max_groups", cfg.Transactions.MaxGroups)
+	}
+
+	if cfg.ServiceDestinations.MaxGroups <= 0 {
+		cfg.ServiceDestinations.MaxGroups = linearScaledValue(5_000, memLimitGB, 5_000)
+		logger.Infof("Aggregation.ServiceDestinations.MaxGroups set to %d based on %0.1fgb of memory",
+			cfg.ServiceDestinations.MaxGroups, memLimitGB,
+		)
+		memWatcher.setSetting("aggregation.service_destinations.max_groups", cfg.ServiceDestinations.MaxGroups)
+	}
+}
+
+// newConfig unpacks the full, raw, configuration, returning the APM Server
+// configuration, the output configuration, and the Elasticsearch output
+// configuration if the output is Elasticsearch.
+func newConfig(rawConfig *agentconfig.C, logger *logp.Logger) (
+	*config.Config, agentconfig.Namespace, *agentconfig.C, error,
+) {
+	var unpackedConfig struct {
+		APMServer  *agentconfig.C        `config:"apm-server"`
+		Output     agentconfig.Namespace `config:"output"`
+		DataStream struct {
+			Namespace string `config:"namespace"`
+		} `config:"data_stream"`
+	}
+	if err := rawConfig.Unpack(&unpackedConfig); err != nil {
+		return nil, agentconfig.Namespace{}, nil, err
+	}
+
+	var elasticsearchOutputConfig *agentconfig.C
+	if unpackedConfig.Output.Name() == "elasticsearch" {
+		elasticsearchOutputConfig = unpackedConfig.Output.Config()
+	}
+	cfg, err := config.NewConfig(unpackedConfig.APMServer, elasticsearchOutputConfig, logger)
+	if err != nil {
+		return nil, agentconfig.Namespace{}, nil, err
+	}
+	if unpackedConfig.DataStream.Namespace != "" {
+		cfg.DataStreams.Namespace = unpackedConfig.DataStream.Namespace
+	}
+	return cfg, unpackedConfig.Output, elasticsearchOutputConfig, nil
+}
+
+// samplingPolicySettings holds the paths of raw configuration settings which
+// UpdateConfig may update without restarting the server. This includes the
+// revision of configuration received from Elastic Agent, which is incremented
+// for every change.
+var samplingPolicySettings = []string{
+	"revision",
+	"apm-server.sampling.tail.policies",
+	"apm-server.sampling.tail.shadow_policies",
+}
+
+// UpdateConfig updates the running server's configuration in place, if the
+// full, raw, configuration cfg differs from the current configuration only
+// in tail-sampling policies. This avoids restarting the server, losing the
+// in-memory state of tail-sampling.
+//
+// UpdateConfig returns false if the configuration cannot be updated in place,
+// in which case the Runner should be replaced.
+func (s *Runner) UpdateConfig(cfg *agentconfig.C) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.updateSamplingPolicies == nil {
+		return false, nil
+	}
+	currentSettings, err := withoutSettings(s.currentConfig, samplingPolicySettings)
+	if err != nil {
+		return false, err
+	}
+	updatedSettings, err := withoutSettings(cfg, samplingPolicySettings)
+	if err != nil {
+		return false, err
+	}
+	if !reflect.DeepEqual(currentSettings, updatedSettings) {
+		return false, nil
+	}
+	updatedConfig, _, _, err := newConfig(cfg, s.logger)
+	if err != nil {
+		return false, err
+	}
+	if err := s.updateSamplingPolicies(updatedConfig.Sampling.Tail); err != nil {
+		return false, err
+	}
+	s.currentConfig = cfg
+	return true, nil
+}
+
+// withoutSettings returns the raw configuration as a map, excluding the
+// settings with the given paths.
+func withoutSettings(cfg *agentconfig.C, paths []string) (map[string]interface{}, error) {
+	// Copy the configuration, so as not to modify it.
+	copied, err := agentconfig.MergeConfigs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		// Errors indicate the setting does not exist.
+		_, _ = copied.Remove(path, -1)
+	}
+	var m map[string]interface{}
+	if err := copied.Unpack(&m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Run runs the server, blocking until ctx is cancelled.
 func (s *Runner) Run(ctx context.Context) error {
 	defer s.listener.Close()
@@ -219,46 +346,37 @@ func (s *Runner) Run(ctx context.Context) error {
 		}
 	}
 
-	memLimitGB := processMemoryLimit(
-		newCgroupReader(),
-		sysMemoryReaderFunc(systemMemoryLimit),
-		s.logger,
-	)
+	cgroups := newCgroupReader()
+	sysMemory := sysMemoryReaderFunc(systemMemoryLimit)
+	memLimitGB := processMemoryLimit(cgroups, sysMemory, s.logger)
 
-	if s.config.MaxConcurrentDecoders == 0 {
-		s.config.MaxConcurrentDecoders = maxConcurrentDecoders(memLimitGB)
-		s.logger.Infof("MaxConcurrentDecoders set to %d based on 80 percent of %0.1fgb of memory",
-			s.config.MaxConcurrentDecoders, memLimitGB,
-		)
-	}
-
-	if s.config.Aggregation.MaxServices <= 0 {
-		s.config.Aggregation.MaxServices = linearScaledValue(1_000, memLimitGB, 0)
-		s.logger.Infof("Aggregation.MaxServices set to %d based on %0.1fgb of memory",
-			s.config.Aggregation.MaxServices, memLimitGB,
-		)
-	}
-
-	if s.config.Aggregation.ServiceTransactions.MaxGroups <= 0 {
-		s.config.Aggregation.ServiceTransactions.MaxGroups = linearScaledValue(1_000, memLimitGB, 0)
-		s.logger.Infof("Aggregation.ServiceTransactions.MaxGroups for service aggregation set to %d based on %0.1fgb of memory",
-			s.config.Aggregation.ServiceTransactions.MaxGroups, memLimitGB,
+	var memWatcher *memoryLimitWatcher
+	if s.config.MemoryAutotune.Enabled {
+		watcher, err := newMemoryLimitWatcher(
+			cgroups, sysMemory, memLimitGB,
+			s.config.MemoryAutotune.Interval,
+			s.meterProvider, s.logger,
 		)
+		if err != nil {
+			return err
+		}
+		memWatcher = watcher
+		g.Go(func() error {
+			return watcher.Run(ctx)
+		})
 	}
 
-	if s.config.Aggregation.Transactions.MaxGroups <= 0 {
-		s.config.Aggregation.Transactions.MaxGroups = linearScaledValue(5_000, memLimitGB, 0)
-		s.logger.Infof("Aggregation.Transactions.MaxGroups set to %d based on %0.1fgb of memory",
-			s.config.Aggregation.Transactions.MaxGroups, memLimitGB,
+	autotuneDecoders := s.config.MaxConcurrentDecoders == 0
+	if autotuneDecoders {
+		s.config.MaxConcurrentDecoders = maxConcurrentDecoders(memLimitGB)
+		s.logger.Infof("MaxConcurrentDecoders set to %d based on 80 percent of %0.1fgb of memory",
+			s.config.MaxConcurrentDecoders, memLimitGB,
 		)
+		memWatcher.setSetting("max_concurrent_decoders", int(s.config.MaxConcurrentDecoders))
 	}
 
-	if s.config.Aggregation.ServiceDestinations.MaxGroups <= 0 {
-		s.config.Aggregation.ServiceDestinations.MaxGroups = linearScaledValue(5_000, memLimitGB, 5_000)
-		s.logger.Infof("Aggregation.ServiceDestinations.MaxGroups set to %d based on %0.1fgb of memory",
-			s.config.Aggregation.ServiceDestinations.MaxGroups, memLimitGB,
-		)
-	}
+	configuredAggregation := s.config.Aggregation
+	autosizeAggregation(&s.config.Aggregation, memLimitGB, memWatcher, s.logger)
 
 	if s.config.Sampling.Tail.Enabled && s.config.Sampling.Tail.DatabaseCacheSize == 0 {
 		// 1GB=16MB, 2GB=24MB, 4GB=40MB, ..., 32GB=264MB, 64GB=520MB
@@ -266,6 +384,18 @@ func (s *Runner) Run(ctx context.Context) error {
 		s.logger.Infof("Sampling.Tail.DatabaseCacheSize set to %d based on %0.1fgb of memory",
 			s.config.Sampling.Tail.DatabaseCacheSize, memLimitGB,
 		)
+		memWatcher.setSetting("sampling.tail.database_cache_size", int(s.config.Sampling.Tail.DatabaseCacheSize))
+
+		// Pebble's block cache is fixed when the database is opened, and the
+		// database is shared by successive servers, so it cannot be resized
+		// without reopening the database.
+		memWatcher.onChange(func(_ context.Context, memLimitGB float64) error {
+			s.logger.Infof(
+				"tail-sampling database cache size will not be resized "+
+					"for %0.1fgb of memory until restart", memLimitGB,
+			)
+			return nil
+		})
 	}
 
 	// Send config to telemetry.
@@ -363,10 +493,20 @@ func (s *Runner) Run(ctx context.Context) error {
 	// Create the runServer function. We start with newBaseRunServer, and then
 	// wrap depending on the configuration in order to inject behaviour.
 	runServer := newBaseRunServer(s.listener)
-	authenticator, err := auth.NewAuthenticator(s.config.AgentAuth, s.tracerProvider, s.logger)
+	authenticator, err := auth.NewAuthenticator(s.config.AgentAuth, s.tracerProvider, s.meterProvider, s.logger)
+	if err != nil {
+		return err
+	}
+
+	auditor, err := s.newAuditor(newElasticsearchClient, memLimitGB)
 	if err != nil {
 		return err
 	}
+	if auditor != nil {
+		g.Go(func() error {
+			return auditor.Run(ctx)
+		})
+	}
 
 	ratelimitStore, err := ratelimit.NewStore(
 		s.config.AgentAuth.Anonymous.RateLimit.IPLimit,
@@ -376,6 +516,52 @@ func (s *Runner) Run(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	quotas, err := newQuotas(s.config.AgentAuth.Quotas, s.meterProvider)
+	if err != nil {
+		return err
+	}
+	trustedProxies, err := netutil.ParseTrustedProxies(s.config.TrustedProxies, s.config.TrustedProxiesHeader)
+	if err != nil {
+		return err
+	}
+	var intakeSemaphore input.Semaphore = semaphore.NewWeighted(int64(s.config.MaxConcurrentDecoders))
+	if autotuneDecoders && memWatcher != nil {
+		decoders := newResizableSemaphore(int64(s.config.MaxConcurrentDecoders), maxDecoders)
+		memWatcher.onChange(func(_ context.Context, memLimitGB float64) error {
+			n := maxConcurrentDecoders(memLimitGB)
+			decoders.resize(int64(n))
+			s.logger.Infof("MaxConcurrentDecoders set to %d based on 80 percent of %0.1fgb of memory", n, memLimitGB)
+			memWatcher.setSetting("max_concurrent_decoders", int(n))
+			return nil
+		})
+		intakeSemaphore = decoders
+	}
+	var loadShedding *loadshed.Controller
+	var outputQueue *loadshed.Queue
+	if s.config.LoadShedding.Enabled {
+		loadSheddingSemaphore := loadshed.NewSemaphore(intakeSemaphore)
+		intakeSemaphore = loadSheddingSemaphore
+		outputQueue = loadshed.NewQueue()
+		loadShedding, err = loadshed.NewController(
+			s.config.LoadShedding, outputQueue, loadSheddingSemaphore,
+			uint64(memLimitGB*(1<<30)), s.meterProvider,
+		)
+		if err != nil {
+			return err
+		}
+		g.Go(func() error {
+			return loadShedding.Run(ctx)
+		})
+		memWatcher.onChange(func(_ context.Context, memLimitGB float64) error {
+			loadShedding.SetMemoryLimit(uint64(memLimitGB * (1 << 30)))
+			return nil
+		})
+	}
+	// All gRPC services are OTLP services.
+	otlpIPFilter, err := s.config.IPFilter.OTLP.Filter()
+	if err != nil {
+		return err
+	}
 
 	// Note that we intentionally do not use a grpc.Creds ServerOption
 	// even if TLS is enabled, as TLS is handled by the net/http server.
@@ -383,18 +569,20 @@ func (s *Runner) Run(ctx context.Context) error {
 	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
 		interceptors.Tracing(s.tracerProvider),
 		interceptors.Recover(),
-		interceptors.ClientMetadata(),
+		interceptors.ClientMetadata(trustedProxies),
 		interceptors.Logging(gRPCLogger),
 		interceptors.Metrics(gRPCLogger, s.meterProvider),
 		interceptors.Timeout(),
-		interceptors.Auth(authenticator),
+		interceptors.IPFilter(otlpIPFilter),
+		interceptors.LoadShedding(loadShedding),
+		interceptors.Auth(authenticator, auditor),
 		interceptors.AnonymousRateLimit(ratelimitStore),
 	))
 
 	// Create the BatchProcessor chain that is used to process all events,
 	// including the metrics aggregated by APM Server.
 	finalBatchProcessor, closeFinalBatchProcessor, err := s.newFinalBatchProcessor(
-		tracer, newElasticsearchClient, memLimitGB, s.logger, s.tracerProvider, s.meterProvider,
+		tracer, newElasticsearchClient, memLimitGB, outputQueue, memWatcher, s.logger, s.tracerProvider, s.meterProvider,
 	)
 	if err != nil {
 		return err
@@ -459,6 +647,8 @@ func (s *Runner) Run(ctx context.Context) error {
 		TracerProvider:         s.tracerProvider,
 		MeterProvider:          s.meterProvider,
 		Authenticator:          authenticator,
+		Auditor:                auditor,
+		LoadShedding:           loadShedding,
 		RateLimitStore:         ratelimitStore,
 		BatchProcessor:         batchProcessor,
 		AgentConfig:            agentConfigReporter,
@@ -467,7 +657,7 @@ func (s *Runner) Run(ctx context.Context) error {
 		KibanaClient:           kibanaClient,
 		NewElasticsearchClient: newElasticsearchClient,
 		GRPCServer:             grpcServer,
-		Semaphore:              semaphore.NewWeighted(int64(s.config.MaxConcurrentDecoders)),
+		Semaphore:              intakeSemaphore,
 		BeatMonitoring:         s.beatMonitoring,
 		StatusReporter:         s.statusReporter,
 	}
@@ -479,6 +669,20 @@ func (s *Runner) Run(ctx context.Context) error {
 			return err
 		}
 	}
+	s.mu.Lock()
+	s.updateSamplingPolicies = serverParams.UpdateSamplingPolicies
+	s.mu.Unlock()
+	if serverParams.ResizeAggregation != nil && configuredAggregation != s.config.Aggregation {
+		// Resize the automatically sized aggregation limits.
+		memWatcher.onChange(func(ctx context.Context, memLimitGB float64) error {
+			cfg := configuredAggregation
+			autosizeAggregation(&cfg, memLimitGB, memWatcher, s.logger)
+			if err := serverParams.ResizeAggregation(ctx, cfg); err != nil {
+				return fmt.Errorf("failed to resize aggregation limits: %w", err)
+			}
+			return nil
+		})
+	}
 
 	// Add pre-processing batch processors to the beginning of the chain,
 	// applying only to the events that are decoded from agent/client payloads.
@@ -486,8 +690,9 @@ func (s *Runner) Run(ctx context.Context) error {
 		// Add a model processor that rate limits, and checks authorization for the
 		// agent and service for each event. These must come at the beginning of the
 		// processor chain.
-		modelpb.ProcessBatchFunc(rateLimitBatchProcessor),
+		newRateLimitBatchProcessor(quotas),
 		modelpb.ProcessBatchFunc(authorizeEventIngestProcessor),
+		newAuditBatchProcessor(auditor),
 
 		// Add a model processor that removes `event.received`, which is added by
 		// apm-data, but which we don't yet map.
@@ -509,6 +714,16 @@ func (s *Runner) Run(ctx context.Context) error {
 			DefaultServiceEnvironment: s.config.DefaultServiceEnvironment,
 		})
 	}
+	eventProcessing, geoip, err := NewEventProcessingChain(s.config, s.meterProvider, s.logger)
+	if err != nil {
+		return err
+	}
+	if geoip != nil {
+		g.Go(func() error {
+			return geoip.Run(ctx)
+		})
+	}
+	preBatchProcessors = append(preBatchProcessors, eventProcessing...)
 	serverParams.BatchProcessor = append(preBatchProcessors, serverParams.BatchProcessor)
 
 	// Start the main server and the optional server for self-instrumentation.
@@ -519,7 +734,7 @@ func (s *Runner) Run(ctx context.Context) error {
 	if tracerServerListener != nil {
 		// use a batch processor without tracing to prevent the tracing processor from sending traces to itself
 		finalTracerBatchProcessor, closeTracerFinalBatchProcessor, err := s.newFinalBatchProcessor(
-			tracer, newElasticsearchClient, memLimitGB, s.logger, tracenoop.NewTracerProvider(), metricnoop.NewMeterProvider(),
+			tracer, newElasticsearchClient, memLimitGB, nil, nil, s.logger, tracenoop.NewTracerProvider(), metricnoop.NewMeterProvider(),
 		)
 		if err != nil {
 			return err
@@ -629,13 +844,16 @@ func newInstrumentation(rawConfig *agentconfig.C, logger *logp.Logger) (instrume
 	return instrumentation.New(rawConfig, "apm-server", version.VersionWithQualifier(), logger)
 }
 
+// maxDecoders is the maximum number of concurrent decoders
+// set based on the memory limit.
+const maxDecoders = 2048
+
 func maxConcurrentDecoders(memLimitGB float64) uint {
-	// Allow 128 concurrent decoders for each 1GB memory, limited to at most 2048.
-	const max = 2048
+	// Allow 128 concurrent decoders for each 1GB memory, limited to at most maxDecoders.
 	// Use 80% of the total memory limit to calculate decoders
 	decoders := uint(128 * memLimitGB * 0.8)
-	if decoders > max {
-		return max
+	if decoders > maxDecoders {
+		return maxDecoders
 	}
 	return decoders
 }
@@ -720,13 +938,70 @@ func (s *Runner) waitReady(
 	return waitReady(ctx, s.config.WaitReadyInterval, s.tracerProvider, s.logger, check)
 }
 
+// newAuditor returns an audit.Auditor for recording the authentication
+// audit trail, or nil if auditing is disabled.
+func (s *Runner) newAuditor(
+	newElasticsearchClient func(elasticsearch.ClientParams) (*elasticsearch.Client, error),
+	memLimit float64,
+) (*audit.Auditor, error) {
+	if !s.config.Audit.Enabled {
+		return nil, nil
+	}
+	var output audit.Output
+	switch s.config.Audit.Output {
+	case config.AuditOutputDataStream:
+		if s.elasticsearchOutputConfig == nil {
+			return nil, errors.New("audit data_stream output requires the Elasticsearch output")
+		}
+		// Audit events are indexed with a dedicated appender, which is
+		// not instrumented to avoid affecting the output metrics.
+		appenderCfg, esCfg, err := s.newDocappenderConfig(
+			tracenoop.NewTracerProvider(), metricnoop.NewMeterProvider(), memLimit,
+		)
+		if err != nil {
+			return nil, err
+		}
+		client, err := newElasticsearchClient(elasticsearch.ClientParams{
+			Config:         esCfg,
+			Logger:         s.logger,
+			TracerProvider: tracenoop.NewTracerProvider(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		appender, err := docappender.New(client, appenderCfg)
+		if err != nil {
+			return nil, err
+		}
+		output = audit.NewDataStreamOutput(appender, s.config.DataStreams.Namespace)
+	default:
+		path := s.config.Audit.File.Path
+		if path == "" {
+			path = paths.Resolve(paths.Logs, "apm-server-audit")
+		}
+		fileOutput, err := audit.NewFileOutput(path, s.config.Audit.File.MaxSizeParsed, s.config.Audit.File.MaxBackups)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create audit file output: %w", err)
+		}
+		output = fileOutput
+	}
+	return audit.NewAuditor(output, s.config.Audit.Interval, s.meterProvider, s.logger.Named("audit"))
+}
+
 // newFinalBatchProcessor returns the final model.BatchProcessor that publishes events,
 // and a cleanup function which should be called on server shutdown. If the output is
 // "elasticsearch", then we use docappender; otherwise we use the libbeat publisher.
+//
+// If queue is non-nil, it is used to track the number of events buffered by docappender.
+// If watcher is non-nil and output.elasticsearch.max_requests is not set explicitly,
+// docappender is replaced with one sized for the new memory limit whenever the memory
+// limit changes.
 func (s *Runner) newFinalBatchProcessor(
 	tracer *apm.Tracer,
 	newElasticsearchClient func(elasticsearch.ClientParams) (*elasticsearch.Client, error),
 	memLimit float64,
+	queue *loadshed.Queue,
+	watcher *memoryLimitWatcher,
 	logger *logp.Logger,
 	tp trace.TracerProvider,
 	mp metric.MeterProvider,
@@ -760,7 +1035,53 @@ func (s *Runner) newFinalBatchProcessor(
 		return nil, nil, err
 	}
 
-	return newDocappenderBatchProcessor(appender), appender.Close, nil
+	queue.SetCapacity(appenderCfg.DocumentBufferSize)
+	if watcher == nil {
+		return newDocappenderBatchProcessor(appender, queue), appender.Close, nil
+	}
+	var explicit struct {
+		MaxRequests int `config:"max_requests"`
+	}
+	if err := s.elasticsearchOutputConfig.Unpack(&explicit); err != nil {
+		return nil, nil, err
+	}
+	if explicit.MaxRequests > 0 {
+		// docappender is sized by configuration rather than by memory,
+		// so there is nothing to resize when the memory limit changes.
+		return newDocappenderBatchProcessor(appender, queue), appender.Close, nil
+	}
+
+	watcher.setSetting("docappender.max_requests", appenderCfg.MaxRequests)
+	watcher.setSetting("docappender.document_buffer_size", appenderCfg.DocumentBufferSize)
+	swappable := newSwappableAppender(appender)
+	watcher.onChange(func(_ context.Context, memLimit float64) error {
+		appenderCfg, _, err := s.newDocappenderConfig(tp, mp, memLimit)
+		if err != nil {
+			return err
+		}
+		appender, err := docappender.New(client, appenderCfg)
+		if err != nil {
+			return err
+		}
+		old := swappable.swap(appender)
+		queue.SetCapacity(appenderCfg.DocumentBufferSize)
+		watcher.setSetting("docappender.max_requests", appenderCfg.MaxRequests)
+		watcher.setSetting("docappender.document_buffer_size", appenderCfg.DocumentBufferSize)
+		// Flush documents buffered by the replaced appender. This is not
+		// cancelled on shutdown, as the documents would otherwise be lost,
+		// but is bounded by the shutdown timeout.
+		closeCtx := context.Background()
+		if s.config.ShutdownTimeout > 0 {
+			var cancel context.CancelFunc
+			closeCtx, cancel = context.WithTimeout(closeCtx, s.config.ShutdownTimeout)
+			defer cancel()
+		}
+		if err := old.Close(closeCtx); err != nil {
+			return fmt.Errorf("failed to close replaced docappender: %w", err)
+		}
+		return nil
+	})
+	return newDocappenderBatchProcessor(swappable, queue), swappable.Close, nil
 }
 
 func (s *Runner) newDocappenderConfig(tp trace.TracerProvider, mp metric.MeterProvider, memLimit float64) (
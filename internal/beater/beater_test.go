@@ -417,3 +417,116 @@ func TestProcessMemoryLimit(t *testing.T) {
 		})
 	}
 }
+
+func TestAutosizeAggregation(t *testing.T) {
+	cfg := config.AggregationConfig{MaxServices: 123}
+	autosizeAggregation(&cfg, 2, nil, logptest.NewTestingLogger(t, ""))
+	assert.Equal(t, config.AggregationConfig{
+		MaxServices:         123, // explicitly configured
+		Transactions:        config.TransactionAggregationConfig{MaxGroups: 10_000},
+		ServiceTransactions: config.ServiceTransactionAggregationConfig{MaxGroups: 2_000},
+		ServiceDestinations: config.ServiceDestinationAggregationConfig{MaxGroups: 15_000},
+	}, cfg)
+
+	// Resizing starts from the configured limits, so limits which were
+	// sized automatically are resized for the new memory limit.
+	cfg = config.AggregationConfig{MaxServices: 123}
+	autosizeAggregation(&cfg, 4, nil, logptest.NewTestingLogger(t, ""))
+	assert.Equal(t, 123, cfg.MaxServices)
+	assert.Equal(t, 20_000, cfg.Transactions.MaxGroups)
+	assert.Equal(t, 4_000, cfg.ServiceTransactions.MaxGroups)
+	assert.Equal(t, 25_000, cfg.ServiceDestinations.MaxGroups)
+}
+
+func TestRunnerUpdateConfig(t *testing.T) {
+	newRawConfig := func(revision int, host string, sampleRate float64) *agentconfig.C {
+		return agentconfig.MustNewConfigFrom(map[string]interface{}{
+			"revision": revision,
+			"apm-server": map[string]interface{}{
+				"host":                   host,
+				"sampling.tail.enabled":  true,
+				"sampling.tail.policies": []map[string]interface{}{{"sample_rate": sampleRate}},
+			},
+			"output.elasticsearch.hosts": []string{"localhost:9200"},
+		})
+	}
+	newRunner := func(t *testing.T, updateErr error) (*Runner, *[]config.TailSamplingConfig) {
+		var updates []config.TailSamplingConfig
+		return &Runner{
+			logger:        logptest.NewTestingLogger(t, ""),
+			currentConfig: newRawConfig(1, "localhost:8200", 0.1),
+			updateSamplingPolicies: func(cfg config.TailSamplingConfig) error {
+				updates = append(updates, cfg)
+				return updateErr
+			},
+		}, &updates
+	}
+
+	t.Run("policies", func(t *testing.T) {
+		runner, updates := newRunner(t, nil)
+		updatedConfig := newRawConfig(2, "localhost:8200", 0.5)
+		updated, err := runner.UpdateConfig(updatedConfig)
+		require.NoError(t, err)
+		assert.True(t, updated)
+		require.Len(t, *updates, 1)
+		require.Len(t, (*updates)[0].Policies, 1)
+		assert.Equal(t, 0.5, (*updates)[0].Policies[0].SampleRate)
+		assert.Same(t, updatedConfig, runner.currentConfig)
+	})
+
+	t.Run("revision", func(t *testing.T) {
+		runner, updates := newRunner(t, nil)
+		updated, err := runner.UpdateConfig(newRawConfig(2, "localhost:8200", 0.1))
+		require.NoError(t, err)
+		assert.True(t, updated)
+		require.Len(t, *updates, 1)
+		assert.Equal(t, 0.1, (*updates)[0].Policies[0].SampleRate)
+	})
+
+	t.Run("other", func(t *testing.T) {
+		runner, updates := newRunner(t, nil)
+		currentConfig := runner.currentConfig
+		updated, err := runner.UpdateConfig(newRawConfig(2, "localhost:8201", 0.5))
+		require.NoError(t, err)
+		assert.False(t, updated)
+		assert.Empty(t, *updates)
+		assert.Same(t, currentConfig, runner.currentConfig)
+	})
+
+	t.Run("update_error", func(t *testing.T) {
+		runner, _ := newRunner(t, errors.New("boom"))
+		currentConfig := runner.currentConfig
+		updated, err := runner.UpdateConfig(newRawConfig(2, "localhost:8200", 0.5))
+		assert.EqualError(t, err, "boom")
+		assert.False(t, updated)
+		assert.Same(t, currentConfig, runner.currentConfig)
+	})
+
+	t.Run("not_updatable", func(t *testing.T) {
+		runner, _ := newRunner(t, nil)
+		runner.updateSamplingPolicies = nil
+		updated, err := runner.UpdateConfig(newRawConfig(2, "localhost:8200", 0.5))
+		require.NoError(t, err)
+		assert.False(t, updated)
+	})
+}
+
+func TestWithoutSettings(t *testing.T) {
+	cfg := agentconfig.MustNewConfigFrom(map[string]interface{}{
+		"revision":                         3,
+		"apm-server.host":                  "localhost:8200",
+		"apm-server.sampling.tail.enabled": true,
+		"apm-server.sampling.tail.ttl":     "1m",
+	})
+	m, err := withoutSettings(cfg, []string{"revision", "apm-server.sampling.tail.ttl", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"apm-server": map[string]interface{}{
+			"host":     "localhost:8200",
+			"sampling": map[string]interface{}{"tail": map[string]interface{}{"enabled": true}},
+		},
+	}, m)
+
+	// The original configuration is not modified.
+	assert.True(t, cfg.HasField("revision"))
+}
@@ -0,0 +1,120 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+const (
+	// AuditOutputFile writes audit events to a rotating local file.
+	AuditOutputFile = "file"
+
+	// AuditOutputDataStream writes audit events to a dedicated
+	// data stream using the Elasticsearch output.
+	AuditOutputDataStream = "data_stream"
+)
+
+// AuditConfig holds configuration for recording an audit trail of
+// authentication outcomes, authorization denials, and per-credential
+// ingested event counts.
+type AuditConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// Output holds the audit output: AuditOutputFile or AuditOutputDataStream.
+	Output string `config:"output"`
+
+	// Interval holds the interval at which per-credential ingested
+	// event counts are recorded.
+	Interval time.Duration `config:"interval"`
+
+	// File holds configuration for AuditOutputFile.
+	File AuditFileConfig `config:"file"`
+}
+
+// AuditFileConfig holds configuration for the audit file output.
+type AuditFileConfig struct {
+	// Path holds the path prefix of the audit files, which are suffixed
+	// with the date and ".ndjson". If empty, the files are written to the
+	// logs directory.
+	Path string `config:"path"`
+
+	// MaxSize holds the size at which the audit file is rotated.
+	MaxSize       string `config:"max_size"`
+	MaxSizeParsed uint64
+
+	// MaxBackups holds the number of rotated audit files to keep.
+	MaxBackups uint `config:"max_backups"`
+}
+
+func (c *AuditConfig) Unpack(in *config.C) error {
+	type auditConfig AuditConfig
+	cfg := auditConfig(defaultAuditConfig())
+	if err := in.Unpack(&cfg); err != nil {
+		return fmt.Errorf("error unpacking audit config: %w", err)
+	}
+	maxSize, err := humanize.ParseBytes(cfg.File.MaxSize)
+	if err != nil {
+		return fmt.Errorf("error parsing audit file max_size: %w", err)
+	}
+	cfg.File.MaxSizeParsed = maxSize
+	*c = AuditConfig(cfg)
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("invalid audit config: %w", err)
+	}
+	return nil
+}
+
+func (c *AuditConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	switch c.Output {
+	case AuditOutputFile, AuditOutputDataStream:
+	default:
+		return fmt.Errorf("unknown output %q, expected %q or %q", c.Output, AuditOutputFile, AuditOutputDataStream)
+	}
+	if c.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	return nil
+}
+
+func defaultAuditConfig() AuditConfig {
+	cfg := AuditConfig{
+		Enabled:  false,
+		Output:   AuditOutputFile,
+		Interval: time.Minute,
+		File: AuditFileConfig{
+			MaxSize:    "10MB",
+			MaxBackups: 7,
+		},
+	}
+	maxSize, err := humanize.ParseBytes(cfg.File.MaxSize)
+	if err != nil {
+		panic(err)
+	}
+	cfg.File.MaxSizeParsed = maxSize
+	return cfg
+}
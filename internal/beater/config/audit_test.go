@@ -0,0 +1,80 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestAuditConfig(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"audit.enabled":          true,
+		"audit.file.path":        "/var/log/apm-server/audit.ndjson",
+		"audit.file.max_size":    "1MiB",
+		"audit.file.max_backups": 2,
+	}), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, AuditConfig{
+		Enabled:  true,
+		Output:   AuditOutputFile,
+		Interval: defaultAuditConfig().Interval,
+		File: AuditFileConfig{
+			Path:          "/var/log/apm-server/audit.ndjson",
+			MaxSize:       "1MiB",
+			MaxSizeParsed: 1024 * 1024,
+			MaxBackups:    2,
+		},
+	}, cfg.Audit)
+}
+
+func TestAuditConfigInvalid(t *testing.T) {
+	for name, test := range map[string]struct {
+		config map[string]interface{}
+		err    string
+	}{
+		"output": {
+			config: map[string]interface{}{"audit.enabled": true, "audit.output": "stdout"},
+			err:    `invalid audit config: unknown output "stdout", expected "file" or "data_stream"`,
+		},
+		"interval": {
+			config: map[string]interface{}{"audit.enabled": true, "audit.interval": "0s"},
+			err:    "invalid audit config: interval must be positive",
+		},
+		"max_size": {
+			config: map[string]interface{}{"audit.file.max_size": "lots"},
+			err:    "error parsing audit file max_size",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(test.config), nil, logptest.NewTestingLogger(t, ""))
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+
+	// Invalid settings are ignored when auditing is disabled.
+	_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"audit.output": "stdout",
+	}), nil, logptest.NewTestingLogger(t, ""))
+	assert.NoError(t, err)
+}
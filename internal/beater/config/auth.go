@@ -18,7 +18,12 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
@@ -31,13 +36,44 @@ type AgentAuth struct {
 	Anonymous   AnonymousAgentAuth `config:"anonymous"`
 	APIKey      APIKeyAgentAuth    `config:"api_key"`
 	SecretToken string             `config:"secret_token"`
+
+	// SecretTokens holds named secret tokens, which may be restricted
+	// to a subset of actions, agents, and services, and may expire.
+	// Multiple tokens may be valid at the same time, enabling tokens
+	// to be rotated without downtime.
+	SecretTokens []SecretTokenAgentAuth `config:"secret_tokens"`
+
+	// SecretTokensFile holds the path to a YAML file defining additional
+	// named secret tokens under the "secret_tokens" key. The file is
+	// watched for changes, and reloaded without restarting the server.
+	SecretTokensFile string `config:"secret_tokens_file"`
+
+	// StaticAPIKeys holds locally defined API Keys, which are authenticated
+	// without Elasticsearch. If API Key auth is also enabled, static API Keys
+	// are checked first, and unknown API Key IDs fall back to Elasticsearch.
+	StaticAPIKeys []StaticAPIKeyAgentAuth `config:"static_api_keys"`
+
+	// Quotas holds event and byte rate quotas applied to authenticated
+	// clients by API Key ID or secret token name, or to events by
+	// service name.
+	Quotas []Quota `config:"quotas"`
+}
+
+// SecretTokenEnabled reports whether any form of secret token auth is configured.
+func (a *AgentAuth) SecretTokenEnabled() bool {
+	return a.SecretToken != "" || len(a.SecretTokens) != 0 || a.SecretTokensFile != ""
+}
+
+// APIKeyEnabled reports whether any form of API Key auth is configured.
+func (a *AgentAuth) APIKeyEnabled() bool {
+	return a.APIKey.Enabled || len(a.StaticAPIKeys) != 0
 }
 
 func (a *AgentAuth) setAnonymousDefaults(logger *logp.Logger, rumEnabled bool) error {
 	if a.Anonymous.enabledSet {
 		return nil
 	}
-	if !a.APIKey.Enabled && a.SecretToken == "" {
+	if !a.APIKeyEnabled() && !a.SecretTokenEnabled() {
 		// No auth is required.
 		return nil
 	}
@@ -79,6 +115,115 @@ func (a *APIKeyAgentAuth) setup(log *logp.Logger, outputESCfg *config.C) error {
 	return nil
 }
 
+// SecretTokenAgentAuth holds config related to a named secret token.
+type SecretTokenAgentAuth struct {
+	// Name identifies the token in logs and metrics. Names must be unique.
+	Name string `config:"name" validate:"required"`
+
+	// Value holds the secret token value. It is recommended to store
+	// the value in the keystore and reference it here.
+	Value string `config:"value" validate:"required"`
+
+	// Expires holds an optional RFC 3339 timestamp, after which the
+	// token will no longer be accepted.
+	Expires       string `config:"expires"`
+	ExpiresParsed time.Time
+
+	// AllowActions restricts the token to the specified actions:
+	// "event_ingest", "agent_config", "sourcemap", and "admin". By default
+	// all actions are allowed.
+	AllowActions []string `config:"allow_actions"`
+
+	// AllowAgent restricts the token to the specified agent names.
+	// By default all agents are allowed.
+	AllowAgent []string `config:"allow_agent"`
+
+	// AllowService restricts the token to the specified service names.
+	// By default all services are allowed.
+	AllowService []string `config:"allow_service"`
+}
+
+func (t *SecretTokenAgentAuth) Unpack(in *config.C) error {
+	type underlyingSecretTokenAgentAuth SecretTokenAgentAuth
+	if err := in.Unpack((*underlyingSecretTokenAgentAuth)(t)); err != nil {
+		return fmt.Errorf("error unpacking secret_tokens config: %w", err)
+	}
+	if t.Expires != "" {
+		expires, err := time.Parse(time.RFC3339, t.Expires)
+		if err != nil {
+			return fmt.Errorf("error parsing expiry of secret token %q: %w", t.Name, err)
+		}
+		t.ExpiresParsed = expires
+	}
+	for _, action := range t.AllowActions {
+		switch action {
+		case "event_ingest", "agent_config", "sourcemap", "admin":
+		default:
+			return fmt.Errorf("invalid action %q for secret token %q", action, t.Name)
+		}
+	}
+	return nil
+}
+
+// StaticAPIKeyAgentAuth holds config related to a locally defined API Key.
+type StaticAPIKeyAgentAuth struct {
+	// ID holds the non-secret ID of the API Key. IDs must be unique.
+	ID string `config:"id" validate:"required"`
+
+	// Hash holds the hex-encoded SHA-256 hash of the API Key secret,
+	// i.e. the part following the colon in "ID:APIKey".
+	Hash string `config:"hash" validate:"required"`
+
+	// Username holds an optional username associated with the API Key,
+	// recorded in authentication details.
+	Username string `config:"username"`
+
+	// Privileges holds the privilege actions granted to the API Key:
+	// "event:write", "config_agent:read", "sourcemap:write", and "server:admin".
+	Privileges []string `config:"privileges" validate:"required"`
+}
+
+func (k *StaticAPIKeyAgentAuth) Unpack(in *config.C) error {
+	type underlyingStaticAPIKeyAgentAuth StaticAPIKeyAgentAuth
+	if err := in.Unpack((*underlyingStaticAPIKeyAgentAuth)(k)); err != nil {
+		return fmt.Errorf("error unpacking static_api_keys config: %w", err)
+	}
+	if hash, err := hex.DecodeString(k.Hash); err != nil || len(hash) != sha256.Size {
+		return fmt.Errorf("invalid hash for API Key %q: expected hex-encoded SHA-256", k.ID)
+	}
+	for _, privilege := range k.Privileges {
+		switch privilege {
+		case "event:write", "config_agent:read", "sourcemap:write", "server:admin":
+		default:
+			return fmt.Errorf("invalid privilege %q for API Key %q", privilege, k.ID)
+		}
+	}
+	return nil
+}
+
+// LoadSecretTokensFile loads named secret tokens from the YAML file at path,
+// which is expected to define a list of tokens under the "secret_tokens" key.
+func LoadSecretTokensFile(path string) ([]SecretTokenAgentAuth, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	in, err := config.NewConfigWithYAML(data, path)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing secret tokens file: %w", err)
+	}
+	var tokens struct {
+		SecretTokens []SecretTokenAgentAuth `config:"secret_tokens"`
+	}
+	if err := in.Unpack(&tokens); err != nil {
+		return nil, err
+	}
+	if len(tokens.SecretTokens) == 0 {
+		return nil, errors.New("no secret tokens defined in secret tokens file")
+	}
+	return tokens.SecretTokens, nil
+}
+
 // AnonymousAgentAuth holds config related to anonymous access for agents.
 //
 // If RUM is enabled, and either secret_token or api_key auth is defined,
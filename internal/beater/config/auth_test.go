@@ -18,6 +18,8 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -182,3 +184,159 @@ func TestSecretTokenAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestSecretTokensAuth(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(`{
+		"auth.secret_tokens": [
+			{"name": "one", "value": "value1", "expires": "2030-01-02T03:04:05Z"},
+			{"name": "two", "value": "value2", "allow_actions": ["event_ingest"], "allow_service": ["opbeans"]}
+		]
+	}`), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, []SecretTokenAgentAuth{{
+		Name:          "one",
+		Value:         "value1",
+		Expires:       "2030-01-02T03:04:05Z",
+		ExpiresParsed: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+	}, {
+		Name:         "two",
+		Value:        "value2",
+		AllowActions: []string{"event_ingest"},
+		AllowService: []string{"opbeans"},
+	}}, cfg.AgentAuth.SecretTokens)
+	assert.True(t, cfg.AgentAuth.SecretTokenEnabled())
+
+	for name, tc := range map[string]struct {
+		cfg         string
+		expectedErr string
+	}{
+		"missing value": {
+			cfg:         `{"auth.secret_tokens": [{"name": "one"}]}`,
+			expectedErr: "string value is not set",
+		},
+		"invalid expires": {
+			cfg:         `{"auth.secret_tokens": [{"name": "one", "value": "value1", "expires": "tomorrow"}]}`,
+			expectedErr: `error parsing expiry of secret token "one"`,
+		},
+		"invalid action": {
+			cfg:         `{"auth.secret_tokens": [{"name": "one", "value": "value1", "allow_actions": ["delete"]}]}`,
+			expectedErr: `invalid action "delete" for secret token "one"`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(tc.cfg), nil, logptest.NewTestingLogger(t, ""))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func TestLoadSecretTokensFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret_tokens.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+secret_tokens:
+  - name: one
+    value: value1
+    allow_agent: [java]
+`), 0600))
+	tokens, err := LoadSecretTokensFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []SecretTokenAgentAuth{{Name: "one", Value: "value1", AllowAgent: []string{"java"}}}, tokens)
+
+	require.NoError(t, os.WriteFile(path, []byte(`secret_tokens: []`), 0600))
+	_, err = LoadSecretTokensFile(path)
+	assert.EqualError(t, err, "no secret tokens defined in secret tokens file")
+}
+
+func TestStaticAPIKeysAuth(t *testing.T) {
+	const hash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	cfg, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"auth.static_api_keys": []map[string]interface{}{{
+			"id":         "key_id",
+			"hash":       hash,
+			"username":   "user",
+			"privileges": []string{"event:write", "config_agent:read"},
+		}},
+	}), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, []StaticAPIKeyAgentAuth{{
+		ID:         "key_id",
+		Hash:       hash,
+		Username:   "user",
+		Privileges: []string{"event:write", "config_agent:read"},
+	}}, cfg.AgentAuth.StaticAPIKeys)
+	assert.True(t, cfg.AgentAuth.APIKeyEnabled())
+	assert.False(t, cfg.AgentAuth.APIKey.Enabled)
+
+	for name, tc := range map[string]struct {
+		key         map[string]interface{}
+		expectedErr string
+	}{
+		"missing privileges": {
+			key:         map[string]interface{}{"id": "key_id", "hash": hash},
+			expectedErr: "missing required field",
+		},
+		"invalid hash": {
+			key:         map[string]interface{}{"id": "key_id", "hash": "abc", "privileges": []string{"event:write"}},
+			expectedErr: `invalid hash for API Key "key_id": expected hex-encoded SHA-256`,
+		},
+		"invalid privilege": {
+			key:         map[string]interface{}{"id": "key_id", "hash": hash, "privileges": []string{"event:read"}},
+			expectedErr: `invalid privilege "event:read" for API Key "key_id"`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+				"auth.static_api_keys": []map[string]interface{}{tc.key},
+			}), nil, logptest.NewTestingLogger(t, ""))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func TestQuotasConfig(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"auth.quotas": []map[string]interface{}{
+			{"api_key_id": "key_id", "event_limit": 100},
+			{"secret_token_name": "token_name", "byte_limit": 1000},
+			{"service_name": "service", "event_limit": 10, "byte_limit": 100},
+		},
+	}), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, []Quota{
+		{APIKeyID: "key_id", EventLimit: 100},
+		{SecretTokenName: "token_name", ByteLimit: 1000},
+		{ServiceName: "service", EventLimit: 10, ByteLimit: 100},
+	}, cfg.AgentAuth.Quotas)
+
+	for name, tc := range map[string]struct {
+		quota       map[string]interface{}
+		expectedErr string
+	}{
+		"no key": {
+			quota:       map[string]interface{}{"event_limit": 1},
+			expectedErr: "quota must specify exactly one of api_key_id, secret_token_name, or service_name",
+		},
+		"multiple keys": {
+			quota:       map[string]interface{}{"api_key_id": "id", "service_name": "service", "event_limit": 1},
+			expectedErr: "quota must specify exactly one of api_key_id, secret_token_name, or service_name",
+		},
+		"no limits": {
+			quota:       map[string]interface{}{"api_key_id": "id"},
+			expectedErr: "quota must specify event_limit or byte_limit",
+		},
+		"negative limit": {
+			quota:       map[string]interface{}{"api_key_id": "id", "event_limit": -1},
+			expectedErr: "requires value >= 0",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+				"auth.quotas": []map[string]interface{}{tc.quota},
+			}), nil, logptest.NewTestingLogger(t, ""))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
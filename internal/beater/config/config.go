@@ -27,6 +27,7 @@ import (
 	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 
 	"github.com/elastic/apm-server/internal/logs"
+	"github.com/elastic/apm-server/internal/netutil"
 )
 
 const (
@@ -43,6 +44,58 @@ type Config struct {
 	// AgentAuth holds agent auth config.
 	AgentAuth AgentAuth `config:"auth"`
 
+	// TrustedProxies holds the CIDR prefixes or IP addresses of proxies
+	// which are trusted to set the Forwarded, X-Real-IP, and X-Forwarded-For
+	// headers. If empty, these headers are trusted from all clients.
+	TrustedProxies []string `config:"trusted_proxies"`
+
+	// TrustedProxiesHeader, if non-empty, holds the name of the single
+	// header which the trusted proxies set: Forwarded, X-Real-IP, or
+	// X-Forwarded-For. Other headers, which clients may forge, are ignored.
+	TrustedProxiesHeader string `config:"trusted_proxies_header"`
+
+	// IPFilter holds IP allow and deny rules for groups of routes.
+	IPFilter IPFilterConfig `config:"ip_filter"`
+
+	// Audit holds configuration for the authentication audit trail.
+	Audit AuditConfig `config:"audit"`
+
+	// LoadShedding holds configuration for rejecting intake
+	// requests early when the server is overloaded.
+	LoadShedding LoadSheddingConfig `config:"load_shedding"`
+
+	// MemoryAutotune holds configuration for resizing components
+	// when the process memory limit changes.
+	MemoryAutotune MemoryAutotuneConfig `config:"memory_autotune"`
+
+	// EventProcessors holds processors applied to events
+	// decoded from agent payloads, in order.
+	EventProcessors []EventProcessorConfig `config:"event_processors"`
+
+	// EventRules holds expression-based rules which are applied
+	// to events after EventProcessors, in order.
+	EventRules []EventRuleConfig `config:"event_rules"`
+
+	// Redaction holds configuration for masking personal
+	// data and secrets in events.
+	Redaction RedactionConfig `config:"redaction"`
+
+	// GeoIP holds configuration for enriching events with
+	// geolocation and autonomous system information.
+	GeoIP GeoIPConfig `config:"geoip"`
+
+	// UserAgent holds configuration for parsing the user agents
+	// of events from browser agents.
+	UserAgent UserAgentConfig `config:"user_agent"`
+
+	// NameNormalization holds configuration for rewriting transaction
+	// and span names containing identifiers into templated names.
+	NameNormalization NameNormalizationConfig `config:"name_normalization"`
+
+	// DBStatementObfuscation holds configuration for replacing literal
+	// values in database span statements with placeholders.
+	DBStatementObfuscation DBStatementObfuscationConfig `config:"db_statement_obfuscation"`
+
 	MaxHeaderSize             int                     `config:"max_header_size"`
 	IdleTimeout               time.Duration           `config:"idle_timeout"`
 	ReadTimeout               time.Duration           `config:"read_timeout"`
@@ -89,6 +142,18 @@ func NewConfig(ucfg *config.C, outputESCfg *config.C, logger *logp.Logger) (*Con
 		return nil, err
 	}
 
+	if _, err := netutil.ParseTrustedProxies(c.TrustedProxies, c.TrustedProxiesHeader); err != nil {
+		return nil, err
+	}
+
+	if err := c.IPFilter.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := validateEventRules(c.EventRules); err != nil {
+		return nil, err
+	}
+
 	if err := c.RumConfig.setup(logger, outputESCfg); err != nil {
 		return nil, err
 	}
@@ -101,7 +166,7 @@ func NewConfig(ucfg *config.C, outputESCfg *config.C, logger *logp.Logger) (*Con
 		return nil, err
 	}
 
-	if err := c.Sampling.Tail.setup(logger, outputESCfg); err != nil {
+	if err := c.Sampling.Tail.setup(logger, outputESCfg, &c.AgentAuth); err != nil {
 		return nil, err
 	}
 
@@ -124,14 +189,22 @@ func DefaultConfig() *Config {
 			Enabled: false,
 			URL:     "/debug/vars",
 		},
-		Pprof:             PprofConfig{Enabled: false},
-		RumConfig:         defaultRum(),
-		Kibana:            defaultKibanaConfig(),
-		AgentConfig:       defaultAgentConfig(),
-		Aggregation:       defaultAggregationConfig(),
-		Sampling:          defaultSamplingConfig(),
-		DataStreams:       defaultDataStreamsConfig(),
-		AgentAuth:         defaultAgentAuth(),
-		WaitReadyInterval: 5 * time.Second,
+		Pprof:                  PprofConfig{Enabled: false},
+		RumConfig:              defaultRum(),
+		Kibana:                 defaultKibanaConfig(),
+		AgentConfig:            defaultAgentConfig(),
+		Aggregation:            defaultAggregationConfig(),
+		Sampling:               defaultSamplingConfig(),
+		DataStreams:            defaultDataStreamsConfig(),
+		AgentAuth:              defaultAgentAuth(),
+		Audit:                  defaultAuditConfig(),
+		LoadShedding:           defaultLoadSheddingConfig(),
+		MemoryAutotune:         defaultMemoryAutotuneConfig(),
+		Redaction:              defaultRedactionConfig(),
+		GeoIP:                  defaultGeoIPConfig(),
+		UserAgent:              defaultUserAgentConfig(),
+		NameNormalization:      defaultNameNormalizationConfig(),
+		DBStatementObfuscation: defaultDBStatementObfuscationConfig(),
+		WaitReadyInterval:      5 * time.Second,
 	}
 }
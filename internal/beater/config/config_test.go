@@ -266,6 +266,11 @@ func TestUnpackConfig(t *testing.T) {
 					},
 				},
 				"default_service_environment": "overridden",
+				"audit": map[string]interface{}{
+					"enabled":  true,
+					"output":   "data_stream",
+					"interval": "30s",
+				},
 			},
 			outCfg: &Config{
 				Host:                  "localhost:3000",
@@ -376,12 +381,66 @@ func TestUnpackConfig(t *testing.T) {
 						StorageLimitParsed:    0,
 						DiskUsageThreshold:    0.8,
 						TTL:                   30 * time.Minute,
+						TraceCompletion: TailSamplingTraceCompletionConfig{
+							IdleTimeout: 30 * time.Second,
+						},
+						Peer: TailSamplingPeerConfig{
+							DiscoveryInterval: 30 * time.Second,
+							BatchSize:         1000,
+							LogSize:           100000,
+						},
+						Forwarding: TailSamplingForwardingConfig{
+							DiscoveryInterval: 30 * time.Second,
+							HandoffPeriod:     time.Minute,
+							BatchSize:         500,
+							FlushInterval:     100 * time.Millisecond,
+							QueueSize:         10000,
+							MaxRetries:        3,
+						},
+						PoliciesFile: TailSamplingPoliciesFileConfig{
+							ReloadPeriod: 10 * time.Second,
+						},
+						Storage: TailSamplingStorageConfig{
+							Codec: TailSamplingStorageCodecProtobuf,
+						},
 					},
 				},
 				DefaultServiceEnvironment: "overridden",
 				DataStreams: DataStreamsConfig{
 					Namespace: "default",
 				},
+				Audit: AuditConfig{
+					Enabled:  true,
+					Output:   "data_stream",
+					Interval: 30 * time.Second,
+					File: AuditFileConfig{
+						MaxSize:       "10MB",
+						MaxSizeParsed: 10000000,
+						MaxBackups:    7,
+					},
+				},
+				LoadShedding: LoadSheddingConfig{
+					QueueThreshold:         0.8,
+					SemaphoreWaitThreshold: time.Second,
+					MemoryThreshold:        0.8,
+					RetryAfter:             5 * time.Second,
+				},
+				MemoryAutotune: MemoryAutotuneConfig{
+					Interval: 30 * time.Second,
+				},
+				Redaction: RedactionConfig{
+					DefaultRules: true,
+					Replacement:  "[REDACTED]",
+				},
+				GeoIP: GeoIPConfig{
+					ReloadInterval: time.Minute,
+				},
+				UserAgent: UserAgentConfig{
+					CacheSize: 10000,
+				},
+				NameNormalization: NameNormalizationConfig{
+					AutoDetect: true,
+				},
 				WaitReadyInterval: 5 * time.Second,
 			},
 		},
@@ -427,6 +486,10 @@ func TestUnpackConfig(t *testing.T) {
 					"ingest_rate_decay":    1.0,
 					"storage_limit":        "1GB",
 					"disk_usage_threshold": 0.8,
+					"storage": map[string]interface{}{
+						"codec":        "zstd",
+						"dictionaries": []string{"events.dict"},
+					},
 				},
 				"data_streams": map[string]interface{}{
 					"namespace": "foo",
@@ -509,11 +572,65 @@ func TestUnpackConfig(t *testing.T) {
 						StorageLimitParsed:    1000000000,
 						DiskUsageThreshold:    0.8,
 						TTL:                   30 * time.Minute,
+						TraceCompletion: TailSamplingTraceCompletionConfig{
+							IdleTimeout: 30 * time.Second,
+						},
+						Peer: TailSamplingPeerConfig{
+							DiscoveryInterval: 30 * time.Second,
+							BatchSize:         1000,
+							LogSize:           100000,
+						},
+						Forwarding: TailSamplingForwardingConfig{
+							DiscoveryInterval: 30 * time.Second,
+							HandoffPeriod:     time.Minute,
+							BatchSize:         500,
+							FlushInterval:     100 * time.Millisecond,
+							QueueSize:         10000,
+							MaxRetries:        3,
+						},
+						PoliciesFile: TailSamplingPoliciesFileConfig{
+							ReloadPeriod: 10 * time.Second,
+						},
+						Storage: TailSamplingStorageConfig{
+							Codec:        TailSamplingStorageCodecZstd,
+							Dictionaries: []string{"events.dict"},
+						},
 					},
 				},
 				DataStreams: DataStreamsConfig{
 					Namespace: "foo",
 				},
+				Audit: AuditConfig{
+					Output:   "file",
+					Interval: time.Minute,
+					File: AuditFileConfig{
+						MaxSize:       "10MB",
+						MaxSizeParsed: 10000000,
+						MaxBackups:    7,
+					},
+				},
+				LoadShedding: LoadSheddingConfig{
+					QueueThreshold:         0.8,
+					SemaphoreWaitThreshold: time.Second,
+					MemoryThreshold:        0.8,
+					RetryAfter:             5 * time.Second,
+				},
+				MemoryAutotune: MemoryAutotuneConfig{
+					Interval: 30 * time.Second,
+				},
+				Redaction: RedactionConfig{
+					DefaultRules: true,
+					Replacement:  "[REDACTED]",
+				},
+				GeoIP: GeoIPConfig{
+					ReloadInterval: time.Minute,
+				},
+				UserAgent: UserAgentConfig{
+					CacheSize: 10000,
+				},
+				NameNormalization: NameNormalizationConfig{
+					AutoDetect: true,
+				},
 				WaitReadyInterval: 5 * time.Second,
 			},
 		},
@@ -758,6 +875,34 @@ func TestNewConfig_ESConfig(t *testing.T) {
 
 }
 
+func TestNewConfig_TrustedProxies(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(`{"trusted_proxies": ["10.0.0.0/8", "192.168.0.1"]}`), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.1"}, cfg.TrustedProxies)
+
+	_, err = NewConfig(config.MustNewConfigFrom(`{"trusted_proxies": ["proxy.invalid"]}`), nil, logptest.NewTestingLogger(t, ""))
+	assert.ErrorContains(t, err, `invalid trusted proxy "proxy.invalid"`)
+
+	cfg, err = NewConfig(config.MustNewConfigFrom(`{"trusted_proxies": ["10.0.0.0/8"], "trusted_proxies_header": "X-Forwarded-For"}`), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, "X-Forwarded-For", cfg.TrustedProxiesHeader)
+
+	_, err = NewConfig(config.MustNewConfigFrom(`{"trusted_proxies": ["10.0.0.0/8"], "trusted_proxies_header": "True-Client-IP"}`), nil, logptest.NewTestingLogger(t, ""))
+	assert.ErrorContains(t, err, `invalid trusted proxies header "True-Client-IP"`)
+}
+
+func TestNewConfig_IPFilter(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(`{"ip_filter": {"rum": {"deny": ["192.0.2.0/24"]}, "agent_config": {"allow": ["10.0.0.1"]}}}`), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, IPFilterConfig{
+		RUM:         IPFilterRules{Deny: []string{"192.0.2.0/24"}},
+		AgentConfig: IPFilterRules{Allow: []string{"10.0.0.1"}},
+	}, cfg.IPFilter)
+
+	_, err = NewConfig(config.MustNewConfigFrom(`{"ip_filter": {"otlp": {"allow": ["host.invalid"]}}}`), nil, logptest.NewTestingLogger(t, ""))
+	assert.ErrorContains(t, err, `ip_filter.otlp: invalid allow rule: "host.invalid"`)
+}
+
 func newBool(v bool) *bool {
 	return &v
 }
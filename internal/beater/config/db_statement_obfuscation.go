@@ -0,0 +1,39 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+// DBStatementObfuscationConfig holds configuration for replacing literal
+// values in the statements of database spans with placeholders.
+type DBStatementObfuscationConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// ServiceName holds the names of services whose statements are
+	// obfuscated. If empty, statements of all services are obfuscated.
+	ServiceName []string `config:"service_name"`
+
+	// KeepOriginalServiceName holds the names of services whose original
+	// statements are kept. The normalized statement is still recorded
+	// for grouping.
+	KeepOriginalServiceName []string `config:"keep_original_service_name"`
+}
+
+func defaultDBStatementObfuscationConfig() DBStatementObfuscationConfig {
+	return DBStatementObfuscationConfig{
+		Enabled: false,
+	}
+}
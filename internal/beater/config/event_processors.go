@@ -0,0 +1,120 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// EventProcessorConfig holds configuration for a processor which is applied
+// to events decoded from agent payloads, before they are aggregated, sampled,
+// or indexed. Exactly one action must be configured.
+type EventProcessorConfig struct {
+	// When holds conditions which an event must match for
+	// the action to be applied. If empty, all events match.
+	When EventConditionConfig `config:"when"`
+
+	// DropEvent drops matching events.
+	DropEvent bool `config:"drop_event"`
+
+	// DropFields holds the names of fields to remove from matching events.
+	DropFields []string `config:"drop_fields"`
+
+	// RenameFields holds fields to rename in matching events.
+	RenameFields []RenameFieldConfig `config:"rename_fields"`
+
+	// SetLabels holds labels to set on matching events.
+	SetLabels map[string]string `config:"set_labels"`
+
+	// TruncateFields holds fields to truncate in matching events.
+	TruncateFields TruncateFieldsConfig `config:"truncate_fields"`
+}
+
+// EventConditionConfig holds conditions for matching events. All of the
+// configured conditions must match; list conditions match if any value matches.
+type EventConditionConfig struct {
+	// EventType holds event types to match: "transaction",
+	// "span", "error", "metric", or "log".
+	EventType []string `config:"event_type"`
+
+	// ServiceName holds service names to match.
+	ServiceName []string `config:"service_name"`
+
+	// Labels holds label values to match.
+	Labels map[string]string `config:"labels"`
+
+	// URLPath holds a regular expression to match against url.path.
+	URLPath string `config:"url_path"`
+}
+
+// RenameFieldConfig holds the source and destination of a field to rename.
+type RenameFieldConfig struct {
+	From string `config:"from" validate:"required"`
+	To   string `config:"to" validate:"required"`
+}
+
+// TruncateFieldsConfig holds the names of fields to truncate,
+// and the maximum length in characters to truncate them to.
+type TruncateFieldsConfig struct {
+	Fields    []string `config:"fields"`
+	MaxLength int      `config:"max_length" validate:"min=0"`
+}
+
+func (c *EventProcessorConfig) Validate() error {
+	var actions int
+	if c.DropEvent {
+		actions++
+	}
+	if len(c.DropFields) != 0 {
+		actions++
+	}
+	if len(c.RenameFields) != 0 {
+		actions++
+	}
+	if len(c.SetLabels) != 0 {
+		actions++
+	}
+	if len(c.TruncateFields.Fields) != 0 {
+		actions++
+		if c.TruncateFields.MaxLength <= 0 {
+			return errors.New("truncate_fields.max_length must be positive")
+		}
+	}
+	if actions != 1 {
+		return fmt.Errorf("exactly one action must be configured, found %d", actions)
+	}
+	return nil
+}
+
+func (c *EventConditionConfig) Validate() error {
+	for _, eventType := range c.EventType {
+		switch eventType {
+		case "transaction", "span", "error", "metric", "log":
+		default:
+			return fmt.Errorf("unknown event_type %q", eventType)
+		}
+	}
+	if c.URLPath != "" {
+		if _, err := regexp.Compile(c.URLPath); err != nil {
+			return fmt.Errorf("invalid url_path regex: %w", err)
+		}
+	}
+	return nil
+}
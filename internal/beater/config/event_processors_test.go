@@ -0,0 +1,90 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestEventProcessorsConfig(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"event_processors": []map[string]interface{}{{
+			"when": map[string]interface{}{
+				"event_type": []string{"transaction"},
+				"url_path":   "^/health",
+			},
+			"drop_event": true,
+		}, {
+			"rename_fields": []map[string]interface{}{{"from": "labels.a", "to": "labels.b"}},
+		}, {
+			"truncate_fields": map[string]interface{}{"fields": []string{"message"}, "max_length": 10},
+		}},
+	}), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	require.Len(t, cfg.EventProcessors, 3)
+	assert.Equal(t, []string{"transaction"}, cfg.EventProcessors[0].When.EventType)
+	assert.Equal(t, "^/health", cfg.EventProcessors[0].When.URLPath)
+	assert.True(t, cfg.EventProcessors[0].DropEvent)
+	assert.Equal(t, []RenameFieldConfig{{From: "labels.a", To: "labels.b"}}, cfg.EventProcessors[1].RenameFields)
+	assert.Equal(t, TruncateFieldsConfig{Fields: []string{"message"}, MaxLength: 10}, cfg.EventProcessors[2].TruncateFields)
+}
+
+func TestEventProcessorsConfigInvalid(t *testing.T) {
+	for name, test := range map[string]struct {
+		processor map[string]interface{}
+		err       string
+	}{
+		"no_action": {
+			processor: map[string]interface{}{"when.event_type": []string{"span"}},
+			err:       "exactly one action must be configured, found 0 accessing 'event_processors.0'",
+		},
+		"multiple_actions": {
+			processor: map[string]interface{}{"drop_event": true, "drop_fields": []string{"message"}},
+			err:       "exactly one action must be configured, found 2 accessing 'event_processors.0'",
+		},
+		"event_type": {
+			processor: map[string]interface{}{"drop_event": true, "when.event_type": []string{"trace"}},
+			err:       `unknown event_type "trace" accessing 'event_processors.0.when'`,
+		},
+		"url_path": {
+			processor: map[string]interface{}{"drop_event": true, "when.url_path": "("},
+			err:       "invalid url_path regex",
+		},
+		"rename_to": {
+			processor: map[string]interface{}{"rename_fields": []map[string]interface{}{{"from": "labels.a"}}},
+			err:       "string value is not set accessing 'event_processors.0.rename_fields.0.to'",
+		},
+		"truncate_max_length": {
+			processor: map[string]interface{}{"truncate_fields.fields": []string{"message"}},
+			err:       "truncate_fields.max_length must be positive accessing 'event_processors.0'",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+				"event_processors": []map[string]interface{}{test.processor},
+			}), nil, logptest.NewTestingLogger(t, ""))
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/elastic/apm-server/internal/model/modelcel"
+)
+
+// EventRuleConfig holds configuration for a rule which evaluates Common
+// Expression Language (CEL) expressions over events decoded from agent
+// payloads. Rules are applied after event processors, in order.
+// Exactly one action must be configured.
+//
+// Expressions are compiled and type-checked when the configuration is
+// loaded; see package modelcel for the expression environment.
+type EventRuleConfig struct {
+	// Name identifies the rule in metrics, and must be unique.
+	Name string `config:"name" validate:"required"`
+
+	// When holds a boolean expression which an event must match
+	// for the action to be applied. If empty, all events match.
+	When string `config:"when"`
+
+	// DropEvent drops matching events.
+	DropEvent bool `config:"drop_event"`
+
+	// SetLabels holds expressions for computing labels to set on
+	// matching events, keyed by label name. Expressions evaluating
+	// to numbers set numeric labels.
+	SetLabels map[string]string `config:"set_labels"`
+}
+
+func (c *EventRuleConfig) Validate() error {
+	if c.DropEvent == (len(c.SetLabels) != 0) {
+		return errors.New("exactly one of drop_event or set_labels must be configured")
+	}
+	if c.When != "" {
+		if _, err := modelcel.CompileCondition(c.When); err != nil {
+			return fmt.Errorf("invalid when expression: %w", err)
+		}
+	}
+	for key, expr := range c.SetLabels {
+		if _, err := modelcel.CompileLabel(expr); err != nil {
+			return fmt.Errorf("invalid set_labels expression for %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func validateEventRules(rules []EventRuleConfig) error {
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate event rule name %q", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestEventRulesConfig(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"event_rules": []map[string]interface{}{{
+			"name":       "drop_fast_cache_spans",
+			"when":       "duration(event.event.duration) < duration('1ms') && event.span.type == 'cache'",
+			"drop_event": true,
+		}, {
+			"name":       "tier",
+			"set_labels": map[string]interface{}{"tier": "event.service.name.startsWith('prod') ? 'gold' : 'silver'"},
+		}},
+	}), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, []EventRuleConfig{{
+		Name:      "drop_fast_cache_spans",
+		When:      "duration(event.event.duration) < duration('1ms') && event.span.type == 'cache'",
+		DropEvent: true,
+	}, {
+		Name:      "tier",
+		SetLabels: map[string]string{"tier": "event.service.name.startsWith('prod') ? 'gold' : 'silver'"},
+	}}, cfg.EventRules)
+}
+
+func TestEventRulesConfigInvalid(t *testing.T) {
+	for name, test := range map[string]struct {
+		rules []map[string]interface{}
+		err   string
+	}{
+		"no_name": {
+			rules: []map[string]interface{}{{"drop_event": true}},
+			err:   "string value is not set accessing 'event_rules.0.name'",
+		},
+		"no_action": {
+			rules: []map[string]interface{}{{"name": "a", "when": "true"}},
+			err:   "exactly one of drop_event or set_labels must be configured accessing 'event_rules.0'",
+		},
+		"multiple_actions": {
+			rules: []map[string]interface{}{{"name": "a", "drop_event": true, "set_labels.x": "'y'"}},
+			err:   "exactly one of drop_event or set_labels must be configured accessing 'event_rules.0'",
+		},
+		"when_type": {
+			rules: []map[string]interface{}{{"name": "a", "drop_event": true, "when": "event.service.name"}},
+			err:   "invalid when expression: expression must evaluate to bool, got string",
+		},
+		"when_field": {
+			rules: []map[string]interface{}{{"name": "a", "drop_event": true, "when": "event.span.tpye == 'x'"}},
+			err:   "undefined field 'tpye'",
+		},
+		"set_labels_type": {
+			rules: []map[string]interface{}{{"name": "a", "set_labels.x": "has(event.span)"}},
+			err:   `invalid set_labels expression for "x": expression must evaluate to one of string, double, int, uint, got bool`,
+		},
+		"duplicate_name": {
+			rules: []map[string]interface{}{{"name": "a", "drop_event": true}, {"name": "a", "drop_event": true}},
+			err:   `duplicate event rule name "a"`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+				"event_rules": test.rules,
+			}), nil, logptest.NewTestingLogger(t, ""))
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// GeoIPConfig holds configuration for enriching events with geolocation and
+// autonomous system (AS) information, looked up from local MaxMind-format
+// (MMDB) database files. The database files are reloaded when modified.
+//
+// The results are recorded as labels, as the event model has no fields for
+// them. Only the country-level labels are global, and so included as
+// dimensions in aggregated metrics.
+type GeoIPConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// CityDatabase holds the path to a City or Country database, used for
+	// resolving the geolocation of client.ip into the global labels
+	// client_geo_continent_name, client_geo_country_iso_code, and
+	// client_geo_country_name, and the labels client_geo_region_iso_code,
+	// client_geo_region_name, and client_geo_city_name.
+	CityDatabase string `config:"city_database"`
+
+	// ASNDatabase holds the path to an ASN database, used for resolving
+	// the autonomous system of source.ip into the labels source_as_number
+	// and source_as_organization_name.
+	ASNDatabase string `config:"asn_database"`
+
+	// ReloadInterval holds the interval at which the database
+	// files are checked for modifications.
+	ReloadInterval time.Duration `config:"reload_interval"`
+}
+
+func (c *GeoIPConfig) Validate() error {
+	if c.Enabled && c.CityDatabase == "" && c.ASNDatabase == "" {
+		return errors.New("at least one of city_database or asn_database must be configured")
+	}
+	if c.ReloadInterval <= 0 {
+		return errors.New("reload_interval must be positive")
+	}
+	return nil
+}
+
+func defaultGeoIPConfig() GeoIPConfig {
+	return GeoIPConfig{
+		Enabled:        false,
+		ReloadInterval: time.Minute,
+	}
+}
@@ -0,0 +1,68 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestGeoIPConfig(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"geoip": map[string]interface{}{
+			"enabled":         true,
+			"city_database":   "/data/GeoLite2-City.mmdb",
+			"reload_interval": "5m",
+		},
+	}), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, GeoIPConfig{
+		Enabled:        true,
+		CityDatabase:   "/data/GeoLite2-City.mmdb",
+		ReloadInterval: 5 * time.Minute,
+	}, cfg.GeoIP)
+}
+
+func TestGeoIPConfigInvalid(t *testing.T) {
+	for name, test := range map[string]struct {
+		config map[string]interface{}
+		err    string
+	}{
+		"no_database": {
+			config: map[string]interface{}{"enabled": true},
+			err:    "at least one of city_database or asn_database must be configured accessing 'geoip'",
+		},
+		"reload_interval": {
+			config: map[string]interface{}{"reload_interval": "0s"},
+			err:    "reload_interval must be positive accessing 'geoip'",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+				"geoip": test.config,
+			}), nil, logptest.NewTestingLogger(t, ""))
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/elastic/apm-server/internal/netutil"
+)
+
+// IPFilterConfig holds IP allow and deny rules for each group of routes.
+// Rules are matched against the client IP, as resolved using trusted_proxies.
+type IPFilterConfig struct {
+	// Backend holds rules for the backend agent intake route.
+	Backend IPFilterRules `config:"backend"`
+
+	// RUM holds rules for the RUM intake and RUM agent config routes.
+	RUM IPFilterRules `config:"rum"`
+
+	// OTLP holds rules for the OTLP/HTTP routes and OTLP/gRPC services.
+	OTLP IPFilterRules `config:"otlp"`
+
+	// AgentConfig holds rules for the backend agent config route.
+	AgentConfig IPFilterRules `config:"agent_config"`
+
+	// Root holds rules for the root route.
+	Root IPFilterRules `config:"root"`
+}
+
+// IPFilterRules holds IP allow and deny rules, as lists of CIDR prefixes
+// or IP addresses. Denied addresses take precedence over allowed addresses,
+// and if Allow is non-empty then only matching addresses are allowed.
+type IPFilterRules struct {
+	Allow []string `config:"allow"`
+	Deny  []string `config:"deny"`
+}
+
+// Filter returns a netutil.IPFilter for the rules, or nil if
+// there are no rules.
+func (r IPFilterRules) Filter() (*netutil.IPFilter, error) {
+	return netutil.NewIPFilter(r.Allow, r.Deny)
+}
+
+func (c *IPFilterConfig) validate() error {
+	for name, rules := range map[string]IPFilterRules{
+		"backend":      c.Backend,
+		"rum":          c.RUM,
+		"otlp":         c.OTLP,
+		"agent_config": c.AgentConfig,
+		"root":         c.Root,
+	} {
+		if _, err := rules.Filter(); err != nil {
+			return fmt.Errorf("ip_filter.%s: %w", name, err)
+		}
+	}
+	return nil
+}
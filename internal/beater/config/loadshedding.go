@@ -0,0 +1,66 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// LoadSheddingConfig holds configuration for rejecting intake requests
+// early when the server is under output backpressure or memory pressure.
+//
+// Each threshold defines the level of a pressure signal at which requests
+// begin to be shed. A threshold of zero disables the signal.
+type LoadSheddingConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// QueueThreshold holds the fraction of the output document
+	// buffer in use at which requests begin to be shed.
+	QueueThreshold float64 `config:"queue_threshold" validate:"min=0, max=1"`
+
+	// SemaphoreWaitThreshold holds the average time spent waiting
+	// for a decoder, as limited by max_concurrent_decoders, at
+	// which requests begin to be shed.
+	SemaphoreWaitThreshold time.Duration `config:"semaphore_wait_threshold" validate:"min=0"`
+
+	// MemoryThreshold holds the fraction of the memory limit in
+	// use by the Go heap at which requests begin to be shed.
+	MemoryThreshold float64 `config:"memory_threshold" validate:"min=0, max=1"`
+
+	// RetryAfter holds the duration after which clients are
+	// advised to retry shed requests.
+	RetryAfter time.Duration `config:"retry_after"`
+}
+
+func (c *LoadSheddingConfig) Validate() error {
+	if c.RetryAfter <= 0 {
+		return errors.New("retry_after must be positive")
+	}
+	return nil
+}
+
+func defaultLoadSheddingConfig() LoadSheddingConfig {
+	return LoadSheddingConfig{
+		Enabled:                false,
+		QueueThreshold:         0.8,
+		SemaphoreWaitThreshold: time.Second,
+		MemoryThreshold:        0.8,
+		RetryAfter:             5 * time.Second,
+	}
+}
@@ -0,0 +1,70 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestLoadSheddingConfig(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"load_shedding.enabled":         true,
+		"load_shedding.queue_threshold": 0.5,
+		"load_shedding.retry_after":     "10s",
+	}), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, LoadSheddingConfig{
+		Enabled:                true,
+		QueueThreshold:         0.5,
+		SemaphoreWaitThreshold: time.Second,
+		MemoryThreshold:        0.8,
+		RetryAfter:             10 * time.Second,
+	}, cfg.LoadShedding)
+}
+
+func TestLoadSheddingConfigInvalid(t *testing.T) {
+	for name, test := range map[string]struct {
+		config map[string]interface{}
+		err    string
+	}{
+		"queue_threshold": {
+			config: map[string]interface{}{"load_shedding.queue_threshold": 1.5},
+			err:    "requires value <= 1 accessing 'load_shedding.queue_threshold'",
+		},
+		"memory_threshold": {
+			config: map[string]interface{}{"load_shedding.memory_threshold": -1},
+			err:    "requires value >= 0 accessing 'load_shedding.memory_threshold'",
+		},
+		"retry_after": {
+			config: map[string]interface{}{"load_shedding.retry_after": "0s"},
+			err:    "retry_after must be positive accessing 'load_shedding'",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(test.config), nil, logptest.NewTestingLogger(t, ""))
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// MemoryAutotuneConfig holds configuration for periodically re-reading the
+// process memory limit, and resizing memory-dependent components when it
+// changes. Only settings which are sized automatically, i.e. those which
+// are not explicitly configured, are resized.
+type MemoryAutotuneConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// Interval holds the interval at which the memory limit is re-read.
+	Interval time.Duration `config:"interval"`
+}
+
+func (c *MemoryAutotuneConfig) Validate() error {
+	if c.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	return nil
+}
+
+func defaultMemoryAutotuneConfig() MemoryAutotuneConfig {
+	return MemoryAutotuneConfig{
+		Enabled:  false,
+		Interval: 30 * time.Second,
+	}
+}
@@ -0,0 +1,98 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// NameNormalizationConfig holds configuration for rewriting transaction and
+// span names which contain identifiers, such as `GET /users/123`, into
+// templated names with bounded cardinality, such as `GET /users/{id}`,
+// before events are aggregated.
+type NameNormalizationConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// AutoDetect enables replacing numeric, UUID, and hexadecimal path
+	// segments of names which do not match any of the rules.
+	AutoDetect bool `config:"auto_detect"`
+
+	// Rules holds path templating rules, applied in order.
+	Rules []NameNormalizationRuleConfig `config:"rules"`
+}
+
+// NameNormalizationRuleConfig holds a path template for normalizing names.
+//
+// Template holds a URL path whose segments are either literal, or
+// placeholders enclosed in braces, such as `/users/{user_id}/orders`.
+// Names whose path has the same literal segments, and any non-empty values
+// for the placeholder segments, have their path replaced with the template.
+type NameNormalizationRuleConfig struct {
+	// Name identifies the rule in metrics, and must be unique.
+	Name string `config:"name" validate:"required"`
+
+	// ServiceName holds the names of services to which the rule applies.
+	// If empty, the rule applies to all services.
+	ServiceName []string `config:"service_name"`
+
+	Template string `config:"template" validate:"required"`
+}
+
+func (c *NameNormalizationConfig) Validate() error {
+	names := make(map[string]bool, len(c.Rules))
+	for _, rule := range c.Rules {
+		if rule.Name == NameNormalizationAutoDetectRule {
+			return fmt.Errorf("rule name %q is reserved", rule.Name)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate rule name %q", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return nil
+}
+
+// NameNormalizationAutoDetectRule is the rule name under which
+// names rewritten by automatic detection are counted in metrics.
+const NameNormalizationAutoDetectRule = "auto_detect"
+
+func (c *NameNormalizationRuleConfig) Validate() error {
+	if !strings.HasPrefix(c.Template, "/") {
+		return errors.New("template must begin with /")
+	}
+	for _, segment := range strings.Split(c.Template[1:], "/") {
+		if strings.ContainsAny(segment, "{}") && !isTemplatePlaceholder(segment) {
+			return fmt.Errorf("invalid template segment %q: placeholders must be entire segments", segment)
+		}
+	}
+	return nil
+}
+
+func isTemplatePlaceholder(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}' &&
+		!strings.ContainsAny(segment[1:len(segment)-1], "{}")
+}
+
+func defaultNameNormalizationConfig() NameNormalizationConfig {
+	return NameNormalizationConfig{
+		Enabled:    false,
+		AutoDetect: true,
+	}
+}
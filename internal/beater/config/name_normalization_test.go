@@ -0,0 +1,89 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestNameNormalizationConfig(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"name_normalization": map[string]interface{}{
+			"enabled":     true,
+			"auto_detect": false,
+			"rules": []map[string]interface{}{{
+				"name":         "users",
+				"service_name": []string{"opbeans"},
+				"template":     "/users/{user_id}",
+			}},
+		},
+	}), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, NameNormalizationConfig{
+		Enabled: true,
+		Rules: []NameNormalizationRuleConfig{{
+			Name:        "users",
+			ServiceName: []string{"opbeans"},
+			Template:    "/users/{user_id}",
+		}},
+	}, cfg.NameNormalization)
+}
+
+func TestNameNormalizationConfigInvalid(t *testing.T) {
+	for name, test := range map[string]struct {
+		rules  []map[string]interface{}
+		expect string
+	}{
+		"missing_template": {
+			rules:  []map[string]interface{}{{"name": "a"}},
+			expect: "string value is not set accessing 'name_normalization.rules.0.template'",
+		},
+		"relative_template": {
+			rules:  []map[string]interface{}{{"name": "a", "template": "users/{id}"}},
+			expect: "template must begin with / accessing 'name_normalization.rules.0'",
+		},
+		"partial_placeholder": {
+			rules:  []map[string]interface{}{{"name": "a", "template": "/users/id-{id}"}},
+			expect: `invalid template segment "id-{id}": placeholders must be entire segments accessing 'name_normalization.rules.0'`,
+		},
+		"duplicate_name": {
+			rules: []map[string]interface{}{
+				{"name": "a", "template": "/a/{id}"},
+				{"name": "a", "template": "/b/{id}"},
+			},
+			expect: `duplicate rule name "a" accessing 'name_normalization'`,
+		},
+		"reserved_name": {
+			rules:  []map[string]interface{}{{"name": "auto_detect", "template": "/a/{id}"}},
+			expect: `rule name "auto_detect" is reserved accessing 'name_normalization'`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+				"name_normalization.rules": test.rules,
+			}), nil, logptest.NewTestingLogger(t, ""))
+			assert.ErrorContains(t, err, test.expect)
+		})
+	}
+}
@@ -17,6 +17,13 @@
 
 package config
 
+import (
+	"errors"
+	"fmt"
+
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
 // RateLimit holds configuration related to IP and event rate limiting.
 type RateLimit struct {
 	// EventLimit holds the event rate limit per IP, measured in
@@ -29,3 +36,48 @@ type RateLimit struct {
 	// done to avoid DDoS attacks.
 	IPLimit int `config:"ip_limit"`
 }
+
+// Quota holds configuration for an event and byte rate quota.
+//
+// Exactly one of APIKeyID, SecretTokenName, and ServiceName must be set,
+// and at least one of EventLimit and ByteLimit must be set.
+type Quota struct {
+	// APIKeyID applies the quota to clients authenticated
+	// with the API Key with this ID.
+	APIKeyID string `config:"api_key_id"`
+
+	// SecretTokenName applies the quota to clients authenticated
+	// with the named secret token with this name.
+	SecretTokenName string `config:"secret_token_name"`
+
+	// ServiceName applies the quota to events for the service
+	// with this name, regardless of how the client authenticated.
+	ServiceName string `config:"service_name"`
+
+	// EventLimit holds the event rate limit, measured in events per second.
+	EventLimit int `config:"event_limit" validate:"min=0"`
+
+	// ByteLimit holds the byte rate limit, measured in bytes per second
+	// of encoded events.
+	ByteLimit int `config:"byte_limit" validate:"min=0"`
+}
+
+func (q *Quota) Unpack(in *config.C) error {
+	type underlyingQuota Quota
+	if err := in.Unpack((*underlyingQuota)(q)); err != nil {
+		return fmt.Errorf("error unpacking quotas config: %w", err)
+	}
+	var keys int
+	for _, key := range []string{q.APIKeyID, q.SecretTokenName, q.ServiceName} {
+		if key != "" {
+			keys++
+		}
+	}
+	if keys != 1 {
+		return errors.New("quota must specify exactly one of api_key_id, secret_token_name, or service_name")
+	}
+	if q.EventLimit == 0 && q.ByteLimit == 0 {
+		return errors.New("quota must specify event_limit or byte_limit")
+	}
+	return nil
+}
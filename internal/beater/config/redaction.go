@@ -0,0 +1,70 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RedactionConfig holds configuration for masking personal data and
+// secrets in events decoded from agent payloads, before they are
+// aggregated, sampled, or indexed.
+//
+// Values of URL query parameters, HTTP headers, cookies, and labels whose
+// names contain one of the keywords are replaced entirely. Matches of the
+// patterns are replaced in the remaining values, and in URLs, database
+// statements, and log and error messages.
+type RedactionConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// DefaultRules enables built-in patterns for email addresses,
+	// bearer tokens, and payment card numbers, and built-in keywords
+	// for common names of secrets such as "password" and "token".
+	DefaultRules bool `config:"default_rules"`
+
+	// Patterns holds additional regular expressions to redact.
+	Patterns []RedactionPatternConfig `config:"patterns"`
+
+	// Keywords holds additional case-insensitive keywords.
+	Keywords []string `config:"keywords"`
+
+	// Replacement holds the string with which redacted values are replaced.
+	Replacement string `config:"replacement"`
+}
+
+// RedactionPatternConfig holds a named regular expression to redact.
+type RedactionPatternConfig struct {
+	Name  string `config:"name" validate:"required"`
+	Regex string `config:"regex" validate:"required"`
+}
+
+func (c *RedactionPatternConfig) Validate() error {
+	if _, err := regexp.Compile(c.Regex); err != nil {
+		return fmt.Errorf("invalid regex for pattern %q: %w", c.Name, err)
+	}
+	return nil
+}
+
+func defaultRedactionConfig() RedactionConfig {
+	return RedactionConfig{
+		Enabled:      false,
+		DefaultRules: true,
+		Replacement:  "[REDACTED]",
+	}
+}
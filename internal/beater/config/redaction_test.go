@@ -0,0 +1,69 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestRedactionConfig(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"redaction": map[string]interface{}{
+			"enabled":  true,
+			"patterns": []map[string]interface{}{{"name": "ssn", "regex": `\d{3}-\d{2}-\d{4}`}},
+			"keywords": []string{"customer"},
+		},
+	}), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, RedactionConfig{
+		Enabled:      true,
+		DefaultRules: true,
+		Patterns:     []RedactionPatternConfig{{Name: "ssn", Regex: `\d{3}-\d{2}-\d{4}`}},
+		Keywords:     []string{"customer"},
+		Replacement:  "[REDACTED]",
+	}, cfg.Redaction)
+}
+
+func TestRedactionConfigInvalid(t *testing.T) {
+	for name, test := range map[string]struct {
+		pattern map[string]interface{}
+		err     string
+	}{
+		"no_name": {
+			pattern: map[string]interface{}{"regex": "x"},
+			err:     "string value is not set accessing 'redaction.patterns.0.name'",
+		},
+		"invalid_regex": {
+			pattern: map[string]interface{}{"name": "x", "regex": "("},
+			err:     `invalid regex for pattern "x"`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+				"redaction.patterns": []map[string]interface{}{test.pattern},
+			}), nil, logptest.NewTestingLogger(t, ""))
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
@@ -20,6 +20,11 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/dustin/go-humanize"
@@ -27,6 +32,7 @@ import (
 	"github.com/elastic/apm-server/internal/elasticsearch"
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 )
 
 // SamplingConfig holds configuration related to sampling.
@@ -45,6 +51,22 @@ type TailSamplingConfig struct {
 	// that dropping non-matching traces is intentional.
 	Policies []TailSamplingPolicy `config:"policies"`
 
+	// ShadowPolicies, if non-empty, holds candidate tail-sampling policies
+	// which are evaluated alongside Policies without affecting sampling
+	// decisions. The number of traces that each shadow policy would have
+	// sampled, and its effective sample rate, are reported as metrics.
+	//
+	// Like Policies, ShadowPolicies must include a default policy.
+	ShadowPolicies []TailSamplingPolicy `config:"shadow_policies"`
+
+	// PoliciesFile holds configuration for loading Policies and
+	// ShadowPolicies from a file, which is watched for changes.
+	PoliciesFile TailSamplingPoliciesFileConfig `config:"policies_file"`
+
+	// InterestingEvents holds criteria for non-root events which cause the
+	// traces containing them to be sampled, regardless of the policies.
+	InterestingEvents []TailSamplingInterestingEvent `config:"interesting_events"`
+
 	ESConfig              *elasticsearch.Config `config:"elasticsearch"`
 	Interval              time.Duration         `config:"interval" validate:"min=1s"`
 	IngestRateDecayFactor float64               `config:"ingest_rate_decay" validate:"min=0, max=1"`
@@ -63,12 +85,226 @@ type TailSamplingConfig struct {
 
 	DiscardOnWriteFailure bool `config:"discard_on_write_failure"`
 
+	// TraceCompletion holds configuration for making sampling decisions
+	// on trace completion, rather than at the end of each Interval.
+	TraceCompletion TailSamplingTraceCompletionConfig `config:"trace_completion"`
+
+	// Peer holds configuration for exchanging sampling decisions directly
+	// with other APM Servers, rather than through Elasticsearch.
+	Peer TailSamplingPeerConfig `config:"peer"`
+
+	// Forwarding holds configuration for routing trace events between
+	// APM Servers by trace ID, so each trace is sampled by one server.
+	Forwarding TailSamplingForwardingConfig `config:"forwarding"`
+
+	// AdminAPI holds configuration for the tail-sampling admin API.
+	AdminAPI TailSamplingAdminAPIConfig `config:"admin_api"`
+
 	// DatabaseCacheSize is cache size in bytes for tail-sampling database.
 	DatabaseCacheSize uint64 `config:"database_cache_size"`
 
+	// Storage holds configuration for encoding events in the
+	// tail-sampling database.
+	Storage TailSamplingStorageConfig `config:"storage"`
+
 	esConfigured bool
 }
 
+// TailSamplingTraceCompletionConfig holds configuration for making
+// sampling decisions on trace completion.
+type TailSamplingTraceCompletionConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// IdleTimeout holds the amount of time after the most recent event
+	// of a trace, whose root transaction has been received, after which
+	// the trace is considered complete.
+	IdleTimeout time.Duration `config:"idle_timeout"`
+
+	// RootGracePeriod, if non-zero, holds the amount of time after the
+	// root transaction of a trace is received after which the trace is
+	// considered complete, even if it has not been idle for IdleTimeout.
+	RootGracePeriod time.Duration `config:"root_grace_period"`
+}
+
+// TailSamplingStorageConfig holds configuration for encoding events
+// in the tail-sampling database.
+type TailSamplingStorageConfig struct {
+	// Codec holds the encoding of stored events: "protobuf" stores
+	// events uncompressed, while "zstd" compresses them with zstd.
+	//
+	// Both codecs can read events stored with either codec, so existing
+	// databases remain readable after switching between them.
+	Codec string `config:"codec"`
+
+	// Dictionaries holds paths of zstd dictionary files, such as those
+	// created by `zstd --train`. The zstd codec encodes events using the
+	// last dictionary, and either codec may decode events using any of
+	// them, so dictionaries should be kept after switching to protobuf
+	// until events stored with zstd have expired.
+	Dictionaries []string `config:"dictionaries"`
+}
+
+const (
+	// TailSamplingStorageCodecProtobuf identifies the protobuf codec.
+	TailSamplingStorageCodecProtobuf = "protobuf"
+
+	// TailSamplingStorageCodecZstd identifies the zstd codec.
+	TailSamplingStorageCodecZstd = "zstd"
+)
+
+func (c *TailSamplingStorageConfig) validate() error {
+	switch c.Codec {
+	case TailSamplingStorageCodecProtobuf, TailSamplingStorageCodecZstd:
+	default:
+		return fmt.Errorf("unknown codec %q, expected %q or %q",
+			c.Codec, TailSamplingStorageCodecProtobuf, TailSamplingStorageCodecZstd,
+		)
+	}
+	return nil
+}
+
+// TailSamplingPoliciesFileConfig holds configuration for loading
+// tail-sampling policies from a file.
+type TailSamplingPoliciesFileConfig struct {
+	// Path, if non-empty, holds the path of a YAML file defining policies
+	// and, optionally, shadow_policies. These replace the policies defined
+	// in the main configuration.
+	Path string `config:"path"`
+
+	// ReloadPeriod holds the interval at which the file is checked for
+	// changes. Policies are updated without restarting the server.
+	ReloadPeriod time.Duration `config:"reload_period"`
+}
+
+// TailSamplingPolicies holds tail-sampling policies loaded from a file.
+type TailSamplingPolicies struct {
+	Policies       []TailSamplingPolicy `config:"policies"`
+	ShadowPolicies []TailSamplingPolicy `config:"shadow_policies"`
+}
+
+// LoadTailSamplingPolicies loads and validates tail-sampling policies
+// from the YAML file at path.
+func LoadTailSamplingPolicies(path string) (TailSamplingPolicies, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return TailSamplingPolicies{}, err
+	}
+	in, err := config.NewConfigWithYAML(data, path)
+	if err != nil {
+		return TailSamplingPolicies{}, err
+	}
+	var policies TailSamplingPolicies
+	if err := in.Unpack(&policies); err != nil {
+		return TailSamplingPolicies{}, err
+	}
+	if len(policies.Policies) == 0 {
+		return TailSamplingPolicies{}, errors.New("no policies specified")
+	}
+	if err := validatePolicies(policies.Policies); err != nil {
+		return TailSamplingPolicies{}, err
+	}
+	if len(policies.ShadowPolicies) != 0 {
+		if err := validatePolicies(policies.ShadowPolicies); err != nil {
+			return TailSamplingPolicies{}, fmt.Errorf("invalid shadow_policies: %w", err)
+		}
+	}
+	return policies, nil
+}
+
+// TailSamplingAdminAPIConfig holds configuration for the tail-sampling
+// admin API, for inspecting and overriding sampling decisions. The admin
+// API may only be enabled if secret token or API Key auth is configured.
+type TailSamplingAdminAPIConfig struct {
+	Enabled bool `config:"enabled"`
+}
+
+// TailSamplingPeerConfig holds configuration for exchanging sampling
+// decisions directly with other APM Servers over gRPC.
+type TailSamplingPeerConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// ListenAddress holds the host:port on which sampled trace IDs
+	// are served to peers.
+	ListenAddress string `config:"listen_address"`
+
+	// Hosts holds the static host:port addresses of peers.
+	Hosts []string `config:"hosts"`
+
+	// DNSName, if non-empty, holds a host:port whose host is resolved
+	// to peer addresses every DiscoveryInterval.
+	DNSName           string        `config:"dns_name"`
+	DiscoveryInterval time.Duration `config:"discovery_interval"`
+
+	// BatchSize holds the maximum number of trace IDs sent to a peer
+	// in each message.
+	BatchSize int `config:"batch_size"`
+
+	// LogSize holds the number of recently sampled trace IDs retained
+	// in memory for peers which are temporarily disconnected. Trace IDs
+	// which peers have not received are lost if they are evicted, or if
+	// the server restarts.
+	LogSize int `config:"log_size"`
+
+	// TLS holds TLS configuration for serving peers. Mutual TLS is
+	// enabled by setting ssl.client_authentication to "required".
+	TLS *tlscommon.ServerConfig `config:"ssl"`
+
+	// ClientTLS holds TLS configuration for subscribing to peers,
+	// including the client certificate for mutual TLS.
+	ClientTLS *tlscommon.Config `config:"client_ssl"`
+
+	// SecretToken, if non-empty, holds a secret shared by peers,
+	// which subscribing peers must present.
+	SecretToken string `config:"secret_token"`
+}
+
+// TailSamplingForwardingConfig holds configuration for forwarding trace
+// events to the APM Server owning their trace ID on a consistent-hash ring.
+type TailSamplingForwardingConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// ListenAddress holds the host:port on which forwarded events are received.
+	ListenAddress string `config:"listen_address"`
+
+	// AdvertiseAddress holds the host:port by which other servers address
+	// this server, matching one of Hosts or an address resolved from DNSName.
+	AdvertiseAddress string `config:"advertise_address"`
+
+	// Hosts holds the static host:port addresses of other servers.
+	Hosts []string `config:"hosts"`
+
+	// DNSName, if non-empty, holds a host:port whose host is resolved
+	// to server addresses every DiscoveryInterval.
+	DNSName           string        `config:"dns_name"`
+	DiscoveryInterval time.Duration `config:"discovery_interval"`
+
+	// HandoffPeriod holds the amount of time after a membership change
+	// during which events of traces first seen before the change continue
+	// to be routed to their previous owner.
+	HandoffPeriod time.Duration `config:"handoff_period"`
+
+	BatchSize     int           `config:"batch_size"`
+	FlushInterval time.Duration `config:"flush_interval"`
+	QueueSize     int           `config:"queue_size"`
+	MaxRetries    int           `config:"max_retries"`
+
+	// TLS holds TLS configuration for receiving forwarded events. Mutual
+	// TLS is enabled by setting ssl.client_authentication to "required".
+	TLS *tlscommon.ServerConfig `config:"ssl"`
+
+	// ClientTLS holds TLS configuration for forwarding events to other
+	// servers, including the client certificate for mutual TLS.
+	ClientTLS *tlscommon.Config `config:"client_ssl"`
+
+	// SecretToken, if non-empty, holds a secret shared by the servers,
+	// which servers forwarding events must present.
+	SecretToken string `config:"secret_token"`
+
+	// IPFilter holds rules for the IP addresses of servers which
+	// are allowed to forward events.
+	IPFilter IPFilterRules `config:"ip_filter"`
+}
+
 // TailSamplingPolicy holds a tail-sampling policy.
 type TailSamplingPolicy struct {
 	// Service holds attributes of the service which this policy matches.
@@ -81,10 +317,94 @@ type TailSamplingPolicy struct {
 	Trace struct {
 		Name    string `config:"name"`
 		Outcome string `config:"outcome"`
+
+		// MinDuration and MaxDuration hold the inclusive lower and exclusive
+		// upper bounds of the root transaction duration which this policy
+		// matches. Zero values are unbounded.
+		MinDuration time.Duration `config:"min_duration"`
+		MaxDuration time.Duration `config:"max_duration"`
 	} `config:"trace"`
 
+	// Conditions holds conditions on fields of the root transaction,
+	// all of which must be satisfied for this policy to match.
+	Conditions []TailSamplingCondition `config:"conditions"`
+
 	// SampleRate holds the sample rate applied for this policy.
 	SampleRate float64 `config:"sample_rate" validate:"min=0, max=1"`
+
+	// TargetTracesPerSecond, if non-zero, holds the target number of traces
+	// sampled per second for each trace group matching this policy, in place
+	// of SampleRate. The effective sample rate is bounded by MinSampleRate
+	// and MaxSampleRate, which defaults to 1.
+	TargetTracesPerSecond float64  `config:"target_traces_per_second" validate:"min=0"`
+	MinSampleRate         float64  `config:"min_sample_rate" validate:"min=0, max=1"`
+	MaxSampleRate         *float64 `config:"max_sample_rate"`
+}
+
+// TailSamplingInterestingEvent holds criteria for events which cause the
+// traces containing them to be sampled.
+type TailSamplingInterestingEvent struct {
+	// Name identifies the criteria in metrics.
+	Name string `config:"name" validate:"required"`
+
+	// EventType holds the type of events matched: transaction, span, or error.
+	EventType string `config:"event_type" validate:"required"`
+
+	// MinDuration holds the inclusive lower bound of the duration of
+	// matching transactions and spans. It may not be set for errors.
+	MinDuration time.Duration `config:"min_duration"`
+
+	// Conditions holds conditions on fields of the event, all of which
+	// must be satisfied for the event to match.
+	Conditions []TailSamplingCondition `config:"conditions"`
+}
+
+// TailSamplingCondition holds a condition on a field of a root transaction.
+//
+// Exactly one of Equals, Glob, Regex, GreaterThan, GreaterThanOrEqual,
+// LessThan, and LessThanOrEqual must be specified. A condition on a field
+// which is not set on the root transaction is not satisfied, unless negated.
+type TailSamplingCondition struct {
+	// Field holds the name of the field: one of TailSamplingConditionFields,
+	// or `labels.<key>` or `numeric_labels.<key>`.
+	Field string `config:"field"`
+
+	Equals             *string  `config:"equals"`
+	Glob               *string  `config:"glob"`
+	Regex              *string  `config:"regex"`
+	GreaterThan        *float64 `config:"gt"`
+	GreaterThanOrEqual *float64 `config:"gte"`
+	LessThan           *float64 `config:"lt"`
+	LessThanOrEqual    *float64 `config:"lte"`
+
+	// Negate negates the condition.
+	Negate bool `config:"negate"`
+}
+
+// TailSamplingConditionFields holds the names of event fields, other than
+// labels, which may be used in tail-sampling conditions. The span.* and
+// error.* fields are only set on span and error interesting events.
+var TailSamplingConditionFields = []string{
+	"agent.name",
+	"error.exception.type",
+	"error.log.level",
+	"event.outcome",
+	"host.name",
+	"http.request.method",
+	"http.response.status_code",
+	"service.environment",
+	"service.name",
+	"service.node.name",
+	"service.version",
+	"span.action",
+	"span.name",
+	"span.subtype",
+	"span.type",
+	"transaction.name",
+	"transaction.result",
+	"transaction.type",
+	"url.path",
+	"user_agent.name",
 }
 
 func (c *TailSamplingConfig) Unpack(in *config.C) error {
@@ -111,15 +431,70 @@ func (c *TailSamplingConfig) Validate() error {
 	if !c.Enabled {
 		return nil
 	}
-	if len(c.Policies) == 0 {
+	if c.PoliciesFile.Path != "" {
+		if c.PoliciesFile.ReloadPeriod <= 0 {
+			return errors.New("policies_file.reload_period must be positive")
+		}
+	} else if len(c.Policies) == 0 {
 		return errors.New("no policies specified")
 	}
+	if len(c.Policies) != 0 {
+		if err := validatePolicies(c.Policies); err != nil {
+			return err
+		}
+	}
+	if len(c.ShadowPolicies) != 0 {
+		if err := validatePolicies(c.ShadowPolicies); err != nil {
+			return fmt.Errorf("invalid shadow_policies: %w", err)
+		}
+	}
+	if c.TraceCompletion.Enabled {
+		if c.TraceCompletion.IdleTimeout <= 0 {
+			return errors.New("trace_completion.idle_timeout must be positive")
+		}
+		if c.TraceCompletion.IdleTimeout >= c.TTL {
+			return errors.New("trace_completion.idle_timeout must be less than ttl")
+		}
+		if c.TraceCompletion.RootGracePeriod < 0 {
+			return errors.New("trace_completion.root_grace_period must not be negative")
+		}
+	}
+	if c.Peer.Enabled {
+		if err := c.Peer.validate(); err != nil {
+			return fmt.Errorf("invalid peer config: %w", err)
+		}
+	}
+	if c.Forwarding.Enabled {
+		if err := c.Forwarding.validate(); err != nil {
+			return fmt.Errorf("invalid forwarding config: %w", err)
+		}
+	}
+	if err := c.Storage.validate(); err != nil {
+		return fmt.Errorf("invalid storage config: %w", err)
+	}
+	names := make(map[string]bool, len(c.InterestingEvents))
+	for i, event := range c.InterestingEvents {
+		if err := event.validate(); err != nil {
+			return fmt.Errorf("invalid interesting event %d: %w", i, err)
+		}
+		if names[event.Name] {
+			return fmt.Errorf("duplicate interesting event name %q", event.Name)
+		}
+		names[event.Name] = true
+	}
+	return nil
+}
+
+// validatePolicies validates each policy, and checks that at least one
+// is a default policy.
+func validatePolicies(policies []TailSamplingPolicy) error {
 	var anyDefaultPolicy bool
-	for _, policy := range c.Policies {
-		if policy == (TailSamplingPolicy{SampleRate: policy.SampleRate}) {
-			// We have at least one default policy.
+	for i, policy := range policies {
+		if err := policy.validate(); err != nil {
+			return fmt.Errorf("invalid policy %d: %w", i, err)
+		}
+		if policy.isDefault() {
 			anyDefaultPolicy = true
-			break
 		}
 	}
 	if !anyDefaultPolicy {
@@ -128,10 +503,183 @@ func (c *TailSamplingConfig) Validate() error {
 	return nil
 }
 
-func (c *TailSamplingConfig) setup(log *logp.Logger, outputESCfg *config.C) error {
+func (c *TailSamplingPeerConfig) validate() error {
+	if c.ListenAddress == "" {
+		return errors.New("listen_address must be specified")
+	}
+	if len(c.Hosts) == 0 && c.DNSName == "" {
+		return errors.New("hosts or dns_name must be specified")
+	}
+	if c.DNSName != "" {
+		if _, _, err := net.SplitHostPort(c.DNSName); err != nil {
+			return fmt.Errorf("invalid dns_name: %w", err)
+		}
+	}
+	if c.DiscoveryInterval <= 0 {
+		return errors.New("discovery_interval must be positive")
+	}
+	if c.BatchSize <= 0 {
+		return errors.New("batch_size must be positive")
+	}
+	if c.LogSize <= 0 {
+		return errors.New("log_size must be positive")
+	}
+	if c.TLS.IsEnabled() != c.ClientTLS.IsEnabled() {
+		return errors.New("ssl and client_ssl must both be enabled or disabled")
+	}
+	return nil
+}
+
+func (c *TailSamplingForwardingConfig) validate() error {
+	if c.ListenAddress == "" {
+		return errors.New("listen_address must be specified")
+	}
+	if c.AdvertiseAddress == "" {
+		return errors.New("advertise_address must be specified")
+	}
+	if c.DNSName != "" {
+		if _, _, err := net.SplitHostPort(c.DNSName); err != nil {
+			return fmt.Errorf("invalid dns_name: %w", err)
+		}
+	}
+	if c.DiscoveryInterval <= 0 {
+		return errors.New("discovery_interval must be positive")
+	}
+	if c.HandoffPeriod < 0 {
+		return errors.New("handoff_period must not be negative")
+	}
+	if c.BatchSize <= 0 {
+		return errors.New("batch_size must be positive")
+	}
+	if c.FlushInterval <= 0 {
+		return errors.New("flush_interval must be positive")
+	}
+	if c.QueueSize <= 0 {
+		return errors.New("queue_size must be positive")
+	}
+	if c.MaxRetries < 0 {
+		return errors.New("max_retries must not be negative")
+	}
+	if c.TLS.IsEnabled() != c.ClientTLS.IsEnabled() {
+		return errors.New("ssl and client_ssl must both be enabled or disabled")
+	}
+	if _, err := c.IPFilter.Filter(); err != nil {
+		return fmt.Errorf("ip_filter: %w", err)
+	}
+	return nil
+}
+
+func (e *TailSamplingInterestingEvent) validate() error {
+	switch e.EventType {
+	case "transaction", "span":
+	case "error":
+		if e.MinDuration != 0 {
+			return errors.New("min_duration may not be specified for errors")
+		}
+	default:
+		return fmt.Errorf("unsupported event_type %q", e.EventType)
+	}
+	if e.MinDuration < 0 {
+		return errors.New("min_duration must not be negative")
+	}
+	for i, cond := range e.Conditions {
+		if err := cond.validate(); err != nil {
+			return fmt.Errorf("invalid condition %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// isDefault reports whether the policy has empty criteria, matching all traces.
+func (p *TailSamplingPolicy) isDefault() bool {
+	return p.Service.Name == "" && p.Service.Environment == "" &&
+		p.Trace.Name == "" && p.Trace.Outcome == "" &&
+		p.Trace.MinDuration == 0 && p.Trace.MaxDuration == 0 &&
+		len(p.Conditions) == 0
+}
+
+func (p *TailSamplingPolicy) validate() error {
+	if p.Trace.MinDuration < 0 || p.Trace.MaxDuration < 0 {
+		return errors.New("trace durations must not be negative")
+	}
+	if p.Trace.MaxDuration != 0 && p.Trace.MaxDuration <= p.Trace.MinDuration {
+		return errors.New("trace.max_duration must be greater than trace.min_duration")
+	}
+	for i, cond := range p.Conditions {
+		if err := cond.validate(); err != nil {
+			return fmt.Errorf("invalid condition %d: %w", i, err)
+		}
+	}
+	if p.TargetTracesPerSecond == 0 {
+		if p.MinSampleRate != 0 || p.MaxSampleRate != nil {
+			return errors.New("min_sample_rate and max_sample_rate require target_traces_per_second")
+		}
+		return nil
+	}
+	if p.SampleRate != 0 {
+		return errors.New("sample_rate and target_traces_per_second are mutually exclusive")
+	}
+	maxSampleRate := p.GetMaxSampleRate()
+	if maxSampleRate <= 0 || maxSampleRate > 1 {
+		return errors.New("max_sample_rate must be in the range (0,1]")
+	}
+	if p.MinSampleRate > maxSampleRate {
+		return errors.New("min_sample_rate must not be greater than max_sample_rate")
+	}
+	return nil
+}
+
+// GetMaxSampleRate returns the maximum effective sample rate for
+// throughput-targeted policies: MaxSampleRate if specified, or 1.
+func (p *TailSamplingPolicy) GetMaxSampleRate() float64 {
+	if p.MaxSampleRate == nil {
+		return 1
+	}
+	return *p.MaxSampleRate
+}
+
+func (c *TailSamplingCondition) validate() error {
+	if c.Field == "" {
+		return errors.New("field unspecified")
+	}
+	if !slices.Contains(TailSamplingConditionFields, c.Field) {
+		key, ok := strings.CutPrefix(c.Field, "labels.")
+		if !ok {
+			key, ok = strings.CutPrefix(c.Field, "numeric_labels.")
+		}
+		if !ok || key == "" {
+			return fmt.Errorf("unsupported field %q", c.Field)
+		}
+	}
+	var operators int
+	for _, set := range []bool{
+		c.Equals != nil, c.Glob != nil, c.Regex != nil,
+		c.GreaterThan != nil, c.GreaterThanOrEqual != nil,
+		c.LessThan != nil, c.LessThanOrEqual != nil,
+	} {
+		if set {
+			operators++
+		}
+	}
+	if operators != 1 {
+		return errors.New("exactly one of equals, glob, regex, gt, gte, lt, or lte must be specified")
+	}
+	if c.Regex != nil {
+		if _, err := regexp.Compile(*c.Regex); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *TailSamplingConfig) setup(log *logp.Logger, outputESCfg *config.C, agentAuth *AgentAuth) error {
 	if !c.Enabled {
 		return nil
 	}
+	if c.AdminAPI.Enabled && !agentAuth.SecretTokenEnabled() && !agentAuth.APIKeyEnabled() {
+		// The admin API would otherwise be open to anyone.
+		return errors.New("invalid sampling.tail config: admin_api.enabled requires secret token or API Key auth")
+	}
 	if !c.esConfigured && outputESCfg != nil {
 		log.Info("Falling back to elasticsearch output for tail-sampling")
 		if err := outputESCfg.Unpack(&c.ESConfig); err != nil {
@@ -158,6 +706,28 @@ func defaultTailSamplingConfig() TailSamplingConfig {
 		StorageLimit:          "0",
 		DiskUsageThreshold:    0.8,
 		DiscardOnWriteFailure: false,
+		TraceCompletion: TailSamplingTraceCompletionConfig{
+			IdleTimeout: 30 * time.Second,
+		},
+		Peer: TailSamplingPeerConfig{
+			DiscoveryInterval: 30 * time.Second,
+			BatchSize:         1000,
+			LogSize:           100000,
+		},
+		Forwarding: TailSamplingForwardingConfig{
+			DiscoveryInterval: 30 * time.Second,
+			HandoffPeriod:     time.Minute,
+			BatchSize:         500,
+			FlushInterval:     100 * time.Millisecond,
+			QueueSize:         10000,
+			MaxRetries:        3,
+		},
+		PoliciesFile: TailSamplingPoliciesFileConfig{
+			ReloadPeriod: 10 * time.Second,
+		},
+		Storage: TailSamplingStorageConfig{
+			Codec: TailSamplingStorageCodecProtobuf,
+		},
 	}
 	parsed, err := humanize.ParseBytes(cfg.StorageLimit)
 	if err != nil {
@@ -18,9 +18,13 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp/logptest"
@@ -52,4 +56,422 @@ func TestSamplingPoliciesValidation(t *testing.T) {
 		assert.EqualError(t, err, "error processing configuration: invalid sampling.tail config: no default (empty criteria) policy specified accessing 'sampling.tail'")
 		assert.Nil(t, c)
 	})
+	t.Run("Conditions", func(t *testing.T) {
+		c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+			"sampling.tail.policies": []map[string]interface{}{{
+				"trace.min_duration": "2s",
+				"sample_rate":        1.0,
+			}, {
+				"conditions": []map[string]interface{}{
+					{"field": "labels.tenant", "equals": "gold"},
+					{"field": "http.response.status_code", "gte": 500, "negate": true},
+				},
+				"sample_rate": 0.5,
+			}, {
+				"sample_rate": 0.1,
+			}},
+		}), nil, logptest.NewTestingLogger(t, ""))
+		require.NoError(t, err)
+		require.Len(t, c.Sampling.Tail.Policies, 3)
+		assert.Equal(t, 2*time.Second, c.Sampling.Tail.Policies[0].Trace.MinDuration)
+		gold, statusCode := "gold", 500.0
+		assert.Equal(t, []TailSamplingCondition{
+			{Field: "labels.tenant", Equals: &gold},
+			{Field: "http.response.status_code", GreaterThanOrEqual: &statusCode, Negate: true},
+		}, c.Sampling.Tail.Policies[1].Conditions)
+	})
+	t.Run("TargetThroughput", func(t *testing.T) {
+		c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+			"sampling.tail.policies": []map[string]interface{}{{
+				"service.name":             "foo",
+				"target_traces_per_second": 10,
+				"min_sample_rate":          0.01,
+			}, {
+				"target_traces_per_second": 5,
+				"max_sample_rate":          0.5,
+			}},
+		}), nil, logptest.NewTestingLogger(t, ""))
+		require.NoError(t, err)
+		require.Len(t, c.Sampling.Tail.Policies, 2)
+		assert.Equal(t, 10.0, c.Sampling.Tail.Policies[0].TargetTracesPerSecond)
+		assert.Equal(t, 0.01, c.Sampling.Tail.Policies[0].MinSampleRate)
+		assert.Equal(t, 1.0, c.Sampling.Tail.Policies[0].GetMaxSampleRate())
+		assert.Equal(t, 0.5, c.Sampling.Tail.Policies[1].GetMaxSampleRate())
+	})
+	t.Run("ShadowPolicies", func(t *testing.T) {
+		c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+			"sampling.tail.policies": []map[string]interface{}{{"sample_rate": 0.5}},
+			"sampling.tail.shadow_policies": []map[string]interface{}{{
+				"service.name": "foo",
+				"sample_rate":  0.1,
+			}, {
+				"sample_rate": 0.2,
+			}},
+		}), nil, logptest.NewTestingLogger(t, ""))
+		require.NoError(t, err)
+		require.Len(t, c.Sampling.Tail.ShadowPolicies, 2)
+		assert.Equal(t, "foo", c.Sampling.Tail.ShadowPolicies[0].Service.Name)
+		assert.Equal(t, 0.2, c.Sampling.Tail.ShadowPolicies[1].SampleRate)
+	})
+	t.Run("NoDefaultShadowPolicies", func(t *testing.T) {
+		c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+			"sampling.tail.policies": []map[string]interface{}{{"sample_rate": 0.5}},
+			"sampling.tail.shadow_policies": []map[string]interface{}{{
+				"service.name": "foo",
+				"sample_rate":  0.1,
+			}},
+		}), nil, logptest.NewTestingLogger(t, ""))
+		assert.EqualError(t, err, "error processing configuration: invalid sampling.tail config: invalid shadow_policies: no default (empty criteria) policy specified accessing 'sampling.tail'")
+		assert.Nil(t, c)
+	})
+	t.Run("InvalidConditions", func(t *testing.T) {
+		for name, test := range map[string]struct {
+			policy map[string]interface{}
+			expect string
+		}{
+			"unsupported_field": {
+				policy: map[string]interface{}{"conditions": []map[string]interface{}{{"field": "foo", "equals": "bar"}}},
+				expect: `invalid policy 0: invalid condition 0: unsupported field "foo"`,
+			},
+			"empty_label": {
+				policy: map[string]interface{}{"conditions": []map[string]interface{}{{"field": "labels.", "equals": "bar"}}},
+				expect: `invalid policy 0: invalid condition 0: unsupported field "labels."`,
+			},
+			"no_operator": {
+				policy: map[string]interface{}{"conditions": []map[string]interface{}{{"field": "labels.foo"}}},
+				expect: "invalid policy 0: invalid condition 0: exactly one of equals, glob, regex, gt, gte, lt, or lte must be specified",
+			},
+			"multiple_operators": {
+				policy: map[string]interface{}{"conditions": []map[string]interface{}{{"field": "labels.foo", "gt": 1, "lt": 2}}},
+				expect: "invalid policy 0: invalid condition 0: exactly one of equals, glob, regex, gt, gte, lt, or lte must be specified",
+			},
+			"invalid_regex": {
+				policy: map[string]interface{}{"conditions": []map[string]interface{}{{"field": "transaction.name", "regex": "("}}},
+				expect: "invalid policy 0: invalid condition 0: invalid regex: error parsing regexp: missing closing ): `(`",
+			},
+			"target_with_sample_rate": {
+				policy: map[string]interface{}{"target_traces_per_second": 10, "sample_rate": 0.5},
+				expect: "invalid policy 0: sample_rate and target_traces_per_second are mutually exclusive",
+			},
+			"sample_rate_bounds_without_target": {
+				policy: map[string]interface{}{"min_sample_rate": 0.1},
+				expect: "invalid policy 0: min_sample_rate and max_sample_rate require target_traces_per_second",
+			},
+			"min_sample_rate_exceeds_max": {
+				policy: map[string]interface{}{"target_traces_per_second": 10, "min_sample_rate": 0.5, "max_sample_rate": 0.1},
+				expect: "invalid policy 0: min_sample_rate must not be greater than max_sample_rate",
+			},
+			"invalid_durations": {
+				policy: map[string]interface{}{"trace.min_duration": "2s", "trace.max_duration": "1s"},
+				expect: "invalid policy 0: trace.max_duration must be greater than trace.min_duration",
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+					"sampling.tail.policies": []map[string]interface{}{test.policy, {"sample_rate": 0.1}},
+				}), nil, logptest.NewTestingLogger(t, ""))
+				assert.ErrorContains(t, err, "invalid sampling.tail config: "+test.expect)
+			})
+		}
+	})
+	t.Run("InterestingEvents", func(t *testing.T) {
+		c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+			"sampling.tail.policies": []map[string]interface{}{{"sample_rate": 0.1}},
+			"sampling.tail.interesting_events": []map[string]interface{}{{
+				"name":       "errors",
+				"event_type": "error",
+			}, {
+				"name":         "slow_db",
+				"event_type":   "span",
+				"min_duration": "500ms",
+				"conditions":   []map[string]interface{}{{"field": "span.type", "equals": "db"}},
+			}},
+		}), nil, logptest.NewTestingLogger(t, ""))
+		require.NoError(t, err)
+		db := "db"
+		assert.Equal(t, []TailSamplingInterestingEvent{
+			{Name: "errors", EventType: "error"},
+			{Name: "slow_db", EventType: "span", MinDuration: 500 * time.Millisecond, Conditions: []TailSamplingCondition{
+				{Field: "span.type", Equals: &db},
+			}},
+		}, c.Sampling.Tail.InterestingEvents)
+	})
+	t.Run("InvalidInterestingEvents", func(t *testing.T) {
+		for name, test := range map[string]struct {
+			events []map[string]interface{}
+			expect string
+		}{
+			"unsupported_event_type": {
+				events: []map[string]interface{}{{"name": "foo", "event_type": "metricset"}},
+				expect: `invalid interesting event 0: unsupported event_type "metricset"`,
+			},
+			"error_min_duration": {
+				events: []map[string]interface{}{{"name": "foo", "event_type": "error", "min_duration": "1s"}},
+				expect: "invalid interesting event 0: min_duration may not be specified for errors",
+			},
+			"invalid_condition": {
+				events: []map[string]interface{}{{"name": "foo", "event_type": "span", "conditions": []map[string]interface{}{{"field": "foo", "equals": "bar"}}}},
+				expect: `invalid interesting event 0: invalid condition 0: unsupported field "foo"`,
+			},
+			"duplicate_name": {
+				events: []map[string]interface{}{{"name": "foo", "event_type": "span"}, {"name": "foo", "event_type": "error"}},
+				expect: `duplicate interesting event name "foo"`,
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+					"sampling.tail.policies":           []map[string]interface{}{{"sample_rate": 0.1}},
+					"sampling.tail.interesting_events": test.events,
+				}), nil, logptest.NewTestingLogger(t, ""))
+				assert.ErrorContains(t, err, "invalid sampling.tail config: "+test.expect)
+			})
+		}
+	})
+}
+
+func TestTailSamplingPoliciesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yml")
+	t.Run("NoInlinePolicies", func(t *testing.T) {
+		c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+			"sampling.tail.enabled":            true,
+			"sampling.tail.policies_file.path": path,
+		}), nil, logptest.NewTestingLogger(t, ""))
+		require.NoError(t, err)
+		assert.Equal(t, path, c.Sampling.Tail.PoliciesFile.Path)
+		assert.Equal(t, 10*time.Second, c.Sampling.Tail.PoliciesFile.ReloadPeriod)
+	})
+	t.Run("InvalidReloadPeriod", func(t *testing.T) {
+		_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+			"sampling.tail.enabled":                     true,
+			"sampling.tail.policies_file.path":          path,
+			"sampling.tail.policies_file.reload_period": "0s",
+		}), nil, logptest.NewTestingLogger(t, ""))
+		assert.EqualError(t, err, "error processing configuration: invalid sampling.tail config: policies_file.reload_period must be positive accessing 'sampling.tail'")
+	})
+	t.Run("Load", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte(`
+policies:
+  - service.name: foo
+    sample_rate: 1.0
+  - sample_rate: 0.1
+shadow_policies:
+  - sample_rate: 0.2
+`[1:]), 0644))
+		policies, err := LoadTailSamplingPolicies(path)
+		require.NoError(t, err)
+		require.Len(t, policies.Policies, 2)
+		assert.Equal(t, "foo", policies.Policies[0].Service.Name)
+		assert.Equal(t, 0.1, policies.Policies[1].SampleRate)
+		require.Len(t, policies.ShadowPolicies, 1)
+		assert.Equal(t, 0.2, policies.ShadowPolicies[0].SampleRate)
+	})
+	t.Run("LoadInvalid", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte(`
+policies:
+  - service.name: foo
+    sample_rate: 1.0
+`[1:]), 0644))
+		_, err := LoadTailSamplingPolicies(path)
+		assert.EqualError(t, err, "no default (empty criteria) policy specified")
+	})
+}
+
+func TestTailSamplingTraceCompletionValidation(t *testing.T) {
+	c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"sampling.tail.policies":                           []map[string]interface{}{{"sample_rate": 0.1}},
+		"sampling.tail.trace_completion.enabled":           true,
+		"sampling.tail.trace_completion.root_grace_period": "2m",
+	}), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, TailSamplingTraceCompletionConfig{
+		Enabled:         true,
+		IdleTimeout:     30 * time.Second,
+		RootGracePeriod: 2 * time.Minute,
+	}, c.Sampling.Tail.TraceCompletion)
+
+	for name, test := range map[string]struct {
+		config map[string]interface{}
+		expect string
+	}{
+		"zero_idle_timeout": {
+			config: map[string]interface{}{"idle_timeout": "0s"},
+			expect: "trace_completion.idle_timeout must be positive",
+		},
+		"idle_timeout_exceeds_ttl": {
+			config: map[string]interface{}{"idle_timeout": "1h"},
+			expect: "trace_completion.idle_timeout must be less than ttl",
+		},
+		"negative_root_grace_period": {
+			config: map[string]interface{}{"root_grace_period": "-1s"},
+			expect: "trace_completion.root_grace_period must not be negative",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			test.config["enabled"] = true
+			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+				"sampling.tail.policies":         []map[string]interface{}{{"sample_rate": 0.1}},
+				"sampling.tail.trace_completion": test.config,
+			}), nil, logptest.NewTestingLogger(t, ""))
+			assert.ErrorContains(t, err, "invalid sampling.tail config: "+test.expect)
+		})
+	}
+}
+
+func TestTailSamplingPeerValidation(t *testing.T) {
+	c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"sampling.tail.policies":            []map[string]interface{}{{"sample_rate": 0.1}},
+		"sampling.tail.peer.enabled":        true,
+		"sampling.tail.peer.listen_address": "0.0.0.0:8201",
+		"sampling.tail.peer.dns_name":       "apm-server-peers:8201",
+	}), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, TailSamplingPeerConfig{
+		Enabled:           true,
+		ListenAddress:     "0.0.0.0:8201",
+		DNSName:           "apm-server-peers:8201",
+		DiscoveryInterval: 30 * time.Second,
+		BatchSize:         1000,
+		LogSize:           100000,
+	}, c.Sampling.Tail.Peer)
+
+	for name, test := range map[string]struct {
+		config map[string]interface{}
+		expect string
+	}{
+		"no_listen_address": {
+			config: map[string]interface{}{"hosts": []string{"peer:8201"}},
+			expect: "listen_address must be specified",
+		},
+		"no_peers": {
+			config: map[string]interface{}{"listen_address": ":8201"},
+			expect: "hosts or dns_name must be specified",
+		},
+		"invalid_dns_name": {
+			config: map[string]interface{}{"listen_address": ":8201", "dns_name": "peers"},
+			expect: "invalid dns_name",
+		},
+		"zero_batch_size": {
+			config: map[string]interface{}{"listen_address": ":8201", "hosts": []string{"peer:8201"}, "batch_size": 0},
+			expect: "batch_size must be positive",
+		},
+		"client_ssl_without_ssl": {
+			config: map[string]interface{}{
+				"listen_address": ":8201", "hosts": []string{"peer:8201"},
+				"client_ssl": map[string]interface{}{"enabled": true},
+			},
+			expect: "ssl and client_ssl must both be enabled or disabled",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			test.config["enabled"] = true
+			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+				"sampling.tail.policies": []map[string]interface{}{{"sample_rate": 0.1}},
+				"sampling.tail.peer":     test.config,
+			}), nil, logptest.NewTestingLogger(t, ""))
+			assert.ErrorContains(t, err, "invalid sampling.tail config: invalid peer config: "+test.expect)
+		})
+	}
+}
+
+func TestTailSamplingStorageValidation(t *testing.T) {
+	for name, test := range map[string]struct {
+		config map[string]interface{}
+		expect string
+	}{
+		"unknown_codec": {
+			config: map[string]interface{}{"codec": "gzip"},
+			expect: `unknown codec "gzip", expected "protobuf" or "zstd"`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+				"sampling.tail.policies": []map[string]interface{}{{"sample_rate": 0.1}},
+				"sampling.tail.storage":  test.config,
+			}), nil, logptest.NewTestingLogger(t, ""))
+			assert.ErrorContains(t, err, "invalid sampling.tail config: invalid storage config: "+test.expect)
+		})
+	}
+}
+
+func TestTailSamplingAdminAPIRequiresAuth(t *testing.T) {
+	_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"sampling.tail.policies":          []map[string]interface{}{{"sample_rate": 0.1}},
+		"sampling.tail.admin_api.enabled": true,
+	}), nil, logptest.NewTestingLogger(t, ""))
+	assert.EqualError(t, err, "invalid sampling.tail config: admin_api.enabled requires secret token or API Key auth")
+
+	c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"sampling.tail.policies":          []map[string]interface{}{{"sample_rate": 0.1}},
+		"sampling.tail.admin_api.enabled": true,
+		"auth.secret_token":               "abc123",
+	}), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.True(t, c.Sampling.Tail.AdminAPI.Enabled)
+}
+
+func TestTailSamplingForwardingValidation(t *testing.T) {
+	c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"sampling.tail.policies":                     []map[string]interface{}{{"sample_rate": 0.1}},
+		"sampling.tail.forwarding.enabled":           true,
+		"sampling.tail.forwarding.listen_address":    "0.0.0.0:8202",
+		"sampling.tail.forwarding.advertise_address": "10.0.0.1:8202",
+		"sampling.tail.forwarding.dns_name":          "apm-server-headless:8202",
+	}), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, TailSamplingForwardingConfig{
+		Enabled:           true,
+		ListenAddress:     "0.0.0.0:8202",
+		AdvertiseAddress:  "10.0.0.1:8202",
+		DNSName:           "apm-server-headless:8202",
+		DiscoveryInterval: 30 * time.Second,
+		HandoffPeriod:     time.Minute,
+		BatchSize:         500,
+		FlushInterval:     100 * time.Millisecond,
+		QueueSize:         10000,
+		MaxRetries:        3,
+	}, c.Sampling.Tail.Forwarding)
+
+	for name, test := range map[string]struct {
+		config map[string]interface{}
+		expect string
+	}{
+		"no_listen_address": {
+			config: map[string]interface{}{"advertise_address": "10.0.0.1:8202"},
+			expect: "listen_address must be specified",
+		},
+		"no_advertise_address": {
+			config: map[string]interface{}{"listen_address": ":8202"},
+			expect: "advertise_address must be specified",
+		},
+		"negative_handoff_period": {
+			config: map[string]interface{}{
+				"listen_address": ":8202", "advertise_address": "10.0.0.1:8202", "handoff_period": "-1s",
+			},
+			expect: "handoff_period must not be negative",
+		},
+		"ssl_without_client_ssl": {
+			config: map[string]interface{}{
+				"listen_address": ":8202", "advertise_address": "10.0.0.1:8202",
+				"ssl":        map[string]interface{}{"enabled": false},
+				"client_ssl": map[string]interface{}{"enabled": true},
+			},
+			expect: "ssl and client_ssl must both be enabled or disabled",
+		},
+		"invalid_ip_filter": {
+			config: map[string]interface{}{
+				"listen_address": ":8202", "advertise_address": "10.0.0.1:8202",
+				"ip_filter": map[string]interface{}{"allow": []string{"10.0.0.0/33"}},
+			},
+			expect: "ip_filter: invalid allow rule",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			test.config["enabled"] = true
+			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+				"sampling.tail.policies":   []map[string]interface{}{{"sample_rate": 0.1}},
+				"sampling.tail.forwarding": test.config,
+			}), nil, logptest.NewTestingLogger(t, ""))
+			assert.ErrorContains(t, err, "invalid sampling.tail config: invalid forwarding config: "+test.expect)
+		})
+	}
 }
@@ -0,0 +1,44 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+// UserAgentConfig holds configuration for parsing user_agent.original
+// of events from browser agents into the browser, operating system, and
+// device, and for optionally dropping events from bots and synthetic monitors.
+type UserAgentConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// AgentNames holds the names of agents whose events are parsed.
+	// If empty, events from the RUM and OpenTelemetry browser agents
+	// are parsed: rum-js, js-base, and opentelemetry/webjs.
+	AgentNames []string `config:"agent_names"`
+
+	// CacheSize holds the maximum number of parsed user agents to cache.
+	CacheSize int `config:"cache_size" validate:"min=1"`
+
+	// DropBots drops events from known bots and synthetic monitors.
+	DropBots bool `config:"drop_bots"`
+}
+
+func defaultUserAgentConfig() UserAgentConfig {
+	return UserAgentConfig{
+		Enabled:   false,
+		CacheSize: 10000,
+		DropBots:  false,
+	}
+}
@@ -0,0 +1,50 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestUserAgentConfig(t *testing.T) {
+	cfg, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"user_agent": map[string]interface{}{
+			"enabled":     true,
+			"agent_names": []string{"rum-js"},
+			"drop_bots":   true,
+		},
+	}), nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, UserAgentConfig{
+		Enabled:    true,
+		AgentNames: []string{"rum-js"},
+		CacheSize:  10000,
+		DropBots:   true,
+	}, cfg.UserAgent)
+
+	_, err = NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+		"user_agent.cache_size": 0,
+	}), nil, logptest.NewTestingLogger(t, ""))
+	assert.ErrorContains(t, err, "requires value >= 1 accessing 'user_agent.cache_size'")
+}
@@ -0,0 +1,180 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beater
+
+import (
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/elastic/apm-data/model/modelprocessor"
+	"github.com/elastic/apm-server/internal/beater/config"
+	srvmodelprocessor "github.com/elastic/apm-server/internal/model/modelprocessor"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// NewEventProcessingChain returns the configured processors which enrich,
+// transform, and redact events decoded from agent payloads, in the order
+// in which they are applied.
+//
+// If GeoIP enrichment is enabled, the GeoIP processor is also returned,
+// and its Run method must be called for the database files to be reloaded
+// when modified.
+func NewEventProcessingChain(
+	cfg *config.Config, mp metric.MeterProvider, logger *logp.Logger,
+) (modelprocessor.Chained, *srvmodelprocessor.GeoIP, error) {
+	var chained modelprocessor.Chained
+	var geoip *srvmodelprocessor.GeoIP
+	if cfg.GeoIP.Enabled {
+		// GeoIP and user agent enrichment are applied before user-defined
+		// processors and rules, so that they may refer to the resulting labels.
+		var err error
+		geoip, err = srvmodelprocessor.NewGeoIP(srvmodelprocessor.GeoIPConfig{
+			CityDatabase:   cfg.GeoIP.CityDatabase,
+			ASNDatabase:    cfg.GeoIP.ASNDatabase,
+			ReloadInterval: cfg.GeoIP.ReloadInterval,
+		}, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		chained = append(chained, geoip)
+	}
+	if cfg.UserAgent.Enabled {
+		userAgentParser, err := srvmodelprocessor.NewUserAgentParser(srvmodelprocessor.UserAgentParserConfig{
+			AgentNames: cfg.UserAgent.AgentNames,
+			CacheSize:  cfg.UserAgent.CacheSize,
+			DropBots:   cfg.UserAgent.DropBots,
+		}, mp)
+		if err != nil {
+			return nil, nil, err
+		}
+		chained = append(chained, userAgentParser)
+	}
+	if len(cfg.EventProcessors) != 0 {
+		// User-defined processors are applied after the built-in
+		// pre-processors, so that they observe the final field values.
+		eventProcessors, err := srvmodelprocessor.NewEventProcessors(eventProcessorConfigs(cfg.EventProcessors))
+		if err != nil {
+			return nil, nil, err
+		}
+		chained = append(chained, eventProcessors)
+	}
+	if len(cfg.EventRules) != 0 {
+		eventRules, err := srvmodelprocessor.NewEventRules(eventRuleConfigs(cfg.EventRules), mp)
+		if err != nil {
+			return nil, nil, err
+		}
+		chained = append(chained, eventRules)
+	}
+	if cfg.NameNormalization.Enabled {
+		// Names are normalized after rules are applied, so that rules
+		// may match on the original names, and before aggregation.
+		nameNormalizer, err := srvmodelprocessor.NewNameNormalizer(nameNormalizerConfig(cfg.NameNormalization), mp)
+		if err != nil {
+			return nil, nil, err
+		}
+		chained = append(chained, nameNormalizer)
+	}
+	if cfg.DBStatementObfuscation.Enabled {
+		chained = append(chained, srvmodelprocessor.NewDBStatementObfuscator(
+			srvmodelprocessor.DBStatementObfuscatorConfig{
+				ServiceName:             cfg.DBStatementObfuscation.ServiceName,
+				KeepOriginalServiceName: cfg.DBStatementObfuscation.KeepOriginalServiceName,
+			},
+		))
+	}
+	if cfg.Redaction.Enabled {
+		// Redaction is applied last, so that values set by
+		// user-defined processors and rules are also redacted.
+		redactor, err := srvmodelprocessor.NewRedactor(redactorConfig(cfg.Redaction))
+		if err != nil {
+			return nil, nil, err
+		}
+		chained = append(chained, redactor)
+	}
+	return chained, geoip, nil
+}
+
+func eventProcessorConfigs(in []config.EventProcessorConfig) []srvmodelprocessor.EventProcessorConfig {
+	out := make([]srvmodelprocessor.EventProcessorConfig, len(in))
+	for i, cfg := range in {
+		renames := make([]srvmodelprocessor.RenameFieldConfig, len(cfg.RenameFields))
+		for j, rename := range cfg.RenameFields {
+			renames[j] = srvmodelprocessor.RenameFieldConfig{From: rename.From, To: rename.To}
+		}
+		out[i] = srvmodelprocessor.EventProcessorConfig{
+			When: srvmodelprocessor.EventConditionConfig{
+				EventType:   cfg.When.EventType,
+				ServiceName: cfg.When.ServiceName,
+				Labels:      cfg.When.Labels,
+				URLPath:     cfg.When.URLPath,
+			},
+			DropEvent:    cfg.DropEvent,
+			DropFields:   cfg.DropFields,
+			RenameFields: renames,
+			SetLabels:    cfg.SetLabels,
+			TruncateFields: srvmodelprocessor.TruncateFieldsConfig{
+				Fields:    cfg.TruncateFields.Fields,
+				MaxLength: cfg.TruncateFields.MaxLength,
+			},
+		}
+	}
+	return out
+}
+
+func eventRuleConfigs(in []config.EventRuleConfig) []srvmodelprocessor.EventRuleConfig {
+	out := make([]srvmodelprocessor.EventRuleConfig, len(in))
+	for i, cfg := range in {
+		out[i] = srvmodelprocessor.EventRuleConfig{
+			Name:      cfg.Name,
+			When:      cfg.When,
+			DropEvent: cfg.DropEvent,
+			SetLabels: cfg.SetLabels,
+		}
+	}
+	return out
+}
+
+func nameNormalizerConfig(in config.NameNormalizationConfig) srvmodelprocessor.NameNormalizerConfig {
+	out := srvmodelprocessor.NameNormalizerConfig{
+		AutoDetect: in.AutoDetect,
+		Rules:      make([]srvmodelprocessor.NameNormalizerRuleConfig, len(in.Rules)),
+	}
+	for i, rule := range in.Rules {
+		out.Rules[i] = srvmodelprocessor.NameNormalizerRuleConfig{
+			Name:        rule.Name,
+			ServiceName: rule.ServiceName,
+			Template:    rule.Template,
+		}
+	}
+	return out
+}
+
+func redactorConfig(in config.RedactionConfig) srvmodelprocessor.RedactorConfig {
+	out := srvmodelprocessor.RedactorConfig{
+		DefaultRules: in.DefaultRules,
+		Patterns:     make([]srvmodelprocessor.RedactionPatternConfig, len(in.Patterns)),
+		Keywords:     in.Keywords,
+		Replacement:  in.Replacement,
+	}
+	for i, pattern := range in.Patterns {
+		out.Patterns[i] = srvmodelprocessor.RedactionPatternConfig{
+			Name:  pattern.Name,
+			Regex: pattern.Regex,
+		}
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/internal/beater/config"
+	srvmodelprocessor "github.com/elastic/apm-server/internal/model/modelprocessor"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestNewEventProcessingChain(t *testing.T) {
+	cfg := config.DefaultConfig()
+	chained, geoip, err := NewEventProcessingChain(cfg, noop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Nil(t, geoip)
+	assert.Empty(t, chained)
+
+	cfg.EventProcessors = []config.EventProcessorConfig{{
+		When:       config.EventConditionConfig{EventType: []string{"span"}},
+		DropFields: []string{"labels.secret"},
+	}}
+	cfg.EventRules = []config.EventRuleConfig{{
+		Name:      "tier",
+		SetLabels: map[string]string{"tier": `"gold"`},
+	}}
+	cfg.Redaction.Enabled = true
+	cfg.Redaction.Patterns = []config.RedactionPatternConfig{{Name: "id", Regex: `id-\d+`}}
+	chained, geoip, err = NewEventProcessingChain(cfg, noop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.Nil(t, geoip)
+	require.Len(t, chained, 3)
+	assert.IsType(t, srvmodelprocessor.EventProcessors{}, chained[0])
+	assert.IsType(t, &srvmodelprocessor.EventRules{}, chained[1])
+	assert.IsType(t, &srvmodelprocessor.Redactor{}, chained[2])
+
+	batch := modelpb.Batch{{
+		Span:    &modelpb.Span{},
+		Message: "id-123",
+		Labels:  modelpb.Labels{"secret": {Value: "s3cr3t"}},
+	}}
+	require.NoError(t, chained.ProcessBatch(context.Background(), &batch))
+	assert.Equal(t, "[REDACTED]", batch[0].Message)
+	assert.NotContains(t, batch[0].Labels, "secret")
+	assert.Equal(t, "gold", batch[0].Labels["tier"].GetValue())
+}
+
+func TestNameNormalizationAutoDetectRule(t *testing.T) {
+	// Configured rule names must not clash with the rule name
+	// under which automatically detected names are counted.
+	assert.Equal(t, srvmodelprocessor.NameNormalizerAutoDetectRule, config.NameNormalizationAutoDetectRule)
+}
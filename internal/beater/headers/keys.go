@@ -36,6 +36,7 @@ const (
 	Etag                       = "Etag"
 	IfNoneMatch                = "If-None-Match"
 	Origin                     = "Origin"
+	RetryAfter                 = "Retry-After"
 	UserAgent                  = "User-Agent"
 	Vary                       = "Vary"
 	XContentTypeOptions        = "X-Content-Type-Options"
@@ -97,7 +97,7 @@ func (h *httpServer) start() error {
 		h.logger.Info("SSL enabled.")
 		return h.ServeTLS(h.httpListener, "", "")
 	}
-	if h.cfg.AgentAuth.SecretToken != "" {
+	if h.cfg.AgentAuth.SecretTokenEnabled() {
 		h.logger.Warn("Secret token is set, but SSL is not enabled.")
 	}
 	h.logger.Info("SSL disabled.")
@@ -26,6 +26,7 @@ import (
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
+	"github.com/elastic/apm-server/internal/beater/audit"
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/headers"
 )
@@ -52,7 +53,11 @@ type UnaryAuthenticator interface {
 //
 // Authentication is performed using the service's AuthenticateUnaryCall
 // method, if implemented, and AuthorizationMetadataAuthenticator otherwise.
-func Auth(authenticator *auth.Authenticator) grpc.UnaryServerInterceptor {
+//
+// If auditor is non-nil, authentication outcomes and authorization denials
+// are recorded in the audit trail. Auth should be wrapped by ClientMetadata
+// to include the client IP in audit events.
+func Auth(authenticator *auth.Authenticator, auditor *audit.Auditor) grpc.UnaryServerInterceptor {
 	var defaultAuthenticator UnaryAuthenticator = AuthorizationMetadataAuthenticator{}
 	return func(
 		ctx context.Context,
@@ -65,6 +70,11 @@ func Auth(authenticator *auth.Authenticator) grpc.UnaryServerInterceptor {
 			unaryAuthenticator = defaultAuthenticator
 		}
 		details, authz, err := unaryAuthenticator.AuthenticateUnaryCall(ctx, req, info.FullMethod, authenticator)
+		auditRequest := audit.Request{Route: info.FullMethod}
+		if clientMetadata, ok := ClientMetadataFromContext(ctx); ok {
+			auditRequest.SourceIP = clientMetadata.ClientIP
+		}
+		auditor.Authentication(auditRequest, details, err)
 		if err != nil {
 			if errors.Is(err, auth.ErrAuthFailed) {
 				return nil, status.Error(codes.Unauthenticated, err.Error())
@@ -72,7 +82,7 @@ func Auth(authenticator *auth.Authenticator) grpc.UnaryServerInterceptor {
 			return nil, err
 		}
 		ctx = ContextWithAuthenticationDetails(ctx, details)
-		ctx = auth.ContextWithAuthorizer(ctx, authz)
+		ctx = auth.ContextWithAuthorizer(ctx, auditor.Authorizer(auditRequest, details, authz))
 		resp, err := handler(ctx, req)
 		if errors.Is(err, auth.ErrUnauthorized) {
 			// Processors may indicate that a request is unauthorized by returning auth.ErrUnauthorized.
@@ -103,15 +113,18 @@ func (a AuthorizationMetadataAuthenticator) AuthenticateUnaryCall(
 	return authenticator.Authenticate(ctx, kind, token)
 }
 
-type authenticationDetailsKey struct{}
-
 // ContextWithAuthenticationDetails returns a copy of ctx with details.
+//
+// If ctx was passed through the Logging interceptor, details are also
+// recorded for inclusion in the request log.
 func ContextWithAuthenticationDetails(ctx context.Context, details auth.AuthenticationDetails) context.Context {
-	return context.WithValue(ctx, authenticationDetailsKey{}, details)
+	if logged, ok := ctx.Value(loggedAuthenticationDetailsKey{}).(*auth.AuthenticationDetails); ok {
+		*logged = details
+	}
+	return auth.ContextWithAuthenticationDetails(ctx, details)
 }
 
-// AuthenticationDetailsFromContext returns client metadata extracted by the ClientMetadata interceptor.
+// AuthenticationDetailsFromContext returns authentication details added to the context by the Auth interceptor.
 func AuthenticationDetailsFromContext(ctx context.Context) (auth.AuthenticationDetails, bool) {
-	details, ok := ctx.Value(authenticationDetailsKey{}).(auth.AuthenticationDetails)
-	return details, ok
+	return auth.AuthenticationDetailsFromContext(ctx)
 }
@@ -25,6 +25,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
 	"go.opentelemetry.io/otel/trace/noop"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -45,7 +46,7 @@ func TestUnaryAuthenticator(t *testing.T) {
 	origErr := errors.New("handler error")
 
 	authenticator := &auth.Authenticator{}
-	interceptor := interceptors.Auth(authenticator)
+	interceptor := interceptors.Auth(authenticator, nil)
 
 	call := func(t *testing.T, authnErr, authzErr error) (interface{}, error) {
 		details := auth.AuthenticationDetails{
@@ -118,9 +119,9 @@ func TestUnaryAuthenticator(t *testing.T) {
 }
 
 func TestAuthorizationMetadataAuthenticator(t *testing.T) {
-	authenticator, err := auth.NewAuthenticator(config.AgentAuth{SecretToken: "abc123"}, noop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))
+	authenticator, err := auth.NewAuthenticator(config.AgentAuth{SecretToken: "abc123"}, noop.NewTracerProvider(), metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
 	require.NoError(t, err)
-	interceptor := interceptors.Auth(authenticator)
+	interceptor := interceptors.Auth(authenticator, nil)
 
 	ctx := context.Background()
 	authContext := metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer abc123"))
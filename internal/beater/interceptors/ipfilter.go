@@ -0,0 +1,59 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package interceptors
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/netutil"
+)
+
+var errIPDenied = status.Error(
+	codes.PermissionDenied,
+	request.MapResultIDToStatus[request.IDResponseErrorsIPDenied].Keyword,
+)
+
+// IPFilter returns a grpc.UnaryServerInterceptor that rejects requests whose
+// client IP is not allowed by filter. If filter is nil, all requests are allowed.
+// IPFilter must be wrapped by the ClientMetadata interceptor, as it requires the
+// client's IP address.
+func IPFilter(filter *netutil.IPFilter) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if filter != nil {
+			clientMetadata, ok := ClientMetadataFromContext(ctx)
+			if !ok {
+				return nil, errors.New("client metadata not found in context")
+			}
+			if !filter.Allowed(clientMetadata.ClientIP) {
+				return nil, errIPDenied
+			}
+		}
+		return handler(ctx, req)
+	}
+}
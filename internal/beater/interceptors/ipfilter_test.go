@@ -0,0 +1,62 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package interceptors_test
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elastic/apm-server/internal/beater/interceptors"
+	"github.com/elastic/apm-server/internal/netutil"
+)
+
+func TestIPFilter(t *testing.T) {
+	filter, err := netutil.NewIPFilter([]string{"10.0.0.0/8"}, nil)
+	require.NoError(t, err)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "response", nil
+	}
+	request := func(interceptor grpc.UnaryServerInterceptor, ip string) (interface{}, error) {
+		ctx := interceptors.ContextWithClientMetadata(context.Background(),
+			interceptors.ClientMetadataValues{ClientIP: netip.MustParseAddr(ip)},
+		)
+		return interceptor(ctx, "request", &grpc.UnaryServerInfo{}, handler)
+	}
+
+	resp, err := request(interceptors.IPFilter(filter), "10.1.2.3")
+	assert.NoError(t, err)
+	assert.Equal(t, "response", resp)
+
+	resp, err = request(interceptors.IPFilter(filter), "192.168.0.1")
+	assert.Nil(t, resp)
+	assert.Equal(t, status.Error(codes.PermissionDenied, "client IP not allowed"), err)
+
+	resp, err = request(interceptors.IPFilter(nil), "192.168.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "response", resp)
+
+	_, err = interceptors.IPFilter(filter)(context.Background(), "request", &grpc.UnaryServerInfo{}, handler)
+	assert.EqualError(t, err, "client metadata not found in context")
+}
@@ -0,0 +1,75 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/elastic/apm-server/internal/beater/loadshed"
+)
+
+// LoadShedding returns a grpc.UnaryServerInterceptor that rejects requests
+// with a ResourceExhausted status while the server is overloaded. OTLP trace
+// requests are given a higher priority than OTLP metrics and logs requests.
+// If controller is nil, requests are never shed.
+func LoadShedding(controller *loadshed.Controller) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		priority := loadshed.PriorityMedium
+		if info.FullMethod == "/opentelemetry.proto.collector.trace.v1.TraceService/Export" {
+			priority = loadshed.PriorityHigh
+		}
+		if err := controller.Admit(ctx, priority); err != nil {
+			return nil, overloadedError(err)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// overloadedError converts err to a ResourceExhausted status error, adding
+// RetryInfo details so that clients know when they may retry. OTLP clients
+// only retry ResourceExhausted errors when RetryInfo is present.
+func overloadedError(err error) error {
+	s := status.New(codes.ResourceExhausted, err.Error())
+	var overloaded *loadshed.OverloadedError
+	if errors.As(err, &overloaded) {
+		if withDetails, detailsErr := s.WithDetails(&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(overloaded.RetryAfter),
+		}); detailsErr == nil {
+			s = withDetails
+		}
+	}
+	return s.Err()
+}
+
+// isOverloadedStatus reports whether s was returned by LoadShedding.
+func isOverloadedStatus(s *status.Status) bool {
+	return s.Code() == codes.ResourceExhausted && strings.HasPrefix(s.Message(), loadshed.ErrOverloaded.Error())
+}
@@ -0,0 +1,81 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package interceptors_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/interceptors"
+	"github.com/elastic/apm-server/internal/beater/loadshed"
+)
+
+func TestLoadShedding(t *testing.T) {
+	queue := loadshed.NewQueue()
+	queue.SetCapacity(100)
+	controller, err := loadshed.NewController(config.LoadSheddingConfig{
+		Enabled:        true,
+		QueueThreshold: 0.8,
+		RetryAfter:     5 * time.Second,
+	}, queue, nil, 0, metricnoop.NewMeterProvider())
+	require.NoError(t, err)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "response", nil
+	}
+	request := func(controller *loadshed.Controller, method string) (interface{}, error) {
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		return interceptors.LoadShedding(controller)(context.Background(), "request", info, handler)
+	}
+	const (
+		traces  = "/opentelemetry.proto.collector.trace.v1.TraceService/Export"
+		metrics = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+	)
+
+	// Queue utilization of 0.9 exceeds the threshold by more than
+	// enough to shed medium priority requests, but not high priority.
+	queue.Add(90)
+	controller.Update()
+
+	resp, err := request(controller, traces)
+	assert.NoError(t, err)
+	assert.Equal(t, "response", resp)
+
+	resp, err = request(controller, metrics)
+	assert.Nil(t, resp)
+	s, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.ResourceExhausted, s.Code())
+	assert.Equal(t, "server overloaded: shedding medium priority requests", s.Message())
+	require.Len(t, s.Details(), 1)
+	assert.Equal(t, 5*time.Second, s.Details()[0].(*errdetails.RetryInfo).RetryDelay.AsDuration())
+
+	resp, err = request(nil, metrics)
+	assert.NoError(t, err)
+	assert.Equal(t, "response", resp)
+}
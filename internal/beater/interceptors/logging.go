@@ -24,6 +24,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/status"
 
+	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/elastic-agent-libs/logp"
 )
 
@@ -31,7 +32,7 @@ import (
 // returned function implements grpc.UnaryServerInterceptor.
 //
 // Logging should be added after ClientMetadata to include `source.address`
-// in log records.
+// in log records, and before Auth to include authentication details.
 func Logging(logger *logp.Logger) grpc.UnaryServerInterceptor {
 	return func(
 		ctx context.Context,
@@ -51,7 +52,15 @@ func Logging(logger *logp.Logger) grpc.UnaryServerInterceptor {
 			}
 		}
 
+		var details auth.AuthenticationDetails
+		ctx = context.WithValue(ctx, loggedAuthenticationDetailsKey{}, &details)
 		resp, err := handler(ctx, req)
+		if details.APIKey != nil {
+			logger = logger.With("api_key.id", details.APIKey.ID)
+		}
+		if details.SecretToken != nil {
+			logger = logger.With("secret_token.name", details.SecretToken.Name)
+		}
 		res, _ := status.FromError(err)
 		logger = logger.With(
 			"grpc.request.method", info.FullMethod,
@@ -66,3 +75,8 @@ func Logging(logger *logp.Logger) grpc.UnaryServerInterceptor {
 		return resp, nil
 	}
 }
+
+// loggedAuthenticationDetailsKey is the context key for a pointer
+// to auth.AuthenticationDetails, set by ContextWithAuthenticationDetails
+// for inclusion in the request log.
+type loggedAuthenticationDetailsKey struct{}
@@ -30,6 +30,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/elastic-agent-libs/logp/logptest"
 )
 
@@ -98,3 +99,24 @@ func TestLogging(t *testing.T) {
 		assert.Equal(t, tc.statusCode.String(), fields["grpc.response.status_code"])
 	}
 }
+
+func TestLoggingAuthenticationDetails(t *testing.T) {
+	observedCore, observedLogs := observer.New(zapcore.InfoLevel)
+	logger := logptest.NewTestingLogger(t, "interceptor.logging.test", zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return observedCore
+	}))
+
+	i := Logging(logger)
+	_, err := i(context.Background(), nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		// Authentication details are set by the Auth interceptor.
+		ContextWithAuthenticationDetails(ctx, auth.AuthenticationDetails{
+			Method: auth.MethodAPIKey,
+			APIKey: &auth.APIKeyAuthenticationDetails{ID: "key_id"},
+		})
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	entries := observedLogs.All()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "key_id", entries[0].ContextMap()["api_key.id"])
+}
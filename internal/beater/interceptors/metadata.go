@@ -33,8 +33,11 @@ import (
 // ClientMetadata returns an interceptor that intercepts unary gRPC requests,
 // extracts metadata relating to the gRPC client, and adds it to the context.
 //
+// Forwarded headers are trusted only from trustedProxies, or from all clients
+// if trustedProxies is empty.
+//
 // Metadata can be extracted from context using ClientMetadataFromContext.
-func ClientMetadata() grpc.UnaryServerInterceptor {
+func ClientMetadata(trustedProxies netutil.TrustedProxies) grpc.UnaryServerInterceptor {
 	return func(
 		ctx context.Context,
 		req interface{},
@@ -53,7 +56,7 @@ func ClientMetadata() grpc.UnaryServerInterceptor {
 				values.UserAgent = ua[0]
 			}
 			// Account for `forwarded`, `x-real-ip`, `x-forwarded-for` headers
-			if ip, port := netutil.ClientAddrFromHeaders(http.Header(md)); ip.IsValid() {
+			if ip, port := trustedProxies.ClientAddrFromHeaders(http.Header(md), values.ClientIP); ip.IsValid() {
 				// this is forcing 16-byte representation even for IPv4
 				// TODO: move to AsSlice and investigate the test failure
 				sliceIP := ip.As16()
@@ -24,8 +24,11 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
+
+	"github.com/elastic/apm-server/internal/netutil"
 )
 
 func TestClientMetadata(t *testing.T) {
@@ -38,7 +41,7 @@ func TestClientMetadata(t *testing.T) {
 		Port: 1111,
 	}
 
-	interceptor := ClientMetadata()
+	interceptor := ClientMetadata(netutil.TrustedProxies{})
 
 	for _, test := range []struct {
 		peer     *peer.Peer
@@ -86,3 +89,32 @@ func TestClientMetadata(t *testing.T) {
 		assert.Equal(t, test.expected, got)
 	}
 }
+
+func TestClientMetadataTrustedProxies(t *testing.T) {
+	trustedProxies, err := netutil.ParseTrustedProxies([]string{"10.0.0.0/8"}, "")
+	require.NoError(t, err)
+	interceptor := ClientMetadata(trustedProxies)
+
+	clientMetadata := func(peerIP string, md metadata.MD) ClientMetadataValues {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP(peerIP), Port: 1234},
+		})
+		ctx = metadata.NewIncomingContext(ctx, md)
+		var values ClientMetadataValues
+		interceptor(ctx, nil, nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+			values, _ = ClientMetadataFromContext(ctx)
+			return nil, nil
+		})
+		return values
+	}
+
+	// Forwarded headers are ignored for untrusted peers.
+	values := clientMetadata("1.2.3.4", metadata.Pairs("X-Forwarded-For", "5.6.7.8"))
+	assert.Equal(t, netip.MustParseAddr("::ffff:1.2.3.4"), values.ClientIP)
+	assert.False(t, values.SourceNATIP.IsValid())
+
+	// Trusted hops are skipped, and spoofed values to the left are ignored.
+	values = clientMetadata("10.0.0.1", metadata.Pairs("X-Forwarded-For", "6.6.6.6, 5.6.7.8, 10.0.0.2"))
+	assert.Equal(t, netip.MustParseAddr("5.6.7.8"), values.ClientIP)
+	assert.Equal(t, netip.MustParseAddr("::ffff:10.0.0.1"), values.SourceNATIP)
+}
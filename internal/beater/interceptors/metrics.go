@@ -19,6 +19,7 @@ package interceptors
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -84,7 +85,15 @@ func (m *metricsInterceptor) Interceptor() grpc.UnaryServerInterceptor {
 				case codes.DeadlineExceeded, codes.Canceled:
 					m.inc(legacyMetricsPrefix, request.IDResponseErrorsTimeout)
 				case codes.ResourceExhausted:
-					m.inc(legacyMetricsPrefix, request.IDResponseErrorsRateLimit)
+					if isOverloadedStatus(s) {
+						m.inc(legacyMetricsPrefix, request.IDResponseErrorsOverloaded)
+					} else {
+						m.inc(legacyMetricsPrefix, request.IDResponseErrorsRateLimit)
+					}
+				case codes.PermissionDenied:
+					if errors.Is(err, errIPDenied) {
+						m.inc(legacyMetricsPrefix, request.IDResponseErrorsIPDenied)
+					}
 				}
 			}
 		}
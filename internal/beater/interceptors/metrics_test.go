@@ -30,6 +30,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/elastic/apm-server/internal/beater/loadshed"
 	"github.com/elastic/apm-server/internal/beater/monitoringtest"
 	"github.com/elastic/apm-server/internal/beater/request"
 	"github.com/elastic/elastic-agent-libs/logp/logptest"
@@ -133,6 +134,34 @@ func TestMetrics(t *testing.T) {
 					"request.duration": 1,
 				},
 			},
+			{
+				name: "with an IP denied error",
+				f: func(ctx context.Context, req interface{}) (interface{}, error) {
+					return nil, errIPDenied
+				},
+				expectedOtel: map[string]interface{}{
+					string(request.IDRequestCount):           1,
+					string(request.IDResponseCount):          1,
+					string(request.IDResponseErrorsCount):    1,
+					string(request.IDResponseErrorsIPDenied): 1,
+
+					"request.duration": 1,
+				},
+			},
+			{
+				name: "with an overloaded error",
+				f: func(ctx context.Context, req interface{}) (interface{}, error) {
+					return nil, overloadedError(&loadshed.OverloadedError{Priority: loadshed.PriorityHigh})
+				},
+				expectedOtel: map[string]interface{}{
+					string(request.IDRequestCount):             1,
+					string(request.IDResponseCount):            1,
+					string(request.IDResponseErrorsCount):      1,
+					string(request.IDResponseErrorsOverloaded): 1,
+
+					"request.duration": 1,
+				},
+			},
 			{
 				name: "with a success",
 				f: func(ctx context.Context, req interface{}) (interface{}, error) {
@@ -21,9 +21,11 @@ import (
 	"context"
 	"errors"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 )
@@ -58,8 +60,23 @@ func AnonymousRateLimit(store *ratelimit.Store) grpc.UnaryServerInterceptor {
 		}
 		result, err := handler(ctx, req)
 		if errors.Is(err, ratelimit.ErrRateLimitExceeded) {
-			err = status.Error(codes.ResourceExhausted, err.Error())
+			err = rateLimitExceededError(err)
 		}
 		return result, err
 	}
 }
+
+// rateLimitExceededError converts err to a ResourceExhausted status error.
+// If err is a quota error, RetryInfo details are added so that clients
+// know when they may retry.
+func rateLimitExceededError(err error) error {
+	s := status.New(codes.ResourceExhausted, err.Error())
+	if retryAfter, ok := ratelimit.RetryAfter(err); ok {
+		if withDetails, detailsErr := s.WithDetails(&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(retryAfter),
+		}); detailsErr == nil {
+			s = withDetails
+		}
+	}
+	return s.Err()
+}
@@ -21,9 +21,11 @@ import (
 	"context"
 	"net/netip"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -114,3 +116,28 @@ func TestAnonymousRateLimitForIP(t *testing.T) {
 	// ratelimit.Store size is 2: the 3rd IP reuses an existing (depleted) rate limiter.
 	assert.Equal(t, status.Error(codes.ResourceExhausted, "rate limit exceeded"), requestWithIP("10.1.1.3"))
 }
+
+func TestAnonymousRateLimitQuotaExceeded(t *testing.T) {
+	store, _ := ratelimit.NewStore(1, 1, 1)
+	interceptor := interceptors.AnonymousRateLimit(store)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, &ratelimit.QuotaExceededError{
+			Kind:       ratelimit.QuotaAPIKeyID,
+			Key:        "key_id",
+			RetryAfter: 2 * time.Second,
+		}
+	}
+	ctx := interceptors.ContextWithAuthenticationDetails(context.Background(), auth.AuthenticationDetails{
+		Method: auth.MethodAPIKey,
+		APIKey: &auth.APIKeyAuthenticationDetails{ID: "key_id"},
+	})
+	_, err := interceptor(ctx, "request", &grpc.UnaryServerInfo{}, handler)
+	s, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.ResourceExhausted, s.Code())
+	assert.Equal(t, `rate limit exceeded: api_key_id quota exceeded for "key_id"`, s.Message())
+	require.Len(t, s.Details(), 1)
+	retryInfo, ok := s.Details()[0].(*errdetails.RetryInfo)
+	require.True(t, ok)
+	assert.Equal(t, 2*time.Second, retryInfo.RetryDelay.AsDuration())
+}
@@ -0,0 +1,210 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package loadshed provides admission control for intake requests,
+// rejecting requests early when the server is overloaded.
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+)
+
+// updateInterval is the interval at which the pressure is updated.
+const updateInterval = time.Second
+
+// ErrOverloaded is returned by Controller.Admit when a request is shed.
+var ErrOverloaded = errors.New("server overloaded")
+
+// Priority holds the priority of a request. Lower priority
+// requests are shed before higher priority requests.
+type Priority int
+
+const (
+	// PriorityLow is the priority of RUM intake requests.
+	PriorityLow Priority = iota
+
+	// PriorityMedium is the priority of OTLP metrics and logs requests.
+	PriorityMedium
+
+	// PriorityHigh is the priority of backend intake and OTLP traces requests.
+	PriorityHigh
+)
+
+// shedPressure holds, for each priority, the pressure at or above
+// which requests of that priority are shed. A pressure of 1 means
+// that at least one signal has reached its configured threshold.
+var shedPressure = [...]float64{
+	PriorityLow:    1,
+	PriorityMedium: 1.1,
+	PriorityHigh:   1.25,
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityMedium:
+		return "medium"
+	case PriorityHigh:
+		return "high"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// OverloadedError is returned by Controller.Admit when a request is shed.
+// OverloadedError wraps ErrOverloaded.
+type OverloadedError struct {
+	Priority Priority
+
+	// RetryAfter holds the duration after which the client may retry.
+	RetryAfter time.Duration
+}
+
+func (e *OverloadedError) Error() string {
+	return fmt.Sprintf("%s: shedding %s priority requests", ErrOverloaded, e.Priority)
+}
+
+func (e *OverloadedError) Unwrap() error {
+	return ErrOverloaded
+}
+
+// Controller sheds requests based on the pressure on the server, measured
+// as the maximum of several signals relative to their configured thresholds:
+// output queue utilization, decoder semaphore wait time, and Go heap usage
+// relative to the memory limit.
+//
+// A nil *Controller admits all requests.
+type Controller struct {
+	cfg         config.LoadSheddingConfig
+	queue       *Queue
+	semaphore   *Semaphore
+	memoryLimit atomic.Uint64
+	heapBytes   func() uint64
+
+	pressure atomic.Uint64 // math.Float64bits
+	shed     metric.Int64Counter
+}
+
+// NewController returns a new Controller. The queue and semaphore signals
+// may be nil, and memoryLimit may be zero, in which case the corresponding
+// signals are ignored. Controller.Run must be called to keep the pressure updated.
+func NewController(
+	cfg config.LoadSheddingConfig,
+	queue *Queue,
+	semaphore *Semaphore,
+	memoryLimit uint64,
+	mp metric.MeterProvider,
+) (*Controller, error) {
+	c := &Controller{
+		cfg:       cfg,
+		queue:     queue,
+		semaphore: semaphore,
+		heapBytes: readHeapBytes,
+	}
+	c.memoryLimit.Store(memoryLimit)
+	meter := mp.Meter("github.com/elastic/apm-server/internal/beater/loadshed")
+	shed, err := meter.Int64Counter("apm-server.loadshed.requests.shed")
+	if err != nil {
+		return nil, err
+	}
+	c.shed = shed
+	if _, err := meter.Float64ObservableGauge(
+		"apm-server.loadshed.pressure",
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			o.Observe(c.Pressure())
+			return nil
+		}),
+	); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Run updates the pressure periodically until ctx is cancelled.
+func (c *Controller) Run(ctx context.Context) error {
+	ticker := time.NewTicker(updateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c.Update()
+		}
+	}
+}
+
+// Pressure returns the most recently measured pressure. A pressure
+// of 1 or more means that at least one signal has reached its threshold.
+func (c *Controller) Pressure() float64 {
+	return math.Float64frombits(c.pressure.Load())
+}
+
+// Admit returns an *OverloadedError if requests with the given
+// priority should be shed, and nil otherwise.
+func (c *Controller) Admit(ctx context.Context, priority Priority) error {
+	if c == nil || c.Pressure() < shedPressure[priority] {
+		return nil
+	}
+	c.shed.Add(ctx, 1, metric.WithAttributes(attribute.String("priority", priority.String())))
+	return &OverloadedError{Priority: priority, RetryAfter: c.cfg.RetryAfter}
+}
+
+// SetMemoryLimit sets the memory limit, in bytes, relative to which
+// heap usage is measured. A limit of zero disables the signal.
+func (c *Controller) SetMemoryLimit(limit uint64) {
+	c.memoryLimit.Store(limit)
+}
+
+// Update measures the pressure. Update is called periodically by Run.
+func (c *Controller) Update() {
+	var pressure float64
+	if c.queue != nil && c.cfg.QueueThreshold > 0 {
+		pressure = max(pressure, c.queue.Utilization()/c.cfg.QueueThreshold)
+	}
+	if c.semaphore != nil && c.cfg.SemaphoreWaitThreshold > 0 {
+		wait := c.semaphore.averageWait()
+		pressure = max(pressure, float64(wait)/float64(c.cfg.SemaphoreWaitThreshold))
+	}
+	if memoryLimit := c.memoryLimit.Load(); memoryLimit > 0 && c.cfg.MemoryThreshold > 0 {
+		used := float64(c.heapBytes()) / float64(memoryLimit)
+		pressure = max(pressure, used/c.cfg.MemoryThreshold)
+	}
+	c.pressure.Store(math.Float64bits(pressure))
+}
+
+// readHeapBytes returns the number of bytes occupied by live and
+// unswept heap objects, without stopping the world.
+func readHeapBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
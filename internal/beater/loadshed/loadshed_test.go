@@ -0,0 +1,127 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package loadshed
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+)
+
+func TestControllerQueue(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	queue := NewQueue()
+	queue.SetCapacity(100)
+	c, err := NewController(config.LoadSheddingConfig{
+		QueueThreshold: 0.8,
+		RetryAfter:     time.Second,
+	}, queue, nil, 0, mp)
+	require.NoError(t, err)
+
+	admitted := func() []bool {
+		c.Update()
+		var result []bool
+		for _, p := range []Priority{PriorityLow, PriorityMedium, PriorityHigh} {
+			result = append(result, c.Admit(context.Background(), p) == nil)
+		}
+		return result
+	}
+	assert.Equal(t, []bool{true, true, true}, admitted())
+
+	queue.Add(80) // pressure 1.0
+	assert.Equal(t, []bool{false, true, true}, admitted())
+	queue.Add(10) // pressure 1.125
+	assert.Equal(t, []bool{false, false, true}, admitted())
+	queue.Add(10) // pressure 1.25
+	assert.Equal(t, []bool{false, false, false}, admitted())
+	queue.Done(100)
+	assert.Equal(t, []bool{true, true, true}, admitted())
+
+	queue.Add(100)
+	c.Update()
+	err = c.Admit(context.Background(), PriorityHigh)
+	assert.ErrorIs(t, err, ErrOverloaded)
+	assert.EqualError(t, err, "server overloaded: shedding high priority requests")
+	assert.Equal(t, &OverloadedError{Priority: PriorityHigh, RetryAfter: time.Second}, err)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	shed := make(map[string]int64)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name != "apm-server.loadshed.requests.shed" {
+			continue
+		}
+		for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+			priority, _ := dp.Attributes.Value("priority")
+			shed[priority.AsString()] = dp.Value
+		}
+	}
+	assert.Equal(t, map[string]int64{"low": 3, "medium": 2, "high": 2}, shed)
+}
+
+func TestControllerMemory(t *testing.T) {
+	c, err := NewController(config.LoadSheddingConfig{MemoryThreshold: 0.5}, nil, nil, 1000, metricnoop.NewMeterProvider())
+	require.NoError(t, err)
+	var heapBytes uint64
+	c.heapBytes = func() uint64 { return heapBytes }
+
+	heapBytes = 250
+	c.Update()
+	assert.Equal(t, 0.5, c.Pressure())
+	assert.NoError(t, c.Admit(context.Background(), PriorityLow))
+
+	heapBytes = 600
+	c.Update()
+	assert.Equal(t, 1.2, c.Pressure())
+	assert.Error(t, c.Admit(context.Background(), PriorityMedium))
+	assert.NoError(t, c.Admit(context.Background(), PriorityHigh))
+
+	c.SetMemoryLimit(2000)
+	c.Update()
+	assert.Equal(t, 0.6, c.Pressure())
+}
+
+func TestControllerSemaphoreWait(t *testing.T) {
+	sem := NewSemaphore(newTestSemaphore())
+	c, err := NewController(config.LoadSheddingConfig{SemaphoreWaitThreshold: time.Second}, nil, sem, 0, metricnoop.NewMeterProvider())
+	require.NoError(t, err)
+	sem.waitTotal = 2 * time.Second
+	sem.acquired = 1
+	c.Update()
+	assert.Equal(t, 2.0, c.Pressure())
+	c.Update()
+	assert.Equal(t, 0.0, c.Pressure())
+}
+
+func TestControllerNil(t *testing.T) {
+	var c *Controller
+	assert.NoError(t, c.Admit(context.Background(), PriorityLow))
+}
+
+func TestControllerHeapBytes(t *testing.T) {
+	assert.NotZero(t, readHeapBytes())
+}
@@ -0,0 +1,139 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package loadshed
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/apm-data/input"
+)
+
+// Queue tracks the number of documents buffered by the output,
+// waiting to be indexed. A nil *Queue tracks nothing.
+type Queue struct {
+	capacity atomic.Int64
+	length   atomic.Int64
+}
+
+// NewQueue returns a new Queue with zero capacity.
+// SetCapacity should be called once the output's
+// buffer capacity is known.
+func NewQueue() *Queue {
+	return &Queue{}
+}
+
+// SetCapacity sets the capacity of the output's buffer.
+func (q *Queue) SetCapacity(n int) {
+	if q != nil {
+		q.capacity.Store(int64(n))
+	}
+}
+
+// Add records that n documents have been added to the output's buffer.
+func (q *Queue) Add(n int) {
+	if q != nil {
+		q.length.Add(int64(n))
+	}
+}
+
+// Done records that n documents have been removed from the output's buffer.
+func (q *Queue) Done(n int) {
+	if q != nil {
+		q.length.Add(-int64(n))
+	}
+}
+
+// Utilization returns the fraction of the output's buffer capacity in use,
+// or zero if the capacity is unknown.
+func (q *Queue) Utilization() float64 {
+	capacity := q.capacity.Load()
+	if capacity <= 0 {
+		return 0
+	}
+	return float64(q.length.Load()) / float64(capacity)
+}
+
+// Semaphore wraps an input.Semaphore, measuring the time spent
+// waiting to acquire it.
+type Semaphore struct {
+	input.Semaphore
+	now func() time.Time
+
+	mu        sync.Mutex
+	waiting   map[*time.Time]struct{}
+	waitTotal time.Duration
+	acquired  int64
+	lastTotal time.Duration
+	lastCount int64
+}
+
+// NewSemaphore returns a new Semaphore wrapping sem.
+func NewSemaphore(sem input.Semaphore) *Semaphore {
+	return &Semaphore{
+		Semaphore: sem,
+		now:       time.Now,
+		waiting:   make(map[*time.Time]struct{}),
+	}
+}
+
+// Acquire acquires the semaphore with a weight of n, recording
+// the time spent waiting.
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	if s.Semaphore.TryAcquire(n) {
+		s.mu.Lock()
+		s.acquired++
+		s.mu.Unlock()
+		return nil
+	}
+	start := s.now()
+	s.mu.Lock()
+	s.waiting[&start] = struct{}{}
+	s.mu.Unlock()
+
+	err := s.Semaphore.Acquire(ctx, n)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.waiting, &start)
+	if err == nil {
+		s.waitTotal += s.now().Sub(start)
+		s.acquired++
+	}
+	return err
+}
+
+// averageWait returns the greater of the average time spent waiting
+// to acquire the semaphore since the last call, and the longest time
+// spent by a caller that is currently waiting.
+func (s *Semaphore) averageWait() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var wait time.Duration
+	if count := s.acquired - s.lastCount; count > 0 {
+		wait = (s.waitTotal - s.lastTotal) / time.Duration(count)
+	}
+	s.lastTotal, s.lastCount = s.waitTotal, s.acquired
+	now := s.now()
+	for start := range s.waiting {
+		wait = max(wait, now.Sub(*start))
+	}
+	return wait
+}
@@ -0,0 +1,85 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package loadshed
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/semaphore"
+)
+
+func TestQueue(t *testing.T) {
+	q := NewQueue()
+	q.Add(10)
+	assert.Zero(t, q.Utilization()) // unknown capacity
+
+	q.SetCapacity(40)
+	assert.Equal(t, 0.25, q.Utilization())
+	q.Done(5)
+	assert.Equal(t, 0.125, q.Utilization())
+
+	var nilQueue *Queue
+	nilQueue.SetCapacity(1)
+	nilQueue.Add(1)
+	nilQueue.Done(1)
+}
+
+func TestSemaphore(t *testing.T) {
+	sem := NewSemaphore(newTestSemaphore())
+	now := time.Now()
+	sem.now = func() time.Time { return now }
+
+	// Acquiring without waiting records no wait time.
+	require.NoError(t, sem.Acquire(context.Background(), 1))
+	assert.Zero(t, sem.averageWait())
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- sem.Acquire(context.Background(), 1)
+	}()
+	require.Eventually(t, func() bool {
+		sem.mu.Lock()
+		defer sem.mu.Unlock()
+		return len(sem.waiting) == 1
+	}, 10*time.Second, time.Millisecond)
+
+	// The current wait is measured while the caller is waiting.
+	sem.mu.Lock()
+	now = now.Add(3 * time.Second)
+	sem.mu.Unlock()
+	assert.Equal(t, 3*time.Second, sem.averageWait())
+
+	sem.Release(1)
+	require.NoError(t, <-acquired)
+	assert.Equal(t, 3*time.Second, sem.averageWait())
+	assert.Zero(t, sem.averageWait())
+
+	// Cancelled waits are not recorded.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, sem.Acquire(ctx, 1))
+	assert.Zero(t, sem.averageWait())
+}
+
+func newTestSemaphore() *semaphore.Weighted {
+	return semaphore.NewWeighted(1)
+}
@@ -0,0 +1,162 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beater
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// memoryLimitWatcher periodically re-reads the process memory limit,
+// and calls the registered resize functions when it changes, so that
+// memory-dependent components can be resized without a restart.
+//
+// The memory limit and the values of memory-dependent settings are
+// exposed as metrics. A nil *memoryLimitWatcher ignores registrations.
+type memoryLimitWatcher struct {
+	cgroups  cgroupReader
+	sys      sysMemoryReader
+	interval time.Duration
+	logger   *logp.Logger
+
+	mu         sync.Mutex
+	memLimitGB float64
+	resizers   []*memoryLimitResizer
+	settings   map[string]int64
+}
+
+// memoryLimitResizer holds a resize function registered with onChange,
+// and the memory limit it was last successfully called with.
+type memoryLimitResizer struct {
+	resize     func(ctx context.Context, memLimitGB float64) error
+	memLimitGB float64
+}
+
+func newMemoryLimitWatcher(
+	cgroups cgroupReader,
+	sys sysMemoryReader,
+	memLimitGB float64,
+	interval time.Duration,
+	mp metric.MeterProvider,
+	logger *logp.Logger,
+) (*memoryLimitWatcher, error) {
+	w := &memoryLimitWatcher{
+		cgroups:    cgroups,
+		sys:        sys,
+		interval:   interval,
+		logger:     logger,
+		memLimitGB: memLimitGB,
+		settings:   make(map[string]int64),
+	}
+	meter := mp.Meter("github.com/elastic/apm-server/internal/beater")
+	memoryLimit, err := meter.Int64ObservableGauge(
+		"apm-server.memory_autotune.memory_limit", metric.WithUnit("By"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	setting, err := meter.Int64ObservableGauge("apm-server.memory_autotune.setting")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		o.ObserveInt64(memoryLimit, int64(w.memLimitGB*(1<<30)))
+		for name, value := range w.settings {
+			o.ObserveInt64(setting, value, metric.WithAttributes(attribute.String("setting", name)))
+		}
+		return nil
+	}, memoryLimit, setting); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// onChange registers resize to be called with the new memory limit when it
+// changes. Resize functions are called sequentially, in registration order.
+// A resize function which fails is called again on the next check, until it
+// succeeds or the memory limit changes back.
+func (w *memoryLimitWatcher) onChange(resize func(ctx context.Context, memLimitGB float64) error) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.resizers = append(w.resizers, &memoryLimitResizer{
+		resize:     resize,
+		memLimitGB: w.memLimitGB,
+	})
+}
+
+// setSetting records the current value of a memory-dependent setting.
+func (w *memoryLimitWatcher) setSetting(name string, value int) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.settings[name] = int64(value)
+}
+
+// Run re-reads the memory limit periodically until ctx is cancelled.
+func (w *memoryLimitWatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.check(ctx)
+		}
+	}
+}
+
+// check re-reads the memory limit, and calls the resize functions which
+// have not yet been successfully called with it. Resize errors are logged,
+// and do not prevent subsequent resize functions from being called.
+func (w *memoryLimitWatcher) check(ctx context.Context) {
+	// processMemoryLimit logs the outcome of each step at info level,
+	// which is only useful on startup.
+	memLimitGB := processMemoryLimit(w.cgroups, w.sys, logp.NewNopLogger())
+	w.mu.Lock()
+	previous := w.memLimitGB
+	resizers := w.resizers
+	w.memLimitGB = memLimitGB
+	w.mu.Unlock()
+	if memLimitGB != previous {
+		w.logger.Infof("memory limit changed from %0.1fgb to %0.1fgb, resizing components", previous, memLimitGB)
+	}
+	for _, r := range resizers {
+		if r.memLimitGB == memLimitGB {
+			continue
+		}
+		if err := r.resize(ctx, memLimitGB); err != nil {
+			w.logger.With(logp.Error(err)).Error("failed to resize component for new memory limit, will retry")
+			continue
+		}
+		r.memLimitGB = memLimitGB
+	}
+}
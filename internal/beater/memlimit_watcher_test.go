@@ -0,0 +1,104 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beater
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestMemoryLimitWatcher(t *testing.T) {
+	const gb = 1 << 30
+	sysLimit := uint64(16 * gb)
+	sys := sysMemoryReaderFunc(func() (uint64, error) { return sysLimit, nil })
+
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	w, err := newMemoryLimitWatcher(nil, sys, 10, time.Minute, mp, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+
+	var resized []float64
+	resizeErr := errors.New("logged and retried")
+	w.onChange(func(_ context.Context, memLimitGB float64) error {
+		resized = append(resized, memLimitGB)
+		if resizeErr != nil {
+			return resizeErr
+		}
+		w.setSetting("setting", int(memLimitGB*10))
+		return nil
+	})
+	w.onChange(func(_ context.Context, memLimitGB float64) error {
+		resized = append(resized, -memLimitGB)
+		return nil
+	})
+	w.setSetting("setting", 100)
+
+	// 62.5% of the system memory limit is used when there is no cgroup limit.
+	w.check(context.Background())
+	assert.Empty(t, resized)
+
+	sysLimit = 32 * gb
+	w.check(context.Background())
+	assert.Equal(t, []float64{20, -20}, resized)
+
+	// Failed resize functions are retried until they succeed, without
+	// calling those which have already succeeded.
+	w.check(context.Background())
+	assert.Equal(t, []float64{20, -20, 20}, resized)
+
+	resizeErr = nil
+	w.check(context.Background())
+	assert.Equal(t, []float64{20, -20, 20, 20}, resized)
+
+	w.check(context.Background())
+	assert.Equal(t, []float64{20, -20, 20, 20}, resized)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	values := make(map[string]int64)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		for _, dp := range m.Data.(metricdata.Gauge[int64]).DataPoints {
+			name := m.Name
+			if setting, ok := dp.Attributes.Value("setting"); ok {
+				name += "/" + setting.AsString()
+			}
+			values[name] = dp.Value
+		}
+	}
+	assert.Equal(t, map[string]int64{
+		"apm-server.memory_autotune.memory_limit":    20 * gb,
+		"apm-server.memory_autotune.setting/setting": 200,
+	}, values)
+}
+
+func TestMemoryLimitWatcherNil(t *testing.T) {
+	var w *memoryLimitWatcher
+	w.setSetting("setting", 1)
+	w.onChange(func(context.Context, float64) error {
+		panic("unexpected call")
+	})
+}
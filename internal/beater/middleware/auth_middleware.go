@@ -21,6 +21,7 @@ import (
 	"context"
 	"errors"
 
+	"github.com/elastic/apm-server/internal/beater/audit"
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/headers"
 	"github.com/elastic/apm-server/internal/beater/request"
@@ -38,12 +39,17 @@ type Authenticator interface {
 // and in the case of unauthenticated requests, the Authentication field
 // will have the zero value and the context will be populated with an
 // auth.Authorizer that denies all actions and resources.
-func AuthMiddleware(authenticator Authenticator, required bool) Middleware {
+//
+// If auditor is non-nil, authentication outcomes and authorization
+// denials are recorded in the audit trail.
+func AuthMiddleware(authenticator Authenticator, required bool, auditor *audit.Auditor) Middleware {
 	return func(h request.Handler) (request.Handler, error) {
 		return func(c *request.Context) {
 			header := c.Request.Header.Get(headers.Authorization)
 			kind, token := auth.ParseAuthorizationHeader(header)
 			details, authorizer, err := authenticator.Authenticate(c.Request.Context(), kind, token)
+			auditRequest := audit.Request{Route: c.Request.URL.Path, SourceIP: c.ClientIP}
+			auditor.Authentication(auditRequest, details, err)
 			if err != nil {
 				if errors.Is(err, auth.ErrAuthFailed) {
 					if !required {
@@ -65,7 +71,10 @@ func AuthMiddleware(authenticator Authenticator, required bool) Middleware {
 				}
 			}
 			c.Authentication = details
-			c.Request = c.Request.WithContext(auth.ContextWithAuthorizer(c.Request.Context(), authorizer))
+			authorizer = auditor.Authorizer(auditRequest, details, authorizer)
+			ctx := auth.ContextWithAuthorizer(c.Request.Context(), authorizer)
+			ctx = auth.ContextWithAuthenticationDetails(ctx, details)
+			c.Request = c.Request.WithContext(ctx)
 			h(c)
 
 			// Processors may indicate that a request is unauthorized by returning auth.ErrUnauthorized.
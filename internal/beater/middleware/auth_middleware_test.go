@@ -23,12 +23,17 @@ import (
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
 
+	"github.com/elastic/apm-server/internal/beater/audit"
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/headers"
 	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
 )
 
 func TestAuthMiddleware(t *testing.T) {
@@ -76,7 +81,7 @@ func TestAuthMiddleware(t *testing.T) {
 				assert.Equal(t, tc.expectToken, token)
 				return auth.AuthenticationDetails{Method: auth.MethodSecretToken}, denyAll{}, tc.authError
 			}
-			m := AuthMiddleware(authenticator, tc.authRequired)
+			m := AuthMiddleware(authenticator, tc.authRequired, nil)
 			Apply(m, Handler202)(c)
 			assert.Equal(t, tc.expectStatus, rec.Code)
 			assert.Equal(t, tc.expectBody, rec.Body.String())
@@ -91,7 +96,7 @@ func TestAuthMiddlewareError(t *testing.T) {
 	}
 	for _, required := range []bool{false, true} {
 		c, rec := DefaultContextWithResponseRecorder()
-		m := AuthMiddleware(authenticator, required)
+		m := AuthMiddleware(authenticator, required, nil)
 		Apply(m, Handler202)(c)
 		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
 		assert.Equal(t, `{"error":"service unavailable"}`+"\n", rec.Body.String())
@@ -111,13 +116,69 @@ func TestAuthUnauthorized(t *testing.T) {
 		c.Result.Err = auth.Authorize(c.Request.Context(), auth.ActionEventIngest, auth.Resource{})
 	}
 	c, _ := DefaultContextWithResponseRecorder()
-	m := AuthMiddleware(authenticator, true)
+	m := AuthMiddleware(authenticator, true, nil)
 	Apply(m, next)(c)
 
 	assert.Equal(t, request.IDResponseErrorsForbidden, c.Result.ID)
 	assert.Equal(t, "unauthorized: none shall pass", c.Result.Body)
 }
 
+func TestAuthMiddlewareAudit(t *testing.T) {
+	output := &auditOutput{}
+	auditor, err := audit.NewAuditor(output, time.Minute, metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+
+	var authorizer authorizerFunc = func(context.Context, auth.Action, auth.Resource) error {
+		return fmt.Errorf("%w: none shall pass", auth.ErrUnauthorized)
+	}
+	var authenticator authenticatorFunc = func(ctx context.Context, kind, token string) (auth.AuthenticationDetails, auth.Authorizer, error) {
+		if token != "abc123" {
+			return auth.AuthenticationDetails{}, nil, auth.ErrAuthFailed
+		}
+		return auth.AuthenticationDetails{Method: auth.MethodSecretToken}, authorizer, nil
+	}
+	next := func(c *request.Context) {
+		c.Result.Err = auth.Authorize(c.Request.Context(), auth.ActionEventIngest, auth.Resource{ServiceName: "opbeans"})
+	}
+	m := AuthMiddleware(authenticator, true, auditor)
+	for _, header := range []string{"Bearer abc123", "Bearer wrong"} {
+		c, _ := DefaultContextWithResponseRecorder()
+		c.Request.Header.Set(headers.Authorization, header)
+		Apply(m, next)(c)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, auditor.Run(ctx))
+	require.Len(t, output.events, 3)
+	for i, expected := range []struct {
+		action  audit.Action
+		outcome audit.Outcome
+	}{
+		{audit.ActionAuthentication, audit.OutcomeSuccess},
+		{audit.ActionAuthorization, audit.OutcomeFailure},
+		{audit.ActionAuthentication, audit.OutcomeFailure},
+	} {
+		assert.Equal(t, expected.action, output.events[i].Action)
+		assert.Equal(t, expected.outcome, output.events[i].Outcome)
+		assert.Equal(t, "/", output.events[i].Request.Route)
+	}
+	assert.Equal(t, auth.Resource{ServiceName: "opbeans"}, output.events[1].Resource)
+}
+
+type auditOutput struct {
+	events []audit.Event
+}
+
+func (o *auditOutput) Write(ctx context.Context, events []audit.Event) error {
+	o.events = append(o.events, events...)
+	return nil
+}
+
+func (o *auditOutput) Close(ctx context.Context) error {
+	return nil
+}
+
 type authenticatorFunc func(ctx context.Context, kind, token string) (auth.AuthenticationDetails, auth.Authorizer, error)
 
 func (f authenticatorFunc) Authenticate(ctx context.Context, kind, token string) (auth.AuthenticationDetails, auth.Authorizer, error) {
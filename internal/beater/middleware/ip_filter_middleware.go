@@ -0,0 +1,42 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package middleware
+
+import (
+	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/netutil"
+)
+
+// IPFilterMiddleware returns a Middleware that rejects requests whose
+// client IP is not allowed by filter. If filter is nil, all requests
+// are allowed.
+func IPFilterMiddleware(filter *netutil.IPFilter) Middleware {
+	return func(h request.Handler) (request.Handler, error) {
+		if filter == nil {
+			return h, nil
+		}
+		return func(c *request.Context) {
+			if !filter.Allowed(c.ClientIP) {
+				c.Result.SetDefault(request.IDResponseErrorsIPDenied)
+				c.WriteResult()
+				return
+			}
+			h(c)
+		}, nil
+	}
+}
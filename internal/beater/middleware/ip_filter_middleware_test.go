@@ -0,0 +1,54 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package middleware
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/netutil"
+)
+
+func TestIPFilterMiddleware(t *testing.T) {
+	t.Run("NoFilter", func(t *testing.T) {
+		c, rec := DefaultContextWithResponseRecorder()
+		Apply(IPFilterMiddleware(nil), Handler202)(c)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+	})
+	t.Run("Allowed", func(t *testing.T) {
+		// httptest.NewRequest uses the remote address 192.0.2.1:1234.
+		filter, err := netutil.NewIPFilter([]string{"192.0.2.0/24"}, nil)
+		require.NoError(t, err)
+		c, rec := DefaultContextWithResponseRecorder()
+		Apply(IPFilterMiddleware(filter), Handler202)(c)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+	})
+	t.Run("Denied", func(t *testing.T) {
+		filter, err := netutil.NewIPFilter([]string{"10.0.0.0/8"}, []string{"192.0.2.1"})
+		require.NoError(t, err)
+		c, rec := DefaultContextWithResponseRecorder()
+		Apply(IPFilterMiddleware(filter), Handler202)(c)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, request.IDResponseErrorsIPDenied, c.Result.ID)
+		assert.Contains(t, rec.Body.String(), "client IP not allowed")
+	})
+}
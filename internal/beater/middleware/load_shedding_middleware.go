@@ -0,0 +1,50 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package middleware
+
+import (
+	"errors"
+
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/loadshed"
+	"github.com/elastic/apm-server/internal/beater/ratelimit"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+// LoadSheddingMiddleware rejects requests of the given priority with
+// 503 Service Unavailable and a Retry-After header while the server is
+// overloaded. If controller is nil, requests are never shed.
+func LoadSheddingMiddleware(controller *loadshed.Controller, priority loadshed.Priority) Middleware {
+	return func(h request.Handler) (request.Handler, error) {
+		if controller == nil {
+			return h, nil
+		}
+		return func(c *request.Context) {
+			if err := controller.Admit(c.Request.Context(), priority); err != nil {
+				var overloaded *loadshed.OverloadedError
+				if errors.As(err, &overloaded) {
+					c.ResponseWriter.Header().Set(headers.RetryAfter, ratelimit.FormatRetryAfter(overloaded.RetryAfter))
+				}
+				c.Result.SetWithError(request.IDResponseErrorsOverloaded, err)
+				c.WriteResult()
+				return
+			}
+			h(c)
+		}, nil
+	}
+}
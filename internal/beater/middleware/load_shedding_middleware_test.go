@@ -0,0 +1,69 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package middleware
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/loadshed"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+func TestLoadSheddingMiddleware(t *testing.T) {
+	t.Run("NoController", func(t *testing.T) {
+		c, rec := DefaultContextWithResponseRecorder()
+		Apply(LoadSheddingMiddleware(nil, loadshed.PriorityLow), Handler202)(c)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+	})
+
+	queue := loadshed.NewQueue()
+	queue.SetCapacity(100)
+	controller, err := loadshed.NewController(config.LoadSheddingConfig{
+		Enabled:        true,
+		QueueThreshold: 0.8,
+		RetryAfter:     1500 * time.Millisecond,
+	}, queue, nil, 0, metricnoop.NewMeterProvider())
+	require.NoError(t, err)
+
+	// Queue utilization of 0.8 reaches the threshold,
+	// shedding only low priority requests.
+	queue.Add(80)
+	controller.Update()
+
+	t.Run("Admitted", func(t *testing.T) {
+		c, rec := DefaultContextWithResponseRecorder()
+		Apply(LoadSheddingMiddleware(controller, loadshed.PriorityHigh), Handler202)(c)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+	})
+	t.Run("Shed", func(t *testing.T) {
+		c, rec := DefaultContextWithResponseRecorder()
+		Apply(LoadSheddingMiddleware(controller, loadshed.PriorityLow), Handler202)(c)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, request.IDResponseErrorsOverloaded, c.Result.ID)
+		assert.Equal(t, "2", rec.Header().Get(headers.RetryAfter))
+		assert.Contains(t, rec.Body.String(), "server overloaded")
+	})
+}
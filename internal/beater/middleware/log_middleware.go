@@ -25,6 +25,7 @@ import (
 
 	"github.com/elastic/elastic-agent-libs/logp"
 
+	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/headers"
 	"github.com/elastic/apm-server/internal/beater/request"
 	"github.com/elastic/apm-server/internal/logs"
@@ -45,6 +46,7 @@ func LogMiddleware(logger *logp.Logger) Middleware {
 				return
 			}
 			h(c)
+			c.Logger = loggerWithAuthentication(c.Logger, c.Authentication)
 			c.Logger = c.Logger.With("event.duration", time.Since(c.Timestamp))
 			c.Logger = c.Logger.With("http.request.body.bytes", c.RequestBodyBytes())
 			if c.MultipleWriteAttempts() {
@@ -79,6 +81,16 @@ func loggerWithRequestContext(logger *logp.Logger, c *request.Context) *logp.Log
 	return logger
 }
 
+func loggerWithAuthentication(logger *logp.Logger, details auth.AuthenticationDetails) *logp.Logger {
+	if details.APIKey != nil {
+		logger = logger.With("api_key.id", details.APIKey.ID)
+	}
+	if details.SecretToken != nil {
+		logger = logger.With("secret_token.name", details.SecretToken.Name)
+	}
+	return logger
+}
+
 func loggerWithTraceContext(c *request.Context) (*logp.Logger, error) {
 	span := trace.SpanFromContext(c.Request.Context())
 	if span == nil || !span.SpanContext().IsValid() {
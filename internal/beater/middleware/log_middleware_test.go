@@ -33,6 +33,7 @@ import (
 	"github.com/elastic/elastic-agent-libs/logp/logptest"
 	"github.com/elastic/elastic-agent-libs/mapstr"
 
+	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/headers"
 	"github.com/elastic/apm-server/internal/beater/request"
 	"github.com/elastic/apm-server/internal/logs"
@@ -65,6 +66,20 @@ func TestLogMiddleware(t *testing.T) {
 			ecsKeys: []string{"url.original", "trace.id", "transaction.id"},
 			traced:  true,
 		},
+		{
+			name:    "Authenticated",
+			message: "request accepted",
+			level:   zapcore.InfoLevel,
+			handler: func(c *request.Context) {
+				c.Authentication = auth.AuthenticationDetails{
+					Method:      auth.MethodSecretToken,
+					SecretToken: &auth.SecretTokenAuthenticationDetails{Name: "fleet"},
+				}
+				Handler202(c)
+			},
+			code:    http.StatusAccepted,
+			ecsKeys: []string{"url.original", "secret_token.name"},
+		},
 		{
 			name:    "Error",
 			message: "forbidden request",
@@ -19,6 +19,7 @@ package otlp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -38,6 +39,8 @@ import (
 	"github.com/elastic/apm-data/input/otlp"
 	"github.com/elastic/apm-data/model/modelpb"
 	"github.com/elastic/apm-data/model/modelprocessor"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/ratelimit"
 )
 
 var (
@@ -192,6 +195,13 @@ func (h HTTPHandlers) writeResponse(w http.ResponseWriter, m protoMarshaler) err
 }
 
 func (h HTTPHandlers) writeError(w http.ResponseWriter, err error, statusCode int) {
+	if errors.Is(err, ratelimit.ErrRateLimitExceeded) {
+		if retryAfter, ok := ratelimit.RetryAfter(err); ok {
+			w.Header().Set(headers.RetryAfter, ratelimit.FormatRetryAfter(retryAfter))
+		}
+		statusCode = http.StatusTooManyRequests
+		err = status.Error(codes.ResourceExhausted, err.Error())
+	}
 	s, ok := status.FromError(err)
 	if !ok {
 		if statusCode == http.StatusBadRequest {
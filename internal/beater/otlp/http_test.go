@@ -24,6 +24,7 @@ import (
 	"net"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -88,6 +89,30 @@ func TestConsumeTracesHTTP(t *testing.T) {
 
 }
 
+func TestConsumeTracesHTTPQuotaExceeded(t *testing.T) {
+	var batchProcessor modelpb.ProcessBatchFunc = func(ctx context.Context, batch *modelpb.Batch) error {
+		return &ratelimit.QuotaExceededError{
+			Kind:       ratelimit.QuotaServiceName,
+			Key:        "service_name",
+			RetryAfter: 3 * time.Second,
+		}
+	}
+	addr, _ := newHTTPServer(t, batchProcessor)
+
+	traces := ptrace.NewTraces()
+	traces.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	request, err := ptraceotlp.NewExportRequestFromTraces(traces).MarshalProto()
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/v1/traces", addr), bytes.NewReader(request))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	rsp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.NoError(t, rsp.Body.Close())
+	assert.Equal(t, http.StatusTooManyRequests, rsp.StatusCode)
+	assert.Equal(t, "3", rsp.Header.Get("Retry-After"))
+}
+
 func TestConsumeMetricsHTTP(t *testing.T) {
 	var reportError error
 	var batchProcessor modelpb.ProcessBatchFunc = func(ctx context.Context, batch *modelpb.Batch) error {
@@ -170,15 +195,18 @@ func newHTTPServer(t *testing.T, batchProcessor modelpb.BatchProcessor) (string,
 	))
 	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
 	cfg := &config.Config{}
-	auth, _ := auth.NewAuthenticator(cfg.AgentAuth, noop.NewTracerProvider(), logptest.NewTestingLogger(t, ""))
+	auth, _ := auth.NewAuthenticator(cfg.AgentAuth, noop.NewTracerProvider(), mp, logptest.NewTestingLogger(t, ""))
 	ratelimitStore, _ := ratelimit.NewStore(1000, 1000, 1000)
 	router, err := api.NewMux(
 		cfg,
 		batchProcessor,
 		auth,
+		nil,
+		nil,
 		agentcfg.NewEmptyFetcher(),
 		ratelimitStore,
 		nil,
+		nil,
 		func() bool { return true },
 		semaphore.NewWeighted(1),
 		mp,
@@ -19,17 +19,23 @@ package beater
 
 import (
 	"context"
+	"errors"
 	"io"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.elastic.co/fastjson"
+	"go.opentelemetry.io/otel/metric"
 
 	"github.com/elastic/apm-data/model/modeljson"
 	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/internal/beater/audit"
 	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/loadshed"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/version"
 	"github.com/elastic/go-docappender/v2"
@@ -53,18 +59,100 @@ func authorizeEventIngestProcessor(ctx context.Context, batch *modelpb.Batch) er
 	return nil
 }
 
-// rateLimitBatchProcessor is a model.BatchProcessor that rate limits based on
-// the batch size. This will be invoked after decoding events, but before sending
-// on to the libbeat publisher.
-func rateLimitBatchProcessor(ctx context.Context, batch *modelpb.Batch) error {
-	if limiter, ok := ratelimit.FromContext(ctx); ok {
-		ctx, cancel := context.WithTimeout(ctx, rateLimitTimeout)
-		defer cancel()
-		if err := limiter.WaitN(ctx, len(*batch)); err != nil {
-			return ratelimit.ErrRateLimitExceeded
+// newAuditBatchProcessor returns a model.BatchProcessor that records the
+// number of events ingested for the client's credential in the audit trail.
+func newAuditBatchProcessor(auditor *audit.Auditor) modelpb.BatchProcessor {
+	return modelpb.ProcessBatchFunc(func(ctx context.Context, batch *modelpb.Batch) error {
+		if details, ok := auth.AuthenticationDetailsFromContext(ctx); ok {
+			auditor.EventsIngested(details, len(*batch))
 		}
+		return nil
+	})
+}
+
+// newQuotas returns ratelimit.Quotas for the configured quotas.
+func newQuotas(cfg []config.Quota, mp metric.MeterProvider) (*ratelimit.Quotas, error) {
+	if len(cfg) == 0 {
+		return nil, nil
+	}
+	quotas, err := ratelimit.NewQuotas(3 /* burst multiplier */, mp)
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range cfg {
+		kind, key := ratelimit.QuotaServiceName, q.ServiceName
+		switch {
+		case q.APIKeyID != "":
+			kind, key = ratelimit.QuotaAPIKeyID, q.APIKeyID
+		case q.SecretTokenName != "":
+			kind, key = ratelimit.QuotaSecretTokenName, q.SecretTokenName
+		}
+		if err := quotas.Add(kind, key, q.EventLimit, q.ByteLimit); err != nil {
+			return nil, err
+		}
+	}
+	return quotas, nil
+}
+
+// newRateLimitBatchProcessor returns a model.BatchProcessor that rate limits
+// based on the batch size. This will be invoked after decoding events, but
+// before sending on to the libbeat publisher.
+//
+// Anonymous clients are rate limited by the limiter in the context, if any.
+// Authenticated clients are subject to the quota for their API Key ID or
+// secret token name, if any, and events are subject to the quota for their
+// service name, if any.
+func newRateLimitBatchProcessor(quotas *ratelimit.Quotas) modelpb.ProcessBatchFunc {
+	return func(ctx context.Context, batch *modelpb.Batch) error {
+		if limiter, ok := ratelimit.FromContext(ctx); ok {
+			ctx, cancel := context.WithTimeout(ctx, rateLimitTimeout)
+			defer cancel()
+			if err := limiter.WaitN(ctx, len(*batch)); err != nil {
+				return ratelimit.ErrRateLimitExceeded
+			}
+		}
+		// Reserve all applicable quotas together, so that nothing is
+		// consumed from any quota if the batch is rejected by another.
+		var usage []ratelimit.QuotaUsage
+		if details, ok := auth.AuthenticationDetailsFromContext(ctx); ok {
+			var quota *ratelimit.Quota
+			switch {
+			case details.APIKey != nil:
+				quota = quotas.Get(ratelimit.QuotaAPIKeyID, details.APIKey.ID)
+			case details.SecretToken != nil:
+				quota = quotas.Get(ratelimit.QuotaSecretTokenName, details.SecretToken.Name)
+			}
+			if quota != nil {
+				var bytes int
+				if quota.LimitsBytes() {
+					for _, event := range *batch {
+						bytes += event.SizeVT()
+					}
+				}
+				usage = append(usage, ratelimit.QuotaUsage{Quota: quota, Events: len(*batch), Bytes: bytes})
+			}
+		}
+		if quotas.Has(ratelimit.QuotaServiceName) {
+			services := make(map[*ratelimit.Quota]int)
+			for _, event := range *batch {
+				quota := quotas.Get(ratelimit.QuotaServiceName, event.GetService().GetName())
+				if quota == nil {
+					continue
+				}
+				i, ok := services[quota]
+				if !ok {
+					i = len(usage)
+					services[quota] = i
+					usage = append(usage, ratelimit.QuotaUsage{Quota: quota})
+				}
+				usage[i].Events++
+				if quota.LimitsBytes() {
+					usage[i].Bytes += event.SizeVT()
+				}
+			}
+		}
+		return ratelimit.AllowQuotas(ctx, usage...)
 	}
-	return nil
 }
 
 // newObserverBatchProcessor returns a model.BatchProcessor that sets
@@ -85,10 +173,13 @@ func newObserverBatchProcessor() modelpb.ProcessBatchFunc {
 	}
 }
 
-func newDocappenderBatchProcessor(a *docappender.Appender) modelpb.ProcessBatchFunc {
+// newDocappenderBatchProcessor returns a modelpb.ProcessBatchFunc that adds
+// events to a. If queue is non-nil, events are counted as added to queue when
+// added to a, and as done when consumed by one of a's bulk indexers.
+func newDocappenderBatchProcessor(a documentAppender, queue *loadshed.Queue) modelpb.ProcessBatchFunc {
 	var pool sync.Pool
 	pool.New = func() any {
-		return &pooledReader{pool: &pool}
+		return &pooledReader{pool: &pool, queue: queue}
 	}
 	return func(ctx context.Context, b *modelpb.Batch) error {
 		for _, event := range *b {
@@ -105,7 +196,9 @@ func newDocappenderBatchProcessor(a *docappender.Appender) modelpb.ProcessBatchF
 			r.indexBuilder.WriteByte('-')
 			r.indexBuilder.WriteString(event.DataStream.Namespace)
 			index := r.indexBuilder.String()
+			queue.Add(1)
 			if err := a.Add(ctx, index, r); err != nil {
+				queue.Done(1)
 				r.reset()
 				return err
 			}
@@ -114,8 +207,55 @@ func newDocappenderBatchProcessor(a *docappender.Appender) modelpb.ProcessBatchF
 	}
 }
 
+// documentAppender is the interface for adding documents
+// to be indexed, implemented by *docappender.Appender.
+type documentAppender interface {
+	Add(ctx context.Context, index string, document io.WriterTo) error
+}
+
+// swappableAppender is a documentAppender which wraps a
+// *docappender.Appender that may be replaced while in use.
+//
+// Documents are added to the current appender without holding a lock, so a
+// blocked Add does not hold up replacing the appender. An Add racing with
+// swap may fail because the replaced appender has been closed, in which case
+// the document is added to the new appender instead.
+type swappableAppender struct {
+	appender atomic.Pointer[docappender.Appender]
+}
+
+func newSwappableAppender(a *docappender.Appender) *swappableAppender {
+	s := &swappableAppender{}
+	s.appender.Store(a)
+	return s
+}
+
+func (s *swappableAppender) Add(ctx context.Context, index string, document io.WriterTo) error {
+	for {
+		a := s.appender.Load()
+		err := a.Add(ctx, index, document)
+		if errors.Is(err, docappender.ErrClosed) && s.appender.Load() != a {
+			continue
+		}
+		return err
+	}
+}
+
+// swap replaces the wrapped appender with a, returning the replaced appender.
+// The replaced appender should be closed to flush any buffered documents;
+// documents added to it after it is closed are added to a instead.
+func (s *swappableAppender) swap(a *docappender.Appender) *docappender.Appender {
+	return s.appender.Swap(a)
+}
+
+// Close closes the wrapped appender.
+func (s *swappableAppender) Close(ctx context.Context) error {
+	return s.appender.Load().Close(ctx)
+}
+
 type pooledReader struct {
 	pool         *sync.Pool
+	queue        *loadshed.Queue
 	jsonw        fastjson.Writer
 	indexBuilder strings.Builder
 }
@@ -126,6 +266,7 @@ func (r *pooledReader) Read(p []byte) (int, error) {
 
 func (r *pooledReader) WriteTo(w io.Writer) (int64, error) {
 	n, err := w.Write(r.jsonw.Bytes())
+	r.queue.Done(1)
 	r.reset()
 	return int64(n), err
 }
@@ -20,13 +20,19 @@ package beater
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
 	"golang.org/x/time/rate"
 
 	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/internal/beater/audit"
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
 )
 
 func TestRateLimitBatchProcessor(t *testing.T) {
@@ -37,13 +43,104 @@ func TestRateLimitBatchProcessor(t *testing.T) {
 	for i := range batch {
 		batch[i] = &modelpb.APMEvent{Transaction: &modelpb.Transaction{}}
 	}
+	processor := newRateLimitBatchProcessor(nil)
 	for i := 0; i < 2; i++ {
-		err := rateLimitBatchProcessor(ctx, &batch)
+		err := processor(ctx, &batch)
 		require.NoError(t, err)
 	}
 
 	// After the second batch, the rate limiter burst has been exhausted,
 	// and the limit is not high enough to allow another one.
-	err := rateLimitBatchProcessor(ctx, &batch)
+	err := processor(ctx, &batch)
 	assert.Equal(t, ratelimit.ErrRateLimitExceeded, err)
 }
+
+func TestRateLimitBatchProcessorQuotas(t *testing.T) {
+	quotas, err := newQuotas([]config.Quota{
+		{APIKeyID: "key_id", EventLimit: 2},
+		{SecretTokenName: "token_name", EventLimit: 10},
+		{ServiceName: "limited_service", EventLimit: 1},
+	}, metricnoop.NewMeterProvider())
+	require.NoError(t, err)
+	processor := newRateLimitBatchProcessor(quotas)
+
+	newBatch := func(serviceName string, n int) *modelpb.Batch {
+		batch := make(modelpb.Batch, n)
+		for i := range batch {
+			batch[i] = &modelpb.APMEvent{Service: &modelpb.Service{Name: serviceName}}
+		}
+		return &batch
+	}
+
+	// The API Key's burst is 2*3 events.
+	apiKeyCtx := auth.ContextWithAuthenticationDetails(context.Background(), auth.AuthenticationDetails{
+		Method: auth.MethodAPIKey,
+		APIKey: &auth.APIKeyAuthenticationDetails{ID: "key_id"},
+	})
+	require.NoError(t, processor(apiKeyCtx, newBatch("service", 6)))
+	err = processor(apiKeyCtx, newBatch("service", 6))
+	var quotaErr *ratelimit.QuotaExceededError
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, ratelimit.QuotaAPIKeyID, quotaErr.Kind)
+	assert.Equal(t, "key_id", quotaErr.Key)
+	assert.ErrorIs(t, err, ratelimit.ErrRateLimitExceeded)
+
+	// Other credentials are not affected by the API Key's quota.
+	secretTokenCtx := auth.ContextWithAuthenticationDetails(context.Background(), auth.AuthenticationDetails{
+		Method:      auth.MethodSecretToken,
+		SecretToken: &auth.SecretTokenAuthenticationDetails{Name: "token_name"},
+	})
+	require.NoError(t, processor(secretTokenCtx, newBatch("service", 6)))
+	require.NoError(t, processor(context.Background(), newBatch("service", 100)))
+
+	// Batches larger than a quota's burst are rejected.
+	err = processor(context.Background(), newBatch("limited_service", 4))
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, "limited_service", quotaErr.Key)
+
+	// Service quotas apply regardless of credentials.
+	require.NoError(t, processor(context.Background(), newBatch("limited_service", 3)))
+	err = processor(secretTokenCtx, newBatch("limited_service", 3))
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, ratelimit.QuotaServiceName, quotaErr.Kind)
+	assert.Equal(t, "limited_service", quotaErr.Key)
+}
+
+func TestAuditBatchProcessor(t *testing.T) {
+	output := &auditOutput{}
+	auditor, err := audit.NewAuditor(output, time.Minute, metricnoop.NewMeterProvider(), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	processor := newAuditBatchProcessor(auditor)
+
+	batch := make(modelpb.Batch, 3)
+	details := auth.AuthenticationDetails{
+		Method:      auth.MethodSecretToken,
+		SecretToken: &auth.SecretTokenAuthenticationDetails{Name: "token_name"},
+	}
+	ctx := auth.ContextWithAuthenticationDetails(context.Background(), details)
+	require.NoError(t, processor.ProcessBatch(ctx, &batch))
+	require.NoError(t, processor.ProcessBatch(ctx, &batch))
+	// Batches without authentication details are not counted.
+	require.NoError(t, processor.ProcessBatch(context.Background(), &batch))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, auditor.Run(ctx))
+	require.Len(t, output.events, 1)
+	assert.Equal(t, audit.ActionIngest, output.events[0].Action)
+	assert.Equal(t, audit.CredentialFromDetails(details), output.events[0].Credential)
+	assert.Equal(t, int64(6), output.events[0].Events)
+}
+
+type auditOutput struct {
+	events []audit.Event
+}
+
+func (o *auditOutput) Write(ctx context.Context, events []audit.Event) error {
+	o.events = append(o.events, events...)
+	return nil
+}
+
+func (o *auditOutput) Close(ctx context.Context) error {
+	return nil
+}
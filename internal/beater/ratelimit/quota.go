@@ -0,0 +1,268 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/time/rate"
+)
+
+// QuotaKind identifies the kind of key by which a quota is applied.
+type QuotaKind string
+
+const (
+	// QuotaAPIKeyID identifies quotas applied to clients authenticated
+	// with a specific API Key ID.
+	QuotaAPIKeyID QuotaKind = "api_key_id"
+
+	// QuotaSecretTokenName identifies quotas applied to clients authenticated
+	// with a specific named secret token.
+	QuotaSecretTokenName QuotaKind = "secret_token_name"
+
+	// QuotaServiceName identifies quotas applied to events for a specific service.
+	QuotaServiceName QuotaKind = "service_name"
+)
+
+// quotaMaxDelay is the maximum amount of time that a request will be delayed
+// for a quota to replenish. If a request would be delayed for longer, it is
+// rejected immediately with QuotaExceededError.
+const quotaMaxDelay = time.Second
+
+// QuotaExceededError is returned by Quota.Allow when a quota is exceeded.
+// QuotaExceededError wraps ErrRateLimitExceeded.
+type QuotaExceededError struct {
+	Kind QuotaKind
+	Key  string
+
+	// RetryAfter holds the estimated amount of time after which
+	// the client may retry.
+	RetryAfter time.Duration
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s: %s quota exceeded for %q", ErrRateLimitExceeded, e.Kind, e.Key)
+}
+
+func (e *QuotaExceededError) Unwrap() error {
+	return ErrRateLimitExceeded
+}
+
+// RetryAfter returns the RetryAfter duration of a *QuotaExceededError
+// in err's tree, and a boolean indicating whether one was found.
+func RetryAfter(err error) (time.Duration, bool) {
+	var quotaErr *QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		return quotaErr.RetryAfter, true
+	}
+	return 0, false
+}
+
+// FormatRetryAfter formats d as a Retry-After header value,
+// rounding up to the nearest second.
+func FormatRetryAfter(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
+
+// Quotas holds event and byte rate quotas, keyed by QuotaKind and key.
+//
+// Unlike Store, Quotas holds a fixed set of rate limiters, one for each
+// configured quota. A nil *Quotas has no quotas.
+type Quotas struct {
+	burstFactor int
+	quotas      map[QuotaKind]map[string]*Quota
+
+	acceptedEvents metric.Int64Counter
+	acceptedBytes  metric.Int64Counter
+	rejectedEvents metric.Int64Counter
+}
+
+// NewQuotas returns a new Quotas with no quotas. Quotas should
+// be added with Add before the Quotas is used.
+func NewQuotas(burstFactor int, mp metric.MeterProvider) (*Quotas, error) {
+	meter := mp.Meter("github.com/elastic/apm-server/internal/beater/ratelimit")
+	acceptedEvents, err := meter.Int64Counter("apm-server.ratelimit.quota.events.accepted")
+	if err != nil {
+		return nil, err
+	}
+	acceptedBytes, err := meter.Int64Counter("apm-server.ratelimit.quota.bytes.accepted")
+	if err != nil {
+		return nil, err
+	}
+	rejectedEvents, err := meter.Int64Counter("apm-server.ratelimit.quota.events.rejected")
+	if err != nil {
+		return nil, err
+	}
+	return &Quotas{
+		burstFactor:    burstFactor,
+		quotas:         make(map[QuotaKind]map[string]*Quota),
+		acceptedEvents: acceptedEvents,
+		acceptedBytes:  acceptedBytes,
+		rejectedEvents: rejectedEvents,
+	}, nil
+}
+
+// Add adds a quota for the given kind and key, limiting events per second
+// and bytes per second. A limit of zero means unlimited.
+func (q *Quotas) Add(kind QuotaKind, key string, eventLimit, byteLimit int) error {
+	if eventLimit < 0 || byteLimit < 0 {
+		return fmt.Errorf("invalid %s quota for %q: limits must not be negative", kind, key)
+	}
+	if q.quotas[kind] == nil {
+		q.quotas[kind] = make(map[string]*Quota)
+	}
+	if _, ok := q.quotas[kind][key]; ok {
+		return fmt.Errorf("duplicate %s quota for %q", kind, key)
+	}
+	quota := &Quota{
+		quotas:     q,
+		kind:       kind,
+		key:        key,
+		attributes: metric.WithAttributes(attribute.String("quota.kind", string(kind)), attribute.String("quota.key", key)),
+	}
+	if eventLimit > 0 {
+		quota.events = rate.NewLimiter(rate.Limit(eventLimit), eventLimit*q.burstFactor)
+	}
+	if byteLimit > 0 {
+		quota.bytes = rate.NewLimiter(rate.Limit(byteLimit), byteLimit*q.burstFactor)
+	}
+	q.quotas[kind][key] = quota
+	return nil
+}
+
+// Get returns the quota for the given kind and key, or nil if there is none.
+func (q *Quotas) Get(kind QuotaKind, key string) *Quota {
+	if q == nil {
+		return nil
+	}
+	return q.quotas[kind][key]
+}
+
+// Has reports whether there are any quotas of the given kind.
+func (q *Quotas) Has(kind QuotaKind) bool {
+	return q != nil && len(q.quotas[kind]) != 0
+}
+
+// Quota limits the rate of events and bytes for a single key.
+type Quota struct {
+	quotas     *Quotas
+	kind       QuotaKind
+	key        string
+	attributes metric.MeasurementOption
+	events     *rate.Limiter
+	bytes      *rate.Limiter
+}
+
+// LimitsBytes reports whether the quota limits the rate of bytes.
+// If it does not, callers need not calculate bytes for Allow.
+func (q *Quota) LimitsBytes() bool {
+	return q.bytes != nil
+}
+
+// Allow checks whether the given number of events and bytes may be accepted
+// under the quota. See AllowQuotas.
+func (q *Quota) Allow(ctx context.Context, events, bytes int) error {
+	return AllowQuotas(ctx, QuotaUsage{Quota: q, Events: events, Bytes: bytes})
+}
+
+// QuotaUsage holds a number of events and bytes to be accepted under a Quota.
+type QuotaUsage struct {
+	Quota  *Quota
+	Events int
+	Bytes  int
+}
+
+// AllowQuotas checks whether the given usage may be accepted under all of the
+// quotas, waiting up to one second for the quotas to replenish. If any quota
+// would not replenish in time, AllowQuotas returns a *QuotaExceededError for
+// that quota and consumes nothing from any of the quotas.
+//
+// Usage exceeding a quota's burst can never be accepted, and is rejected with
+// RetryAfter set to the time taken for the quota's burst to replenish.
+func AllowQuotas(ctx context.Context, usage ...QuotaUsage) error {
+	now := time.Now()
+	var delay time.Duration
+	var delayed *Quota
+	var reservations []*rate.Reservation
+	cancel := func() {
+		for _, reservation := range reservations {
+			reservation.CancelAt(now)
+		}
+	}
+	reject := func(q *Quota, events int, retryAfter time.Duration) error {
+		cancel()
+		q.quotas.rejectedEvents.Add(ctx, int64(events), q.attributes)
+		return &QuotaExceededError{Kind: q.kind, Key: q.key, RetryAfter: retryAfter}
+	}
+	for _, u := range usage {
+		for _, r := range []struct {
+			limiter *rate.Limiter
+			n       int
+		}{{u.Quota.events, u.Events}, {u.Quota.bytes, u.Bytes}} {
+			if r.limiter == nil || r.n == 0 {
+				continue
+			}
+			reservation := r.limiter.ReserveN(now, r.n)
+			if !reservation.OK() {
+				// r.n exceeds the burst.
+				burst := float64(r.limiter.Burst()) / float64(r.limiter.Limit())
+				return reject(u.Quota, u.Events, time.Duration(burst*float64(time.Second)))
+			}
+			reservations = append(reservations, reservation)
+			if d := reservation.DelayFrom(now); d > delay {
+				delay, delayed = d, u.Quota
+			}
+		}
+	}
+	if delay > quotaMaxDelay {
+		return reject(delayed, eventsFor(delayed, usage), delay)
+	}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return reject(delayed, eventsFor(delayed, usage), delay)
+		case <-timer.C:
+		}
+	}
+	for _, u := range usage {
+		q := u.Quota
+		q.quotas.acceptedEvents.Add(ctx, int64(u.Events), q.attributes)
+		if q.bytes != nil {
+			q.quotas.acceptedBytes.Add(ctx, int64(u.Bytes), q.attributes)
+		}
+	}
+	return nil
+}
+
+// eventsFor returns the number of events in usage for the quota q.
+func eventsFor(q *Quota, usage []QuotaUsage) int {
+	for _, u := range usage {
+		if u.Quota == q {
+			return u.Events
+		}
+	}
+	return 0
+}
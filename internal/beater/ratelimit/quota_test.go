@@ -0,0 +1,133 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestQuotasAdd(t *testing.T) {
+	quotas, err := NewQuotas(3, metricnoop.NewMeterProvider())
+	require.NoError(t, err)
+	require.NoError(t, quotas.Add(QuotaAPIKeyID, "id", 1, 0))
+	require.NoError(t, quotas.Add(QuotaServiceName, "id", 0, 1))
+	assert.EqualError(t, quotas.Add(QuotaAPIKeyID, "id", 1, 0), `duplicate api_key_id quota for "id"`)
+	assert.EqualError(t, quotas.Add(QuotaAPIKeyID, "other", -1, 0), `invalid api_key_id quota for "other": limits must not be negative`)
+
+	assert.NotNil(t, quotas.Get(QuotaAPIKeyID, "id"))
+	assert.Nil(t, quotas.Get(QuotaSecretTokenName, "id"))
+	assert.True(t, quotas.Has(QuotaServiceName))
+	assert.False(t, quotas.Has(QuotaSecretTokenName))
+
+	var nilQuotas *Quotas
+	assert.Nil(t, nilQuotas.Get(QuotaAPIKeyID, "id"))
+	assert.False(t, nilQuotas.Has(QuotaAPIKeyID))
+}
+
+func TestQuotaAllow(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	quotas, err := NewQuotas(3, sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	require.NoError(t, err)
+	require.NoError(t, quotas.Add(QuotaSecretTokenName, "name", 1, 100))
+	quota := quotas.Get(QuotaSecretTokenName, "name")
+	assert.True(t, quota.LimitsBytes())
+
+	// Requests exceeding the burst are rejected, and consume nothing.
+	err = quota.Allow(context.Background(), 1, 1000)
+	var quotaErr *QuotaExceededError
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, 3*time.Second, quotaErr.RetryAfter)
+	require.NoError(t, quota.Allow(context.Background(), 1, 300))
+
+	err = quota.Allow(context.Background(), 3, 250)
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, QuotaSecretTokenName, quotaErr.Kind)
+	assert.Equal(t, "name", quotaErr.Key)
+	assert.Greater(t, quotaErr.RetryAfter, time.Second)
+	assert.True(t, errors.Is(err, ErrRateLimitExceeded))
+	assert.EqualError(t, err, `rate limit exceeded: secret_token_name quota exceeded for "name"`)
+
+	retryAfter, ok := RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, quotaErr.RetryAfter, retryAfter)
+	_, ok = RetryAfter(ErrRateLimitExceeded)
+	assert.False(t, ok)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	counts := make(map[string]int64)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+			key, _ := dp.Attributes.Value("quota.key")
+			assert.Equal(t, "name", key.AsString())
+			counts[m.Name] += dp.Value
+		}
+	}
+	assert.Equal(t, map[string]int64{
+		"apm-server.ratelimit.quota.events.accepted": 1,
+		"apm-server.ratelimit.quota.bytes.accepted":  300,
+		"apm-server.ratelimit.quota.events.rejected": 4,
+	}, counts)
+}
+
+func TestAllowQuotas(t *testing.T) {
+	quotas, err := NewQuotas(3, metricnoop.NewMeterProvider())
+	require.NoError(t, err)
+	require.NoError(t, quotas.Add(QuotaAPIKeyID, "id", 1, 0))
+	require.NoError(t, quotas.Add(QuotaServiceName, "service", 1, 0))
+	apiKeyQuota := quotas.Get(QuotaAPIKeyID, "id")
+	serviceQuota := quotas.Get(QuotaServiceName, "service")
+	require.NoError(t, AllowQuotas(context.Background()))
+
+	// Exhaust the service quota's burst.
+	require.NoError(t, serviceQuota.Allow(context.Background(), 3, 0))
+
+	// The service quota is exceeded, so nothing is consumed
+	// from the API Key quota.
+	err = AllowQuotas(context.Background(),
+		QuotaUsage{Quota: apiKeyQuota, Events: 3},
+		QuotaUsage{Quota: serviceQuota, Events: 3},
+	)
+	var quotaErr *QuotaExceededError
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, QuotaServiceName, quotaErr.Kind)
+	assert.Equal(t, "service", quotaErr.Key)
+
+	// The API Key quota's entire burst is still available,
+	// and would otherwise be delayed for longer than a second.
+	require.NoError(t, apiKeyQuota.Allow(context.Background(), 3, 0))
+	err = apiKeyQuota.Allow(context.Background(), 3, 0)
+	require.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, QuotaAPIKeyID, quotaErr.Kind)
+}
+
+func TestFormatRetryAfter(t *testing.T) {
+	assert.Equal(t, "1", FormatRetryAfter(time.Millisecond))
+	assert.Equal(t, "1", FormatRetryAfter(time.Second))
+	assert.Equal(t, "3", FormatRetryAfter(2*time.Second+time.Nanosecond))
+}
@@ -61,6 +61,10 @@ type Context struct {
 	gzipReader                  *gzip.Reader
 	zlibReader                  zlibReadCloseResetter
 
+	// trustedProxies holds the proxies trusted to set forwarded headers.
+	// If empty, forwarded headers are trusted from all clients.
+	trustedProxies netutil.TrustedProxies
+
 	Request        *http.Request
 	Logger         *logp.Logger
 	Authentication auth.AuthenticationDetails
@@ -71,7 +75,7 @@ type Context struct {
 	Timestamp time.Time
 
 	// SourceIP holds the IP address of the originating client, if known,
-	// as recorded in Forwarded, X-Forwarded-For, etc.
+	// as recorded in Forwarded, X-Forwarded-For, etc. by trusted proxies.
 	SourceIP netip.Addr
 
 	// SourcePort holds the port of the originating client, as recorded in
@@ -80,7 +84,7 @@ type Context struct {
 	SourcePort int
 
 	// ClientIP holds the IP address of the originating client, if known,
-	// as recorded in Forwarded, X-Forwarded-For, etc.
+	// as recorded in Forwarded, X-Forwarded-For, etc. by trusted proxies.
 	//
 	// For TCP-based requests this will have the same value as SourceIP.
 	ClientIP netip.Addr
@@ -108,6 +112,12 @@ func NewContext() *Context {
 	return &Context{}
 }
 
+// NewContextWithTrustedProxies creates an empty Context struct which
+// trusts forwarded headers only from the given proxies.
+func NewContextWithTrustedProxies(trustedProxies netutil.TrustedProxies) *Context {
+	return &Context{trustedProxies: trustedProxies}
+}
+
 // Reset allows to reuse a context by removing all request specific information.
 //
 // It is valid to call Reset(nil, nil), which will just clear all information.
@@ -139,6 +149,8 @@ func (c *Context) Reset(w http.ResponseWriter, r *http.Request) {
 		// Reuse gzip and zlib reader buffers.
 		gzipReader: c.gzipReader,
 		zlibReader: c.zlibReader,
+
+		trustedProxies: c.trustedProxies,
 	}
 	c.Result.Reset()
 
@@ -155,7 +167,7 @@ func (c *Context) setRequest(r *http.Request) {
 	ip, port := netutil.SplitAddrPort(r.RemoteAddr)
 	c.SourceIP, c.ClientIP = ip, ip
 	c.SourcePort, c.ClientPort = int(port), int(port)
-	if ip, port := netutil.ClientAddrFromHeaders(r.Header); ip.IsValid() {
+	if ip, port := c.trustedProxies.ClientAddrFromHeaders(r.Header, ip); ip.IsValid() {
 		c.SourceNATIP = c.ClientIP
 		c.SourceIP, c.ClientIP = ip, ip
 		c.SourcePort, c.ClientPort = int(port), int(port)
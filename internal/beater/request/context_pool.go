@@ -20,6 +20,8 @@ package request
 import (
 	"net/http"
 	"sync"
+
+	"github.com/elastic/apm-server/internal/netutil"
 )
 
 // ContextPool provides a pool of Context objects, and a
@@ -28,11 +30,12 @@ type ContextPool struct {
 	p sync.Pool
 }
 
-// NewContextPool returns a new ContextPool.
-func NewContextPool() *ContextPool {
+// NewContextPool returns a new ContextPool. Contexts trust forwarded
+// headers only from trustedProxies, or from all clients if it is empty.
+func NewContextPool(trustedProxies netutil.TrustedProxies) *ContextPool {
 	pool := ContextPool{}
 	pool.p.New = func() interface{} {
-		return NewContext()
+		return NewContextWithTrustedProxies(trustedProxies)
 	}
 	return &pool
 }
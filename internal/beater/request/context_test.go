@@ -40,6 +40,7 @@ import (
 
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/netutil"
 )
 
 func TestContext_Reset(t *testing.T) {
@@ -214,6 +215,50 @@ func BenchmarkContextReset(b *testing.B) {
 	}
 }
 
+func TestContextTrustedProxies(t *testing.T) {
+	trustedProxies, err := netutil.ParseTrustedProxies([]string{"10.0.0.0/8"}, "")
+	require.NoError(t, err)
+	c := NewContextWithTrustedProxies(trustedProxies)
+
+	reset := func(remoteAddr, xff string) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-Forwarded-For", xff)
+		c.Reset(httptest.NewRecorder(), r)
+	}
+
+	// Forwarded headers are ignored for untrusted peers.
+	reset("1.2.3.4:1234", "5.6.7.8")
+	assert.Equal(t, netip.MustParseAddr("1.2.3.4"), c.ClientIP)
+	assert.Equal(t, 1234, c.ClientPort)
+	assert.False(t, c.SourceNATIP.IsValid())
+
+	// Trusted hops are skipped, and spoofed values to the left are ignored.
+	// The trusted proxies are retained across resets.
+	reset("10.1.2.3:1234", "6.6.6.6, 5.6.7.8, 10.0.0.1")
+	assert.Equal(t, netip.MustParseAddr("5.6.7.8"), c.ClientIP)
+	assert.Equal(t, netip.MustParseAddr("5.6.7.8"), c.SourceIP)
+	assert.Equal(t, 0, c.ClientPort)
+	assert.Equal(t, netip.MustParseAddr("10.1.2.3"), c.SourceNATIP)
+
+	// A client-forged X-Real-IP header forwarded by a trusted proxy,
+	// which conflicts with X-Forwarded-For, is not used.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.1.2.3:1234"
+	r.Header.Set("X-Real-IP", "6.6.6.6")
+	r.Header.Set("X-Forwarded-For", "5.6.7.8")
+	c.Reset(httptest.NewRecorder(), r)
+	assert.Equal(t, netip.MustParseAddr("10.1.2.3"), c.ClientIP)
+	assert.False(t, c.SourceNATIP.IsValid())
+
+	// When the header set by the proxies is configured, other headers are ignored.
+	trustedProxies, err = netutil.ParseTrustedProxies([]string{"10.0.0.0/8"}, "X-Forwarded-For")
+	require.NoError(t, err)
+	c = NewContextWithTrustedProxies(trustedProxies)
+	c.Reset(httptest.NewRecorder(), r)
+	assert.Equal(t, netip.MustParseAddr("5.6.7.8"), c.ClientIP)
+}
+
 func TestContextResetContentEncoding(t *testing.T) {
 	test := func(
 		name string,
@@ -50,6 +50,8 @@ const (
 
 	// IDResponseErrorsForbidden identifies responses for forbidden requests
 	IDResponseErrorsForbidden ResultID = "response.errors.forbidden"
+	// IDResponseErrorsIPDenied identifies responses for requests from client IPs denied by an IP filter
+	IDResponseErrorsIPDenied ResultID = "response.errors.ipdenied"
 	// IDResponseErrorsUnauthorized identifies responses for unauthorized requests
 	IDResponseErrorsUnauthorized ResultID = "response.errors.unauthorized"
 	// IDResponseErrorsNotFound identifies responses where route was not found
@@ -70,6 +72,8 @@ const (
 	IDResponseErrorsMethodNotAllowed ResultID = "response.errors.method"
 	// IDResponseErrorsFullQueue identifies responses when internal queue was full
 	IDResponseErrorsFullQueue ResultID = "response.errors.queue"
+	// IDResponseErrorsOverloaded identifies responses for requests shed because the server is overloaded
+	IDResponseErrorsOverloaded ResultID = "response.errors.overloaded"
 	// IDResponseErrorsShuttingDown identifies responses requests occuring after channel was closed
 	IDResponseErrorsShuttingDown ResultID = "response.errors.closed"
 	// IDResponseErrorsServiceUnavailable identifies responses where service was unavailable
@@ -85,6 +89,7 @@ var (
 		IDResponseValidAccepted:            {Code: http.StatusAccepted, Keyword: "request accepted"},
 		IDResponseValidNotModified:         {Code: http.StatusNotModified, Keyword: "not modified"},
 		IDResponseErrorsForbidden:          {Code: http.StatusForbidden, Keyword: "forbidden request"},
+		IDResponseErrorsIPDenied:           {Code: http.StatusForbidden, Keyword: "client IP not allowed"},
 		IDResponseErrorsUnauthorized:       {Code: http.StatusUnauthorized, Keyword: "unauthorized"},
 		IDResponseErrorsNotFound:           {Code: http.StatusNotFound, Keyword: "404 page not found"},
 		IDResponseErrorsRequestTooLarge:    {Code: http.StatusRequestEntityTooLarge, Keyword: "request body too large"},
@@ -95,6 +100,7 @@ var (
 		IDResponseErrorsRateLimit:          {Code: http.StatusTooManyRequests, Keyword: "too many requests"},
 		IDResponseErrorsTimeout:            {Code: http.StatusServiceUnavailable, Keyword: "request timed out"},
 		IDResponseErrorsFullQueue:          {Code: http.StatusServiceUnavailable, Keyword: "queue is full"},
+		IDResponseErrorsOverloaded:         {Code: http.StatusServiceUnavailable, Keyword: "server overloaded"},
 		IDResponseErrorsShuttingDown:       {Code: http.StatusServiceUnavailable, Keyword: "server is shutting down"},
 		IDResponseErrorsServiceUnavailable: {Code: http.StatusServiceUnavailable, Keyword: "service unavailable"},
 		IDResponseErrorsInternal:           {Code: http.StatusInternalServerError, Keyword: "internal error"},
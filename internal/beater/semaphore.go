@@ -0,0 +1,100 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beater
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// resizableSemaphore is an input.Semaphore whose size may be changed while
+// it is in use. It is implemented with a semaphore of the maximum size, of
+// which the difference between the maximum and current size is held in
+// reserve.
+//
+// Shrinking never blocks: if the reserve cannot be taken from the semaphore
+// immediately, it is recorded as debt which is paid down by Release, so
+// Acquire callers are not queued behind a resize.
+type resizableSemaphore struct {
+	sem *semaphore.Weighted
+	max int64
+
+	mu   sync.Mutex
+	size int64
+	debt int64
+}
+
+// newResizableSemaphore returns a new resizableSemaphore with the given
+// size, which may be resized up to max.
+func newResizableSemaphore(size, max int64) *resizableSemaphore {
+	s := &resizableSemaphore{
+		sem:  semaphore.NewWeighted(max),
+		max:  max,
+		size: max,
+	}
+	s.resize(size)
+	return s
+}
+
+// Acquire acquires the semaphore with a weight of n, blocking until
+// resources are available or ctx is done.
+func (s *resizableSemaphore) Acquire(ctx context.Context, n int64) error {
+	return s.sem.Acquire(ctx, n)
+}
+
+// TryAcquire acquires the semaphore with a weight of n without blocking.
+func (s *resizableSemaphore) TryAcquire(n int64) bool {
+	return s.sem.TryAcquire(n)
+}
+
+// Release releases the semaphore with a weight of n, first paying down
+// any debt left by shrinking the semaphore.
+func (s *resizableSemaphore) Release(n int64) {
+	s.mu.Lock()
+	paid := min(n, s.debt)
+	s.debt -= paid
+	s.mu.Unlock()
+	if n -= paid; n > 0 {
+		s.sem.Release(n)
+	}
+}
+
+// resize changes the size of the semaphore, clamped to the range [1, max].
+// When shrinking, the semaphore may remain over-subscribed until enough of
+// it has been released by its holders.
+func (s *resizableSemaphore) resize(size int64) {
+	size = min(max(size, 1), s.max)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case size > s.size:
+		grow := size - s.size
+		forgiven := min(grow, s.debt)
+		s.debt -= forgiven
+		if grow -= forgiven; grow > 0 {
+			s.sem.Release(grow)
+		}
+	case size < s.size:
+		if shrink := s.size - size; !s.sem.TryAcquire(shrink) {
+			s.debt += shrink
+		}
+	}
+	s.size = size
+}
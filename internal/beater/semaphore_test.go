@@ -0,0 +1,89 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizableSemaphore(t *testing.T) {
+	sem := newResizableSemaphore(2, 4)
+	assert.True(t, sem.TryAcquire(2))
+	assert.False(t, sem.TryAcquire(1))
+
+	// Growing the semaphore unblocks waiters immediately.
+	sem.resize(3)
+	assert.True(t, sem.TryAcquire(1))
+	assert.False(t, sem.TryAcquire(1))
+
+	// Shrinking the semaphore does not block, and takes effect as
+	// holders release.
+	sem.resize(1)
+	sem.Release(2)
+	assert.False(t, sem.TryAcquire(1))
+	sem.Release(1)
+	assert.True(t, sem.TryAcquire(1))
+	assert.False(t, sem.TryAcquire(1))
+	sem.Release(1)
+
+	// Growing the semaphore forgives debt before releasing capacity.
+	require.True(t, sem.TryAcquire(1))
+	sem.resize(4)
+	require.True(t, sem.TryAcquire(3))
+	sem.resize(2) // 2 in debt
+	sem.resize(3) // 1 in debt
+	sem.Release(2)
+	assert.True(t, sem.TryAcquire(1))
+	assert.False(t, sem.TryAcquire(1))
+	sem.Release(3)
+	assert.True(t, sem.TryAcquire(3))
+	assert.False(t, sem.TryAcquire(1))
+	sem.Release(3)
+
+	// Sizes are clamped to [1, max].
+	sem = newResizableSemaphore(0, 4)
+	assert.True(t, sem.TryAcquire(1))
+	assert.False(t, sem.TryAcquire(1))
+	sem.resize(100)
+	assert.True(t, sem.TryAcquire(3))
+	assert.False(t, sem.TryAcquire(1))
+}
+
+func TestResizableSemaphoreShrinkDoesNotBlockAcquire(t *testing.T) {
+	sem := newResizableSemaphore(4, 4)
+	require.True(t, sem.TryAcquire(3))
+
+	// The shrink cannot take 2 from the semaphore while 3 of 4 are held,
+	// so it is left pending. This must not queue Acquire callers behind it.
+	sem.resize(2)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, sem.Acquire(ctx, 1))
+
+	// Releases pay down the pending shrink before freeing capacity.
+	sem.Release(2)
+	assert.False(t, sem.TryAcquire(1))
+	sem.Release(2)
+	assert.True(t, sem.TryAcquire(2))
+	assert.False(t, sem.TryAcquire(1))
+}
@@ -39,8 +39,10 @@ import (
 
 	"github.com/elastic/apm-server/internal/agentcfg"
 	"github.com/elastic/apm-server/internal/beater/api"
+	"github.com/elastic/apm-server/internal/beater/audit"
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/loadshed"
 	"github.com/elastic/apm-server/internal/beater/otlp"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/elasticsearch"
@@ -91,6 +93,14 @@ type ServerParams struct {
 	// actions on resources.
 	Authenticator *auth.Authenticator
 
+	// Auditor holds an audit.Auditor for recording the authentication
+	// audit trail, or nil if auditing is disabled.
+	Auditor *audit.Auditor
+
+	// LoadShedding holds a loadshed.Controller for shedding intake
+	// requests when overloaded, or nil if load shedding is disabled.
+	LoadShedding *loadshed.Controller
+
 	// RateLimitStore holds an IP-based rate-limiter LRU cache.
 	RateLimitStore *ratelimit.Store
 
@@ -98,6 +108,11 @@ type ServerParams struct {
 	// mapping is disabled.
 	SourcemapFetcher sourcemap.Fetcher
 
+	// AdminHandlers holds HTTP handlers for administrative APIs, keyed by
+	// route pattern. Clients must be authenticated and authorized for
+	// auth.ActionAdmin to use these APIs.
+	AdminHandlers map[string]http.Handler
+
 	// AgentConfig holds an interface for fetching agent configuration.
 	AgentConfig agentcfg.Fetcher
 
@@ -145,6 +160,18 @@ type ServerParams struct {
 
 	// StatusReporter holds the status reporter
 	StatusReporter status.StatusReporter
+
+	// UpdateSamplingPolicies, if non-nil, is called with the updated
+	// tail-sampling configuration when the server's configuration is
+	// changed only in its tail-sampling policies, to update them without
+	// restarting the server. It may be set by a WrapServerFunc.
+	UpdateSamplingPolicies func(config.TailSamplingConfig) error
+
+	// ResizeAggregation, if non-nil, is called with updated aggregation
+	// limits when the memory limit changes and memory autotuning is enabled,
+	// if any aggregation limits are sized automatically. It may be set by a
+	// WrapServerFunc.
+	ResizeAggregation func(context.Context, config.AggregationConfig) error
 }
 
 // newBaseRunServer returns the base RunServerFunc.
@@ -181,9 +208,12 @@ func newServer(args ServerParams, listener net.Listener) (server, error) {
 		args.Config,
 		args.BatchProcessor,
 		args.Authenticator,
+		args.Auditor,
+		args.LoadShedding,
 		args.AgentConfig,
 		args.RateLimitStore,
 		args.SourcemapFetcher,
+		args.AdminHandlers,
 		publishReady,
 		args.Semaphore,
 		args.MeterProvider,
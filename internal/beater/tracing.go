@@ -42,7 +42,7 @@ func newTracerServer(cfg *config.Config, listener net.Listener, logger *logp.Log
 	if err != nil {
 		return nil, err
 	}
-	authenticator, err := auth.NewAuthenticator(config.AgentAuth{}, nooptrace.NewTracerProvider(), logger)
+	authenticator, err := auth.NewAuthenticator(config.AgentAuth{}, nooptrace.NewTracerProvider(), noopmetric.NewMeterProvider(), logger)
 	if err != nil {
 		return nil, err
 	}
@@ -50,9 +50,12 @@ func newTracerServer(cfg *config.Config, listener net.Listener, logger *logp.Log
 		cfg,
 		batchProcessor,
 		authenticator,
+		nil, // no audit trail for self-instrumentation
+		nil, // no load shedding for self-instrumentation
 		agentcfg.NewEmptyFetcher(),
 		ratelimitStore,
 		nil,                         // no sourcemap store
+		nil,                         // no admin APIs
 		func() bool { return true }, // ready for publishing
 		semaphore,
 		noopmetric.NewMeterProvider(),
@@ -0,0 +1,159 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package modelcel provides compilation and evaluation of Common Expression
+// Language (CEL) expressions over modelpb.APMEvent.
+//
+// Expressions refer to the event being evaluated with the variable "event",
+// whose fields are named as in the protobuf definition, e.g.
+// `event.service.name` or `event.span.type`. Unset message fields evaluate
+// to their zero value, so `event.span.type == 'cache'` is false for events
+// which are not spans. In addition to the standard CEL functions and the
+// string extensions, `duration(uint)` converts nanoseconds to a duration,
+// e.g. `duration(event.event.duration) < duration('1ms')`.
+package modelcel
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/ext"
+	"github.com/google/cel-go/interpreter"
+
+	"github.com/elastic/apm-data/model/modelpb"
+)
+
+// Program is a compiled expression, which may be evaluated concurrently.
+type Program struct {
+	program cel.Program
+}
+
+var newEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Types(&modelpb.APMEvent{}),
+		cel.Variable("event", cel.ObjectType(string((&modelpb.APMEvent{}).ProtoReflect().Descriptor().FullName()))),
+		ext.Strings(),
+		cel.Function("duration",
+			cel.Overload("uint_to_duration",
+				[]*cel.Type{cel.UintType}, cel.DurationType,
+				cel.UnaryBinding(func(value ref.Val) ref.Val {
+					return types.Duration{Duration: time.Duration(value.(types.Uint))}
+				}),
+			),
+		),
+	)
+})
+
+// CompileCondition compiles and type-checks expr,
+// which must evaluate to a bool.
+func CompileCondition(expr string) (*Program, error) {
+	return compile(expr, cel.BoolType)
+}
+
+// CompileLabel compiles and type-checks expr, which must evaluate to a
+// string for a label value, or to a number for a numeric label value.
+func CompileLabel(expr string) (*Program, error) {
+	return compile(expr, cel.StringType, cel.DoubleType, cel.IntType, cel.UintType)
+}
+
+func compile(expr string, outputTypes ...*cel.Type) (*Program, error) {
+	env, err := newEnv()
+	if err != nil {
+		return nil, fmt.Errorf("error creating CEL environment: %w", err)
+	}
+	ast, issues := env.Compile(expr)
+	if err := issues.Err(); err != nil {
+		return nil, err
+	}
+	outputType := ast.OutputType()
+	var valid bool
+	typeNames := make([]string, len(outputTypes))
+	for i, t := range outputTypes {
+		valid = valid || outputType.IsExactType(t)
+		typeNames[i] = t.String()
+	}
+	if !valid {
+		expected := typeNames[0]
+		if len(typeNames) > 1 {
+			expected = "one of " + strings.Join(typeNames, ", ")
+		}
+		return nil, fmt.Errorf("expression must evaluate to %s, got %s", expected, outputType)
+	}
+	program, err := env.Program(ast, cel.EvalOptions(cel.OptOptimize))
+	if err != nil {
+		return nil, err
+	}
+	return &Program{program: program}, nil
+}
+
+// EvalCondition evaluates a program compiled with CompileCondition.
+func (p *Program) EvalCondition(event *modelpb.APMEvent) (bool, error) {
+	out, err := p.eval(event)
+	if err != nil {
+		return false, err
+	}
+	return out == types.True, nil
+}
+
+// EvalLabel evaluates a program compiled with CompileLabel, returning either
+// a string label value, or a numeric label value and numeric=true.
+func (p *Program) EvalLabel(event *modelpb.APMEvent) (value string, numericValue float64, numeric bool, err error) {
+	out, err := p.eval(event)
+	if err != nil {
+		return "", 0, false, err
+	}
+	switch out := out.(type) {
+	case types.String:
+		return string(out), 0, false, nil
+	case types.Double:
+		return "", float64(out), true, nil
+	case types.Int:
+		return "", float64(out), true, nil
+	case types.Uint:
+		return "", float64(out), true, nil
+	}
+	return "", 0, false, fmt.Errorf("unexpected label value type %s", out.Type())
+}
+
+func (p *Program) eval(event *modelpb.APMEvent) (ref.Val, error) {
+	out, _, err := p.program.Eval(activation{event: event})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// activation binds the "event" variable without allocating a map.
+type activation struct {
+	event *modelpb.APMEvent
+}
+
+func (a activation) ResolveName(name string) (any, bool) {
+	if name == "event" {
+		return a.event, true
+	}
+	return nil, false
+}
+
+func (a activation) Parent() interpreter.Activation {
+	return nil
+}
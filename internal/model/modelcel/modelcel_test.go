@@ -0,0 +1,107 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelcel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-data/model/modelpb"
+)
+
+func TestCompileCondition(t *testing.T) {
+	program, err := CompileCondition(`duration(event.event.duration) < duration('1ms') && event.span.type == 'cache'`)
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		event  *modelpb.APMEvent
+		expect bool
+	}{{
+		event:  &modelpb.APMEvent{Event: &modelpb.Event{Duration: 100}, Span: &modelpb.Span{Type: "cache"}},
+		expect: true,
+	}, {
+		event:  &modelpb.APMEvent{Event: &modelpb.Event{Duration: 2000000}, Span: &modelpb.Span{Type: "cache"}},
+		expect: false,
+	}, {
+		event:  &modelpb.APMEvent{Event: &modelpb.Event{Duration: 100}, Span: &modelpb.Span{Type: "db"}},
+		expect: false,
+	}, {
+		// Unset fields evaluate to their zero value.
+		event:  &modelpb.APMEvent{Transaction: &modelpb.Transaction{}},
+		expect: false,
+	}} {
+		match, err := program.EvalCondition(test.event)
+		require.NoError(t, err)
+		assert.Equal(t, test.expect, match)
+	}
+}
+
+func TestCompileConditionErrors(t *testing.T) {
+	_, err := CompileCondition(`event.service.name`)
+	assert.EqualError(t, err, "expression must evaluate to bool, got string")
+
+	_, err = CompileCondition(`event.service.nmae == 'x'`)
+	assert.ErrorContains(t, err, "undefined field 'nmae'")
+
+	_, err = CompileCondition(`event.service.name ==`)
+	assert.ErrorContains(t, err, "Syntax error")
+}
+
+func TestEvalConditionError(t *testing.T) {
+	program, err := CompileCondition(`event.labels['tier'].value == 'gold'`)
+	require.NoError(t, err)
+	_, err = program.EvalCondition(&modelpb.APMEvent{})
+	assert.EqualError(t, err, "no such key: tier")
+
+	match, err := program.EvalCondition(&modelpb.APMEvent{
+		Labels: map[string]*modelpb.LabelValue{"tier": {Value: "gold"}},
+	})
+	require.NoError(t, err)
+	assert.True(t, match)
+}
+
+func TestCompileLabel(t *testing.T) {
+	event := &modelpb.APMEvent{
+		Service: &modelpb.Service{Name: "prod-api"},
+		Event:   &modelpb.Event{Duration: 1500000},
+	}
+	for _, test := range []struct {
+		expr    string
+		value   string
+		numeric float64
+	}{
+		{expr: `event.service.name.startsWith('prod') ? 'gold' : 'silver'`, value: "gold"},
+		{expr: `event.service.name.upperAscii()`, value: "PROD-API"},
+		{expr: `double(event.event.duration) / 1e6`, numeric: 1.5},
+		{expr: `event.event.duration`, numeric: 1500000},
+		{expr: `size(event.service.name)`, numeric: 8},
+	} {
+		program, err := CompileLabel(test.expr)
+		require.NoError(t, err, test.expr)
+		value, numericValue, numeric, err := program.EvalLabel(event)
+		require.NoError(t, err, test.expr)
+		assert.Equal(t, test.value, value, test.expr)
+		assert.Equal(t, test.numeric, numericValue, test.expr)
+		assert.Equal(t, test.value == "", numeric, test.expr)
+	}
+
+	_, err := CompileLabel(`event.service.name == 'x'`)
+	assert.EqualError(t, err, "expression must evaluate to one of string, double, int, uint, got bool")
+}
@@ -0,0 +1,107 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+
+	"github.com/elastic/apm-data/model/modelpb"
+)
+
+// normalizedStatementLabel is the label in which the normalized
+// statement of a database span is recorded.
+const normalizedStatementLabel = "db_statement_normalized"
+
+// DBStatementObfuscator is a modelpb.BatchProcessor which replaces literal
+// values in span.db.statement with placeholders, so that statements do not
+// contain customer data and may be grouped.
+//
+// Statements are parsed according to span.db.type: "mongodb" and
+// "elasticsearch" statements are parsed as JSON query DSL, "redis"
+// statements as commands, and all others as SQL. The SQL dialect is taken
+// from span.subtype: brackets quote identifiers only for "mssql" and
+// "sqlserver", and backslashes are escapes only for "mysql" and "mariadb",
+// and in PostgreSQL escape strings such as E'\n'.
+//
+// The normalized statement is also recorded in the label
+// `db_statement_normalized`, for grouping statements consistently
+// across services whose original statements are kept.
+type DBStatementObfuscator struct {
+	services     map[string]bool
+	keepOriginal map[string]bool
+}
+
+// DBStatementObfuscatorConfig holds configuration for DBStatementObfuscator.
+type DBStatementObfuscatorConfig struct {
+	// ServiceName holds the names of services whose statements are
+	// obfuscated. If empty, statements of all services are obfuscated.
+	ServiceName []string
+
+	// KeepOriginalServiceName holds the names of services whose original
+	// statements are kept. The normalized statement is still recorded.
+	KeepOriginalServiceName []string
+}
+
+// NewDBStatementObfuscator returns a DBStatementObfuscator
+// for the given configuration.
+func NewDBStatementObfuscator(cfg DBStatementObfuscatorConfig) *DBStatementObfuscator {
+	o := &DBStatementObfuscator{keepOriginal: make(map[string]bool)}
+	if len(cfg.ServiceName) != 0 {
+		o.services = make(map[string]bool, len(cfg.ServiceName))
+		for _, name := range cfg.ServiceName {
+			o.services[name] = true
+		}
+	}
+	for _, name := range cfg.KeepOriginalServiceName {
+		o.keepOriginal[name] = true
+	}
+	return o
+}
+
+// ProcessBatch obfuscates the statements of database spans in b.
+func (o *DBStatementObfuscator) ProcessBatch(ctx context.Context, b *modelpb.Batch) error {
+	for _, event := range *b {
+		db := event.GetSpan().GetDb()
+		if db.GetStatement() == "" {
+			continue
+		}
+		serviceName := event.GetService().GetName()
+		if o.services != nil && !o.services[serviceName] {
+			continue
+		}
+		normalized := obfuscateStatement(db.Type, event.GetSpan().GetSubtype(), db.Statement)
+		if event.Labels == nil {
+			event.Labels = make(map[string]*modelpb.LabelValue)
+		}
+		event.Labels[normalizedStatementLabel] = &modelpb.LabelValue{Value: normalized}
+		if !o.keepOriginal[serviceName] {
+			db.Statement = normalized
+		}
+	}
+	return nil
+}
+
+func obfuscateStatement(dbType, subtype, statement string) string {
+	switch dbType {
+	case "mongodb", "elasticsearch":
+		return obfuscateJSON(statement)
+	case "redis":
+		return obfuscateRedis(statement)
+	}
+	return obfuscateSQL(statement, sqlDialectFor(subtype))
+}
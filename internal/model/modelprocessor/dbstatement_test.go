@@ -0,0 +1,151 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestDBStatementObfuscatorStatements(t *testing.T) {
+	for _, test := range []struct {
+		dbType    string
+		subtype   string
+		statement string
+		expect    string
+	}{{
+		dbType:    "sql",
+		statement: "SELECT * FROM users WHERE name = 'O''Brien' AND age > 42",
+		expect:    "SELECT * FROM users WHERE name = ? AND age > ?",
+	}, {
+		dbType:    "sql",
+		statement: "SELECT u.id, \"Col 1\" FROM `users` u WHERE u.id IN (1, 2, 3.5, -4) -- trailing\n  AND u.balance > .5",
+		expect:    "SELECT u.id, \"Col 1\" FROM `users` u WHERE u.id IN (?) AND u.balance > ?",
+	}, {
+		dbType:    "sql",
+		statement: "/* app=checkout */ INSERT INTO t2 (a, b) VALUES ($1, 0x1F)",
+		expect:    "INSERT INTO t2 (a, b) VALUES ($1, ?)",
+	}, {
+		dbType:    "sql",
+		subtype:   "mssql",
+		statement: "UPDATE [dbo].[users] SET email = 'a@b.c' WHERE id = :id",
+		expect:    "UPDATE [dbo].[users] SET email = ? WHERE id = :id",
+	}, {
+		// Brackets only quote identifiers in SQL Server.
+		dbType:    "sql",
+		subtype:   "postgresql",
+		statement: "SELECT * FROM t WHERE tags[1] = 'x' AND ARRAY[secret, 42] @> b",
+		expect:    "SELECT * FROM t WHERE tags[?] = ? AND ARRAY[secret, ?] @> b",
+	}, {
+		// Backslashes are not escapes in standard SQL strings.
+		dbType:    "sql",
+		subtype:   "postgresql",
+		statement: `SELECT * FROM t WHERE a = 'C:\' AND b = 'secret'`,
+		expect:    "SELECT * FROM t WHERE a = ? AND b = ?",
+	}, {
+		dbType:    "sql",
+		subtype:   "postgresql",
+		statement: `SELECT * FROM t WHERE a = E'it\'s' AND b = 'secret'`,
+		expect:    "SELECT * FROM t WHERE a = ? AND b = ?",
+	}, {
+		dbType:    "sql",
+		subtype:   "mysql",
+		statement: `SELECT * FROM t WHERE a = 'it\'s' AND b = 'secret'`,
+		expect:    "SELECT * FROM t WHERE a = ? AND b = ?",
+	}, {
+		// Double quotes delimit strings in MySQL and MariaDB.
+		dbType:    "sql",
+		subtype:   "mysql",
+		statement: `SELECT * FROM t WHERE email = "a@b.com" AND name = "it\"s" AND ` + "`Col 1` = 1",
+		expect:    "SELECT * FROM t WHERE email = ? AND name = ? AND `Col 1` = ?",
+	}, {
+		dbType:    "sql",
+		subtype:   "mariadb",
+		statement: `SELECT * FROM t WHERE email = "a@b.com"`,
+		expect:    "SELECT * FROM t WHERE email = ?",
+	}, {
+		// Double quotes quote identifiers in PostgreSQL.
+		dbType:    "sql",
+		subtype:   "postgresql",
+		statement: `SELECT "Email" FROM t WHERE "Email" = 'a@b.com'`,
+		expect:    `SELECT "Email" FROM t WHERE "Email" = ?`,
+	}, {
+		dbType:    "cassandra",
+		statement: "SELECT * FROM ks.t WHERE k = 'secret'",
+		expect:    "SELECT * FROM ks.t WHERE k = ?",
+	}, {
+		dbType:    "mongodb",
+		statement: `db.users.find({"email": "alice@example.com", "age": {"$gt": 21}, active: true, tags: ["a", "b"]})`,
+		expect:    `db.users.find({"email": ?, "age": {"$gt": ?}, active: ?, tags: [?]})`,
+	}, {
+		dbType:    "elasticsearch",
+		statement: `{"query": {"term": {"user.id": {"value": "kimchy"}}}, "size": -1}`,
+		expect:    `{"query": {"term": {"user.id": {"value": ?}}}, "size": ?}`,
+	}, {
+		dbType:    "redis",
+		statement: "SET session:123 secret-value EX 60\nGET session:123\nPING",
+		expect:    "SET ? ? ? ?\nGET ?\nPING",
+	}} {
+		t.Run(test.dbType+"/"+test.subtype, func(t *testing.T) {
+			obfuscator := modelprocessor.NewDBStatementObfuscator(modelprocessor.DBStatementObfuscatorConfig{})
+			batch := modelpb.Batch{{
+				Span: &modelpb.Span{
+					Type:    "db",
+					Subtype: test.subtype,
+					Db:      &modelpb.DB{Type: test.dbType, Statement: test.statement},
+				},
+			}}
+			require.NoError(t, obfuscator.ProcessBatch(context.Background(), &batch))
+			assert.Equal(t, test.expect, batch[0].Span.Db.Statement)
+			assert.Equal(t, test.expect, batch[0].Labels["db_statement_normalized"].GetValue())
+		})
+	}
+}
+
+func TestDBStatementObfuscatorServices(t *testing.T) {
+	obfuscator := modelprocessor.NewDBStatementObfuscator(modelprocessor.DBStatementObfuscatorConfig{
+		ServiceName:             []string{"opbeans", "checkout"},
+		KeepOriginalServiceName: []string{"checkout"},
+	})
+	span := func(service string) *modelpb.APMEvent {
+		return &modelpb.APMEvent{
+			Service: &modelpb.Service{Name: service},
+			Span:    &modelpb.Span{Type: "db", Db: &modelpb.DB{Type: "sql", Statement: "SELECT 1"}},
+		}
+	}
+	withStatement := func(event *modelpb.APMEvent, statement string) *modelpb.APMEvent {
+		event.Span.Db.Statement = statement
+		event.Labels = map[string]*modelpb.LabelValue{"db_statement_normalized": {Value: "SELECT ?"}}
+		return event
+	}
+	batch := modelpb.Batch{span("opbeans"), span("checkout"), span("other")}
+	require.NoError(t, obfuscator.ProcessBatch(context.Background(), &batch))
+	assert.Empty(t, cmp.Diff(modelpb.Batch{
+		withStatement(span("opbeans"), "SELECT ?"),
+		withStatement(span("checkout"), "SELECT 1"),
+		span("other"),
+	}, batch, protocmp.Transform()))
+}
@@ -0,0 +1,166 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/apm-data/model/modelpb"
+)
+
+// eventField is a string field of an event which may be read,
+// set, or removed by name. Setting a field to "" removes it.
+type eventField struct {
+	get func(*modelpb.APMEvent) string
+	set func(*modelpb.APMEvent, string)
+
+	// label holds the label key for "labels.<key>" fields.
+	label string
+}
+
+// eventFields holds the fields which may be referenced by event
+// processors, other than labels.
+var eventFields = map[string]eventField{
+	"message": {
+		get: func(e *modelpb.APMEvent) string { return e.Message },
+		set: func(e *modelpb.APMEvent, v string) { e.Message = v },
+	},
+	"url.original": nestedField(func(e *modelpb.APMEvent) **modelpb.URL { return &e.Url }, func(u *modelpb.URL) *string { return &u.Original }),
+	"url.full":     nestedField(func(e *modelpb.APMEvent) **modelpb.URL { return &e.Url }, func(u *modelpb.URL) *string { return &u.Full }),
+	"url.domain":   nestedField(func(e *modelpb.APMEvent) **modelpb.URL { return &e.Url }, func(u *modelpb.URL) *string { return &u.Domain }),
+	"url.path":     nestedField(func(e *modelpb.APMEvent) **modelpb.URL { return &e.Url }, func(u *modelpb.URL) *string { return &u.Path }),
+	"url.query":    nestedField(func(e *modelpb.APMEvent) **modelpb.URL { return &e.Url }, func(u *modelpb.URL) *string { return &u.Query }),
+	"url.fragment": nestedField(func(e *modelpb.APMEvent) **modelpb.URL { return &e.Url }, func(u *modelpb.URL) *string { return &u.Fragment }),
+
+	"user.id":     nestedField(func(e *modelpb.APMEvent) **modelpb.User { return &e.User }, func(u *modelpb.User) *string { return &u.Id }),
+	"user.name":   nestedField(func(e *modelpb.APMEvent) **modelpb.User { return &e.User }, func(u *modelpb.User) *string { return &u.Name }),
+	"user.email":  nestedField(func(e *modelpb.APMEvent) **modelpb.User { return &e.User }, func(u *modelpb.User) *string { return &u.Email }),
+	"user.domain": nestedField(func(e *modelpb.APMEvent) **modelpb.User { return &e.User }, func(u *modelpb.User) *string { return &u.Domain }),
+
+	"user_agent.original": nestedField(func(e *modelpb.APMEvent) **modelpb.UserAgent { return &e.UserAgent }, func(u *modelpb.UserAgent) *string { return &u.Original }),
+	"user_agent.name":     nestedField(func(e *modelpb.APMEvent) **modelpb.UserAgent { return &e.UserAgent }, func(u *modelpb.UserAgent) *string { return &u.Name }),
+
+	"service.environment": nestedField(func(e *modelpb.APMEvent) **modelpb.Service { return &e.Service }, func(s *modelpb.Service) *string { return &s.Environment }),
+	"service.version":     nestedField(func(e *modelpb.APMEvent) **modelpb.Service { return &e.Service }, func(s *modelpb.Service) *string { return &s.Version }),
+
+	"transaction.name": existingField(func(e *modelpb.APMEvent) *string {
+		if e.Transaction == nil {
+			return nil
+		}
+		return &e.Transaction.Name
+	}),
+	"span.name": existingField(func(e *modelpb.APMEvent) *string {
+		if e.Span == nil {
+			return nil
+		}
+		return &e.Span.Name
+	}),
+	"error.message": existingField(func(e *modelpb.APMEvent) *string {
+		if e.Error == nil {
+			return nil
+		}
+		return &e.Error.Message
+	}),
+	"error.exception.message": existingField(func(e *modelpb.APMEvent) *string {
+		if e.Error.GetException() == nil {
+			return nil
+		}
+		return &e.Error.Exception.Message
+	}),
+	"error.log.message": existingField(func(e *modelpb.APMEvent) *string {
+		if e.Error.GetLog() == nil {
+			return nil
+		}
+		return &e.Error.Log.Message
+	}),
+}
+
+// nestedField returns an eventField for a field of a message, which is
+// created when setting the field if it does not already exist.
+func nestedField[T any](parent func(*modelpb.APMEvent) **T, field func(*T) *string) eventField {
+	return eventField{
+		get: func(e *modelpb.APMEvent) string {
+			if p := *parent(e); p != nil {
+				return *field(p)
+			}
+			return ""
+		},
+		set: func(e *modelpb.APMEvent, v string) {
+			p := parent(e)
+			if *p == nil {
+				if v == "" {
+					return
+				}
+				*p = new(T)
+			}
+			*field(*p) = v
+		},
+	}
+}
+
+// existingField returns an eventField for a field of a message which is
+// only set if the message exists, such as fields specific to an event type.
+// The field function returns nil if the message does not exist.
+func existingField(field func(*modelpb.APMEvent) *string) eventField {
+	return eventField{
+		get: func(e *modelpb.APMEvent) string {
+			if f := field(e); f != nil {
+				return *f
+			}
+			return ""
+		},
+		set: func(e *modelpb.APMEvent, v string) {
+			if f := field(e); f != nil {
+				*f = v
+			}
+		},
+	}
+}
+
+// labelField returns an eventField for the label with the given key.
+func labelField(key string) eventField {
+	return eventField{
+		label: key,
+		get: func(e *modelpb.APMEvent) string {
+			return e.Labels[key].GetValue()
+		},
+		set: func(e *modelpb.APMEvent, v string) {
+			if v == "" {
+				delete(e.Labels, key)
+				return
+			}
+			if e.Labels == nil {
+				e.Labels = make(map[string]*modelpb.LabelValue)
+			}
+			e.Labels[key] = &modelpb.LabelValue{Value: v}
+		},
+	}
+}
+
+// parseEventField returns the eventField with the given name,
+// which may be "labels.<key>" or one of the names in eventFields.
+func parseEventField(name string) (eventField, error) {
+	if key, ok := strings.CutPrefix(name, "labels."); ok && key != "" {
+		return labelField(key), nil
+	}
+	if field, ok := eventFields[name]; ok {
+		return field, nil
+	}
+	return eventField{}, fmt.Errorf("unsupported field %q", name)
+}
@@ -0,0 +1,312 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/elastic/apm-data/model/modelpb"
+)
+
+// EventProcessors is a modelpb.BatchProcessor which applies declaratively
+// configured processors to each event in a batch, in order. Events dropped
+// by a processor are removed from the batch, and are not passed to later
+// processors. The order of the remaining events is preserved.
+type EventProcessors []eventProcessor
+
+type eventProcessor struct {
+	when   eventCondition
+	action func(*modelpb.APMEvent) (drop bool)
+}
+
+// EventProcessorConfig holds configuration for an event processor.
+// Exactly one action should be configured; the first one found in
+// field order is applied.
+type EventProcessorConfig struct {
+	// When holds conditions which an event must match for
+	// the action to be applied. If empty, all events match.
+	When EventConditionConfig
+
+	// DropEvent drops matching events.
+	DropEvent bool
+
+	// DropFields holds the names of fields to remove from matching events.
+	DropFields []string
+
+	// RenameFields holds fields to rename in matching events.
+	RenameFields []RenameFieldConfig
+
+	// SetLabels holds labels to set on matching events.
+	SetLabels map[string]string
+
+	// TruncateFields holds fields to truncate in matching events.
+	TruncateFields TruncateFieldsConfig
+}
+
+// EventConditionConfig holds conditions for matching events. All of the
+// configured conditions must match; list conditions match if any value matches.
+type EventConditionConfig struct {
+	// EventType holds event types to match: "transaction",
+	// "span", "error", "metric", or "log".
+	EventType []string
+
+	// ServiceName holds service names to match.
+	ServiceName []string
+
+	// Labels holds label values to match.
+	Labels map[string]string
+
+	// URLPath holds a regular expression to match against url.path.
+	URLPath string
+}
+
+// RenameFieldConfig holds the source and destination of a field to rename.
+type RenameFieldConfig struct {
+	From string
+	To   string
+}
+
+// TruncateFieldsConfig holds the names of fields to truncate,
+// and the maximum length in characters to truncate them to.
+type TruncateFieldsConfig struct {
+	Fields    []string
+	MaxLength int
+}
+
+// NewEventProcessors returns EventProcessors for the given configuration,
+// or an error if the configuration references an unsupported field.
+func NewEventProcessors(cfg []EventProcessorConfig) (EventProcessors, error) {
+	processors := make(EventProcessors, len(cfg))
+	for i, processorConfig := range cfg {
+		processor, err := newEventProcessor(processorConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event processor %d: %w", i, err)
+		}
+		processors[i] = processor
+	}
+	return processors, nil
+}
+
+func newEventProcessor(cfg EventProcessorConfig) (eventProcessor, error) {
+	when, err := newEventCondition(cfg.When)
+	if err != nil {
+		return eventProcessor{}, err
+	}
+	processor := eventProcessor{when: when}
+	switch {
+	case cfg.DropEvent:
+		processor.action = func(*modelpb.APMEvent) bool { return true }
+	case len(cfg.DropFields) != 0:
+		fields, err := parseEventFields(cfg.DropFields)
+		if err != nil {
+			return eventProcessor{}, err
+		}
+		processor.action = func(event *modelpb.APMEvent) bool {
+			for _, field := range fields {
+				field.set(event, "")
+			}
+			return false
+		}
+	case len(cfg.RenameFields) != 0:
+		type rename struct{ from, to eventField }
+		renames := make([]rename, len(cfg.RenameFields))
+		for i, renameConfig := range cfg.RenameFields {
+			from, err := parseEventField(renameConfig.From)
+			if err != nil {
+				return eventProcessor{}, err
+			}
+			to, err := parseEventField(renameConfig.To)
+			if err != nil {
+				return eventProcessor{}, err
+			}
+			renames[i] = rename{from: from, to: to}
+		}
+		processor.action = func(event *modelpb.APMEvent) bool {
+			for _, r := range renames {
+				if r.from.label != "" && r.to.label != "" {
+					// Move the label value as is, preserving
+					// array values and the global flag.
+					if value, ok := event.Labels[r.from.label]; ok {
+						delete(event.Labels, r.from.label)
+						event.Labels[r.to.label] = value
+					}
+					continue
+				}
+				if value := r.from.get(event); value != "" {
+					r.from.set(event, "")
+					r.to.set(event, value)
+				}
+			}
+			return false
+		}
+	case len(cfg.SetLabels) != 0:
+		labels := make([]eventField, 0, len(cfg.SetLabels))
+		values := make([]string, 0, len(cfg.SetLabels))
+		for key, value := range cfg.SetLabels {
+			labels = append(labels, labelField(key))
+			values = append(values, value)
+		}
+		processor.action = func(event *modelpb.APMEvent) bool {
+			for i, label := range labels {
+				label.set(event, values[i])
+			}
+			return false
+		}
+	case len(cfg.TruncateFields.Fields) != 0:
+		fields, err := parseEventFields(cfg.TruncateFields.Fields)
+		if err != nil {
+			return eventProcessor{}, err
+		}
+		maxLength := cfg.TruncateFields.MaxLength
+		processor.action = func(event *modelpb.APMEvent) bool {
+			for _, field := range fields {
+				if value, ok := truncate(field.get(event), maxLength); ok {
+					field.set(event, value)
+				}
+			}
+			return false
+		}
+	default:
+		return eventProcessor{}, fmt.Errorf("no action configured")
+	}
+	return processor, nil
+}
+
+func parseEventFields(names []string) ([]eventField, error) {
+	fields := make([]eventField, len(names))
+	for i, name := range names {
+		field, err := parseEventField(name)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = field
+	}
+	return fields, nil
+}
+
+// truncate truncates s to at most maxLength characters, returning
+// the truncated string and true if s was longer than maxLength.
+func truncate(s string, maxLength int) (string, bool) {
+	if len(s) <= maxLength {
+		// Fast path: there are at least as many bytes as characters.
+		return s, false
+	}
+	var n int
+	for i := range s {
+		if n == maxLength {
+			return s[:i], true
+		}
+		n++
+	}
+	return s, false
+}
+
+// ProcessBatch applies the processors to each event in b,
+// removing dropped events from b.
+func (p EventProcessors) ProcessBatch(ctx context.Context, b *modelpb.Batch) error {
+	if len(p) == 0 {
+		return nil
+	}
+	events := (*b)[:0]
+	for _, event := range *b {
+		if !p.process(event) {
+			events = append(events, event)
+		}
+	}
+	clear((*b)[len(events):])
+	*b = events
+	return nil
+}
+
+// process applies the processors to event, returning true if it is dropped.
+func (p EventProcessors) process(event *modelpb.APMEvent) bool {
+	for _, processor := range p {
+		if processor.when.match(event) && processor.action(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventCondition matches events by type, service name, labels, and URL path.
+// The zero value matches all events.
+type eventCondition struct {
+	eventTypes   map[modelpb.APMEventType]bool
+	serviceNames map[string]bool
+	labels       map[string]string
+	urlPath      *regexp.Regexp
+}
+
+func newEventCondition(cfg EventConditionConfig) (eventCondition, error) {
+	var c eventCondition
+	if len(cfg.EventType) != 0 {
+		c.eventTypes = make(map[modelpb.APMEventType]bool, len(cfg.EventType))
+		for _, name := range cfg.EventType {
+			eventType, ok := eventTypes[name]
+			if !ok {
+				return eventCondition{}, fmt.Errorf("unknown event_type %q", name)
+			}
+			c.eventTypes[eventType] = true
+		}
+	}
+	if len(cfg.ServiceName) != 0 {
+		c.serviceNames = make(map[string]bool, len(cfg.ServiceName))
+		for _, name := range cfg.ServiceName {
+			c.serviceNames[name] = true
+		}
+	}
+	if len(cfg.Labels) != 0 {
+		c.labels = cfg.Labels
+	}
+	if cfg.URLPath != "" {
+		re, err := regexp.Compile(cfg.URLPath)
+		if err != nil {
+			return eventCondition{}, fmt.Errorf("invalid url_path regex: %w", err)
+		}
+		c.urlPath = re
+	}
+	return c, nil
+}
+
+var eventTypes = map[string]modelpb.APMEventType{
+	"transaction": modelpb.TransactionEventType,
+	"span":        modelpb.SpanEventType,
+	"error":       modelpb.ErrorEventType,
+	"metric":      modelpb.MetricEventType,
+	"log":         modelpb.LogEventType,
+}
+
+func (c eventCondition) match(event *modelpb.APMEvent) bool {
+	if c.eventTypes != nil && !c.eventTypes[event.Type()] {
+		return false
+	}
+	if c.serviceNames != nil && !c.serviceNames[event.GetService().GetName()] {
+		return false
+	}
+	for key, value := range c.labels {
+		if event.Labels[key].GetValue() != value {
+			return false
+		}
+	}
+	if c.urlPath != nil && !c.urlPath.MatchString(event.GetUrl().GetPath()) {
+		return false
+	}
+	return true
+}
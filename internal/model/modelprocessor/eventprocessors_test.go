@@ -0,0 +1,185 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestEventProcessors(t *testing.T) {
+	newTransaction := func(service, path string) *modelpb.APMEvent {
+		return &modelpb.APMEvent{
+			Service:     &modelpb.Service{Name: service},
+			Url:         &modelpb.URL{Path: path},
+			Transaction: &modelpb.Transaction{Type: "request", Name: "GET " + path},
+			Labels:      map[string]*modelpb.LabelValue{"env": {Value: "prod"}},
+		}
+	}
+	for name, test := range map[string]struct {
+		config []modelprocessor.EventProcessorConfig
+		input  modelpb.Batch
+		output modelpb.Batch
+	}{
+		"drop_event": {
+			config: []modelprocessor.EventProcessorConfig{{
+				When:      modelprocessor.EventConditionConfig{EventType: []string{"transaction"}, URLPath: "^/health"},
+				DropEvent: true,
+			}},
+			input: modelpb.Batch{
+				newTransaction("a", "/health"),
+				newTransaction("a", "/users"),
+				{Span: &modelpb.Span{Type: "db"}, Url: &modelpb.URL{Path: "/health"}},
+				newTransaction("b", "/healthz"),
+			},
+			output: modelpb.Batch{
+				newTransaction("a", "/users"),
+				{Span: &modelpb.Span{Type: "db"}, Url: &modelpb.URL{Path: "/health"}},
+			},
+		},
+		"conditions": {
+			config: []modelprocessor.EventProcessorConfig{{
+				When: modelprocessor.EventConditionConfig{
+					ServiceName: []string{"a", "b"},
+					Labels:      map[string]string{"env": "prod"},
+				},
+				DropEvent: true,
+			}},
+			input: modelpb.Batch{
+				newTransaction("a", "/"),
+				newTransaction("c", "/"),
+				{Service: &modelpb.Service{Name: "b"}, Labels: map[string]*modelpb.LabelValue{"env": {Value: "dev"}}},
+			},
+			output: modelpb.Batch{
+				newTransaction("c", "/"),
+				{Service: &modelpb.Service{Name: "b"}, Labels: map[string]*modelpb.LabelValue{"env": {Value: "dev"}}},
+			},
+		},
+		"drop_fields": {
+			config: []modelprocessor.EventProcessorConfig{{
+				DropFields: []string{"url.query", "labels.env", "user.email", "error.exception.message"},
+			}},
+			input: modelpb.Batch{{
+				Url:    &modelpb.URL{Path: "/", Query: "secret=1"},
+				Labels: map[string]*modelpb.LabelValue{"env": {Value: "prod"}, "team": {Value: "x"}},
+				Error:  &modelpb.Error{Message: "boom"},
+			}},
+			output: modelpb.Batch{{
+				Url:    &modelpb.URL{Path: "/"},
+				Labels: map[string]*modelpb.LabelValue{"team": {Value: "x"}},
+				Error:  &modelpb.Error{Message: "boom"},
+			}},
+		},
+		"rename_fields": {
+			config: []modelprocessor.EventProcessorConfig{{
+				RenameFields: []modelprocessor.RenameFieldConfig{
+					{From: "labels.env", To: "labels.environment"},
+					{From: "labels.tier", To: "service.environment"},
+					{From: "user.name", To: "labels.user"},
+				},
+			}},
+			input: modelpb.Batch{{
+				Labels: map[string]*modelpb.LabelValue{
+					"env":  {Values: []string{"a", "b"}, Global: true},
+					"tier": {Value: "production"},
+				},
+			}},
+			output: modelpb.Batch{{
+				Labels: map[string]*modelpb.LabelValue{
+					"environment": {Values: []string{"a", "b"}, Global: true},
+				},
+				Service: &modelpb.Service{Environment: "production"},
+			}},
+		},
+		"set_labels": {
+			config: []modelprocessor.EventProcessorConfig{{
+				When:      modelprocessor.EventConditionConfig{EventType: []string{"error"}},
+				SetLabels: map[string]string{"team": "payments"},
+			}},
+			input: modelpb.Batch{
+				{Error: &modelpb.Error{}},
+				{Metricset: &modelpb.Metricset{}},
+			},
+			output: modelpb.Batch{
+				{Error: &modelpb.Error{}, Labels: map[string]*modelpb.LabelValue{"team": {Value: "payments"}}},
+				{Metricset: &modelpb.Metricset{}},
+			},
+		},
+		"truncate_fields": {
+			config: []modelprocessor.EventProcessorConfig{{
+				TruncateFields: modelprocessor.TruncateFieldsConfig{
+					Fields:    []string{"message", "transaction.name", "span.name"},
+					MaxLength: 3,
+				},
+			}},
+			input: modelpb.Batch{
+				{Message: "héllo", Transaction: &modelpb.Transaction{Name: "abc"}},
+			},
+			output: modelpb.Batch{
+				{Message: "hél", Transaction: &modelpb.Transaction{Name: "abc"}},
+			},
+		},
+		"ordered": {
+			config: []modelprocessor.EventProcessorConfig{{
+				SetLabels: map[string]string{"drop": "true"},
+				When:      modelprocessor.EventConditionConfig{ServiceName: []string{"a"}},
+			}, {
+				When:      modelprocessor.EventConditionConfig{Labels: map[string]string{"drop": "true"}},
+				DropEvent: true,
+			}, {
+				SetLabels: map[string]string{"kept": "true"},
+			}},
+			input: modelpb.Batch{
+				{Service: &modelpb.Service{Name: "a"}},
+				{Service: &modelpb.Service{Name: "b"}},
+			},
+			output: modelpb.Batch{
+				{Service: &modelpb.Service{Name: "b"}, Labels: map[string]*modelpb.LabelValue{"kept": {Value: "true"}}},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			processors, err := modelprocessor.NewEventProcessors(test.config)
+			require.NoError(t, err)
+			batch := test.input
+			require.NoError(t, processors.ProcessBatch(context.Background(), &batch))
+			assert.Empty(t, cmp.Diff(test.output, batch, protocmp.Transform()))
+		})
+	}
+}
+
+func TestEventProcessorsUnsupportedField(t *testing.T) {
+	_, err := modelprocessor.NewEventProcessors([]modelprocessor.EventProcessorConfig{
+		{SetLabels: map[string]string{"a": "b"}},
+		{DropFields: []string{"service.name"}},
+	})
+	assert.EqualError(t, err, `invalid event processor 1: unsupported field "service.name"`)
+
+	_, err = modelprocessor.NewEventProcessors([]modelprocessor.EventProcessorConfig{
+		{RenameFields: []modelprocessor.RenameFieldConfig{{From: "labels.", To: "message"}}},
+	})
+	assert.EqualError(t, err, `invalid event processor 0: unsupported field "labels."`)
+}
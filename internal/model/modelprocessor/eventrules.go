@@ -0,0 +1,194 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/internal/model/modelcel"
+)
+
+// EventRules is a modelpb.BatchProcessor which applies rules defined with
+// CEL expressions to each event in a batch, in order. Events dropped by a
+// rule are removed from the batch, and are not passed to later rules.
+//
+// The number of events matched by each rule is recorded in the metric
+// `apm-server.event_rules.hits`, and the number of expression evaluation
+// errors in `apm-server.event_rules.errors`, with the attribute `rule.name`.
+// A rule whose condition fails to evaluate is not applied.
+type EventRules struct {
+	rules  []eventRule
+	hits   metric.Int64Counter
+	errors metric.Int64Counter
+}
+
+type eventRule struct {
+	attributes metric.MeasurementOption
+	when       *modelcel.Program
+	dropEvent  bool
+	setLabels  []labelRule
+}
+
+type labelRule struct {
+	key  string
+	expr *modelcel.Program
+}
+
+// EventRuleConfig holds configuration for an event rule. Expressions
+// are compiled when the rule is created; see package modelcel for the
+// expression environment.
+type EventRuleConfig struct {
+	// Name identifies the rule in metrics.
+	Name string
+
+	// When holds a boolean expression which an event must match
+	// for the action to be applied. If empty, all events match.
+	When string
+
+	// DropEvent drops matching events.
+	DropEvent bool
+
+	// SetLabels holds expressions for computing labels to set on
+	// matching events, keyed by label name.
+	SetLabels map[string]string
+}
+
+// NewEventRules returns EventRules for the given configuration.
+func NewEventRules(cfg []EventRuleConfig, mp metric.MeterProvider) (*EventRules, error) {
+	meter := mp.Meter("github.com/elastic/apm-server/internal/model/modelprocessor")
+	hits, err := meter.Int64Counter("apm-server.event_rules.hits")
+	if err != nil {
+		return nil, err
+	}
+	errors, err := meter.Int64Counter("apm-server.event_rules.errors")
+	if err != nil {
+		return nil, err
+	}
+	r := &EventRules{rules: make([]eventRule, len(cfg)), hits: hits, errors: errors}
+	for i, ruleConfig := range cfg {
+		rule, err := newEventRule(ruleConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event rule %q: %w", ruleConfig.Name, err)
+		}
+		r.rules[i] = rule
+	}
+	return r, nil
+}
+
+func newEventRule(cfg EventRuleConfig) (eventRule, error) {
+	rule := eventRule{
+		attributes: metric.WithAttributes(attribute.String("rule.name", cfg.Name)),
+		dropEvent:  cfg.DropEvent,
+	}
+	if cfg.When != "" {
+		when, err := modelcel.CompileCondition(cfg.When)
+		if err != nil {
+			return eventRule{}, err
+		}
+		rule.when = when
+	}
+	for key, expr := range cfg.SetLabels {
+		program, err := modelcel.CompileLabel(expr)
+		if err != nil {
+			return eventRule{}, fmt.Errorf("label %q: %w", key, err)
+		}
+		rule.setLabels = append(rule.setLabels, labelRule{key: key, expr: program})
+	}
+	// Set labels in a consistent order, for predictable
+	// behaviour when a label expression fails to evaluate.
+	slices.SortFunc(rule.setLabels, func(a, b labelRule) int {
+		return strings.Compare(a.key, b.key)
+	})
+	return rule, nil
+}
+
+// ProcessBatch applies the rules to each event in b,
+// removing dropped events from b.
+func (r *EventRules) ProcessBatch(ctx context.Context, b *modelpb.Batch) error {
+	if len(r.rules) == 0 {
+		return nil
+	}
+	hits := make([]int64, len(r.rules))
+	errors := make([]int64, len(r.rules))
+	events := (*b)[:0]
+	for _, event := range *b {
+		if !r.process(event, hits, errors) {
+			events = append(events, event)
+		}
+	}
+	clear((*b)[len(events):])
+	*b = events
+	for i, rule := range r.rules {
+		if hits[i] != 0 {
+			r.hits.Add(ctx, hits[i], rule.attributes)
+		}
+		if errors[i] != 0 {
+			r.errors.Add(ctx, errors[i], rule.attributes)
+		}
+	}
+	return nil
+}
+
+// process applies the rules to event, returning true if it is dropped.
+// The number of matches and errors for each rule are added to hits and errors.
+func (r *EventRules) process(event *modelpb.APMEvent, hits, errors []int64) bool {
+	for i, rule := range r.rules {
+		if rule.when != nil {
+			match, err := rule.when.EvalCondition(event)
+			if err != nil {
+				errors[i]++
+				continue
+			}
+			if !match {
+				continue
+			}
+		}
+		hits[i]++
+		if rule.dropEvent {
+			return true
+		}
+		for _, label := range rule.setLabels {
+			value, numericValue, numeric, err := label.expr.EvalLabel(event)
+			if err != nil {
+				errors[i]++
+				continue
+			}
+			if numeric {
+				delete(event.Labels, label.key)
+				if event.NumericLabels == nil {
+					event.NumericLabels = make(map[string]*modelpb.NumericLabelValue)
+				}
+				event.NumericLabels[label.key] = &modelpb.NumericLabelValue{Value: numericValue}
+			} else {
+				delete(event.NumericLabels, label.key)
+				if event.Labels == nil {
+					event.Labels = make(map[string]*modelpb.LabelValue)
+				}
+				event.Labels[label.key] = &modelpb.LabelValue{Value: value}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestEventRules(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	rules, err := modelprocessor.NewEventRules([]modelprocessor.EventRuleConfig{{
+		Name:      "drop_fast_cache_spans",
+		When:      "duration(event.event.duration) < duration('1ms') && event.span.type == 'cache'",
+		DropEvent: true,
+	}, {
+		Name: "tier",
+		SetLabels: map[string]string{
+			"tier":        "event.service.name.startsWith('prod') ? 'gold' : 'silver'",
+			"duration_ms": "double(event.event.duration) / 1e6",
+		},
+	}, {
+		Name:      "drop_tier",
+		When:      "event.labels['tier'].value == 'silver' && event.labels['drop'].value == 'true'",
+		DropEvent: true,
+	}}, mp)
+	require.NoError(t, err)
+
+	batch := modelpb.Batch{{
+		Service: &modelpb.Service{Name: "prod-api"},
+		Event:   &modelpb.Event{Duration: 100000},
+		Span:    &modelpb.Span{Type: "cache"},
+	}, {
+		Service: &modelpb.Service{Name: "prod-api"},
+		Event:   &modelpb.Event{Duration: 2000000},
+		Span:    &modelpb.Span{Type: "cache"},
+		Labels:  map[string]*modelpb.LabelValue{"drop": {Value: "true"}},
+	}, {
+		Service:       &modelpb.Service{Name: "staging-api"},
+		Event:         &modelpb.Event{Duration: 500000},
+		Transaction:   &modelpb.Transaction{Type: "request"},
+		NumericLabels: map[string]*modelpb.NumericLabelValue{"tier": {Value: 1}},
+	}, {
+		Service:     &modelpb.Service{Name: "staging-api"},
+		Transaction: &modelpb.Transaction{Type: "request"},
+		Labels:      map[string]*modelpb.LabelValue{"drop": {Value: "true"}},
+	}}
+	require.NoError(t, rules.ProcessBatch(context.Background(), &batch))
+
+	assert.Empty(t, cmp.Diff(modelpb.Batch{{
+		Service:       &modelpb.Service{Name: "prod-api"},
+		Event:         &modelpb.Event{Duration: 2000000},
+		Span:          &modelpb.Span{Type: "cache"},
+		Labels:        map[string]*modelpb.LabelValue{"drop": {Value: "true"}, "tier": {Value: "gold"}},
+		NumericLabels: map[string]*modelpb.NumericLabelValue{"duration_ms": {Value: 2}},
+	}, {
+		Service:       &modelpb.Service{Name: "staging-api"},
+		Event:         &modelpb.Event{Duration: 500000},
+		Transaction:   &modelpb.Transaction{Type: "request"},
+		Labels:        map[string]*modelpb.LabelValue{"tier": {Value: "silver"}},
+		NumericLabels: map[string]*modelpb.NumericLabelValue{"duration_ms": {Value: 0.5}},
+	}}, batch, protocmp.Transform()))
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	counts := make(map[string]map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			counts[m.Name] = make(map[string]int64)
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				name, _ := dp.Attributes.Value("rule.name")
+				counts[m.Name][name.AsString()] = dp.Value
+			}
+		}
+	}
+	assert.Equal(t, map[string]map[string]int64{
+		"apm-server.event_rules.hits": {
+			"drop_fast_cache_spans": 1,
+			"tier":                  3,
+			"drop_tier":             1,
+		},
+		// The drop_tier condition fails to evaluate
+		// for events without a "drop" label.
+		"apm-server.event_rules.errors": {
+			"drop_tier": 1,
+		},
+	}, counts)
+}
+
+func TestEventRulesInvalid(t *testing.T) {
+	_, err := modelprocessor.NewEventRules([]modelprocessor.EventRuleConfig{{
+		Name:      "invalid",
+		When:      "event.service.name",
+		DropEvent: true,
+	}}, sdkmetric.NewMeterProvider())
+	assert.EqualError(t, err, `invalid event rule "invalid": expression must evaluate to bool, got string`)
+}
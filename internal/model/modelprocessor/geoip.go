@@ -0,0 +1,239 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// GeoIP is a modelpb.BatchProcessor which enriches events with geolocation
+// information for client.ip, and autonomous system (AS) information for
+// source.ip, looked up from local MaxMind-format (MMDB) database files.
+//
+// The event model has no fields for geolocation or AS information, so they
+// are recorded as labels named after the corresponding ECS fields:
+//
+//   - client_geo_continent_name, client_geo_country_iso_code, and
+//     client_geo_country_name are recorded as global labels, and so are
+//     included as dimensions in aggregated metrics.
+//   - client_geo_region_iso_code, client_geo_region_name,
+//     client_geo_city_name, source_as_number, and source_as_organization_name
+//     are recorded as non-global labels, and so are excluded from aggregated
+//     metrics to limit their cardinality.
+type GeoIP struct {
+	logger   *logp.Logger
+	interval time.Duration
+	city     *geoIPDatabase
+	asn      *geoIPDatabase
+}
+
+// geoIPDatabase holds an MMDB database file loaded into memory,
+// which is replaced atomically when the file is modified.
+type geoIPDatabase struct {
+	path    string
+	modTime time.Time
+	reader  atomic.Pointer[maxminddb.Reader]
+}
+
+type geoIPCityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Continent struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"continent"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+}
+
+type geoIPASNRecord struct {
+	Number       uint32 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// GeoIPConfig holds configuration for GeoIP.
+type GeoIPConfig struct {
+	// CityDatabase holds the path to a City or Country database,
+	// used for resolving the geolocation of client.ip.
+	CityDatabase string
+
+	// ASNDatabase holds the path to an ASN database,
+	// used for resolving the autonomous system of source.ip.
+	ASNDatabase string
+
+	// ReloadInterval holds the interval at which the database
+	// files are checked for modifications.
+	ReloadInterval time.Duration
+}
+
+// NewGeoIP returns a GeoIP for the given configuration, loading the
+// configured database files. Run must be called for the database
+// files to be reloaded when modified.
+func NewGeoIP(cfg GeoIPConfig, logger *logp.Logger) (*GeoIP, error) {
+	g := &GeoIP{logger: logger, interval: cfg.ReloadInterval}
+	for _, db := range []struct {
+		path string
+		out  **geoIPDatabase
+	}{{cfg.CityDatabase, &g.city}, {cfg.ASNDatabase, &g.asn}} {
+		if db.path == "" {
+			continue
+		}
+		database := &geoIPDatabase{path: db.path}
+		if _, err := database.load(); err != nil {
+			return nil, fmt.Errorf("error loading GeoIP database: %w", err)
+		}
+		*db.out = database
+	}
+	return g, nil
+}
+
+// Run periodically checks the database files for modifications, and reloads
+// them, until ctx is cancelled. Errors are logged, and the previously loaded
+// databases remain in use.
+func (g *GeoIP) Run(ctx context.Context) error {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			g.reload()
+		}
+	}
+}
+
+func (g *GeoIP) reload() {
+	for _, db := range []*geoIPDatabase{g.city, g.asn} {
+		if db == nil {
+			continue
+		}
+		reloaded, err := db.load()
+		if err != nil {
+			g.logger.With(logp.Error(err)).Errorf("failed to reload GeoIP database %s", db.path)
+		} else if reloaded {
+			g.logger.Infof("reloaded GeoIP database %s", db.path)
+		}
+	}
+}
+
+// load loads the database file if it has been modified since it was last
+// loaded, and reports whether it was loaded. The file is read into memory
+// rather than memory-mapped, so that readers replaced by a reload remain
+// valid for any lookups still in progress.
+func (db *geoIPDatabase) load() (bool, error) {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(db.modTime) {
+		return false, nil
+	}
+	// Record the modification time before reading the file, so
+	// that an invalid file is not reloaded until it is modified.
+	db.modTime = info.ModTime()
+	data, err := os.ReadFile(db.path)
+	if err != nil {
+		return false, err
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return false, fmt.Errorf("error reading %s: %w", db.path, err)
+	}
+	db.reader.Store(reader)
+	return true, nil
+}
+
+// lookup looks up ip in the database, returning false
+// if the database is not configured or ip is not found.
+func (db *geoIPDatabase) lookup(ip *modelpb.IP, result any) bool {
+	if db == nil || ip == nil {
+		return false
+	}
+	addr := modelpb.IP2Addr(ip)
+	if !addr.IsValid() {
+		return false
+	}
+	_, ok, err := db.reader.Load().LookupNetwork(net.IP(addr.AsSlice()), result)
+	return ok && err == nil
+}
+
+// ProcessBatch enriches each event in b with geolocation and AS information.
+func (g *GeoIP) ProcessBatch(ctx context.Context, b *modelpb.Batch) error {
+	for _, event := range *b {
+		g.processEvent(event)
+	}
+	return nil
+}
+
+func (g *GeoIP) processEvent(event *modelpb.APMEvent) {
+	var city geoIPCityRecord
+	if g.city.lookup(event.GetClient().GetIp(), &city) {
+		setLabel(event, "client_geo_continent_name", city.Continent.Names["en"], true)
+		setLabel(event, "client_geo_country_iso_code", city.Country.ISOCode, true)
+		setLabel(event, "client_geo_country_name", city.Country.Names["en"], true)
+		if len(city.Subdivisions) != 0 {
+			region := city.Subdivisions[0]
+			if city.Country.ISOCode != "" && region.ISOCode != "" {
+				setLabel(event, "client_geo_region_iso_code", city.Country.ISOCode+"-"+region.ISOCode, false)
+			}
+			setLabel(event, "client_geo_region_name", region.Names["en"], false)
+		}
+		setLabel(event, "client_geo_city_name", city.City.Names["en"], false)
+	}
+	var asn geoIPASNRecord
+	if g.asn.lookup(event.GetSource().GetIp(), &asn) {
+		if asn.Number != 0 {
+			if event.NumericLabels == nil {
+				event.NumericLabels = make(map[string]*modelpb.NumericLabelValue)
+			}
+			event.NumericLabels["source_as_number"] = &modelpb.NumericLabelValue{
+				Value: float64(asn.Number),
+			}
+		}
+		setLabel(event, "source_as_organization_name", asn.Organization, false)
+	}
+}
+
+// setLabel sets the label key to value, if value is non-empty. Global labels
+// are included as dimensions in aggregated metrics.
+func setLabel(event *modelpb.APMEvent, key, value string, global bool) {
+	if value == "" {
+		return
+	}
+	if event.Labels == nil {
+		event.Labels = make(map[string]*modelpb.LabelValue)
+	}
+	event.Labels[key] = &modelpb.LabelValue{Value: value, Global: global}
+}
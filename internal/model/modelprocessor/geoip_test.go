@@ -0,0 +1,247 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestGeoIP(t *testing.T) {
+	dir := t.TempDir()
+	cityPath := filepath.Join(dir, "city.mmdb")
+	asnPath := filepath.Join(dir, "asn.mmdb")
+	writeCityDatabase(t, cityPath, "London", time.Unix(1, 0))
+	writeMMDB(t, asnPath, "GeoLite2-ASN", map[string]map[string]any{
+		"1.128.0.0/11": {
+			"autonomous_system_number":       uint32(1221),
+			"autonomous_system_organization": "Telstra Pty Ltd",
+		},
+	}, time.Unix(1, 0))
+
+	geoip, err := modelprocessor.NewGeoIP(modelprocessor.GeoIPConfig{
+		CityDatabase:   cityPath,
+		ASNDatabase:    asnPath,
+		ReloadInterval: 10 * time.Millisecond,
+	}, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+
+	newBatch := func() modelpb.Batch {
+		return modelpb.Batch{{
+			Client: &modelpb.Client{Ip: modelpb.MustParseIP("81.2.69.142")},
+			Source: &modelpb.Source{Ip: modelpb.MustParseIP("1.128.0.1")},
+		}, {
+			Client: &modelpb.Client{Ip: modelpb.MustParseIP("10.0.0.1")},
+			Source: &modelpb.Source{Ip: modelpb.MustParseIP("2001:db8::1")},
+		}, {}}
+	}
+	batch := newBatch()
+	require.NoError(t, geoip.ProcessBatch(context.Background(), &batch))
+	assert.Empty(t, cmp.Diff(modelpb.Batch{{
+		Client: &modelpb.Client{Ip: modelpb.MustParseIP("81.2.69.142")},
+		Source: &modelpb.Source{Ip: modelpb.MustParseIP("1.128.0.1")},
+		Labels: map[string]*modelpb.LabelValue{
+			"client_geo_continent_name":   {Value: "Europe", Global: true},
+			"client_geo_country_iso_code": {Value: "GB", Global: true},
+			"client_geo_country_name":     {Value: "United Kingdom", Global: true},
+			"client_geo_region_iso_code":  {Value: "GB-ENG"},
+			"client_geo_region_name":      {Value: "England"},
+			"client_geo_city_name":        {Value: "London"},
+			"source_as_organization_name": {Value: "Telstra Pty Ltd"},
+		},
+		NumericLabels: map[string]*modelpb.NumericLabelValue{
+			"source_as_number": {Value: 1221},
+		},
+	}, {
+		Client: &modelpb.Client{Ip: modelpb.MustParseIP("10.0.0.1")},
+		Source: &modelpb.Source{Ip: modelpb.MustParseIP("2001:db8::1")},
+	}, {}}, batch, protocmp.Transform()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go geoip.Run(ctx)
+
+	// Modified database files are reloaded, and invalid
+	// database files are ignored.
+	require.NoError(t, os.WriteFile(asnPath, []byte("invalid"), 0644))
+	writeCityDatabase(t, cityPath, "Londinium", time.Unix(2, 0))
+	assert.Eventually(t, func() bool {
+		batch := newBatch()
+		require.NoError(t, geoip.ProcessBatch(context.Background(), &batch))
+		return batch[0].Labels["client_geo_city_name"].GetValue() == "Londinium" &&
+			batch[0].Labels["source_as_organization_name"].GetValue() == "Telstra Pty Ltd"
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestGeoIPInvalidDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte("invalid"), 0644))
+	_, err := modelprocessor.NewGeoIP(modelprocessor.GeoIPConfig{
+		CityDatabase:   path,
+		ReloadInterval: time.Minute,
+	}, logptest.NewTestingLogger(t, ""))
+	assert.ErrorContains(t, err, "error loading GeoIP database")
+}
+
+func writeCityDatabase(t testing.TB, path, cityName string, modTime time.Time) {
+	writeMMDB(t, path, "GeoLite2-City", map[string]map[string]any{
+		"81.2.69.0/24": {
+			"city":      map[string]any{"names": map[string]any{"en": cityName}},
+			"continent": map[string]any{"names": map[string]any{"en": "Europe"}},
+			"country": map[string]any{
+				"iso_code": "GB",
+				"names":    map[string]any{"en": "United Kingdom"},
+			},
+			"subdivisions": []any{map[string]any{
+				"iso_code": "ENG",
+				"names":    map[string]any{"en": "England"},
+			}},
+		},
+	}, modTime)
+}
+
+// writeMMDB writes an IPv4 MaxMind DB file with 24-bit records,
+// holding the given records keyed by non-overlapping network.
+func writeMMDB(t testing.TB, path, databaseType string, records map[string]map[string]any, modTime time.Time) {
+	var data bytes.Buffer
+	const empty = -1
+	nodes := [][2]int{{empty, empty}}
+	dataOffsets := make(map[int]int) // record ID -> data offset
+	for network, record := range records {
+		offset := data.Len()
+		encodeMMDBValue(&data, record)
+		prefix := netip.MustParsePrefix(network)
+		ip := prefix.Addr().As4()
+		node := 0
+		for i := 0; i < prefix.Bits(); i++ {
+			bit := (ip[i/8] >> (7 - i%8)) & 1
+			if i == prefix.Bits()-1 {
+				id := -2 - len(dataOffsets)
+				dataOffsets[id] = offset
+				nodes[node][bit] = id
+				break
+			}
+			if nodes[node][bit] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	var buf bytes.Buffer
+	nodeCount := len(nodes)
+	for _, node := range nodes {
+		for _, record := range node {
+			value := record
+			switch {
+			case record == empty:
+				value = nodeCount
+			case record < 0:
+				value = nodeCount + 16 + dataOffsets[record]
+			}
+			buf.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(data.Bytes())
+	buf.WriteString("\xAB\xCD\xEFMaxMind.com")
+	encodeMMDBValue(&buf, map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(modTime.Unix()),
+		"database_type":               databaseType,
+		"description":                 map[string]any{},
+		"ip_version":                  uint16(4),
+		"languages":                   []any{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+	})
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func encodeMMDBValue(buf *bytes.Buffer, value any) {
+	control := func(dataType, size int) {
+		var extendedSize []byte
+		if size >= 29 {
+			// Sizes up to 284 are encoded in one extra byte.
+			extendedSize = []byte{byte(size - 29)}
+			size = 29
+		}
+		if dataType <= 7 {
+			buf.WriteByte(byte(dataType<<5 | size))
+		} else {
+			buf.WriteByte(byte(size))
+			buf.WriteByte(byte(dataType - 7))
+		}
+		buf.Write(extendedSize)
+	}
+	uint := func(dataType int, v uint64) {
+		var b []byte
+		for ; v != 0; v >>= 8 {
+			b = append([]byte{byte(v)}, b...)
+		}
+		control(dataType, len(b))
+		buf.Write(b)
+	}
+	switch value := value.(type) {
+	case string:
+		control(2, len(value))
+		buf.WriteString(value)
+	case uint16:
+		uint(5, uint64(value))
+	case uint32:
+		uint(6, uint64(value))
+	case uint64:
+		uint(9, value)
+	case map[string]any:
+		control(7, len(value))
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			encodeMMDBValue(buf, key)
+			encodeMMDBValue(buf, value[key])
+		}
+	case []any:
+		control(11, len(value))
+		for _, elem := range value {
+			encodeMMDBValue(buf, elem)
+		}
+	default:
+		panic("unsupported type")
+	}
+}
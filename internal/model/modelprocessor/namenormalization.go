@@ -0,0 +1,273 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/elastic/apm-data/model/modelpb"
+)
+
+// NameNormalizerAutoDetectRule is the rule name under which names
+// rewritten by automatic detection are counted in metrics.
+const NameNormalizerAutoDetectRule = "auto_detect"
+
+// originalNameLabel is the label in which the original transaction
+// or span name is recorded when it is rewritten by NameNormalizer.
+const originalNameLabel = "original_name"
+
+// NameNormalizer is a modelpb.BatchProcessor which rewrites transaction
+// and span names containing identifiers into templated names, limiting
+// the cardinality of names seen by aggregation.
+//
+// The path of each name, starting at the first '/', is matched against the
+// configured templates in order, and replaced by the first matching template.
+// If no template matches and automatic detection is enabled, path segments
+// which are numeric, UUIDs, or hexadecimal are replaced with `{id}`, `{uuid}`,
+// or `{hex}` respectively. The original name is recorded in the label
+// `original_name`.
+//
+// The number of names rewritten by each rule is recorded in the metric
+// `apm-server.name_normalization.rewrites`, with the attribute `rule.name`.
+type NameNormalizer struct {
+	rules      []nameRule
+	autoDetect bool
+	autoAttrs  metric.MeasurementOption
+	rewrites   metric.Int64Counter
+}
+
+type nameRule struct {
+	attributes metric.MeasurementOption
+	services   map[string]bool
+	template   string
+	segments   []string
+}
+
+// NameNormalizerConfig holds configuration for NameNormalizer.
+type NameNormalizerConfig struct {
+	// AutoDetect enables replacing numeric, UUID, and hexadecimal path
+	// segments of names which do not match any of the rules.
+	AutoDetect bool
+
+	// Rules holds path templating rules, applied in order.
+	Rules []NameNormalizerRuleConfig
+}
+
+// NameNormalizerRuleConfig holds a path template for normalizing names,
+// such as `/users/{user_id}/orders`.
+type NameNormalizerRuleConfig struct {
+	// Name identifies the rule in metrics.
+	Name string
+
+	// ServiceName holds the names of services to which the rule applies.
+	// If empty, the rule applies to all services.
+	ServiceName []string
+
+	Template string
+}
+
+// NewNameNormalizer returns a NameNormalizer for the given configuration.
+func NewNameNormalizer(cfg NameNormalizerConfig, mp metric.MeterProvider) (*NameNormalizer, error) {
+	meter := mp.Meter("github.com/elastic/apm-server/internal/model/modelprocessor")
+	rewrites, err := meter.Int64Counter("apm-server.name_normalization.rewrites")
+	if err != nil {
+		return nil, err
+	}
+	n := &NameNormalizer{
+		rules:      make([]nameRule, len(cfg.Rules)),
+		autoDetect: cfg.AutoDetect,
+		autoAttrs: metric.WithAttributes(
+			attribute.String("rule.name", NameNormalizerAutoDetectRule),
+		),
+		rewrites: rewrites,
+	}
+	for i, ruleConfig := range cfg.Rules {
+		rule := nameRule{
+			attributes: metric.WithAttributes(attribute.String("rule.name", ruleConfig.Name)),
+			template:   ruleConfig.Template,
+			segments:   strings.Split(ruleConfig.Template, "/"),
+		}
+		if len(ruleConfig.ServiceName) != 0 {
+			rule.services = make(map[string]bool, len(ruleConfig.ServiceName))
+			for _, name := range ruleConfig.ServiceName {
+				rule.services[name] = true
+			}
+		}
+		n.rules[i] = rule
+	}
+	return n, nil
+}
+
+// ProcessBatch normalizes the names of transactions and spans in b.
+func (n *NameNormalizer) ProcessBatch(ctx context.Context, b *modelpb.Batch) error {
+	rewrites := make([]int64, len(n.rules)+1)
+	for _, event := range *b {
+		var name *string
+		switch event.Type() {
+		case modelpb.TransactionEventType:
+			name = &event.Transaction.Name
+		case modelpb.SpanEventType:
+			name = &event.Span.Name
+		default:
+			continue
+		}
+		normalized, rule := n.normalize(event.GetService().GetName(), *name)
+		if rule < 0 {
+			continue
+		}
+		if event.Labels == nil {
+			event.Labels = make(map[string]*modelpb.LabelValue)
+		}
+		event.Labels[originalNameLabel] = &modelpb.LabelValue{Value: *name}
+		*name = normalized
+		rewrites[rule]++
+	}
+	for i, rule := range n.rules {
+		if rewrites[i] != 0 {
+			n.rewrites.Add(ctx, rewrites[i], rule.attributes)
+		}
+	}
+	if auto := rewrites[len(n.rules)]; auto != 0 {
+		n.rewrites.Add(ctx, auto, n.autoAttrs)
+	}
+	return nil
+}
+
+// normalize returns the normalized name and the index of the rule which
+// rewrote it, with len(n.rules) identifying automatic detection. If the
+// name is not rewritten, normalize returns -1.
+func (n *NameNormalizer) normalize(serviceName, name string) (string, int) {
+	i := strings.IndexByte(name, '/')
+	if i < 0 {
+		return "", -1
+	}
+	prefix, path := name[:i], name[i:]
+	for j, rule := range n.rules {
+		if rule.services != nil && !rule.services[serviceName] {
+			continue
+		}
+		if rule.match(path) {
+			if path == rule.template {
+				return "", -1
+			}
+			return prefix + rule.template, j
+		}
+	}
+	if n.autoDetect {
+		if normalized, ok := autoDetectPath(path); ok {
+			return prefix + normalized, len(n.rules)
+		}
+	}
+	return "", -1
+}
+
+func (r *nameRule) match(path string) bool {
+	for _, segment := range r.segments {
+		var value string
+		value, path, _ = strings.Cut(path, "/")
+		if isPlaceholderSegment(segment) {
+			if value == "" {
+				return false
+			}
+		} else if value != segment {
+			return false
+		}
+	}
+	return path == ""
+}
+
+// autoDetectPath replaces numeric, UUID, and hexadecimal segments of path
+// with placeholders, reporting whether any segments were replaced.
+func autoDetectPath(path string) (string, bool) {
+	var sb strings.Builder
+	var replaced bool
+	for i := 0; ; i++ {
+		segment, rest, more := strings.Cut(path, "/")
+		if i > 0 {
+			sb.WriteByte('/')
+		}
+		if placeholder := detectIdentifier(segment); placeholder != "" {
+			sb.WriteString(placeholder)
+			replaced = true
+		} else {
+			sb.WriteString(segment)
+		}
+		if !more {
+			break
+		}
+		path = rest
+	}
+	if !replaced {
+		return "", false
+	}
+	return sb.String(), true
+}
+
+// minHexIdentifierLength is the minimum length of a hexadecimal segment to be
+// considered an identifier, to avoid replacing short words such as "cafe".
+const minHexIdentifierLength = 8
+
+// detectIdentifier returns the placeholder with which segment should be
+// replaced, or an empty string if it is not an identifier.
+func detectIdentifier(segment string) string {
+	if segment == "" {
+		return ""
+	}
+	var digits, hexLetters, dashes int
+	for i := 0; i < len(segment); i++ {
+		switch c := segment[i]; {
+		case c >= '0' && c <= '9':
+			digits++
+		case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+			hexLetters++
+		case c == '-':
+			dashes++
+		default:
+			return ""
+		}
+	}
+	switch {
+	case digits == len(segment):
+		return "{id}"
+	case dashes == 4 && isUUID(segment):
+		return "{uuid}"
+	case dashes == 0 && digits > 0 && len(segment) >= minHexIdentifierLength:
+		return "{hex}"
+	}
+	return ""
+}
+
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for _, i := range []int{8, 13, 18, 23} {
+		if s[i] != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func isPlaceholderSegment(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}'
+}
@@ -0,0 +1,125 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestNameNormalizer(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	normalizer, err := modelprocessor.NewNameNormalizer(modelprocessor.NameNormalizerConfig{
+		AutoDetect: true,
+		Rules: []modelprocessor.NameNormalizerRuleConfig{{
+			Name:        "opbeans_users",
+			ServiceName: []string{"opbeans"},
+			Template:    "/users/{username}/orders",
+		}, {
+			Name:     "products",
+			Template: "/products/{sku}",
+		}},
+	}, mp)
+	require.NoError(t, err)
+
+	transaction := func(service, name string) *modelpb.APMEvent {
+		return &modelpb.APMEvent{
+			Service:     &modelpb.Service{Name: service},
+			Transaction: &modelpb.Transaction{Type: "request", Name: name},
+		}
+	}
+	span := func(service, name string) *modelpb.APMEvent {
+		return &modelpb.APMEvent{
+			Service: &modelpb.Service{Name: service},
+			Span:    &modelpb.Span{Type: "external", Name: name},
+		}
+	}
+	withOriginal := func(event *modelpb.APMEvent, original string) *modelpb.APMEvent {
+		event.Labels = map[string]*modelpb.LabelValue{"original_name": {Value: original}}
+		return event
+	}
+
+	batch := modelpb.Batch{
+		transaction("opbeans", "GET /users/alice/orders"),
+		transaction("other", "GET /users/alice/orders"),
+		transaction("other", "GET /products/ABC-1"),
+		transaction("other", "GET /products/{sku}"),
+		transaction("other", "GET /orders/123456/items/42"),
+		span("other", "GET /objects/5f1c2b3a9d8e7f6a5b4c3d2e"),
+		span("other", "GET /sessions/0b9d4a52-3c3e-4f4b-9a4e-1f2d3c4b5a69"),
+		transaction("other", "GET /cafe/deadbeef"),
+		transaction("other", "ProcessOrder"),
+		{Service: &modelpb.Service{Name: "other"}, Error: &modelpb.Error{Message: "/users/123"}},
+	}
+	require.NoError(t, normalizer.ProcessBatch(context.Background(), &batch))
+
+	assert.Empty(t, cmp.Diff(modelpb.Batch{
+		withOriginal(transaction("opbeans", "GET /users/{username}/orders"), "GET /users/alice/orders"),
+		transaction("other", "GET /users/alice/orders"),
+		withOriginal(transaction("other", "GET /products/{sku}"), "GET /products/ABC-1"),
+		transaction("other", "GET /products/{sku}"),
+		withOriginal(transaction("other", "GET /orders/{id}/items/{id}"), "GET /orders/123456/items/42"),
+		withOriginal(span("other", "GET /objects/{hex}"), "GET /objects/5f1c2b3a9d8e7f6a5b4c3d2e"),
+		withOriginal(span("other", "GET /sessions/{uuid}"), "GET /sessions/0b9d4a52-3c3e-4f4b-9a4e-1f2d3c4b5a69"),
+		transaction("other", "GET /cafe/deadbeef"),
+		transaction("other", "ProcessOrder"),
+		{Service: &modelpb.Service{Name: "other"}, Error: &modelpb.Error{Message: "/users/123"}},
+	}, batch, protocmp.Transform()))
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	require.Len(t, rm.ScopeMetrics[0].Metrics, 1)
+	metric := rm.ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, "apm-server.name_normalization.rewrites", metric.Name)
+	counts := make(map[string]int64)
+	for _, dp := range metric.Data.(metricdata.Sum[int64]).DataPoints {
+		name, _ := dp.Attributes.Value("rule.name")
+		counts[name.AsString()] = dp.Value
+	}
+	assert.Equal(t, map[string]int64{
+		"opbeans_users": 1,
+		"products":      1,
+		"auto_detect":   3,
+	}, counts)
+}
+
+func TestNameNormalizerAutoDetectDisabled(t *testing.T) {
+	normalizer, err := modelprocessor.NewNameNormalizer(
+		modelprocessor.NameNormalizerConfig{}, sdkmetric.NewMeterProvider(),
+	)
+	require.NoError(t, err)
+
+	batch := modelpb.Batch{{
+		Transaction: &modelpb.Transaction{Type: "request", Name: "GET /users/123"},
+	}}
+	require.NoError(t, normalizer.ProcessBatch(context.Background(), &batch))
+	assert.Equal(t, "GET /users/123", batch[0].Transaction.Name)
+	assert.Empty(t, batch[0].Labels)
+}
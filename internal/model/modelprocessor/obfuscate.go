@@ -0,0 +1,254 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"regexp"
+	"strings"
+)
+
+// placeholderListRegexp matches parenthesized or bracketed lists of
+// placeholders, such as the values of `IN (?, ?, ?)`, which are collapsed
+// to a single placeholder so that statements differing only in the number
+// of values are grouped together.
+var placeholderListRegexp = regexp.MustCompile(`([(\[])\s*-?\?(?:\s*,\s*-?\?)+\s*([)\]])`)
+
+// sqlDialect holds the lexical differences between SQL dialects
+// which affect obfuscation.
+type sqlDialect struct {
+	// bracketIdentifiers reports whether brackets quote identifiers,
+	// such as [dbo].[users]. Otherwise the contents of brackets, such
+	// as array subscripts, are obfuscated.
+	bracketIdentifiers bool
+
+	// backslashEscapes reports whether backslashes escape characters in
+	// string literals. Standard SQL strings only escape quotes by doubling
+	// them, so that 'C:\' is a complete string.
+	backslashEscapes bool
+
+	// doubleQuotedStrings reports whether double quotes delimit string
+	// literals, as in MySQL unless ANSI_QUOTES is set, rather than quoting
+	// identifiers as in standard SQL.
+	doubleQuotedStrings bool
+}
+
+// sqlDialectFor returns the sqlDialect for the database span subtype.
+func sqlDialectFor(subtype string) sqlDialect {
+	switch subtype {
+	case "mssql", "sqlserver":
+		return sqlDialect{bracketIdentifiers: true}
+	case "mysql", "mariadb":
+		return sqlDialect{backslashEscapes: true, doubleQuotedStrings: true}
+	}
+	return sqlDialect{}
+}
+
+// obfuscateSQL replaces string and numeric literals in the SQL statement s
+// with `?`, removes comments, and collapses whitespace. Identifiers, including
+// quoted identifiers, keywords, and bind parameters are retained.
+func obfuscateSQL(s string, dialect sqlDialect) string {
+	var sb strings.Builder
+	sb.Grow(len(s))
+	var space bool
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case isSpaceByte(c):
+			space = true
+			i++
+			continue
+		case c == '-' && strings.HasPrefix(s[i:], "--"):
+			if n := strings.IndexByte(s[i:], '\n'); n >= 0 {
+				i += n + 1
+			} else {
+				i = len(s)
+			}
+			space = true
+			continue
+		case c == '/' && strings.HasPrefix(s[i:], "/*"):
+			if n := strings.Index(s[i+2:], "*/"); n >= 0 {
+				i += n + 4
+			} else {
+				i = len(s)
+			}
+			space = true
+			continue
+		}
+		if space && sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+		switch {
+		case c == '\'':
+			i = skipQuoted(s, i, '\'', dialect.backslashEscapes)
+			sb.WriteByte('?')
+		case (c == 'E' || c == 'e') && i+1 < len(s) && s[i+1] == '\'':
+			// PostgreSQL escape string, e.g. E'\n', in which
+			// backslashes escape characters in any dialect.
+			i = skipQuoted(s, i+1, '\'', true)
+			sb.WriteByte('?')
+		case c == '"' && dialect.doubleQuotedStrings:
+			i = skipQuoted(s, i, '"', dialect.backslashEscapes)
+			sb.WriteByte('?')
+		case c == '"' || c == '`':
+			end := skipQuoted(s, i, c, dialect.backslashEscapes)
+			sb.WriteString(s[i:end])
+			i = end
+		case c == '[' && dialect.bracketIdentifiers:
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				end = len(s) - i - 1
+			}
+			sb.WriteString(s[i : i+end+1])
+			i += end + 1
+		case c == '$' && i+1 < len(s) && isDigitByte(s[i+1]):
+			// Positional bind parameter, e.g. $1.
+			end := i + 1
+			for end < len(s) && isDigitByte(s[end]) {
+				end++
+			}
+			sb.WriteString(s[i:end])
+			i = end
+		case isDigitByte(c) || c == '.' && i+1 < len(s) && isDigitByte(s[i+1]):
+			i = skipWord(s, i)
+			sb.WriteByte('?')
+		case isWordByte(c):
+			end := skipWord(s, i)
+			sb.WriteString(s[i:end])
+			i = end
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return placeholderListRegexp.ReplaceAllString(sb.String(), "$1?$2")
+}
+
+// obfuscateJSON replaces values in the JSON-like statement s, such as
+// a MongoDB command or Elasticsearch query, with `?`. Object keys and
+// bare identifiers, such as function names, are retained.
+func obfuscateJSON(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s))
+	var space bool
+	for i := 0; i < len(s); {
+		c := s[i]
+		if isSpaceByte(c) {
+			space = true
+			i++
+			continue
+		}
+		if space && sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+		switch {
+		case c == '"' || c == '\'':
+			end := skipQuoted(s, i, c, true)
+			if isObjectKey(s[end:]) {
+				sb.WriteString(s[i:end])
+			} else {
+				sb.WriteByte('?')
+			}
+			i = end
+		case isDigitByte(c) || c == '-' && i+1 < len(s) && isDigitByte(s[i+1]):
+			i = skipWord(s, i+1)
+			sb.WriteByte('?')
+		case isWordByte(c):
+			end := skipWord(s, i)
+			switch word := s[i:end]; {
+			case isObjectKey(s[end:]):
+				sb.WriteString(word)
+			case word == "true" || word == "false" || word == "null":
+				sb.WriteByte('?')
+			default:
+				sb.WriteString(word)
+			}
+			i = end
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return placeholderListRegexp.ReplaceAllString(sb.String(), "$1?$2")
+}
+
+// obfuscateRedis replaces the arguments of each command in the Redis
+// statement s with `?`, retaining the command names.
+func obfuscateRedis(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			lines[i] = ""
+			continue
+		}
+		lines[i] = fields[0] + strings.Repeat(" ?", len(fields)-1)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isObjectKey reports whether s, which follows a string or
+// identifier, begins with a colon, ignoring whitespace.
+func isObjectKey(s string) bool {
+	s = strings.TrimLeft(s, " \t\r\n")
+	return strings.HasPrefix(s, ":")
+}
+
+// skipQuoted returns the index following the string starting with the
+// quote character at s[i]. Doubled quotes are handled, as are backslash
+// escapes if backslashEscapes is true. If the string is not terminated,
+// len(s) is returned.
+func skipQuoted(s string, i int, quote byte, backslashEscapes bool) int {
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if backslashEscapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// skipWord returns the index of the first non-word byte at or after s[i].
+func skipWord(s string, i int) int {
+	for i < len(s) && (isWordByte(s[i]) || s[i] == '.') {
+		i++
+	}
+	return i
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || isDigitByte(c) ||
+		c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigitByte(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
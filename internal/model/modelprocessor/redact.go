@@ -0,0 +1,334 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/elastic/apm-data/model/modelpb"
+)
+
+var (
+	// defaultRedactionPatterns match email addresses, bearer
+	// tokens, and payment card numbers (PANs).
+	defaultRedactionPatterns = []redactionPattern{
+		{regexp: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+		{regexp: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`)},
+		{regexp: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), valid: luhnValid},
+	}
+
+	// defaultRedactionKeywords match common names of fields holding
+	// secrets, similar to the agents' default sanitize_field_names.
+	defaultRedactionKeywords = []string{
+		"password", "passwd", "pwd", "secret", "token", "session",
+		"credit", "card", "auth", "authorization", "authentication",
+		"cookie", "api_key", "apikey",
+	}
+)
+
+// Redactor is a modelpb.BatchProcessor which masks personal data and
+// secrets in URLs, HTTP headers and cookies, labels, database statements,
+// and log and error messages.
+//
+// Values whose names contain one of the keywords are replaced entirely.
+// Names are split into words at underscores, hyphens, dots, and changes of
+// case, and keywords must match one or more consecutive whole words, so that
+// "auth" matches "X-Auth-Token" and "apikey" matches "apiKey", but "auth"
+// does not match "author". HTTP cookie values are always replaced entirely, as they typically hold
+// session identifiers. Matches of the patterns are replaced in other values.
+type Redactor struct {
+	keywords    []string
+	patterns    []redactionPattern
+	replacement string
+}
+
+type redactionPattern struct {
+	regexp *regexp.Regexp
+
+	// valid, if non-nil, reports whether a match should be redacted.
+	valid func(match string) bool
+}
+
+// RedactorConfig holds configuration for Redactor.
+type RedactorConfig struct {
+	// DefaultRules enables built-in patterns for email addresses,
+	// bearer tokens, and payment card numbers, and built-in keywords
+	// for common names of secrets such as "password" and "token".
+	DefaultRules bool
+
+	// Patterns holds additional regular expressions to redact.
+	Patterns []RedactionPatternConfig
+
+	// Keywords holds additional case-insensitive keywords.
+	Keywords []string
+
+	// Replacement holds the string with which redacted values are replaced.
+	Replacement string
+}
+
+// RedactionPatternConfig holds a named regular expression to redact.
+type RedactionPatternConfig struct {
+	Name  string
+	Regex string
+}
+
+// NewRedactor returns a Redactor for the given configuration.
+func NewRedactor(cfg RedactorConfig) (*Redactor, error) {
+	r := &Redactor{replacement: cfg.Replacement}
+	if cfg.DefaultRules {
+		r.keywords = append(r.keywords, defaultRedactionKeywords...)
+		r.patterns = append(r.patterns, defaultRedactionPatterns...)
+	}
+	r.keywords = append(r.keywords, cfg.Keywords...)
+	for i, keyword := range r.keywords {
+		r.keywords[i] = strings.Join(nameWords(keyword), "")
+	}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex for pattern %q: %w", pattern.Name, err)
+		}
+		r.patterns = append(r.patterns, redactionPattern{regexp: re})
+	}
+	return r, nil
+}
+
+// ProcessBatch redacts each event in b.
+func (r *Redactor) ProcessBatch(ctx context.Context, b *modelpb.Batch) error {
+	for _, event := range *b {
+		r.redactEvent(event)
+	}
+	return nil
+}
+
+func (r *Redactor) redactEvent(event *modelpb.APMEvent) {
+	event.Message = r.redactString(event.Message)
+	if u := event.Url; u != nil {
+		u.Original = r.redactURL(u.Original)
+		u.Full = r.redactURL(u.Full)
+		u.Path = r.redactString(u.Path)
+		u.Query = r.redactQuery(u.Query)
+		u.Fragment = r.redactString(u.Fragment)
+	}
+	if request := event.GetHttp().GetRequest(); request != nil {
+		r.redactHeaders(request.Headers)
+		for _, cookie := range request.Cookies {
+			cookie.Value = structpb.NewStringValue(r.replacement)
+		}
+		request.Referrer = r.redactURL(request.Referrer)
+	}
+	if response := event.GetHttp().GetResponse(); response != nil {
+		r.redactHeaders(response.Headers)
+	}
+	for key, label := range event.Labels {
+		if r.isKeyword(key) {
+			if label.Value != "" {
+				label.Value = r.replacement
+			}
+			for i := range label.Values {
+				label.Values[i] = r.replacement
+			}
+			continue
+		}
+		label.Value = r.redactString(label.Value)
+		for i, value := range label.Values {
+			label.Values[i] = r.redactString(value)
+		}
+	}
+	if db := event.GetSpan().GetDb(); db != nil {
+		db.Statement = r.redactString(db.Statement)
+	}
+	if e := event.Error; e != nil {
+		e.Message = r.redactString(e.Message)
+		r.redactException(e.Exception)
+		if e.Log != nil {
+			e.Log.Message = r.redactString(e.Log.Message)
+		}
+	}
+}
+
+func (r *Redactor) redactException(exception *modelpb.Exception) {
+	if exception == nil {
+		return
+	}
+	exception.Message = r.redactString(exception.Message)
+	for _, cause := range exception.Cause {
+		r.redactException(cause)
+	}
+}
+
+func (r *Redactor) redactHeaders(headers []*modelpb.HTTPHeader) {
+	for _, header := range headers {
+		keyword := r.isKeyword(header.Key)
+		for i, value := range header.Value {
+			if keyword {
+				header.Value[i] = r.replacement
+			} else {
+				header.Value[i] = r.redactString(value)
+			}
+		}
+	}
+}
+
+// redactURL redacts the path, query, and fragment of the URL s.
+// Query parameters are redacted as described for redactQuery.
+func (r *Redactor) redactURL(s string) string {
+	base, fragment, hasFragment := strings.Cut(s, "#")
+	base, query, hasQuery := strings.Cut(base, "?")
+	result := r.redactString(base)
+	if hasQuery {
+		result += "?" + r.redactQuery(query)
+	}
+	if hasFragment {
+		result += "#" + r.redactString(fragment)
+	}
+	return result
+}
+
+// redactQuery redacts the values of query parameters whose names contain
+// a keyword, and matches of the patterns in other query parameter values.
+// Patterns are matched against the unescaped values.
+func (r *Redactor) redactQuery(query string) string {
+	if query == "" {
+		return query
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			params[i] = r.redactString(param)
+			continue
+		}
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if r.isKeyword(name) {
+			params[i] = key + "=" + r.replacement
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil && unescaped != value {
+			if redacted := r.redactString(unescaped); redacted != unescaped {
+				params[i] = key + "=" + url.QueryEscape(redacted)
+			}
+			continue
+		}
+		params[i] = key + "=" + r.redactString(value)
+	}
+	return strings.Join(params, "&")
+}
+
+// redactString replaces matches of the patterns in s.
+func (r *Redactor) redactString(s string) string {
+	if s == "" {
+		return s
+	}
+	for _, pattern := range r.patterns {
+		if pattern.valid == nil {
+			s = pattern.regexp.ReplaceAllLiteralString(s, r.replacement)
+			continue
+		}
+		s = pattern.regexp.ReplaceAllStringFunc(s, func(match string) string {
+			if pattern.valid(match) {
+				return r.replacement
+			}
+			return match
+		})
+	}
+	return s
+}
+
+// isKeyword reports whether one or more consecutive words of name,
+// joined together, match a keyword.
+func (r *Redactor) isKeyword(name string) bool {
+	words := nameWords(name)
+	for i := range words {
+		joined := ""
+		for _, word := range words[i:] {
+			joined += word
+			if slices.Contains(r.keywords, joined) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nameWords splits name into lower-case words at underscores, hyphens,
+// dots, and changes of case, such as in "apiKey" and "HTTPAuth".
+func nameWords(name string) []string {
+	var words []string
+	start := 0
+	add := func(end int) {
+		if end > start {
+			words = append(words, strings.ToLower(name[start:end]))
+		}
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == '_' || c == '-' || c == '.' {
+			add(i)
+			start = i + 1
+			continue
+		}
+		if i == start || !isUpper(c) {
+			continue
+		}
+		// Split before an upper-case letter following a lower-case letter
+		// or digit, or ending a run of upper-case letters before a word.
+		prev := name[i-1]
+		if !isUpper(prev) || (i+1 < len(name) && isLower(name[i+1])) {
+			add(i)
+			start = i
+		}
+	}
+	add(len(name))
+	return words
+}
+
+func isUpper(c byte) bool { return 'A' <= c && c <= 'Z' }
+func isLower(c byte) bool { return 'a' <= c && c <= 'z' }
+
+// luhnValid reports whether the digits in s, ignoring any other
+// characters, have a valid Luhn checksum, as payment card numbers do.
+func luhnValid(s string) bool {
+	var sum, n int
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if n%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
@@ -0,0 +1,176 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestRedactor(t *testing.T) {
+	redactor, err := modelprocessor.NewRedactor(modelprocessor.RedactorConfig{
+		DefaultRules: true,
+		Patterns:     []modelprocessor.RedactionPatternConfig{{Name: "ssn", Regex: `\b\d{3}-\d{2}-\d{4}\b`}},
+		Keywords:     []string{"Customer"},
+		Replacement:  "[REDACTED]",
+	})
+	require.NoError(t, err)
+
+	// Numbers which fail the Luhn check are not redacted.
+	batch := modelpb.Batch{{
+		Message: "user jane@example.com paid with 4111 1111 1111 1111, ref 4111 1111 1111 1112",
+		Url: &modelpb.URL{
+			Original: "/users/jane@example.com?password=hunter2&q=ssn%20123-45-6789&page=2#top",
+			Full:     "https://example.com/search?email=jane%40example.com&access_token=abc",
+			Path:     "/users/jane@example.com",
+			Query:    "password=hunter2&page=2",
+		},
+		Http: &modelpb.HTTP{
+			Request: &modelpb.HTTPRequest{
+				Headers: []*modelpb.HTTPHeader{
+					{Key: "Authorization", Value: []string{"Bearer abc.def"}},
+					{Key: "X-Forwarded-User", Value: []string{"jane@example.com"}},
+					{Key: "Accept", Value: []string{"text/html"}},
+				},
+				Cookies: []*modelpb.KeyValue{
+					{Key: "id", Value: structpb.NewStringValue("abc")},
+				},
+			},
+			Response: &modelpb.HTTPResponse{
+				Headers: []*modelpb.HTTPHeader{{Key: "Set-Cookie", Value: []string{"id=abc"}}},
+			},
+		},
+		Labels: map[string]*modelpb.LabelValue{
+			"customer_id": {Value: "c123"},
+			"note":        {Values: []string{"call 123-45-6789", "ok"}},
+		},
+		Span: &modelpb.Span{Db: &modelpb.DB{
+			Statement: "SELECT * FROM users WHERE email = 'jane@example.com'",
+		}},
+		Error: &modelpb.Error{
+			Exception: &modelpb.Exception{
+				Message: "invalid token Bearer xyz",
+				Cause:   []*modelpb.Exception{{Message: "card 5500-0000-0000-0004 declined"}},
+			},
+			Log: &modelpb.ErrorLog{Message: "failed for jane@example.com"},
+		},
+	}}
+	require.NoError(t, redactor.ProcessBatch(context.Background(), &batch))
+
+	assert.Empty(t, cmp.Diff(modelpb.Batch{{
+		Message: "user [REDACTED] paid with [REDACTED], ref 4111 1111 1111 1112",
+		Url: &modelpb.URL{
+			Original: "/users/[REDACTED]?password=[REDACTED]&q=ssn+%5BREDACTED%5D&page=2#top",
+			Full:     "https://example.com/search?email=%5BREDACTED%5D&access_token=[REDACTED]",
+			Path:     "/users/[REDACTED]",
+			Query:    "password=[REDACTED]&page=2",
+		},
+		Http: &modelpb.HTTP{
+			Request: &modelpb.HTTPRequest{
+				Headers: []*modelpb.HTTPHeader{
+					{Key: "Authorization", Value: []string{"[REDACTED]"}},
+					{Key: "X-Forwarded-User", Value: []string{"[REDACTED]"}},
+					{Key: "Accept", Value: []string{"text/html"}},
+				},
+				Cookies: []*modelpb.KeyValue{
+					{Key: "id", Value: structpb.NewStringValue("[REDACTED]")},
+				},
+			},
+			Response: &modelpb.HTTPResponse{
+				Headers: []*modelpb.HTTPHeader{{Key: "Set-Cookie", Value: []string{"[REDACTED]"}}},
+			},
+		},
+		Labels: map[string]*modelpb.LabelValue{
+			"customer_id": {Value: "[REDACTED]"},
+			"note":        {Values: []string{"call [REDACTED]", "ok"}},
+		},
+		Span: &modelpb.Span{Db: &modelpb.DB{
+			Statement: "SELECT * FROM users WHERE email = '[REDACTED]'",
+		}},
+		Error: &modelpb.Error{
+			Exception: &modelpb.Exception{
+				Message: "invalid token [REDACTED]",
+				Cause:   []*modelpb.Exception{{Message: "card [REDACTED] declined"}},
+			},
+			Log: &modelpb.ErrorLog{Message: "failed for [REDACTED]"},
+		},
+	}}, batch, protocmp.Transform()))
+}
+
+func TestRedactorKeywords(t *testing.T) {
+	redactor, err := modelprocessor.NewRedactor(modelprocessor.RedactorConfig{
+		DefaultRules: true,
+		Keywords:     []string{"Customer_ID"},
+		Replacement:  "***",
+	})
+	require.NoError(t, err)
+
+	for name, redacted := range map[string]bool{
+		"password":            true,
+		"access_token":        true,
+		"X-Auth-Token":        true,
+		"Proxy-Authorization": true,
+		"apiKey":              true,
+		"X-Api-Key":           true,
+		"HTTPAuthHeader":      true,
+		"sessionId":           true,
+		"customerId":          true,
+		"author":              false,
+		"authority":           false,
+		"cardinality":         false,
+		"tokenizer":           false,
+		"customer":            false,
+	} {
+		t.Run(name, func(t *testing.T) {
+			batch := modelpb.Batch{{
+				Labels: map[string]*modelpb.LabelValue{name: {Value: "value"}},
+			}}
+			require.NoError(t, redactor.ProcessBatch(context.Background(), &batch))
+			expect := "value"
+			if redacted {
+				expect = "***"
+			}
+			assert.Equal(t, expect, batch[0].Labels[name].Value)
+		})
+	}
+}
+
+func TestRedactorNoDefaultRules(t *testing.T) {
+	redactor, err := modelprocessor.NewRedactor(modelprocessor.RedactorConfig{
+		Keywords:    []string{"secret"},
+		Replacement: "***",
+	})
+	require.NoError(t, err)
+
+	batch := modelpb.Batch{{
+		Message: "jane@example.com",
+		Url:     &modelpb.URL{Query: "password=hunter2&secret=abc"},
+	}}
+	require.NoError(t, redactor.ProcessBatch(context.Background(), &batch))
+	assert.Equal(t, "jane@example.com", batch[0].Message)
+	assert.Equal(t, "password=hunter2&secret=***", batch[0].Url.Query)
+}
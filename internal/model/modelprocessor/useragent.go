@@ -0,0 +1,244 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"go.opentelemetry.io/otel/metric"
+	"go.yaml.in/yaml/v2"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/go-freelru"
+)
+
+// defaultUserAgentAgentNames holds the names of the RUM and OpenTelemetry
+// browser agents, whose events are parsed if no agent names are configured.
+var defaultUserAgentAgentNames = []string{"rum-js", "js-base", "opentelemetry/webjs"}
+
+//go:embed useragent.yml
+var userAgentDatabaseYAML []byte
+
+var loadUserAgentDatabase = sync.OnceValues(func() (*userAgentDatabase, error) {
+	var db userAgentDatabase
+	if err := yaml.UnmarshalStrict(userAgentDatabaseYAML, &db); err != nil {
+		return nil, err
+	}
+	for _, bot := range db.Bots {
+		re, err := regexp.Compile(bot)
+		if err != nil {
+			return nil, err
+		}
+		db.bots = append(db.bots, re)
+	}
+	for _, rules := range [][]userAgentRule{db.Browsers, db.OS, db.Devices} {
+		for i := range rules {
+			re, err := regexp.Compile(rules[i].Regex)
+			if err != nil {
+				return nil, err
+			}
+			rules[i].regexp = re
+		}
+	}
+	return &db, nil
+})
+
+// userAgentDatabase holds the embedded user agent regex database.
+type userAgentDatabase struct {
+	Bots     []string        `yaml:"bots"`
+	Browsers []userAgentRule `yaml:"browsers"`
+	OS       []userAgentRule `yaml:"os"`
+	Devices  []userAgentRule `yaml:"devices"`
+
+	bots []*regexp.Regexp
+}
+
+type userAgentRule struct {
+	Regex   string `yaml:"regex"`
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+
+	regexp *regexp.Regexp
+}
+
+// userAgentInfo holds the result of parsing a user agent string.
+type userAgentInfo struct {
+	bot        bool
+	name       string
+	version    string
+	osName     string
+	osVersion  string
+	deviceName string
+}
+
+// parse parses s, returning the information from the first matching
+// rule of each kind. Bots are reported with the device name "Spider".
+func (db *userAgentDatabase) parse(s string) userAgentInfo {
+	var info userAgentInfo
+	for _, re := range db.bots {
+		if re.MatchString(s) {
+			info.bot = true
+			info.deviceName = "Spider"
+			break
+		}
+	}
+	info.name, info.version = matchUserAgentRules(db.Browsers, s)
+	info.osName, info.osVersion = matchUserAgentRules(db.OS, s)
+	if !info.bot {
+		info.deviceName, _ = matchUserAgentRules(db.Devices, s)
+	}
+	return info
+}
+
+func matchUserAgentRules(rules []userAgentRule, s string) (name, version string) {
+	for _, rule := range rules {
+		match := rule.regexp.FindStringSubmatchIndex(s)
+		if match == nil {
+			continue
+		}
+		name = string(rule.regexp.ExpandString(nil, rule.Name, s, match))
+		version = string(rule.regexp.ExpandString(nil, rule.Version, s, match))
+		return strings.TrimSpace(name), strings.Trim(version, ".")
+	}
+	return "", ""
+}
+
+// UserAgentParser is a modelpb.BatchProcessor which parses user_agent.original
+// of events from browser agents into the browser name and major version, and
+// the operating system and device, using an embedded regex database. Parsed
+// results are cached.
+//
+// The browser name is recorded in user_agent.name. The event model has no
+// fields for the browser version or the operating system and device of
+// user agents, so these are recorded as labels named after the
+// corresponding ECS fields: user_agent_version, user_agent_os_name,
+// user_agent_os_version, and user_agent_device_name. The browser name
+// is also recorded in the label user_agent_name.
+//
+// Only user_agent_name and user_agent_os_name are global labels, which are
+// included as dimensions in aggregated metrics; the others have too many
+// distinct values to aggregate by.
+//
+// If configured, events from bots and synthetic monitors are dropped,
+// and counted in the metric `apm-server.user_agent.bots.dropped`.
+type UserAgentParser struct {
+	db          *userAgentDatabase
+	agentNames  map[string]bool
+	dropBots    bool
+	cache       *freelru.ShardedLRU[string, userAgentInfo]
+	botsDropped metric.Int64Counter
+}
+
+// UserAgentParserConfig holds configuration for UserAgentParser.
+type UserAgentParserConfig struct {
+	// AgentNames holds the names of agents whose events are parsed.
+	// If empty, events from the RUM and OpenTelemetry browser agents
+	// are parsed.
+	AgentNames []string
+
+	// CacheSize holds the maximum number of parsed user agents to cache.
+	CacheSize int
+
+	// DropBots drops events from known bots and synthetic monitors.
+	DropBots bool
+}
+
+// NewUserAgentParser returns a UserAgentParser for the given configuration.
+func NewUserAgentParser(cfg UserAgentParserConfig, mp metric.MeterProvider) (*UserAgentParser, error) {
+	db, err := loadUserAgentDatabase()
+	if err != nil {
+		return nil, fmt.Errorf("error loading user agent database: %w", err)
+	}
+	cache, err := freelru.NewSharded[string, userAgentInfo](uint32(cfg.CacheSize), hashStringXXHASH)
+	if err != nil {
+		return nil, err
+	}
+	meter := mp.Meter("github.com/elastic/apm-server/internal/model/modelprocessor")
+	botsDropped, err := meter.Int64Counter("apm-server.user_agent.bots.dropped")
+	if err != nil {
+		return nil, err
+	}
+	agentNames := cfg.AgentNames
+	if len(agentNames) == 0 {
+		agentNames = defaultUserAgentAgentNames
+	}
+	p := &UserAgentParser{
+		db:          db,
+		agentNames:  make(map[string]bool, len(agentNames)),
+		dropBots:    cfg.DropBots,
+		cache:       cache,
+		botsDropped: botsDropped,
+	}
+	for _, name := range agentNames {
+		p.agentNames[name] = true
+	}
+	return p, nil
+}
+
+func hashStringXXHASH(s string) uint32 {
+	return uint32(xxhash.Sum64String(s))
+}
+
+// ProcessBatch parses the user agents of events in b,
+// removing events from bots if configured to do so.
+func (p *UserAgentParser) ProcessBatch(ctx context.Context, b *modelpb.Batch) error {
+	events := (*b)[:0]
+	for _, event := range *b {
+		if !p.process(event) {
+			events = append(events, event)
+		}
+	}
+	if dropped := len(*b) - len(events); dropped > 0 {
+		p.botsDropped.Add(ctx, int64(dropped))
+	}
+	clear((*b)[len(events):])
+	*b = events
+	return nil
+}
+
+// process parses the user agent of event, returning true
+// if the event is from a bot and should be dropped.
+func (p *UserAgentParser) process(event *modelpb.APMEvent) bool {
+	original := event.GetUserAgent().GetOriginal()
+	if original == "" || !p.agentNames[event.GetAgent().GetName()] {
+		return false
+	}
+	info, ok := p.cache.Get(original)
+	if !ok {
+		info = p.db.parse(original)
+		p.cache.Add(original, info)
+	}
+	if info.bot && p.dropBots {
+		return true
+	}
+	if info.name != "" && event.UserAgent.Name == "" {
+		event.UserAgent.Name = info.name
+	}
+	setLabel(event, "user_agent_name", info.name, true)
+	setLabel(event, "user_agent_version", info.version, false)
+	setLabel(event, "user_agent_os_name", info.osName, true)
+	setLabel(event, "user_agent_os_version", info.osVersion, false)
+	setLabel(event, "user_agent_device_name", info.deviceName, false)
+	return false
+}
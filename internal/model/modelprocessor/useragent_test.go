@@ -0,0 +1,167 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestUserAgentParser(t *testing.T) {
+	parser, err := modelprocessor.NewUserAgentParser(modelprocessor.UserAgentParserConfig{CacheSize: 10}, sdkmetric.NewMeterProvider())
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		userAgent string
+		labels    map[string]string
+	}{{
+		userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		labels: map[string]string{
+			"user_agent_name":       "Chrome",
+			"user_agent_version":    "120",
+			"user_agent_os_name":    "Windows",
+			"user_agent_os_version": "10",
+		},
+	}, {
+		userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+		labels: map[string]string{
+			"user_agent_name":       "Edge",
+			"user_agent_version":    "120",
+			"user_agent_os_name":    "Windows",
+			"user_agent_os_version": "10",
+		},
+	}, {
+		userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+		labels: map[string]string{
+			"user_agent_name":        "Safari",
+			"user_agent_version":     "17",
+			"user_agent_os_name":     "Mac OS X",
+			"user_agent_os_version":  "10.15",
+			"user_agent_device_name": "Mac",
+		},
+	}, {
+		userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+		labels: map[string]string{
+			"user_agent_name":        "Mobile Safari",
+			"user_agent_version":     "17",
+			"user_agent_os_name":     "iOS",
+			"user_agent_os_version":  "17.2",
+			"user_agent_device_name": "iPhone",
+		},
+	}, {
+		userAgent: "Mozilla/5.0 (Linux; Android 13; Pixel 7 Build/TQ3A.230901.001) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+		labels: map[string]string{
+			"user_agent_name":        "Chrome Mobile",
+			"user_agent_version":     "120",
+			"user_agent_os_name":     "Android",
+			"user_agent_os_version":  "13",
+			"user_agent_device_name": "Pixel 7",
+		},
+	}, {
+		userAgent: "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+		labels: map[string]string{
+			"user_agent_name":    "Firefox",
+			"user_agent_version": "121",
+			"user_agent_os_name": "Ubuntu",
+		},
+	}, {
+		userAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		labels: map[string]string{
+			"user_agent_device_name": "Spider",
+		},
+	}, {
+		userAgent: "curl/8.4.0",
+		labels:    map[string]string{},
+	}} {
+		t.Run(test.userAgent, func(t *testing.T) {
+			batch := modelpb.Batch{{
+				Agent:     &modelpb.Agent{Name: "rum-js"},
+				UserAgent: &modelpb.UserAgent{Original: test.userAgent},
+			}}
+			require.NoError(t, parser.ProcessBatch(context.Background(), &batch))
+			require.Len(t, batch, 1)
+			labels := make(map[string]string)
+			for key, value := range batch[0].Labels {
+				global := key == "user_agent_name" || key == "user_agent_os_name"
+				assert.Equal(t, global, value.Global, key)
+				labels[key] = value.Value
+			}
+			assert.Equal(t, test.labels, labels)
+			assert.Equal(t, test.labels["user_agent_name"], batch[0].UserAgent.Name)
+		})
+	}
+}
+
+func TestUserAgentParserAgentNames(t *testing.T) {
+	parser, err := modelprocessor.NewUserAgentParser(modelprocessor.UserAgentParserConfig{CacheSize: 10}, sdkmetric.NewMeterProvider())
+	require.NoError(t, err)
+
+	// Events from backend agents are not parsed by default.
+	batch := modelpb.Batch{{
+		Agent:     &modelpb.Agent{Name: "java"},
+		UserAgent: &modelpb.UserAgent{Original: "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"},
+	}, {
+		Agent:     &modelpb.Agent{Name: "opentelemetry/webjs"},
+		UserAgent: &modelpb.UserAgent{Original: "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"},
+	}}
+	require.NoError(t, parser.ProcessBatch(context.Background(), &batch))
+	assert.Empty(t, batch[0].UserAgent.Name)
+	assert.Equal(t, "Firefox", batch[1].UserAgent.Name)
+}
+
+func TestUserAgentParserDropBots(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	parser, err := modelprocessor.NewUserAgentParser(modelprocessor.UserAgentParserConfig{
+		AgentNames: []string{"rum-js"},
+		CacheSize:  10,
+		DropBots:   true,
+	}, sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	require.NoError(t, err)
+
+	newEvent := func(userAgent string) *modelpb.APMEvent {
+		return &modelpb.APMEvent{
+			Agent:     &modelpb.Agent{Name: "rum-js"},
+			UserAgent: &modelpb.UserAgent{Original: userAgent},
+		}
+	}
+	batch := modelpb.Batch{
+		newEvent("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36"),
+		newEvent("Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"),
+		newEvent("Pingdom.com_bot_version_1.4_(http://www.pingdom.com/)"),
+		newEvent("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Elastic/Synthetics"),
+	}
+	require.NoError(t, parser.ProcessBatch(context.Background(), &batch))
+	require.Len(t, batch, 1)
+	assert.Equal(t, "Firefox", batch[0].UserAgent.Name)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	require.Len(t, rm.ScopeMetrics[0].Metrics, 1)
+	metric := rm.ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, "apm-server.user_agent.bots.dropped", metric.Name)
+	assert.Equal(t, int64(3), metric.Data.(metricdata.Sum[int64]).DataPoints[0].Value)
+}
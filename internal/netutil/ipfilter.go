@@ -0,0 +1,65 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package netutil
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// IPFilter allows or denies IP addresses by network prefix.
+//
+// An address is denied if it matches any deny prefix. Otherwise, if there
+// are any allow prefixes, the address is allowed only if it matches one of
+// them. A nil *IPFilter allows all addresses.
+type IPFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// NewIPFilter returns a new IPFilter with the given lists of CIDR prefixes or
+// IP addresses. If both lists are empty, NewIPFilter returns nil.
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	allowPrefixes, err := parsePrefixes(allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow rule: %w", err)
+	}
+	denyPrefixes, err := parsePrefixes(deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny rule: %w", err)
+	}
+	if len(allowPrefixes) == 0 && len(denyPrefixes) == 0 {
+		return nil, nil
+	}
+	return &IPFilter{allow: allowPrefixes, deny: denyPrefixes}, nil
+}
+
+// Allowed reports whether ip is allowed by the filter. If ip is invalid,
+// it is allowed only if the filter has no allow prefixes.
+func (f *IPFilter) Allowed(ip netip.Addr) bool {
+	if f == nil {
+		return true
+	}
+	if ip.IsValid() && prefixesContain(f.deny, ip) {
+		return false
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	return ip.IsValid() && prefixesContain(f.allow, ip)
+}
@@ -0,0 +1,70 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package netutil
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPFilter(t *testing.T) {
+	filter, err := NewIPFilter([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.1.0.0/16", "10.2.3.4"})
+	require.NoError(t, err)
+	for ip, allowed := range map[string]bool{
+		"10.0.0.1":        true,
+		"::ffff:10.0.0.1": true,
+		"2001:db8::1":     true,
+		"10.1.2.3":        false,
+		"10.2.3.4":        false,
+		"::ffff:10.2.3.4": false,
+		"192.168.0.1":     false,
+		"2001:db9::1":     false,
+		"10.2.3.5":        true,
+		"::1":             false,
+		"1.2.3.4":         false,
+	} {
+		assert.Equal(t, allowed, filter.Allowed(netip.MustParseAddr(ip)), ip)
+	}
+	assert.False(t, filter.Allowed(netip.Addr{}))
+}
+
+func TestIPFilterDenyOnly(t *testing.T) {
+	filter, err := NewIPFilter(nil, []string{"192.168.0.0/16"})
+	require.NoError(t, err)
+	assert.True(t, filter.Allowed(netip.MustParseAddr("10.0.0.1")))
+	assert.False(t, filter.Allowed(netip.MustParseAddr("192.168.1.1")))
+	assert.True(t, filter.Allowed(netip.Addr{}))
+}
+
+func TestIPFilterEmpty(t *testing.T) {
+	filter, err := NewIPFilter(nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, filter)
+	assert.True(t, filter.Allowed(netip.MustParseAddr("10.0.0.1")))
+	assert.True(t, filter.Allowed(netip.Addr{}))
+}
+
+func TestNewIPFilterInvalid(t *testing.T) {
+	_, err := NewIPFilter([]string{"10.0.0.0/33"}, nil)
+	assert.EqualError(t, err, `invalid allow rule: "10.0.0.0/33": netip.ParsePrefix("10.0.0.0/33"): prefix length out of range`)
+	_, err = NewIPFilter(nil, []string{"host.invalid"})
+	assert.ErrorContains(t, err, `invalid deny rule: "host.invalid"`)
+}
@@ -18,8 +18,10 @@
 package netutil
 
 import (
+	"fmt"
 	"net/http"
 	"net/netip"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -32,6 +34,7 @@ import (
 // If the client is able to control the headers, they can control the result of this
 // function. The result should therefore not necessarily be trusted to be correct;
 // that depends on the presence and configuration of proxies in front of apm-server.
+// Use TrustedProxies.ClientAddrFromHeaders to only trust headers set by known proxies.
 func ClientAddrFromHeaders(header http.Header) (ip netip.Addr, port uint16) {
 	for _, parse := range parseHeadersInOrder {
 		if ip, port := parse(header); ip.IsValid() {
@@ -41,6 +44,175 @@ func ClientAddrFromHeaders(header http.Header) (ip netip.Addr, port uint16) {
 	return netip.Addr{}, 0
 }
 
+// trustedProxyHeaders holds the lower-case names of the headers which
+// trusted proxies may set, in the order in which they are considered.
+var trustedProxyHeaders = []string{"forwarded", "x-real-ip", "x-forwarded-for"}
+
+// TrustedProxies holds the network prefixes of proxies which are trusted
+// to set the Forwarded, X-Real-IP, and X-Forwarded-For headers, and
+// optionally the single header which they set.
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+
+	// header holds the lower-case name of the only header which is
+	// considered, or is empty if all headers are considered.
+	header string
+}
+
+// ParseTrustedProxies parses a list of CIDR prefixes or IP addresses
+// into TrustedProxies.
+//
+// If header is non-empty, it must be one of Forwarded, X-Real-IP, or
+// X-Forwarded-For, and only that header is considered. A header may
+// only be specified along with trusted proxies.
+func ParseTrustedProxies(in []string, header string) (TrustedProxies, error) {
+	prefixes, err := parsePrefixes(in)
+	if err != nil {
+		return TrustedProxies{}, fmt.Errorf("invalid trusted proxy %w", err)
+	}
+	if header != "" {
+		if len(prefixes) == 0 {
+			return TrustedProxies{}, fmt.Errorf("trusted proxies header %q specified without trusted proxies", header)
+		}
+		if !slices.Contains(trustedProxyHeaders, strings.ToLower(header)) {
+			return TrustedProxies{}, fmt.Errorf(
+				"invalid trusted proxies header %q, expected one of Forwarded, X-Real-IP, or X-Forwarded-For", header,
+			)
+		}
+	}
+	return TrustedProxies{prefixes: prefixes, header: strings.ToLower(header)}, nil
+}
+
+// parsePrefixes parses a list of CIDR prefixes or IP addresses. IP addresses
+// are converted to single-address prefixes, and IPv4-mapped IPv6 addresses
+// are unmapped.
+func parsePrefixes(in []string) ([]netip.Prefix, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make([]netip.Prefix, len(in))
+	for i, s := range in {
+		if strings.ContainsRune(s, '/') {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", s, err)
+			}
+			out[i] = prefix.Masked()
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", s, err)
+		}
+		addr = addr.Unmap()
+		out[i] = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	return out, nil
+}
+
+// prefixesContain reports whether any of prefixes contains ip.
+func prefixesContain(prefixes []netip.Prefix, ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains reports whether ip is the address of a trusted proxy.
+func (t TrustedProxies) Contains(ip netip.Addr) bool {
+	return prefixesContain(t.prefixes, ip)
+}
+
+// ClientAddrFromHeaders returns the IP address, and optionally port, of the client
+// for an HTTP request received from peer, from the same headers as the package-level
+// ClientAddrFromHeaders function.
+//
+// If t is empty, all headers are trusted and the result is identical to that of
+// the package-level ClientAddrFromHeaders. Otherwise the headers are ignored unless
+// peer is a trusted proxy, and each header is walked from right to left, skipping
+// trusted proxies, to find the first address that is not a trusted proxy. If every
+// address is a trusted proxy, the left-most address is used.
+//
+// If t specifies a header, only that header is considered. Otherwise, as a client may
+// send any of the headers to a proxy which only sets one of them, the result is
+// unknown (an invalid address) if the headers which are present resolve to different
+// addresses. The result is also unknown if any header which is considered is present,
+// but an invalid address is encountered before an untrusted one.
+func (t TrustedProxies) ClientAddrFromHeaders(header http.Header, peer netip.Addr) (netip.Addr, uint16) {
+	if len(t.prefixes) == 0 {
+		return ClientAddrFromHeaders(header)
+	}
+	if !t.Contains(peer) {
+		return netip.Addr{}, 0
+	}
+	if t.header != "" {
+		ip, port, _ := t.parseHeader(header, t.header)
+		return ip, port
+	}
+	var clientIP netip.Addr
+	var clientPort uint16
+	for _, name := range trustedProxyHeaders {
+		ip, port, ok := t.parseHeader(header, name)
+		if !ok {
+			continue
+		}
+		if !ip.IsValid() || (clientIP.IsValid() && ip != clientIP) {
+			return netip.Addr{}, 0
+		}
+		if !clientIP.IsValid() {
+			clientIP, clientPort = ip, port
+		}
+	}
+	return clientIP, clientPort
+}
+
+// parseHeader walks the hops of the header with the given lower-case name,
+// returning the client address and reporting whether the header is present.
+func (t TrustedProxies) parseHeader(header http.Header, name string) (netip.Addr, uint16, bool) {
+	values := getHeaderValues(header, http.CanonicalHeaderKey(name), name)
+	if len(values) == 0 {
+		return netip.Addr{}, 0, false
+	}
+	hops := splitHeaderList(values)
+	if name == "forwarded" {
+		for i, element := range hops {
+			hops[i] = parseForwarded(element).For
+		}
+	}
+	ip, port := t.walkHops(hops)
+	return ip, port, true
+}
+
+// walkHops walks hops from right to left, returning the first address
+// that is not a trusted proxy, or the left-most address.
+func (t TrustedProxies) walkHops(hops []string) (netip.Addr, uint16) {
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, port := SplitAddrPort(hops[i])
+		if !ip.IsValid() {
+			return netip.Addr{}, 0
+		}
+		if i == 0 || !t.Contains(ip) {
+			return ip, port
+		}
+	}
+	return netip.Addr{}, 0
+}
+
+// splitHeaderList splits comma-separated header values into a single list,
+// trimming whitespace from each element.
+func splitHeaderList(values []string) []string {
+	var out []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			out = append(out, strings.TrimSpace(element))
+		}
+	}
+	return out
+}
+
 var parseHeadersInOrder = []func(http.Header) (netip.Addr, uint16){
 	parseForwardedHeader,
 	parseXRealIP,
@@ -84,6 +256,14 @@ func getHeader(header http.Header, key, keyLower string) string {
 	return ""
 }
 
+// getHeaderValues is like getHeader, but returns all values for the header.
+func getHeaderValues(header http.Header, key, keyLower string) []string {
+	if v := header.Values(key); len(v) > 0 {
+		return v
+	}
+	return header[keyLower]
+}
+
 // forwardedHeader holds information extracted from a "Forwarded" HTTP header.
 type forwardedHeader struct {
 	For   string
@@ -136,7 +316,7 @@ func parseForwarded(f string) forwardedHeader {
 }
 
 // SplitAddrPort splits a network address of the form "host",
-// "host:port", "[host]:port" or "[host]:port" into a netip.Addr
+// "host:port", "[host]" or "[host]:port" into a netip.Addr
 // and port.
 //
 // If input has no port, 0 will be returned for the port.
@@ -147,6 +327,14 @@ func SplitAddrPort(in string) (netip.Addr, uint16) {
 		return netip.Addr{}, 0
 	}
 
+	// [host]
+	if in[0] == '[' && in[len(in)-1] == ']' {
+		if addr, err := netip.ParseAddr(in[1 : len(in)-1]); err == nil {
+			return addr, 0
+		}
+		return netip.Addr{}, 0
+	}
+
 	// [host]:port or host:port
 	if in[0] == '[' || (strings.Contains(in, ".") && strings.Contains(in, ":")) {
 		if addr, err := netip.ParseAddrPort(in); err == nil {
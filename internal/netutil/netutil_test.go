@@ -19,6 +19,7 @@ package netutil
 
 import (
 	"net/http"
+	"net/netip"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -99,6 +100,163 @@ func TestClientAddrFromHeaders(t *testing.T) {
 	}
 }
 
+func TestTrustedProxiesClientAddrFromHeaders(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"}, "")
+	require.NoError(t, err)
+
+	proxy := netip.MustParseAddr("10.0.0.1")
+	for name, tc := range map[string]struct {
+		header http.Header
+		peer   netip.Addr
+		ip     string
+		port   uint16
+	}{
+		"untrusted peer": {
+			header: http.Header{headerXForwardedFor: []string{"123.0.0.1"}},
+			peer:   netip.MustParseAddr("1.2.3.4"),
+		},
+		"untrusted peer X-Real-IP": {
+			header: http.Header{headerXRealIP: []string{"123.0.0.1"}},
+			peer:   netip.MustParseAddr("1.2.3.4"),
+		},
+		"trusted peer X-Real-IP": {
+			header: http.Header{headerXRealIP: []string{"123.0.0.1:6060"}},
+			peer:   proxy,
+			ip:     "123.0.0.1",
+			port:   6060,
+		},
+		"IPv4-mapped trusted peer": {
+			header: http.Header{headerXForwardedFor: []string{"123.0.0.1"}},
+			peer:   netip.MustParseAddr("::ffff:10.0.0.1"),
+			ip:     "123.0.0.1",
+		},
+		"X-Forwarded-For spoofed": {
+			header: http.Header{headerXForwardedFor: []string{"6.6.6.6, 123.0.0.1"}},
+			peer:   proxy,
+			ip:     "123.0.0.1",
+		},
+		"X-Forwarded-For trusted hops": {
+			header: http.Header{headerXForwardedFor: []string{"6.6.6.6, 123.0.0.1, 192.168.1.1", "10.1.1.1"}},
+			peer:   proxy,
+			ip:     "123.0.0.1",
+		},
+		"X-Forwarded-For all trusted": {
+			header: http.Header{headerXForwardedFor: []string{"10.1.1.2, 10.1.1.1"}},
+			peer:   proxy,
+			ip:     "10.1.1.2",
+		},
+		"X-Forwarded-For invalid hop": {
+			header: http.Header{headerXForwardedFor: []string{"123.0.0.1, invalid, 10.1.1.1"}},
+			peer:   proxy,
+		},
+		"Forwarded trusted hops": {
+			header: http.Header{headerForwarded: []string{`for=6.6.6.6, for="[2001:db9::1]:4711";proto=https, for="[2001:db8::1]"`}},
+			peer:   proxy,
+			ip:     "2001:db9::1",
+			port:   4711,
+		},
+		"Forwarded invalid does not fall back": {
+			header: http.Header{
+				headerForwarded:     []string{"for=unknown"},
+				headerXForwardedFor: []string{"123.0.0.1"},
+			},
+			peer: proxy,
+		},
+		"X-Real-IP trusted hops": {
+			header: http.Header{headerXRealIP: []string{"123.0.0.1, 10.1.1.1"}},
+			peer:   proxy,
+			ip:     "123.0.0.1",
+		},
+		"headers agree": {
+			header: http.Header{
+				headerXRealIP:       []string{"123.0.0.1"},
+				headerXForwardedFor: []string{"6.6.6.6, 123.0.0.1"},
+			},
+			peer: proxy,
+			ip:   "123.0.0.1",
+		},
+		"X-Real-IP forged": {
+			// The client sent X-Real-IP, and the proxy appended
+			// the client's address to X-Forwarded-For.
+			header: http.Header{
+				headerXRealIP:       []string{"6.6.6.6"},
+				headerXForwardedFor: []string{"123.0.0.1"},
+			},
+			peer: proxy,
+		},
+		"gRPC Metadata": {
+			header: http.Header{"x-forwarded-for": []string{"6.6.6.6, 123.0.0.1"}},
+			peer:   proxy,
+			ip:     "123.0.0.1",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ip, port := trusted.ClientAddrFromHeaders(tc.header, tc.peer)
+			if tc.ip == "" {
+				assert.False(t, ip.IsValid())
+			} else {
+				assert.Equal(t, tc.ip, ip.String())
+			}
+			assert.Equal(t, tc.port, port)
+		})
+	}
+}
+
+func TestTrustedProxiesHeader(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8"}, "X-Forwarded-For")
+	require.NoError(t, err)
+	proxy := netip.MustParseAddr("10.0.0.1")
+
+	// Only the configured header is considered, so a forged
+	// X-Real-IP or Forwarded header sent by the client is ignored.
+	ip, _ := trusted.ClientAddrFromHeaders(http.Header{
+		headerForwarded:     []string{"for=6.6.6.6"},
+		headerXRealIP:       []string{"6.6.6.6"},
+		headerXForwardedFor: []string{"6.6.6.6, 123.0.0.1"},
+	}, proxy)
+	assert.Equal(t, "123.0.0.1", ip.String())
+
+	// Other headers are not used in the absence of the configured header.
+	ip, _ = trusted.ClientAddrFromHeaders(http.Header{headerXRealIP: []string{"6.6.6.6"}}, proxy)
+	assert.False(t, ip.IsValid())
+
+	// gRPC metadata uses lower-case keys.
+	ip, _ = trusted.ClientAddrFromHeaders(http.Header{"x-forwarded-for": []string{"123.0.0.1"}}, proxy)
+	assert.Equal(t, "123.0.0.1", ip.String())
+}
+
+func TestTrustedProxiesEmpty(t *testing.T) {
+	// With no trusted proxies, all headers are trusted.
+	var trusted TrustedProxies
+	ip, _ := trusted.ClientAddrFromHeaders(
+		http.Header{headerXForwardedFor: []string{"6.6.6.6, 123.0.0.1"}},
+		netip.MustParseAddr("1.2.3.4"),
+	)
+	assert.Equal(t, "6.6.6.6", ip.String())
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.1.2.3/8", "::1", "::ffff:192.168.0.1"}, "X-Real-IP")
+	require.NoError(t, err)
+	assert.Equal(t, TrustedProxies{
+		prefixes: []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("::1/128"),
+			netip.MustParsePrefix("192.168.0.1/32"),
+		},
+		header: "x-real-ip",
+	}, trusted)
+
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/33"}, "")
+	assert.EqualError(t, err, `invalid trusted proxy "10.0.0.0/33": netip.ParsePrefix("10.0.0.0/33"): prefix length out of range`)
+	_, err = ParseTrustedProxies([]string{"proxy.invalid"}, "")
+	assert.Error(t, err)
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/8"}, "True-Client-IP")
+	assert.EqualError(t, err, `invalid trusted proxies header "True-Client-IP", expected one of Forwarded, X-Real-IP, or X-Forwarded-For`)
+	_, err = ParseTrustedProxies(nil, "X-Forwarded-For")
+	assert.EqualError(t, err, `trusted proxies header "X-Forwarded-For" specified without trusted proxies`)
+}
+
 func TestParseForwarded(t *testing.T) {
 	type test struct {
 		name   string
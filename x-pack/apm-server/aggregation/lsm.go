@@ -7,6 +7,7 @@ package aggregation
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"go.opentelemetry.io/otel"
@@ -20,7 +21,11 @@ import (
 )
 
 type Aggregator struct {
-	logger         *zap.Logger
+	logger        *zap.Logger
+	nextProcessor modelpb.BatchProcessor
+
+	mu             sync.RWMutex
+	stopped        bool
 	baseaggregator *aggregators.Aggregator
 }
 
@@ -29,8 +34,21 @@ func New(
 	maxSvcs, maxTxGroups, maxSvcTxGroups, maxSpanGroups int,
 	nextProcessor modelpb.BatchProcessor, logger *logp.Logger,
 ) (*Aggregator, error) {
-	zapLogger := zap.New(logger.Core(), zap.WithCaller(true)).Named("aggregator")
+	agg := &Aggregator{
+		logger:        zap.New(logger.Core(), zap.WithCaller(true)).Named("aggregator"),
+		nextProcessor: nextProcessor,
+	}
+	baseaggregator, err := agg.newBaseAggregator(maxSvcs, maxTxGroups, maxSvcTxGroups, maxSpanGroups)
+	if err != nil {
+		return nil, err
+	}
+	agg.baseaggregator = baseaggregator
+	return agg, nil
+}
 
+func (a *Aggregator) newBaseAggregator(
+	maxSvcs, maxTxGroups, maxSvcTxGroups, maxSpanGroups int,
+) (*aggregators.Aggregator, error) {
 	baseaggregator, err := aggregators.New(
 		aggregators.WithLimits(aggregators.Limits{
 			MaxSpanGroups:                         maxSpanGroups,
@@ -41,36 +59,79 @@ func New(
 			MaxServiceTransactionGroupsPerService: max(maxSvcTxGroups/10, 1),
 			MaxServices:                           maxSvcs,
 		}),
-		aggregators.WithProcessor(wrapNextProcessor(nextProcessor)),
+		aggregators.WithProcessor(wrapNextProcessor(a.nextProcessor)),
 		aggregators.WithAggregationIntervals([]time.Duration{time.Minute, 10 * time.Minute, time.Hour}),
-		aggregators.WithLogger(zapLogger),
+		aggregators.WithLogger(a.logger),
 		aggregators.WithMeter(otel.GetMeterProvider().Meter("aggregator")),
 		aggregators.WithTracer(otel.GetTracerProvider().Tracer("aggregator")),
 		aggregators.WithInMemory(true),
 		aggregators.WithOverflowLogging(true),
 	)
-
 	if err != nil {
 		return nil, fmt.Errorf("failed to create base aggregator: %w", err)
 	}
-	agg := &Aggregator{
-		logger:         zapLogger,
-		baseaggregator: baseaggregator,
-	}
-	return agg, err
+	return baseaggregator, nil
 }
 
 // Run runs all the components of aggregator.
+//
+// Run continues running across calls to SetLimits, and returns once
+// Stop is called.
 func (a *Aggregator) Run() error {
-	if err := a.baseaggregator.Run(context.Background()); err != aggregators.ErrAggregatorClosed {
+	for {
+		a.mu.RLock()
+		baseaggregator := a.baseaggregator
+		a.mu.RUnlock()
+
+		if err := baseaggregator.Run(context.Background()); err != aggregators.ErrAggregatorClosed {
+			return err
+		}
+
+		a.mu.RLock()
+		done := a.stopped || a.baseaggregator == baseaggregator
+		a.mu.RUnlock()
+		if done {
+			return nil
+		}
+	}
+}
+
+// SetLimits replaces the aggregator with one using the given limits.
+//
+// The limits of an aggregator are fixed on creation, so the replaced
+// aggregator is closed, harvesting its metrics. Metrics for the longer
+// aggregation intervals may therefore be published for a partial interval.
+func (a *Aggregator) SetLimits(
+	ctx context.Context,
+	maxSvcs, maxTxGroups, maxSvcTxGroups, maxSpanGroups int,
+) error {
+	baseaggregator, err := a.newBaseAggregator(maxSvcs, maxTxGroups, maxSvcTxGroups, maxSpanGroups)
+	if err != nil {
 		return err
 	}
+	a.mu.Lock()
+	if a.stopped {
+		a.mu.Unlock()
+		return baseaggregator.Close(ctx)
+	}
+	old := a.baseaggregator
+	a.baseaggregator = baseaggregator
+	a.mu.Unlock()
+
+	if err := old.Close(ctx); err != nil {
+		return fmt.Errorf("failed to close replaced aggregator: %w", err)
+	}
 	return nil
 }
 
 // Stop stops all the component of aggregator.
 func (a *Aggregator) Stop(ctx context.Context) error {
-	err := a.baseaggregator.Close(ctx)
+	a.mu.Lock()
+	a.stopped = true
+	baseaggregator := a.baseaggregator
+	a.mu.Unlock()
+
+	err := baseaggregator.Close(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to stop aggregator: %w", err)
 	}
@@ -83,6 +144,8 @@ func (a *Aggregator) ProcessBatch(ctx context.Context, b *modelpb.Batch) error {
 	for _, e := range *b {
 		removeRUMGlobalLabels(e)
 	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	return a.baseaggregator.AggregateBatch(ctx, [16]byte{}, b)
 }
 
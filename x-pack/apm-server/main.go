@@ -8,8 +8,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"net/http"
 	"os"
+	"reflect"
 	"sync"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"go.opentelemetry.io/otel/metric"
@@ -23,14 +27,19 @@ import (
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/paths"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 
 	"github.com/elastic/apm-data/model/modelpb"
 	"github.com/elastic/apm-data/model/modelprocessor"
 	"github.com/elastic/apm-server/internal/beatcmd"
 	"github.com/elastic/apm-server/internal/beater"
+	beaterconfig "github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation"
 	"github.com/elastic/apm-server/x-pack/apm-server/sampling"
 	"github.com/elastic/apm-server/x-pack/apm-server/sampling/eventstorage"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/forwarding"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/transport"
 )
 
 const (
@@ -97,11 +106,176 @@ func newProcessors(args beater.ServerParams) ([]namedProcessor, error) {
 		if err != nil {
 			return nil, fmt.Errorf("error creating %s: %w", name, err)
 		}
+		if args.Config.Sampling.Tail.Forwarding.Enabled {
+			// Route trace events to the server owning their trace ID
+			// before tail-sampling, so each trace is sampled by one server.
+			const name = "tail sampling forwarder"
+			forwarder, err := newTailSamplingForwarder(args, modelprocessor.Chained{sampler, args.BatchProcessor})
+			if err != nil {
+				return nil, fmt.Errorf("error creating %s: %w", name, err)
+			}
+			processors = append(processors, namedProcessor{name: name, processor: forwarder})
+		}
 		processors = append(processors, namedProcessor{name: name, processor: sampler})
 	}
 	return processors, nil
 }
 
+func newTailSamplingForwarder(args beater.ServerParams, next modelpb.BatchProcessor) (*forwarding.Forwarder, error) {
+	cfg := args.Config.Sampling.Tail.Forwarding
+	transportConfig, err := samplingTransportConfig(cfg.TLS, cfg.ClientTLS, cfg.SecretToken, args.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS config: %w", err)
+	}
+	if transportConfig.IPFilter, err = cfg.IPFilter.Filter(); err != nil {
+		return nil, err
+	}
+	if !transportConfig.Secure() {
+		args.Logger.Warn("tail-sampling forwarding service is unauthenticated and unencrypted; configure ssl or secret_token unless it is only exposed on a private network")
+	}
+	return forwarding.NewForwarder(forwarding.Config{
+		Next:              next,
+		MeterProvider:     args.MeterProvider,
+		Logger:            args.Logger,
+		ListenAddress:     cfg.ListenAddress,
+		AdvertiseAddress:  cfg.AdvertiseAddress,
+		Peers:             cfg.Hosts,
+		DNSName:           cfg.DNSName,
+		DiscoveryInterval: cfg.DiscoveryInterval,
+		HandoffPeriod:     cfg.HandoffPeriod,
+		BatchSize:         cfg.BatchSize,
+		FlushInterval:     cfg.FlushInterval,
+		QueueSize:         cfg.QueueSize,
+		MaxRetries:        cfg.MaxRetries,
+		Transport:         transportConfig,
+	})
+}
+
+// tailSamplingPolicies returns the tail-sampling policies and shadow policies,
+// loading them from the policies file if one is configured, and otherwise
+// using the policies defined inline.
+func tailSamplingPolicies(cfg beaterconfig.TailSamplingConfig) (policies, shadowPolicies []sampling.Policy, _ error) {
+	in := beaterconfig.TailSamplingPolicies{Policies: cfg.Policies, ShadowPolicies: cfg.ShadowPolicies}
+	if cfg.PoliciesFile.Path != "" {
+		var err error
+		in, err = beaterconfig.LoadTailSamplingPolicies(paths.Resolve(paths.Config, cfg.PoliciesFile.Path))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load tail-sampling policies: %w", err)
+		}
+	}
+	return samplingPolicies(in.Policies), samplingPolicies(in.ShadowPolicies), nil
+}
+
+// watchTailSamplingPoliciesFile returns a function which polls the policies
+// file every reload period until its context is cancelled, updating the
+// sampler's policies when the file's modification time or size changes.
+//
+// Errors loading or applying the policies are logged, and the sampler
+// continues to use its existing policies.
+func watchTailSamplingPoliciesFile(
+	cfg beaterconfig.TailSamplingPoliciesFileConfig,
+	sampler *sampling.Processor,
+	logger *logp.Logger,
+) func(context.Context) error {
+	path := paths.Resolve(paths.Config, cfg.Path)
+	var modTime time.Time
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+	return func(ctx context.Context) error {
+		ticker := time.NewTicker(cfg.ReloadPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				logger.With(logp.Error(err)).Warn("failed to stat tail-sampling policies file")
+				continue
+			}
+			if info.ModTime().Equal(modTime) && info.Size() == size {
+				continue
+			}
+			// Record the file's state before loading, so invalid
+			// policies are reported only once for each change.
+			modTime, size = info.ModTime(), info.Size()
+			policies, err := beaterconfig.LoadTailSamplingPolicies(path)
+			if err == nil {
+				err = sampler.UpdatePolicies(
+					samplingPolicies(policies.Policies),
+					samplingPolicies(policies.ShadowPolicies),
+				)
+			}
+			if err != nil {
+				logger.With(logp.Error(err)).Error("failed to reload tail-sampling policies file, retaining existing policies")
+			}
+		}
+	}
+}
+
+// samplingPolicies converts tail-sampling policies from configuration,
+// which have been validated, to sampling.Policies.
+func samplingPolicies(in []beaterconfig.TailSamplingPolicy) []sampling.Policy {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make([]sampling.Policy, len(in))
+	for i, p := range in {
+		out[i] = sampling.Policy{
+			PolicyCriteria: sampling.PolicyCriteria{
+				ServiceName:        p.Service.Name,
+				ServiceEnvironment: p.Service.Environment,
+				TraceName:          p.Trace.Name,
+				TraceOutcome:       p.Trace.Outcome,
+				MinTraceDuration:   p.Trace.MinDuration,
+				MaxTraceDuration:   p.Trace.MaxDuration,
+				Conditions:         samplingConditions(p.Conditions),
+			},
+			SampleRate: p.SampleRate,
+		}
+		if p.TargetTracesPerSecond > 0 {
+			out[i].TargetTracesPerSecond = p.TargetTracesPerSecond
+			out[i].MinSampleRate = p.MinSampleRate
+			out[i].MaxSampleRate = p.GetMaxSampleRate()
+		}
+	}
+	return out
+}
+
+// samplingConditions converts tail-sampling policy conditions from
+// configuration, which have been validated, to sampling.Conditions.
+func samplingConditions(in []beaterconfig.TailSamplingCondition) []sampling.Condition {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make([]sampling.Condition, len(in))
+	for i, c := range in {
+		condition := sampling.Condition{Field: c.Field, Negate: c.Negate}
+		switch {
+		case c.Equals != nil:
+			condition.Operator, condition.Value = sampling.OperatorEquals, *c.Equals
+		case c.Glob != nil:
+			condition.Operator, condition.Value = sampling.OperatorGlob, *c.Glob
+		case c.Regex != nil:
+			condition.Operator, condition.Value = sampling.OperatorRegex, *c.Regex
+		case c.GreaterThan != nil:
+			condition.Operator, condition.Number = sampling.OperatorGreaterThan, *c.GreaterThan
+		case c.GreaterThanOrEqual != nil:
+			condition.Operator, condition.Number = sampling.OperatorGreaterThanOrEqual, *c.GreaterThanOrEqual
+		case c.LessThan != nil:
+			condition.Operator, condition.Number = sampling.OperatorLessThan, *c.LessThan
+		case c.LessThanOrEqual != nil:
+			condition.Operator, condition.Number = sampling.OperatorLessThanOrEqual, *c.LessThanOrEqual
+		}
+		out[i] = condition
+	}
+	return out
+}
+
 func newTailSamplingProcessor(args beater.ServerParams) (*sampling.Processor, error) {
 	tailSamplingConfig := args.Config.Sampling.Tail
 	es, err := args.NewElasticsearchClient(elasticsearch.ClientParams{
@@ -114,44 +288,86 @@ func newTailSamplingProcessor(args beater.ServerParams) (*sampling.Processor, er
 	}
 
 	storageDir := paths.Resolve(paths.Data, tailSamplingStorageDir)
-	db, err := getDB(storageDir, tailSamplingConfig.DatabaseCacheSize, args.MeterProvider, args.Logger)
+	codec, err := newStorageCodec(tailSamplingConfig.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tail-sampling storage codec: %w", err)
+	}
+	db, err := getDB(storageDir, tailSamplingConfig.DatabaseCacheSize, codec, args.MeterProvider, args.Logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tail-sampling database: %w", err)
 	}
 
-	policies := make([]sampling.Policy, len(tailSamplingConfig.Policies))
-	for i, in := range tailSamplingConfig.Policies {
-		policies[i] = sampling.Policy{
-			PolicyCriteria: sampling.PolicyCriteria{
-				ServiceName:        in.Service.Name,
-				ServiceEnvironment: in.Service.Environment,
-				TraceName:          in.Trace.Name,
-				TraceOutcome:       in.Trace.Outcome,
-			},
-			SampleRate: in.SampleRate,
+	var interestingEvents []sampling.InterestingEvent
+	for _, in := range tailSamplingConfig.InterestingEvents {
+		event := sampling.InterestingEvent{
+			Name:        in.Name,
+			MinDuration: in.MinDuration,
+			Conditions:  samplingConditions(in.Conditions),
+		}
+		switch in.EventType {
+		case "transaction":
+			event.EventType = modelpb.TransactionEventType
+		case "span":
+			event.EventType = modelpb.SpanEventType
+		case "error":
+			event.EventType = modelpb.ErrorEventType
+		}
+		interestingEvents = append(interestingEvents, event)
+	}
+
+	policies, shadowPolicies, err := tailSamplingPolicies(tailSamplingConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	localSamplingConfig := sampling.LocalSamplingConfig{
+		FlushInterval:         tailSamplingConfig.Interval,
+		MaxDynamicServices:    1000,
+		Policies:              policies,
+		ShadowPolicies:        shadowPolicies,
+		IngestRateDecayFactor: tailSamplingConfig.IngestRateDecayFactor,
+		InterestingEvents:     interestingEvents,
+	}
+	if tailSamplingConfig.TraceCompletion.Enabled {
+		localSamplingConfig.TraceIdleTimeout = tailSamplingConfig.TraceCompletion.IdleTimeout
+		localSamplingConfig.TraceRootGracePeriod = tailSamplingConfig.TraceCompletion.RootGracePeriod
+	}
+
+	remoteSamplingConfig := sampling.RemoteSamplingConfig{
+		CompressionLevel: tailSamplingConfig.ESConfig.CompressionLevel,
+		Elasticsearch:    es,
+		SampledTracesDataStream: sampling.DataStreamConfig{
+			Type:      "traces",
+			Dataset:   "apm.sampled",
+			Namespace: args.Namespace,
+		},
+		UUID: samplerUUID.String(),
+	}
+	if peer := tailSamplingConfig.Peer; peer.Enabled {
+		transportConfig, err := samplingTransportConfig(peer.TLS, peer.ClientTLS, peer.SecretToken, args.Logger)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tail-sampling peer TLS config: %w", err)
+		}
+		if !transportConfig.Secure() {
+			args.Logger.Warn("tail-sampling peer service is unauthenticated and unencrypted; configure ssl or secret_token unless it is only exposed on a private network")
+		}
+		remoteSamplingConfig.Peer = &sampling.PeerConfig{
+			ListenAddress:     peer.ListenAddress,
+			Peers:             peer.Hosts,
+			DNSName:           peer.DNSName,
+			DiscoveryInterval: peer.DiscoveryInterval,
+			BatchSize:         peer.BatchSize,
+			LogSize:           peer.LogSize,
+			Transport:         transportConfig,
 		}
 	}
 
 	return sampling.NewProcessor(sampling.ProcessorParams{
 		Config: sampling.Config{
-			BatchProcessor: args.BatchProcessor,
-			MeterProvider:  args.MeterProvider,
-			LocalSamplingConfig: sampling.LocalSamplingConfig{
-				FlushInterval:         tailSamplingConfig.Interval,
-				MaxDynamicServices:    1000,
-				Policies:              policies,
-				IngestRateDecayFactor: tailSamplingConfig.IngestRateDecayFactor,
-			},
-			RemoteSamplingConfig: sampling.RemoteSamplingConfig{
-				CompressionLevel: tailSamplingConfig.ESConfig.CompressionLevel,
-				Elasticsearch:    es,
-				SampledTracesDataStream: sampling.DataStreamConfig{
-					Type:      "traces",
-					Dataset:   "apm.sampled",
-					Namespace: args.Namespace,
-				},
-				UUID: samplerUUID.String(),
-			},
+			BatchProcessor:       args.BatchProcessor,
+			MeterProvider:        args.MeterProvider,
+			LocalSamplingConfig:  localSamplingConfig,
+			RemoteSamplingConfig: remoteSamplingConfig,
 			StorageConfig: sampling.StorageConfig{
 				DB:                    db,
 				Storage:               db.NewReadWriter(tailSamplingConfig.StorageLimitParsed, tailSamplingConfig.DiskUsageThreshold),
@@ -164,12 +380,42 @@ func newTailSamplingProcessor(args beater.ServerParams) (*sampling.Processor, er
 	})
 }
 
-func getDB(storageDir string, cacheSize uint64, mp metric.MeterProvider, logger *logp.Logger) (*eventstorage.StorageManager, error) {
+// samplingTransportConfig returns the transport security configuration for
+// gRPC services exchanged between APM Servers for tail-sampling.
+func samplingTransportConfig(
+	serverTLS *tlscommon.ServerConfig,
+	clientTLS *tlscommon.Config,
+	secretToken string,
+	logger *logp.Logger,
+) (transport.Config, error) {
+	transportConfig := transport.Config{SecretToken: secretToken}
+	if serverTLS.IsEnabled() {
+		tlsConfig, err := tlscommon.LoadTLSServerConfig(serverTLS, logger)
+		if err != nil {
+			return transport.Config{}, err
+		}
+		transportConfig.ServerTLS = tlsConfig.BuildServerConfig("")
+	}
+	if clientTLS.IsEnabled() {
+		tlsConfig, err := tlscommon.LoadTLSConfig(clientTLS, logger)
+		if err != nil {
+			return transport.Config{}, err
+		}
+		transportConfig.ClientTLS = tlsConfig
+	}
+	return transportConfig, nil
+}
+
+func getDB(
+	storageDir string, cacheSize uint64, codec eventstorage.Codec,
+	mp metric.MeterProvider, logger *logp.Logger,
+) (*eventstorage.StorageManager, error) {
 	dbMu.Lock()
 	defer dbMu.Unlock()
 	if db == nil {
 		opts := []eventstorage.StorageManagerOptions{
 			eventstorage.WithDBCacheSize(cacheSize),
+			eventstorage.WithCodec(codec),
 		}
 		if mp != nil {
 			opts = append(opts, eventstorage.WithMeterProvider(mp))
@@ -183,6 +429,31 @@ func getDB(storageDir string, cacheSize uint64, mp metric.MeterProvider, logger
 	return db, nil
 }
 
+// newStorageCodec returns the eventstorage.Codec for encoding events in the
+// tail-sampling database. Relative dictionary paths are resolved relative to
+// the configuration directory.
+//
+// Both codecs decode events encoded by either codec, using the configured
+// dictionaries, so that existing events remain readable when switching.
+func newStorageCodec(cfg beaterconfig.TailSamplingStorageConfig) (eventstorage.Codec, error) {
+	dicts := make([][]byte, len(cfg.Dictionaries))
+	for i, path := range cfg.Dictionaries {
+		dict, err := os.ReadFile(paths.Resolve(paths.Config, path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read zstd dictionary: %w", err)
+		}
+		dicts[i] = dict
+	}
+	zstdCodec, err := eventstorage.NewZstdCodec(dicts...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Codec != beaterconfig.TailSamplingStorageCodecZstd {
+		return eventstorage.ProtobufCodec{Zstd: zstdCodec}, nil
+	}
+	return zstdCodec, nil
+}
+
 // runServerWithProcessors runs the APM Server and the given list of processors.
 //
 // newProcessors returns a list of processors which will process events in
@@ -238,8 +509,81 @@ func wrapServer(args beater.ServerParams, runServer beater.RunServerFunc) (beate
 	processorChain[len(processors)] = args.BatchProcessor
 	args.BatchProcessor = processorChain
 
+	var sampler *sampling.Processor
+	var aggregator *aggregation.Aggregator
+	for _, p := range processors {
+		switch p := p.processor.(type) {
+		case *sampling.Processor:
+			sampler = p
+		case *aggregation.Aggregator:
+			aggregator = p
+		}
+	}
+
+	// Resize the aggregator when its automatically sized limits change.
+	if aggregator != nil {
+		args.ResizeAggregation = func(ctx context.Context, cfg beaterconfig.AggregationConfig) error {
+			return aggregator.SetLimits(ctx,
+				cfg.MaxServices,
+				cfg.Transactions.MaxGroups,
+				cfg.ServiceTransactions.MaxGroups,
+				cfg.ServiceDestinations.MaxGroups,
+			)
+		}
+	}
+
+	// Serve the tail-sampling admin API alongside the other APM Server APIs,
+	// requiring clients to be authorized for administrative actions.
+	if sampler != nil && args.Config.Sampling.Tail.AdminAPI.Enabled {
+		adminHandlers := make(map[string]http.Handler, len(args.AdminHandlers)+1)
+		maps.Copy(adminHandlers, args.AdminHandlers)
+		adminHandlers[sampling.AdminPathPrefix] = sampler.AdminHandler()
+		args.AdminHandlers = adminHandlers
+	}
+
+	// Update tail-sampling policies without restarting the server, either
+	// when they are changed in the server's configuration, or when the
+	// policies file changes. The policies file takes precedence.
+	var watchPoliciesFile func(context.Context) error
+	if sampler != nil {
+		policiesFile := args.Config.Sampling.Tail.PoliciesFile
+		configPolicies := args.Config.Sampling.Tail
+		logger := args.Logger
+		args.UpdateSamplingPolicies = func(cfg beaterconfig.TailSamplingConfig) error {
+			if policiesFile.Path != "" {
+				// Inline policies are ignored in favour of the policies file;
+				// warn if they change, so the change is not silently lost.
+				if !reflect.DeepEqual(cfg.Policies, configPolicies.Policies) ||
+					!reflect.DeepEqual(cfg.ShadowPolicies, configPolicies.ShadowPolicies) {
+					logger.With(logp.String("sampling.tail.policies_file.path", policiesFile.Path)).Warn(
+						"ignoring updated tail-sampling policies in favour of the policies file",
+					)
+					configPolicies = cfg
+				}
+				return nil
+			}
+			return sampler.UpdatePolicies(samplingPolicies(cfg.Policies), samplingPolicies(cfg.ShadowPolicies))
+		}
+		if policiesFile.Path != "" {
+			watchPoliciesFile = watchTailSamplingPoliciesFile(policiesFile, sampler, args.Logger)
+		}
+	}
+
 	wrappedRunServer := func(ctx context.Context, args beater.ServerParams) error {
-		return runServerWithProcessors(ctx, runServer, args, processors...)
+		if watchPoliciesFile == nil {
+			return runServerWithProcessors(ctx, runServer, args, processors...)
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		g, ctx := errgroup.WithContext(ctx)
+		g.Go(func() error {
+			return watchPoliciesFile(ctx)
+		})
+		g.Go(func() error {
+			defer cancel() // stop watching when the server stops
+			return runServerWithProcessors(ctx, runServer, args, processors...)
+		})
+		return g.Wait()
 	}
 	return args, wrappedRunServer, nil
 }
@@ -8,10 +8,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
@@ -25,6 +33,7 @@ import (
 	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/beater/monitoringtest"
 	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling"
 )
 
 func TestMainMonitoring(t *testing.T) {
@@ -82,3 +91,212 @@ func TestMainMonitoring(t *testing.T) {
 		assert.Equal(t, runServerError, err)
 	}
 }
+
+func TestWrapServerUpdateSamplingPolicies(t *testing.T) {
+	home := t.TempDir()
+	err := paths.InitPaths(&paths.Path{Home: home})
+	require.NoError(t, err)
+	defer closeDB() // close DB so data dir can be deleted on Windows
+
+	cfg := newTailSamplingTestConfig()
+	cfg.Sampling.Tail.Policies = make([]config.TailSamplingPolicy, 2)
+	cfg.Sampling.Tail.Policies[0].Service.Name = "foo"
+	cfg.Sampling.Tail.Policies[0].SampleRate = 0.5
+	cfg.Sampling.Tail.Policies[1].SampleRate = 1
+
+	serverParams, _, err := wrapServer(newTailSamplingTestServerParams(t, cfg), nil)
+	require.NoError(t, err)
+	require.NotNil(t, serverParams.UpdateSamplingPolicies)
+	assert.Equal(t, []float64{0.5}, adminGroupSampleRates(t, serverParams))
+
+	updated := cfg.Sampling.Tail
+	updated.Policies = slices.Clone(updated.Policies)
+	updated.Policies[0].SampleRate = 0.25
+	require.NoError(t, serverParams.UpdateSamplingPolicies(updated))
+	assert.Equal(t, []float64{0.25}, adminGroupSampleRates(t, serverParams))
+
+	updated.Policies = updated.Policies[:1]
+	assert.Error(t, serverParams.UpdateSamplingPolicies(updated))
+	assert.Equal(t, []float64{0.25}, adminGroupSampleRates(t, serverParams))
+}
+
+func TestWrapServerPoliciesFile(t *testing.T) {
+	home := t.TempDir()
+	err := paths.InitPaths(&paths.Path{Home: home})
+	require.NoError(t, err)
+	defer closeDB() // close DB so data dir can be deleted on Windows
+
+	path := filepath.Join(home, "policies.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+policies:
+  - service.name: foo
+    sample_rate: 0.5
+  - sample_rate: 1.0
+`[1:]), 0644))
+
+	cfg := newTailSamplingTestConfig()
+	cfg.Sampling.Tail.PoliciesFile.Path = path
+	cfg.Sampling.Tail.PoliciesFile.ReloadPeriod = 10 * time.Millisecond
+
+	const ignoredPoliciesMessage = "ignoring updated tail-sampling policies in favour of the policies file"
+	logger, observed := logptest.NewTestingLoggerWithObserver(t, "")
+	runServerFunc := func(ctx context.Context, args beater.ServerParams) error {
+		assert.Equal(t, []float64{0.5}, adminGroupSampleRates(t, args))
+
+		// Inline policies are ignored when a policies file is configured,
+		// and a warning is logged if they change.
+		require.NoError(t, args.UpdateSamplingPolicies(cfg.Sampling.Tail))
+		assert.Zero(t, observed.FilterMessage(ignoredPoliciesMessage).Len())
+		updated := cfg.Sampling.Tail
+		updated.Policies = []config.TailSamplingPolicy{{SampleRate: 0.1}}
+		require.NoError(t, args.UpdateSamplingPolicies(updated))
+		assert.Equal(t, []float64{0.5}, adminGroupSampleRates(t, args))
+		assert.Equal(t, 1, observed.FilterMessage(ignoredPoliciesMessage).Len())
+
+		require.NoError(t, os.WriteFile(path, []byte(`
+policies:
+  - service.name: foo
+    sample_rate: 0.25
+  - sample_rate: 1.0
+`[1:]), 0644))
+		assert.EventuallyWithT(t, func(c *assert.CollectT) {
+			assert.Equal(c, []float64{0.25}, adminGroupSampleRates(t, args))
+		}, 10*time.Second, 10*time.Millisecond)
+
+		// Invalid policies are logged, and the existing policies retained.
+		require.NoError(t, os.WriteFile(path, []byte("policies: []"), 0644))
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, []float64{0.25}, adminGroupSampleRates(t, args))
+		return nil
+	}
+	params := newTailSamplingTestServerParams(t, cfg)
+	params.Logger = logger
+	serverParams, runServer, err := wrapServer(params, runServerFunc)
+	require.NoError(t, err)
+	assert.NoError(t, runServer(context.Background(), serverParams))
+}
+
+func TestGetDBStorageCodec(t *testing.T) {
+	home := t.TempDir()
+	err := paths.InitPaths(&paths.Path{Home: home})
+	require.NoError(t, err)
+	defer closeDB() // close DB so data dir can be deleted on Windows
+
+	storageDir := paths.Resolve(paths.Data, tailSamplingStorageDir)
+	newTransaction := func(id string) *modelpb.APMEvent {
+		return &modelpb.APMEvent{
+			Transaction: &modelpb.Transaction{Id: id, Type: "request"},
+			Service:     &modelpb.Service{Name: "opbeans", Environment: "production"},
+		}
+	}
+
+	// Write an event to a database using the default protobuf codec.
+	codec, err := newStorageCodec(config.DefaultConfig().Sampling.Tail.Storage)
+	require.NoError(t, err)
+	sm, err := getDB(storageDir, 0, codec, nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	require.NoError(t, sm.NewReadWriter(0, 0).WriteTraceEvent("trace_id", "protobuf", newTransaction("protobuf")))
+	require.NoError(t, closeDB())
+
+	// Reopen the database with the zstd codec and a dictionary, whose
+	// relative path is resolved relative to the configuration directory.
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		data, err := newTransaction(fmt.Sprintf("transaction_%d", i)).MarshalVT()
+		require.NoError(t, err)
+		samples = append(samples, data)
+	}
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       1,
+		Contents: samples,
+		History:  slices.Concat(samples[:10]...),
+		Offsets:  [3]int{1, 4, 8},
+		Level:    zstd.SpeedDefault,
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(home, "events.dict"), dict, 0644))
+	codec, err = newStorageCodec(config.TailSamplingStorageConfig{
+		Codec:        config.TailSamplingStorageCodecZstd,
+		Dictionaries: []string{"events.dict"},
+	})
+	require.NoError(t, err)
+	sm, err = getDB(storageDir, 0, codec, nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+
+	// Events stored with the protobuf codec remain readable,
+	// alongside those stored with the zstd codec.
+	rw := sm.NewReadWriter(0, 0)
+	require.NoError(t, rw.WriteTraceEvent("trace_id", "zstd", newTransaction("zstd")))
+	readIDs := func() []string {
+		var batch modelpb.Batch
+		require.NoError(t, sm.NewReadWriter(0, 0).ReadTraceEvents("trace_id", &batch))
+		var ids []string
+		for _, event := range batch {
+			ids = append(ids, event.Transaction.Id)
+		}
+		return ids
+	}
+	assert.ElementsMatch(t, []string{"protobuf", "zstd"}, readIDs())
+	require.NoError(t, closeDB())
+
+	// Reopen the database with the protobuf codec, keeping the dictionary.
+	// Events stored with the zstd codec remain readable.
+	codec, err = newStorageCodec(config.TailSamplingStorageConfig{
+		Codec:        config.TailSamplingStorageCodecProtobuf,
+		Dictionaries: []string{"events.dict"},
+	})
+	require.NoError(t, err)
+	sm, err = getDB(storageDir, 0, codec, nil, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"protobuf", "zstd"}, readIDs())
+
+	_, err = newStorageCodec(config.TailSamplingStorageConfig{
+		Codec:        config.TailSamplingStorageCodecZstd,
+		Dictionaries: []string{"missing.dict"},
+	})
+	assert.ErrorContains(t, err, "failed to read zstd dictionary")
+}
+
+func newTailSamplingTestConfig() *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.Sampling.Tail.Enabled = true
+	cfg.Sampling.Tail.AdminAPI.Enabled = true
+	// MaxServices and MaxGroups are configured based on memory limit.
+	// Overriding here to avoid validation errors.
+	cfg.Aggregation.MaxServices = 10000
+	cfg.Aggregation.Transactions.MaxGroups = 10000
+	cfg.Aggregation.ServiceTransactions.MaxGroups = 10000
+	cfg.Aggregation.ServiceDestinations.MaxGroups = 10000
+	return cfg
+}
+
+func newTailSamplingTestServerParams(t testing.TB, cfg *config.Config) beater.ServerParams {
+	return beater.ServerParams{
+		Config:                 cfg,
+		Logger:                 logptest.NewTestingLogger(t, ""),
+		MeterProvider:          sdkmetric.NewMeterProvider(),
+		BatchProcessor:         modelpb.ProcessBatchFunc(func(ctx context.Context, b *modelpb.Batch) error { return nil }),
+		Namespace:              "default",
+		NewElasticsearchClient: elasticsearch.NewClient,
+	}
+}
+
+// adminGroupSampleRates returns the sample rates of the tail-sampling trace
+// groups, as reported by the admin API.
+func adminGroupSampleRates(t testing.TB, args beater.ServerParams) []float64 {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, sampling.AdminPathPrefix+"groups", nil)
+	args.AdminHandlers[sampling.AdminPathPrefix].ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var body struct {
+		Groups []struct {
+			SampleRate float64 `json:"sample_rate"`
+		} `json:"groups"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	rates := make([]float64, len(body.Groups))
+	for i, group := range body.Groups {
+		rates[i] = group.SampleRate
+	}
+	return rates
+}
@@ -0,0 +1,277 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package sampling
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/eventstorage"
+)
+
+// AdminPathPrefix holds the path prefix of the tail-sampling admin API,
+// served by the handler returned by Processor.AdminHandler.
+const AdminPathPrefix = "/admin/sampling/"
+
+// TraceDecision describes the tail-sampling decision for a trace.
+type TraceDecision string
+
+const (
+	// TraceDecisionSampled indicates that the trace has been sampled.
+	TraceDecisionSampled TraceDecision = "sampled"
+
+	// TraceDecisionUnsampled indicates that the trace has not been sampled.
+	TraceDecisionUnsampled TraceDecision = "unsampled"
+
+	// TraceDecisionPending indicates that the trace's root transaction has
+	// been received, and a decision will be made on trace completion.
+	TraceDecisionPending TraceDecision = "pending"
+
+	// TraceDecisionUndecided indicates that no decision is known for the
+	// trace: either its root transaction is in a reservoir awaiting the
+	// end of the tail-sampling interval, or it has not been received, or
+	// the decision has expired from local storage.
+	TraceDecisionUndecided TraceDecision = "undecided"
+)
+
+// TraceStatus holds the tail-sampling status of a trace.
+type TraceStatus struct {
+	// TraceID holds the trace ID.
+	TraceID string `json:"trace_id"`
+
+	// Decision holds the sampling decision for the trace.
+	Decision TraceDecision `json:"decision"`
+
+	// Interesting reports whether the trace has been marked as interesting.
+	Interesting bool `json:"interesting"`
+
+	// StoredEvents holds the number of the trace's events in local storage.
+	StoredEvents int `json:"stored_events"`
+}
+
+// TraceGroupStatus holds the status of a trace group.
+type TraceGroupStatus struct {
+	// PolicyIndex holds the index of the group's policy in Policies.
+	PolicyIndex int
+
+	// Policy holds the group's policy.
+	Policy Policy
+
+	// ServiceName holds the service name of the group: either the policy's
+	// service name, or the service name of a dynamic group.
+	ServiceName string
+
+	// SampleRate holds the group's current effective sample rate.
+	SampleRate float64
+
+	// ReservoirSize holds the capacity of the group's sampling reservoir,
+	// and ReservoirLen the number of root transactions it currently holds.
+	ReservoirSize int
+	ReservoirLen  int
+
+	// Total holds the number of root transactions observed for the group
+	// in the current tail-sampling interval.
+	Total int
+
+	// IngestRate holds the exponentially weighted moving average number of
+	// root transactions observed for the group per tail-sampling interval.
+	IngestRate float64
+}
+
+// TraceStatus returns the tail-sampling status of the trace.
+func (p *Processor) TraceStatus(traceID string) (TraceStatus, error) {
+	status := TraceStatus{TraceID: traceID}
+	p.shardLock.RLock(traceID)
+	sampled, err := p.eventStore.IsTraceSampled(traceID)
+	p.shardLock.RUnlock(traceID)
+	switch {
+	case err == nil && sampled:
+		status.Decision = TraceDecisionSampled
+	case err == nil:
+		status.Decision = TraceDecisionUnsampled
+	case err != eventstorage.ErrNotFound:
+		return TraceStatus{}, err
+	case p.pending != nil && p.pending.has(traceID):
+		status.Decision = TraceDecisionPending
+	default:
+		status.Decision = TraceDecisionUndecided
+	}
+	if len(p.interesting) != 0 {
+		interesting, err := p.eventStore.IsTraceInteresting(traceID)
+		if err != nil {
+			return TraceStatus{}, err
+		}
+		status.Interesting = interesting
+	}
+	var events modelpb.Batch
+	if err := p.eventStore.ReadTraceEvents(traceID, &events); err != nil {
+		return TraceStatus{}, err
+	}
+	status.StoredEvents = len(events)
+	return status, nil
+}
+
+// ForceSampleTrace causes the trace to be sampled at the end of the current
+// tail-sampling interval, regardless of any local sampling decision already
+// made for it. The decision is published to other servers, and the trace's
+// stored events are reported, as for any other local sampling decision.
+//
+// ForceSampleTrace returns false if the trace has already been sampled.
+func (p *Processor) ForceSampleTrace(traceID string) (bool, error) {
+	p.shardLock.RLock(traceID)
+	sampled, err := p.eventStore.IsTraceSampled(traceID)
+	p.shardLock.RUnlock(traceID)
+	if err == nil && sampled {
+		return false, nil
+	} else if err != nil && err != eventstorage.ErrNotFound {
+		return false, err
+	}
+	if p.pending != nil {
+		// Prevent a decision being made on trace completion.
+		p.pending.remove(traceID)
+	}
+	p.groups.forceSampleTrace(traceID)
+	return true, nil
+}
+
+// TraceGroups returns the status of each trace group, in policy order.
+func (p *Processor) TraceGroups() []TraceGroupStatus {
+	return p.groups.status()
+}
+
+// StorageStatus returns the status of the processor's local storage.
+func (p *Processor) StorageStatus() eventstorage.Status {
+	return p.config.DB.Status()
+}
+
+// AdminHandler returns an http.Handler serving the tail-sampling admin API
+// under AdminPathPrefix, for inspecting and overriding sampling decisions:
+//
+//   - GET traces/{trace_id} returns the trace's TraceStatus
+//   - POST traces/{trace_id}/sample force-samples the trace
+//   - GET groups returns the status of each trace group
+//   - GET storage returns the status of local storage
+//
+// The handler does not authenticate or authorize requests.
+func (p *Processor) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+AdminPathPrefix+"traces/{trace_id}", func(w http.ResponseWriter, r *http.Request) {
+		status, err := p.TraceStatus(r.PathValue("trace_id"))
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeAdminResponse(w, http.StatusOK, status)
+	})
+	mux.HandleFunc("POST "+AdminPathPrefix+"traces/{trace_id}/sample", func(w http.ResponseWriter, r *http.Request) {
+		traceID := r.PathValue("trace_id")
+		forced, err := p.ForceSampleTrace(traceID)
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err)
+			return
+		}
+		code := http.StatusOK
+		if forced {
+			code = http.StatusAccepted
+		}
+		writeAdminResponse(w, code, map[string]any{"trace_id": traceID, "forced": forced})
+	})
+	mux.HandleFunc("GET "+AdminPathPrefix+"groups", func(w http.ResponseWriter, r *http.Request) {
+		groups := p.TraceGroups()
+		out := make([]adminTraceGroup, len(groups))
+		for i, group := range groups {
+			out[i] = newAdminTraceGroup(group)
+		}
+		writeAdminResponse(w, http.StatusOK, map[string]any{"groups": out})
+	})
+	mux.HandleFunc("GET "+AdminPathPrefix+"storage", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminResponse(w, http.StatusOK, p.StorageStatus())
+	})
+	return mux
+}
+
+// adminTraceGroup is the admin API representation of TraceGroupStatus.
+type adminTraceGroup struct {
+	PolicyIndex   int         `json:"policy_index"`
+	Policy        adminPolicy `json:"policy"`
+	ServiceName   string      `json:"service_name,omitempty"`
+	SampleRate    float64     `json:"sample_rate"`
+	ReservoirSize int         `json:"reservoir_size"`
+	ReservoirLen  int         `json:"reservoir_len"`
+	Total         int         `json:"total"`
+	IngestRate    float64     `json:"ingest_rate"`
+}
+
+// adminPolicy is the admin API representation of Policy, using the
+// names of the corresponding configuration options.
+type adminPolicy struct {
+	ServiceName           string           `json:"service.name,omitempty"`
+	ServiceEnvironment    string           `json:"service.environment,omitempty"`
+	TraceOutcome          string           `json:"trace.outcome,omitempty"`
+	TraceName             string           `json:"trace.name,omitempty"`
+	MinTraceDuration      string           `json:"trace.min_duration,omitempty"`
+	MaxTraceDuration      string           `json:"trace.max_duration,omitempty"`
+	Conditions            []adminCondition `json:"conditions,omitempty"`
+	SampleRate            float64          `json:"sample_rate"`
+	TargetTracesPerSecond float64          `json:"target_traces_per_second,omitempty"`
+	MinSampleRate         float64          `json:"min_sample_rate,omitempty"`
+	MaxSampleRate         float64          `json:"max_sample_rate,omitempty"`
+}
+
+type adminCondition struct {
+	Field    string            `json:"field"`
+	Operator ConditionOperator `json:"operator"`
+	Value    any               `json:"value"`
+	Negate   bool              `json:"negate,omitempty"`
+}
+
+func newAdminTraceGroup(group TraceGroupStatus) adminTraceGroup {
+	policy := adminPolicy{
+		ServiceName:           group.Policy.ServiceName,
+		ServiceEnvironment:    group.Policy.ServiceEnvironment,
+		TraceOutcome:          group.Policy.TraceOutcome,
+		TraceName:             group.Policy.TraceName,
+		SampleRate:            group.Policy.SampleRate,
+		TargetTracesPerSecond: group.Policy.TargetTracesPerSecond,
+		MinSampleRate:         group.Policy.MinSampleRate,
+		MaxSampleRate:         group.Policy.MaxSampleRate,
+	}
+	if group.Policy.MinTraceDuration != 0 {
+		policy.MinTraceDuration = group.Policy.MinTraceDuration.String()
+	}
+	if group.Policy.MaxTraceDuration != 0 {
+		policy.MaxTraceDuration = group.Policy.MaxTraceDuration.String()
+	}
+	for _, c := range group.Policy.Conditions {
+		condition := adminCondition{Field: c.Field, Operator: c.Operator, Value: c.Value, Negate: c.Negate}
+		switch c.Operator {
+		case OperatorEquals, OperatorGlob, OperatorRegex:
+		default:
+			condition.Value = c.Number
+		}
+		policy.Conditions = append(policy.Conditions, condition)
+	}
+	return adminTraceGroup{
+		PolicyIndex:   group.PolicyIndex,
+		Policy:        policy,
+		ServiceName:   group.ServiceName,
+		SampleRate:    group.SampleRate,
+		ReservoirSize: group.ReservoirSize,
+		ReservoirLen:  group.ReservoirLen,
+		Total:         group.Total,
+		IngestRate:    group.IngestRate,
+	}
+}
+
+func writeAdminResponse(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeAdminError(w http.ResponseWriter, code int, err error) {
+	writeAdminResponse(w, code, map[string]string{"error": err.Error()})
+}
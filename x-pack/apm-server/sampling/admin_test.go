@@ -0,0 +1,131 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package sampling_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/pubsub/pubsubtest"
+)
+
+func TestAdminHandler(t *testing.T) {
+	config := newTempdirConfig(t).Config
+	config.FlushInterval = 100 * time.Millisecond
+	config.Policies = []sampling.Policy{{SampleRate: 0}}
+	published := make(chan string, 1)
+	config.Elasticsearch = pubsubtest.Client(pubsubtest.PublisherChan(published), nil)
+	reported := make(chan modelpb.Batch, 1)
+	config.BatchProcessor = modelpb.ProcessBatchFunc(func(ctx context.Context, batch *modelpb.Batch) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case reported <- batch.Clone():
+		}
+		return nil
+	})
+
+	processor, err := sampling.NewProcessor(sampling.ProcessorParams{
+		Config:         config,
+		Logger:         logptest.NewTestingLogger(t, ""),
+		StatusReporter: noopStatusReport{},
+	})
+	require.NoError(t, err)
+	go processor.Run()
+	defer processor.Stop(context.Background())
+	handler := processor.AdminHandler()
+
+	// Process a span and then its root transaction, which is not sampled.
+	batch := modelpb.Batch{{
+		Trace:    &modelpb.Trace{Id: "trace_id"},
+		Span:     &modelpb.Span{Type: "type", Id: "span_id"},
+		ParentId: "transaction_id",
+	}, {
+		Service: &modelpb.Service{Name: "service_name"},
+		Trace:   &modelpb.Trace{Id: "trace_id"},
+		Event:   &modelpb.Event{Duration: uint64(time.Millisecond)},
+		Transaction: &modelpb.Transaction{
+			Type:    "type",
+			Id:      "transaction_id",
+			Sampled: true,
+		},
+	}}
+	require.NoError(t, processor.ProcessBatch(context.Background(), &batch))
+	assert.Empty(t, batch)
+
+	var status sampling.TraceStatus
+	adminRequest(t, handler, http.MethodGet, "traces/trace_id", http.StatusOK, &status)
+	assert.Equal(t, sampling.TraceStatus{
+		TraceID:      "trace_id",
+		Decision:     sampling.TraceDecisionUnsampled,
+		StoredEvents: 1,
+	}, status)
+
+	adminRequest(t, handler, http.MethodGet, "traces/unknown", http.StatusOK, &status)
+	assert.Equal(t, sampling.TraceStatus{
+		TraceID:  "unknown",
+		Decision: sampling.TraceDecisionUndecided,
+	}, status)
+
+	var groups struct {
+		Groups []map[string]any `json:"groups"`
+	}
+	adminRequest(t, handler, http.MethodGet, "groups", http.StatusOK, &groups)
+	require.Len(t, groups.Groups, 1)
+	assert.Equal(t, "service_name", groups.Groups[0]["service_name"])
+	assert.Equal(t, map[string]any{"sample_rate": 0.0}, groups.Groups[0]["policy"])
+	assert.Equal(t, 1000.0, groups.Groups[0]["reservoir_size"])
+
+	var storage map[string]any
+	adminRequest(t, handler, http.MethodGet, "storage", http.StatusOK, &storage)
+	assert.Contains(t, storage, "active_partition_ids")
+	assert.Equal(t, false, storage["limit_reached"])
+
+	// Force-sampling the trace publishes the decision, and
+	// reports the stored events.
+	var forced map[string]any
+	adminRequest(t, handler, http.MethodPost, "traces/trace_id/sample", http.StatusAccepted, &forced)
+	assert.Equal(t, map[string]any{"trace_id": "trace_id", "forced": true}, forced)
+	select {
+	case traceID := <-published:
+		assert.Equal(t, "trace_id", traceID)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for publication")
+	}
+	select {
+	case events := <-reported:
+		require.Len(t, events, 1)
+		assert.Equal(t, "span_id", events[0].Span.Id)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for events to be reported")
+	}
+
+	assert.Eventually(t, func() bool {
+		status, err := processor.TraceStatus("trace_id")
+		require.NoError(t, err)
+		return status.Decision == sampling.TraceDecisionSampled
+	}, 10*time.Second, 10*time.Millisecond)
+	adminRequest(t, handler, http.MethodPost, "traces/trace_id/sample", http.StatusOK, &forced)
+	assert.Equal(t, map[string]any{"trace_id": "trace_id", "forced": false}, forced)
+}
+
+func adminRequest(t testing.TB, handler http.Handler, method, path string, expectCode int, out any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, sampling.AdminPathPrefix+path, nil))
+	require.Equal(t, expectCode, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), out))
+}
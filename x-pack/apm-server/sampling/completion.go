@@ -0,0 +1,96 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package sampling
+
+import (
+	"sync"
+	"time"
+)
+
+// pendingTraces tracks traces whose root transactions have been received,
+// and for which sampling decisions will be made on trace completion.
+//
+// The time of each trace's most recent activity is held only in memory, to
+// avoid a storage write per event. Pending traces are also recorded in
+// storage, so that they can be recovered after a restart; their activity
+// is then measured from the time of recovery.
+type pendingTraces struct {
+	mu     sync.Mutex
+	traces map[string]pendingTrace
+}
+
+type pendingTrace struct {
+	traceID string
+
+	// group holds the trace group of the root transaction.
+	group *traceGroup
+
+	// rootReceived holds the time at which the root transaction was received.
+	rootReceived time.Time
+
+	// lastActivity holds the time at which an event of the trace
+	// was last received, or at which the trace was recovered.
+	lastActivity time.Time
+}
+
+func newPendingTraces() *pendingTraces {
+	return &pendingTraces{traces: make(map[string]pendingTrace)}
+}
+
+// add adds a pending trace, and reports whether it was added. If the trace
+// is already pending, e.g. due to a duplicate root transaction, it is left
+// unmodified.
+func (p *pendingTraces) add(traceID string, group *traceGroup, rootReceived, lastActivity time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.traces[traceID]; ok {
+		return false
+	}
+	p.traces[traceID] = pendingTrace{
+		traceID:      traceID,
+		group:        group,
+		rootReceived: rootReceived,
+		lastActivity: lastActivity,
+	}
+	return true
+}
+
+// touch records now as the time of the most recent activity
+// for the trace, if it is pending.
+func (p *pendingTraces) touch(traceID string, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.traces[traceID]; ok && now.After(t.lastActivity) {
+		t.lastActivity = now
+		p.traces[traceID] = t
+	}
+}
+
+// remove removes a pending trace.
+func (p *pendingTraces) remove(traceID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.traces, traceID)
+}
+
+// has reports whether the trace is pending.
+func (p *pendingTraces) has(traceID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.traces[traceID]
+	return ok
+}
+
+// list returns a snapshot of the pending traces, so that they may be checked
+// for completion without blocking the addition of new pending traces.
+func (p *pendingTraces) list() []pendingTrace {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	traces := make([]pendingTrace, 0, len(p.traces))
+	for _, t := range p.traces {
+		traces = append(traces, t)
+	}
+	return traces
+}
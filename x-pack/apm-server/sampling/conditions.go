@@ -0,0 +1,222 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package sampling
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/apm-data/model/modelpb"
+)
+
+// ConditionOperator identifies the comparison made by a Condition.
+type ConditionOperator string
+
+const (
+	// OperatorEquals matches fields equal to Condition.Value. Numeric
+	// fields are compared with Condition.Value parsed as a number.
+	OperatorEquals ConditionOperator = "equals"
+
+	// OperatorGlob matches fields matching the glob pattern Condition.Value,
+	// in which `*` matches any sequence of characters, and `?` matches any
+	// single character.
+	OperatorGlob ConditionOperator = "glob"
+
+	// OperatorRegex matches fields matching the regular expression
+	// Condition.Value. The expression is not implicitly anchored.
+	OperatorRegex ConditionOperator = "regex"
+
+	// OperatorGreaterThan, OperatorGreaterThanOrEqual, OperatorLessThan,
+	// and OperatorLessThanOrEqual match numeric fields compared with
+	// Condition.Number.
+	OperatorGreaterThan        ConditionOperator = "gt"
+	OperatorGreaterThanOrEqual ConditionOperator = "gte"
+	OperatorLessThan           ConditionOperator = "lt"
+	OperatorLessThanOrEqual    ConditionOperator = "lte"
+)
+
+// Condition holds a condition on a field of a root transaction, or of
+// an interesting event.
+//
+// A condition on a field which is not set on the event is not satisfied,
+// unless it is negated.
+type Condition struct {
+	// Field holds the name of the field: one of the fields supported
+	// by conditionField, `labels.<key>`, or `numeric_labels.<key>`.
+	Field string
+
+	// Operator holds the comparison to make.
+	Operator ConditionOperator
+
+	// Value holds the operand for OperatorEquals, OperatorGlob,
+	// and OperatorRegex.
+	Value string
+
+	// Number holds the operand for numeric comparisons.
+	Number float64
+
+	// Negate negates the condition.
+	Negate bool
+}
+
+func (c Condition) validate() error {
+	if _, _, _, _, err := conditionField(&modelpb.APMEvent{}, c.Field); err != nil {
+		return err
+	}
+	switch c.Operator {
+	case OperatorEquals, OperatorGlob,
+		OperatorGreaterThan, OperatorGreaterThanOrEqual,
+		OperatorLessThan, OperatorLessThanOrEqual:
+	case OperatorRegex:
+		if _, err := regexp.Compile(c.Value); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown operator %q", c.Operator)
+	}
+	return nil
+}
+
+// conditionMatcher matches events against a Condition.
+type conditionMatcher struct {
+	Condition
+	regexp *regexp.Regexp // for OperatorGlob and OperatorRegex
+}
+
+// newConditionMatcher returns a conditionMatcher for c,
+// which must have been validated.
+func newConditionMatcher(c Condition) conditionMatcher {
+	m := conditionMatcher{Condition: c}
+	switch c.Operator {
+	case OperatorGlob:
+		m.regexp = regexp.MustCompile(globRegexp(c.Value))
+	case OperatorRegex:
+		m.regexp = regexp.MustCompile(c.Value)
+	}
+	return m
+}
+
+func (m *conditionMatcher) match(event *modelpb.APMEvent) bool {
+	value, number, numeric, ok, _ := conditionField(event, m.Field)
+	if !ok {
+		return m.Negate
+	}
+	return m.compare(value, number, numeric) != m.Negate
+}
+
+func (m *conditionMatcher) compare(value string, number float64, numeric bool) bool {
+	if numeric {
+		value = strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	switch m.Operator {
+	case OperatorEquals:
+		if numeric {
+			operand, err := strconv.ParseFloat(m.Value, 64)
+			return err == nil && number == operand
+		}
+		return value == m.Value
+	case OperatorGlob, OperatorRegex:
+		return m.regexp.MatchString(value)
+	}
+	if !numeric {
+		var err error
+		if number, err = strconv.ParseFloat(value, 64); err != nil {
+			return false
+		}
+	}
+	switch m.Operator {
+	case OperatorGreaterThan:
+		return number > m.Number
+	case OperatorGreaterThanOrEqual:
+		return number >= m.Number
+	case OperatorLessThan:
+		return number < m.Number
+	case OperatorLessThanOrEqual:
+		return number <= m.Number
+	}
+	return false
+}
+
+// conditionField returns the value of the named field of event, and whether
+// it is set. Numeric fields are returned in number, with numeric set to true.
+// An error is returned if the field is not supported.
+func conditionField(event *modelpb.APMEvent, field string) (
+	value string, number float64, numeric, ok bool, err error,
+) {
+	switch field {
+	case "agent.name":
+		value = event.GetAgent().GetName()
+	case "error.exception.type":
+		value = event.GetError().GetException().GetType()
+	case "error.log.level":
+		value = event.GetError().GetLog().GetLevel()
+	case "event.outcome":
+		value = event.GetEvent().GetOutcome()
+	case "host.name":
+		value = event.GetHost().GetName()
+	case "http.request.method":
+		value = event.GetHttp().GetRequest().GetMethod()
+	case "http.response.status_code":
+		statusCode := event.GetHttp().GetResponse().GetStatusCode()
+		return "", float64(statusCode), true, statusCode != 0, nil
+	case "service.environment":
+		value = event.GetService().GetEnvironment()
+	case "service.name":
+		value = event.GetService().GetName()
+	case "service.node.name":
+		value = event.GetService().GetNode().GetName()
+	case "service.version":
+		value = event.GetService().GetVersion()
+	case "span.action":
+		value = event.GetSpan().GetAction()
+	case "span.name":
+		value = event.GetSpan().GetName()
+	case "span.subtype":
+		value = event.GetSpan().GetSubtype()
+	case "span.type":
+		value = event.GetSpan().GetType()
+	case "transaction.name":
+		value = event.GetTransaction().GetName()
+	case "transaction.result":
+		value = event.GetTransaction().GetResult()
+	case "transaction.type":
+		value = event.GetTransaction().GetType()
+	case "url.path":
+		value = event.GetUrl().GetPath()
+	case "user_agent.name":
+		value = event.GetUserAgent().GetName()
+	default:
+		if key, found := strings.CutPrefix(field, "labels."); found && key != "" {
+			label, ok := event.Labels[key]
+			return label.GetValue(), 0, false, ok && label.GetValue() != "", nil
+		}
+		if key, found := strings.CutPrefix(field, "numeric_labels."); found && key != "" {
+			label, ok := event.NumericLabels[key]
+			return "", label.GetValue(), true, ok, nil
+		}
+		return "", 0, false, false, fmt.Errorf("unsupported field %q", field)
+	}
+	return value, 0, false, value != "", nil
+}
+
+// globRegexp returns an anchored regular expression equivalent to glob.
+func globRegexp(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
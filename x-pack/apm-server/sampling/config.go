@@ -8,6 +8,7 @@ package sampling
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"go.opentelemetry.io/otel/metric"
@@ -15,6 +16,7 @@ import (
 	"github.com/elastic/apm-data/model/modelpb"
 	"github.com/elastic/apm-server/x-pack/apm-server/sampling/eventstorage"
 	"github.com/elastic/apm-server/x-pack/apm-server/sampling/pubsub"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/transport"
 	"github.com/elastic/elastic-transport-go/v8/elastictransport"
 )
 
@@ -57,10 +59,41 @@ type LocalSamplingConfig struct {
 	// that dropping non-matching traces is intentional.
 	Policies []Policy
 
+	// ShadowPolicies, if non-empty, holds candidate tail-sampling policies
+	// which are evaluated alongside Policies, using separate trace groups.
+	// Shadow policies do not affect sampling decisions: the number of traces
+	// each shadow trace group would have sampled, and its effective sample
+	// rate, are reported as metrics for comparison with Policies.
+	//
+	// Shadow policies are always evaluated with reservoir sampling at the end
+	// of each FlushInterval, even when decisions are made on trace completion.
+	// Like Policies, ShadowPolicies must include a policy that matches all
+	// traces.
+	ShadowPolicies []Policy
+
 	// IngestRateDecayFactor holds the ingest rate decay factor, used for calculating
 	// the exponentially weighted moving average (EWMA) ingest rate for each trace
 	// group.
 	IngestRateDecayFactor float64
+
+	// InterestingEvents holds criteria for matching events which cause
+	// the traces containing them to be sampled, regardless of the outcome
+	// of reservoir sampling for their root transactions.
+	InterestingEvents []InterestingEvent
+
+	// TraceIdleTimeout, if non-zero, enables making sampling decisions on
+	// trace completion rather than at the end of each FlushInterval. A trace
+	// whose root transaction has been received is considered complete once
+	// no events have been received for it for TraceIdleTimeout.
+	//
+	// Decisions made on trace completion respect each trace group's sample
+	// rate, but do not weight traces by duration.
+	TraceIdleTimeout time.Duration
+
+	// TraceRootGracePeriod, if non-zero, holds the amount of time after a
+	// root transaction is received after which its trace is considered
+	// complete, even if it has not been idle for TraceIdleTimeout.
+	TraceRootGracePeriod time.Duration
 }
 
 // RemoteSamplingConfig holds Processor configuration related to publishing and
@@ -72,8 +105,15 @@ type RemoteSamplingConfig struct {
 
 	// Elasticsearch holds the Elasticsearch client to use for publishing
 	// and subscribing to remote sampling decisions.
+	//
+	// Elasticsearch is not required if Peer is specified.
 	Elasticsearch *elastictransport.Client
 
+	// Peer, if non-nil, holds configuration for exchanging sampling
+	// decisions directly with other APM Servers over gRPC, instead of
+	// through Elasticsearch.
+	Peer *PeerConfig
+
 	// SampledTracesDataStream holds the identifiers for the Elasticsearch
 	// data stream for storing and searching sampled trace IDs.
 	SampledTracesDataStream DataStreamConfig
@@ -88,6 +128,37 @@ type RemoteSamplingConfig struct {
 	UUID string
 }
 
+// PeerConfig holds configuration for exchanging sampling decisions
+// directly with other APM Servers.
+type PeerConfig struct {
+	// ListenAddress holds the host:port on which to serve sampled
+	// trace IDs to peers.
+	ListenAddress string
+
+	// Peers holds the static host:port addresses of peers.
+	Peers []string
+
+	// DNSName, if non-empty, holds a host:port whose host is periodically
+	// resolved to peer addresses.
+	DNSName string
+
+	// DiscoveryInterval holds the time between DNSName lookups.
+	DiscoveryInterval time.Duration
+
+	// BatchSize holds the maximum number of trace IDs sent to a peer
+	// in each message.
+	BatchSize int
+
+	// LogSize holds the number of recently sampled trace IDs retained
+	// in memory for peers. Trace IDs which peers have not received are
+	// lost if they are evicted, or if the server restarts.
+	LogSize int
+
+	// Transport holds the transport security configuration for serving
+	// and subscribing to peers.
+	Transport transport.Config
+}
+
 // DataStreamConfig holds configuration to identify a data stream.
 type DataStreamConfig struct {
 	// Type holds the data stream's type.
@@ -126,8 +197,21 @@ type Policy struct {
 	PolicyCriteria
 
 	// SampleRate holds the tail-based sample rate to use for traces that
-	// match this policy.
+	// match this policy. SampleRate must be zero if TargetTracesPerSecond
+	// is specified.
 	SampleRate float64
+
+	// TargetTracesPerSecond, if non-zero, holds the target number of traces
+	// to sample per second for each trace group matching this policy. The
+	// effective sample rate is derived from each group's ingest rate after
+	// every FlushInterval, and bounded by MinSampleRate and MaxSampleRate.
+	TargetTracesPerSecond float64
+
+	// MinSampleRate and MaxSampleRate hold the inclusive bounds of the
+	// effective sample rate when TargetTracesPerSecond is specified.
+	// MaxSampleRate must then be greater than zero.
+	MinSampleRate float64
+	MaxSampleRate float64
 }
 
 // PolicyCriteria holds the criteria for matching root transactions to a
@@ -166,6 +250,59 @@ type PolicyCriteria struct {
 	// from the same service) will be grouped together for sampling purposes,
 	// similar to head-based sampling.
 	TraceName string
+
+	// MinTraceDuration and MaxTraceDuration hold the inclusive lower and
+	// exclusive upper bounds of the root transaction duration for which
+	// this policy applies.
+	//
+	// If unspecified, root transactions of any duration will be grouped
+	// together for sampling purposes.
+	MinTraceDuration time.Duration
+	MaxTraceDuration time.Duration
+
+	// Conditions holds conditions on fields of the root transaction, all
+	// of which must be satisfied for this policy to apply.
+	Conditions []Condition
+}
+
+// isEmpty reports whether c has no criteria, matching all transactions.
+func (c PolicyCriteria) isEmpty() bool {
+	return c.ServiceName == "" && c.ServiceEnvironment == "" &&
+		c.TraceOutcome == "" && c.TraceName == "" &&
+		c.MinTraceDuration == 0 && c.MaxTraceDuration == 0 &&
+		len(c.Conditions) == 0
+}
+
+// equal reports whether c and other hold the same criteria.
+func (c PolicyCriteria) equal(other PolicyCriteria) bool {
+	return c.ServiceName == other.ServiceName &&
+		c.ServiceEnvironment == other.ServiceEnvironment &&
+		c.TraceOutcome == other.TraceOutcome &&
+		c.TraceName == other.TraceName &&
+		c.MinTraceDuration == other.MinTraceDuration &&
+		c.MaxTraceDuration == other.MaxTraceDuration &&
+		slices.Equal(c.Conditions, other.Conditions)
+}
+
+// InterestingEvent holds criteria for matching trace events which cause
+// their traces to be sampled.
+type InterestingEvent struct {
+	// Name identifies the criteria in metrics.
+	Name string
+
+	// EventType holds the type of events matched: one of
+	// modelpb.TransactionEventType, modelpb.SpanEventType, or
+	// modelpb.ErrorEventType.
+	EventType modelpb.APMEventType
+
+	// MinDuration holds the inclusive lower bound of the duration of
+	// matching transactions and spans. MinDuration must be zero for
+	// errors.
+	MinDuration time.Duration
+
+	// Conditions holds conditions on fields of the event, all of which
+	// must be satisfied for the event to match.
+	Conditions []Condition
 }
 
 // Validate validates the configuration.
@@ -200,16 +337,44 @@ func (config LocalSamplingConfig) validate() error {
 		if err := policy.validate(); err != nil {
 			return fmt.Errorf("Policy %d invalid: %w", i, err)
 		}
-		if policy.PolicyCriteria == (PolicyCriteria{}) {
+		if policy.PolicyCriteria.isEmpty() {
 			anyDefaultPolicy = true
 		}
 	}
 	if !anyDefaultPolicy {
 		return errors.New("Policies does not contain a default (empty criteria) policy")
 	}
+	if len(config.ShadowPolicies) != 0 {
+		var anyDefaultShadowPolicy bool
+		for i, policy := range config.ShadowPolicies {
+			if err := policy.validate(); err != nil {
+				return fmt.Errorf("ShadowPolicy %d invalid: %w", i, err)
+			}
+			if policy.PolicyCriteria.isEmpty() {
+				anyDefaultShadowPolicy = true
+			}
+		}
+		if !anyDefaultShadowPolicy {
+			return errors.New("ShadowPolicies does not contain a default (empty criteria) policy")
+		}
+	}
 	if config.IngestRateDecayFactor <= 0 || config.IngestRateDecayFactor > 1 {
 		return errors.New("IngestRateDecayFactor unspecified or out of range (0,1]")
 	}
+	if config.TraceIdleTimeout < 0 {
+		return errors.New("TraceIdleTimeout negative")
+	}
+	if config.TraceRootGracePeriod < 0 {
+		return errors.New("TraceRootGracePeriod negative")
+	}
+	if config.TraceRootGracePeriod != 0 && config.TraceIdleTimeout == 0 {
+		return errors.New("TraceRootGracePeriod specified without TraceIdleTimeout")
+	}
+	for i, event := range config.InterestingEvents {
+		if err := event.validate(); err != nil {
+			return fmt.Errorf("InterestingEvent %d invalid: %w", i, err)
+		}
+	}
 	return nil
 }
 
@@ -217,11 +382,17 @@ func (config RemoteSamplingConfig) validate() error {
 	if config.CompressionLevel < -1 || config.CompressionLevel > 9 {
 		return errors.New("CompressionLevel out of range [-1,9]")
 	}
-	if config.Elasticsearch == nil {
-		return errors.New("Elasticsearch unspecified")
-	}
-	if err := config.SampledTracesDataStream.validate(); err != nil {
-		return errors.New("SampledTracesDataStream unspecified or invalid")
+	if config.Peer != nil {
+		if err := config.Peer.validate(); err != nil {
+			return fmt.Errorf("Peer invalid: %w", err)
+		}
+	} else {
+		if config.Elasticsearch == nil {
+			return errors.New("Elasticsearch unspecified")
+		}
+		if err := config.SampledTracesDataStream.validate(); err != nil {
+			return errors.New("SampledTracesDataStream unspecified or invalid")
+		}
 	}
 	if config.UUID == "" {
 		return errors.New("UUID unspecified")
@@ -229,6 +400,25 @@ func (config RemoteSamplingConfig) validate() error {
 	return nil
 }
 
+func (config PeerConfig) validate() error {
+	if config.ListenAddress == "" {
+		return errors.New("ListenAddress unspecified")
+	}
+	if len(config.Peers) == 0 && config.DNSName == "" {
+		return errors.New("Peers and DNSName unspecified")
+	}
+	if config.DiscoveryInterval <= 0 {
+		return errors.New("DiscoveryInterval unspecified or negative")
+	}
+	if config.BatchSize <= 0 {
+		return errors.New("BatchSize unspecified or negative")
+	}
+	if config.LogSize <= 0 {
+		return errors.New("LogSize unspecified or negative")
+	}
+	return nil
+}
+
 func (config DataStreamConfig) validate() error {
 	return pubsub.DataStreamConfig(config).Validate()
 }
@@ -250,5 +440,54 @@ func (p Policy) validate() error {
 	if p.SampleRate < 0 || p.SampleRate > 1 {
 		return errors.New("SampleRate unspecified or out of range [0,1]")
 	}
+	if p.TargetTracesPerSecond < 0 {
+		return errors.New("TargetTracesPerSecond negative")
+	}
+	if p.TargetTracesPerSecond > 0 {
+		if p.SampleRate != 0 {
+			return errors.New("SampleRate specified with TargetTracesPerSecond")
+		}
+		if p.MaxSampleRate <= 0 || p.MaxSampleRate > 1 {
+			return errors.New("MaxSampleRate unspecified or out of range (0,1]")
+		}
+		if p.MinSampleRate < 0 || p.MinSampleRate > p.MaxSampleRate {
+			return errors.New("MinSampleRate out of range [0,MaxSampleRate]")
+		}
+	}
+	if p.MinTraceDuration < 0 || p.MaxTraceDuration < 0 {
+		return errors.New("MinTraceDuration or MaxTraceDuration negative")
+	}
+	if p.MaxTraceDuration != 0 && p.MaxTraceDuration <= p.MinTraceDuration {
+		return errors.New("MaxTraceDuration not greater than MinTraceDuration")
+	}
+	for i, condition := range p.Conditions {
+		if err := condition.validate(); err != nil {
+			return fmt.Errorf("Condition %d invalid: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (e InterestingEvent) validate() error {
+	if e.Name == "" {
+		return errors.New("Name unspecified")
+	}
+	switch e.EventType {
+	case modelpb.TransactionEventType, modelpb.SpanEventType:
+	case modelpb.ErrorEventType:
+		if e.MinDuration != 0 {
+			return errors.New("MinDuration specified for errors")
+		}
+	default:
+		return fmt.Errorf("EventType %q unsupported", e.EventType)
+	}
+	if e.MinDuration < 0 {
+		return errors.New("MinDuration negative")
+	}
+	for i, condition := range e.Conditions {
+		if err := condition.validate(); err != nil {
+			return fmt.Errorf("Condition %d invalid: %w", i, err)
+		}
+	}
 	return nil
 }
@@ -6,6 +6,7 @@ package sampling_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -51,16 +52,76 @@ func TestNewProcessorConfigInvalid(t *testing.T) {
 	}
 	config.Policies[0].SampleRate = 1.0
 
+	config.Policies = append(config.Policies, sampling.Policy{TargetTracesPerSecond: 10, SampleRate: 0.5})
+	assertInvalidConfigError("invalid local sampling config: Policy 1 invalid: SampleRate specified with TargetTracesPerSecond")
+	config.Policies[1].SampleRate = 0
+	assertInvalidConfigError("invalid local sampling config: Policy 1 invalid: MaxSampleRate unspecified or out of range (0,1]")
+	config.Policies[1].MaxSampleRate = 0.5
+	config.Policies[1].MinSampleRate = 0.6
+	assertInvalidConfigError("invalid local sampling config: Policy 1 invalid: MinSampleRate out of range [0,MaxSampleRate]")
+
+	config.Policies[1] = sampling.Policy{
+		PolicyCriteria: sampling.PolicyCriteria{MinTraceDuration: 2, MaxTraceDuration: 1},
+	}
+	assertInvalidConfigError("invalid local sampling config: Policy 1 invalid: MaxTraceDuration not greater than MinTraceDuration")
+	config.Policies[1].PolicyCriteria = sampling.PolicyCriteria{Conditions: []sampling.Condition{{
+		Field: "unknown", Operator: sampling.OperatorEquals,
+	}}}
+	assertInvalidConfigError(`invalid local sampling config: Policy 1 invalid: Condition 0 invalid: unsupported field "unknown"`)
+	config.Policies[1].Conditions[0] = sampling.Condition{Field: "labels.tenant", Operator: sampling.OperatorRegex, Value: "("}
+	assertInvalidConfigError("invalid local sampling config: Policy 1 invalid: Condition 0 invalid: invalid regex: error parsing regexp: missing closing ): `(`")
+	config.Policies = config.Policies[:1]
+
+	config.ShadowPolicies = []sampling.Policy{{
+		PolicyCriteria: sampling.PolicyCriteria{ServiceName: "foo"},
+		SampleRate:     0.5,
+	}}
+	assertInvalidConfigError("invalid local sampling config: ShadowPolicies does not contain a default (empty criteria) policy")
+	config.ShadowPolicies = append(config.ShadowPolicies, sampling.Policy{SampleRate: 2})
+	assertInvalidConfigError("invalid local sampling config: ShadowPolicy 1 invalid: SampleRate unspecified or out of range [0,1]")
+	config.ShadowPolicies = nil
+
 	for _, invalid := range []float64{-1, 0, 2.0} {
 		config.IngestRateDecayFactor = invalid
 		assertInvalidConfigError("invalid local sampling config: IngestRateDecayFactor unspecified or out of range (0,1]")
 	}
 	config.IngestRateDecayFactor = 0.5
 
+	config.TraceIdleTimeout = -1
+	assertInvalidConfigError("invalid local sampling config: TraceIdleTimeout negative")
+	config.TraceIdleTimeout = 0
+	config.TraceRootGracePeriod = 1
+	assertInvalidConfigError("invalid local sampling config: TraceRootGracePeriod specified without TraceIdleTimeout")
+	config.TraceRootGracePeriod = 0
+
+	config.InterestingEvents = []sampling.InterestingEvent{{EventType: modelpb.ErrorEventType}}
+	assertInvalidConfigError("invalid local sampling config: InterestingEvent 0 invalid: Name unspecified")
+	config.InterestingEvents[0].Name = "errors"
+	config.InterestingEvents[0].MinDuration = 1
+	assertInvalidConfigError("invalid local sampling config: InterestingEvent 0 invalid: MinDuration specified for errors")
+	config.InterestingEvents[0].EventType = modelpb.MetricEventType
+	assertInvalidConfigError(`invalid local sampling config: InterestingEvent 0 invalid: EventType "metric" unsupported`)
+	config.InterestingEvents = nil
+
 	config.CompressionLevel = 11
 	assertInvalidConfigError("invalid remote sampling config: CompressionLevel out of range [-1,9]")
 	config.CompressionLevel = 0
 
+	// Elasticsearch is not required when exchanging sampling decisions with peers.
+	config.Peer = &sampling.PeerConfig{}
+	assertInvalidConfigError("invalid remote sampling config: Peer invalid: ListenAddress unspecified")
+	config.Peer.ListenAddress = ":8201"
+	assertInvalidConfigError("invalid remote sampling config: Peer invalid: Peers and DNSName unspecified")
+	config.Peer.Peers = []string{"peer:8201"}
+	assertInvalidConfigError("invalid remote sampling config: Peer invalid: DiscoveryInterval unspecified or negative")
+	config.Peer.DiscoveryInterval = time.Second
+	assertInvalidConfigError("invalid remote sampling config: Peer invalid: BatchSize unspecified or negative")
+	config.Peer.BatchSize = 1
+	assertInvalidConfigError("invalid remote sampling config: Peer invalid: LogSize unspecified or negative")
+	config.Peer.LogSize = 1
+	assertInvalidConfigError("invalid remote sampling config: UUID unspecified")
+	config.Peer = nil
+
 	assertInvalidConfigError("invalid remote sampling config: Elasticsearch unspecified")
 	config.Elasticsearch = &elastictransport.Client{}
 
@@ -6,6 +6,7 @@ package eventstorage
 
 import (
 	"errors"
+	"time"
 
 	"github.com/elastic/apm-data/model/modelpb"
 )
@@ -47,6 +48,53 @@ func (rw *PartitionReadWriter) IsTraceSampled(traceID string) (bool, error) {
 	return false, ErrNotFound
 }
 
+// WriteTraceInteresting marks the trace as interesting, such that it
+// should be sampled regardless of the outcome of reservoir sampling.
+func (rw *PartitionReadWriter) WriteTraceInteresting(traceID string) (err error) {
+	rw.s.partitioner.CurrentIDFunc(func(pid int) {
+		err = NewPrefixReadWriter(rw.s.db, byte(pid), rw.s.codec).WriteTraceInteresting(traceID)
+	})
+	return
+}
+
+// IsTraceInteresting reports whether the trace has been marked as
+// interesting in any active partition.
+func (rw *PartitionReadWriter) IsTraceInteresting(traceID string) (bool, error) {
+	var errs []error
+	for pid := range rw.s.partitioner.ActiveIDs() {
+		interesting, err := NewPrefixReadWriter(rw.s.db, byte(pid), rw.s.codec).IsTraceInteresting(traceID)
+		if err != nil {
+			errs = append(errs, err)
+		} else if interesting {
+			return true, nil
+		}
+	}
+	return false, errors.Join(errs...)
+}
+
+// WriteTracePending records that the sampling decision for the trace is
+// pending its completion, and the time at which its root transaction was
+// received.
+func (rw *PartitionReadWriter) WriteTracePending(traceID string, rootReceived time.Time) (err error) {
+	rw.s.partitioner.CurrentIDFunc(func(pid int) {
+		err = NewPrefixReadWriter(rw.s.db, byte(pid), rw.s.codec).WriteTracePending(traceID, rootReceived)
+	})
+	return
+}
+
+// ReadPendingTraces calls f for each pending trace recorded in any active
+// partition. A trace may be reported more than once.
+func (rw *PartitionReadWriter) ReadPendingTraces(f func(traceID string, rootReceived time.Time)) error {
+	var errs []error
+	for pid := range rw.s.partitioner.ActiveIDs() {
+		err := NewPrefixReadWriter(rw.s.db, byte(pid), rw.s.codec).ReadPendingTraces(f)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // WriteTraceEvent writes a trace event to storage.
 func (rw *PartitionReadWriter) WriteTraceEvent(traceID, id string, event *modelpb.APMEvent) (err error) {
 	rw.s.partitioner.CurrentIDFunc(func(pid int) {
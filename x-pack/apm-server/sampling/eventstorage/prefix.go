@@ -6,8 +6,10 @@ package eventstorage
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/cockroachdb/pebble/v2"
 
@@ -17,8 +19,10 @@ import (
 const (
 	// NOTE(axw) these values (and their meanings) must remain stable
 	// over time, to avoid misinterpreting historical data.
-	entryMetaTraceSampled   byte = 's'
-	entryMetaTraceUnsampled byte = 'u'
+	entryMetaTraceSampled     byte = 's'
+	entryMetaTraceUnsampled   byte = 'u'
+	entryMetaTraceInteresting byte = 'i'
+	entryMetaTracePending     byte = 'p'
 
 	// traceIDSeparator is the separator between trace ID and transaction / span ID
 	traceIDSeparator byte = ':'
@@ -30,8 +34,9 @@ var (
 	ErrNotFound = errors.New("key not found")
 
 	// Reuse sampling decision value byte slices for performance
-	traceSampledVal   = []byte{entryMetaTraceSampled}
-	traceUnsampledVal = []byte{entryMetaTraceUnsampled}
+	traceSampledVal     = []byte{entryMetaTraceSampled}
+	traceUnsampledVal   = []byte{entryMetaTraceUnsampled}
+	traceInterestingVal = []byte{entryMetaTraceInteresting}
 )
 
 func NewPrefixReadWriter(db db, prefix byte, codec Codec) PrefixReadWriter {
@@ -129,6 +134,78 @@ func (rw PrefixReadWriter) IsTraceSampled(traceID string) (bool, error) {
 	return item[0] == entryMetaTraceSampled, nil
 }
 
+// WriteTraceInteresting marks the trace as interesting in rw.db, with a key consisting of
+// rw.prefix, traceID, the trace ID separator, and entryMetaTraceInteresting. The key is
+// distinct from the sampling decision key, so that the marker does not imply a decision.
+func (rw PrefixReadWriter) WriteTraceInteresting(traceID string) error {
+	return rw.db.Set(rw.interestingKey(traceID), traceInterestingVal, pebble.NoSync)
+}
+
+// IsTraceInteresting reports whether the trace has been marked as interesting in rw.db.
+func (rw PrefixReadWriter) IsTraceInteresting(traceID string) (bool, error) {
+	_, closer, err := rw.db.Get(rw.interestingKey(traceID))
+	if err == pebble.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	closer.Close()
+	return true, nil
+}
+
+func (rw PrefixReadWriter) interestingKey(traceID string) []byte {
+	return rw.traceMetaKey(traceID, entryMetaTraceInteresting)
+}
+
+// WriteTracePending records that the sampling decision for the trace is pending its
+// completion, and the time at which its root transaction was received, in rw.db with
+// a key consisting of rw.prefix, traceID, the trace ID separator, and entryMetaTracePending.
+func (rw PrefixReadWriter) WriteTracePending(traceID string, rootReceived time.Time) error {
+	var val [8]byte
+	binary.BigEndian.PutUint64(val[:], uint64(rootReceived.UnixNano()))
+	return rw.db.Set(rw.traceMetaKey(traceID, entryMetaTracePending), val[:], pebble.NoSync)
+}
+
+// ReadPendingTraces calls f for each trace recorded by WriteTracePending in rw.db,
+// with the time at which its root transaction was received. All keys with rw.prefix
+// are scanned, so this should only be used for recovery on startup.
+func (rw PrefixReadWriter) ReadPendingTraces(f func(traceID string, rootReceived time.Time)) error {
+	iter, err := rw.db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte{rw.prefix},
+		UpperBound: []byte{rw.prefix + 1},
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		n := len(key)
+		if n < 3 || key[n-2] != traceIDSeparator || key[n-1] != entryMetaTracePending {
+			continue
+		}
+		item, err := iter.ValueAndErr()
+		if err != nil {
+			return err
+		}
+		if len(item) != 8 {
+			return fmt.Errorf("invalid pending trace value length %d", len(item))
+		}
+		f(string(key[1:n-2]), time.Unix(0, int64(binary.BigEndian.Uint64(item))))
+	}
+	return iter.Error()
+}
+
+func (rw PrefixReadWriter) traceMetaKey(traceID string, meta byte) []byte {
+	var b bytes.Buffer
+	b.Grow(1 + len(traceID) + 2)
+	b.WriteByte(rw.prefix)
+	b.WriteString(traceID)
+	b.WriteByte(traceIDSeparator)
+	b.WriteByte(meta)
+	return b.Bytes()
+}
+
 // DeleteTraceEvent deletes event associated with key consisting of rw.prefix, traceID and id from rw.db.
 func (rw PrefixReadWriter) DeleteTraceEvent(traceID, id string) error {
 	var b bytes.Buffer
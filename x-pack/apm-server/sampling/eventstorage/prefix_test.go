@@ -7,6 +7,7 @@ package eventstorage_test
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/pebble/v2"
 	"github.com/gofrs/uuid/v5"
@@ -179,3 +180,56 @@ func TestPrefixReadWriter_IsTraceSampled(t *testing.T) {
 		})
 	}
 }
+
+func TestPrefixReadWriter_TraceInteresting(t *testing.T) {
+	db := newDecisionPebble(t)
+	rw := eventstorage.NewPrefixReadWriter(db, 1, nopCodec{})
+	traceID := uuid.Must(uuid.NewV4()).String()
+
+	interesting, err := rw.IsTraceInteresting(traceID)
+	require.NoError(t, err)
+	assert.False(t, interesting)
+
+	require.NoError(t, rw.WriteTraceInteresting(traceID))
+	interesting, err = rw.IsTraceInteresting(traceID)
+	require.NoError(t, err)
+	assert.True(t, interesting)
+
+	// Marking a trace as interesting does not record a sampling decision.
+	_, err = rw.IsTraceSampled(traceID)
+	assert.ErrorIs(t, err, eventstorage.ErrNotFound)
+	require.NoError(t, rw.WriteTraceSampled(traceID, false))
+	interesting, err = rw.IsTraceInteresting(traceID)
+	require.NoError(t, err)
+	assert.True(t, interesting)
+}
+
+func TestPrefixReadWriter_TracePending(t *testing.T) {
+	db := newDecisionPebble(t)
+	rw := eventstorage.NewPrefixReadWriter(db, 1, nopCodec{})
+	traceID := uuid.Must(uuid.NewV4()).String()
+
+	readPending := func(rw eventstorage.PrefixReadWriter) map[string]time.Time {
+		pending := make(map[string]time.Time)
+		require.NoError(t, rw.ReadPendingTraces(func(traceID string, rootReceived time.Time) {
+			pending[traceID] = rootReceived
+		}))
+		return pending
+	}
+	assert.Empty(t, readPending(rw))
+
+	now := time.Unix(123, 456)
+	require.NoError(t, rw.WriteTracePending(traceID, now))
+	require.NoError(t, rw.WriteTraceInteresting(traceID))
+	require.NoError(t, rw.WriteTraceSampled("other", false))
+	pending := readPending(rw)
+	require.Len(t, pending, 1)
+	assert.True(t, now.Equal(pending[traceID]))
+
+	// Pending traces are read only from the reader's partition.
+	assert.Empty(t, readPending(eventstorage.NewPrefixReadWriter(db, 2, nopCodec{})))
+
+	// Recording a pending trace does not record a sampling decision.
+	_, err := rw.IsTraceSampled(traceID)
+	assert.ErrorIs(t, err, eventstorage.ErrNotFound)
+}
@@ -5,15 +5,43 @@
 package eventstorage
 
 import (
+	"sync"
+
 	"github.com/elastic/apm-data/model/modelpb"
 )
 
+// defaultZstdCodec returns a ZstdCodec without dictionaries, for decoding
+// events encoded by ZstdCodec with a ProtobufCodec that has no Zstd codec.
+var defaultZstdCodec = sync.OnceValues(func() (*ZstdCodec, error) {
+	return NewZstdCodec()
+})
+
 // ProtobufCodec is an implementation of Codec, using protobuf encoding.
-type ProtobufCodec struct{}
+//
+// ProtobufCodec also decodes events encoded by ZstdCodec, so that events
+// stored in existing databases remain readable after switching back from
+// ZstdCodec.
+type ProtobufCodec struct {
+	// Zstd, if non-nil, is used to decode events encoded by ZstdCodec,
+	// and should be configured with any dictionaries they were encoded
+	// with. If Zstd is nil, such events are decoded without dictionaries.
+	Zstd *ZstdCodec
+}
 
-// DecodeEvent decodes data as protobuf into event.
-func (ProtobufCodec) DecodeEvent(data []byte, event *modelpb.APMEvent) error {
-	return event.UnmarshalVT(data)
+// DecodeEvent decodes data into event. Data is decompressed if it was
+// encoded by ZstdCodec, and otherwise decoded as protobuf.
+func (c ProtobufCodec) DecodeEvent(data []byte, event *modelpb.APMEvent) error {
+	if len(data) == 0 || data[0] != encodingPrefix {
+		return event.UnmarshalVT(data)
+	}
+	zstdCodec := c.Zstd
+	if zstdCodec == nil {
+		var err error
+		if zstdCodec, err = defaultZstdCodec(); err != nil {
+			return err
+		}
+	}
+	return zstdCodec.DecodeEvent(data, event)
 }
 
 // EncodeEvent encodes event as protobuf.
@@ -7,6 +7,7 @@ package eventstorage
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/elastic/apm-data/model/modelpb"
 )
@@ -23,12 +24,16 @@ type RW interface {
 	WriteTraceEvent(traceID, id string, event *modelpb.APMEvent) error
 	WriteTraceSampled(traceID string, sampled bool) error
 	IsTraceSampled(traceID string) (bool, error)
+	WriteTraceInteresting(traceID string) error
+	IsTraceInteresting(traceID string) (bool, error)
+	WriteTracePending(traceID string, rootReceived time.Time) error
+	ReadPendingTraces(f func(traceID string, rootReceived time.Time)) error
 	DeleteTraceEvent(traceID, id string) error
 }
 
 // SplitReadWriter is a RW that splits method calls to eventRW and decisionRW.
 // - *TraceEvent* method calls are passed through to eventRW.
-// - *TraceSampled method calls are passed through to decisionRW.
+// - *TraceSampled, *TraceInteresting, and *Pending* method calls are passed through to decisionRW.
 type SplitReadWriter struct {
 	eventRW, decisionRW RW
 }
@@ -49,6 +54,22 @@ func (s SplitReadWriter) IsTraceSampled(traceID string) (bool, error) {
 	return s.decisionRW.IsTraceSampled(traceID)
 }
 
+func (s SplitReadWriter) WriteTraceInteresting(traceID string) error {
+	return s.decisionRW.WriteTraceInteresting(traceID)
+}
+
+func (s SplitReadWriter) IsTraceInteresting(traceID string) (bool, error) {
+	return s.decisionRW.IsTraceInteresting(traceID)
+}
+
+func (s SplitReadWriter) WriteTracePending(traceID string, rootReceived time.Time) error {
+	return s.decisionRW.WriteTracePending(traceID, rootReceived)
+}
+
+func (s SplitReadWriter) ReadPendingTraces(f func(traceID string, rootReceived time.Time)) error {
+	return s.decisionRW.ReadPendingTraces(f)
+}
+
 func (s SplitReadWriter) DeleteTraceEvent(traceID, id string) error {
 	return s.eventRW.DeleteTraceEvent(traceID, id)
 }
@@ -134,6 +155,32 @@ func (s StorageLimitReadWriter) IsTraceSampled(traceID string) (bool, error) {
 	return s.nextRW.IsTraceSampled(traceID)
 }
 
+// WriteTraceInteresting passes through to s.nextRW.WriteTraceInteresting only if storage limit is not reached.
+func (s StorageLimitReadWriter) WriteTraceInteresting(traceID string) error {
+	if err := s.checkStorageLimit(); err != nil {
+		return err
+	}
+	return s.nextRW.WriteTraceInteresting(traceID)
+}
+
+// IsTraceInteresting passes through to s.nextRW.IsTraceInteresting.
+func (s StorageLimitReadWriter) IsTraceInteresting(traceID string) (bool, error) {
+	return s.nextRW.IsTraceInteresting(traceID)
+}
+
+// WriteTracePending passes through to s.nextRW.WriteTracePending only if storage limit is not reached.
+func (s StorageLimitReadWriter) WriteTracePending(traceID string, rootReceived time.Time) error {
+	if err := s.checkStorageLimit(); err != nil {
+		return err
+	}
+	return s.nextRW.WriteTracePending(traceID, rootReceived)
+}
+
+// ReadPendingTraces passes through to s.nextRW.ReadPendingTraces.
+func (s StorageLimitReadWriter) ReadPendingTraces(f func(traceID string, rootReceived time.Time)) error {
+	return s.nextRW.ReadPendingTraces(f)
+}
+
 // DeleteTraceEvent passes through to s.nextRW.DeleteTraceEvent.
 func (s StorageLimitReadWriter) DeleteTraceEvent(traceID, id string) error {
 	// Technically DeleteTraceEvent writes, but it should have a net effect of reducing disk usage
@@ -7,6 +7,7 @@ package eventstorage_test
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -50,6 +51,26 @@ func (m mockRW) IsTraceSampled(traceID string) (bool, error) {
 	return false, nil
 }
 
+func (m mockRW) WriteTraceInteresting(traceID string) error {
+	m.callback()
+	return nil
+}
+
+func (m mockRW) IsTraceInteresting(traceID string) (bool, error) {
+	m.callback()
+	return false, nil
+}
+
+func (m mockRW) WriteTracePending(traceID string, rootReceived time.Time) error {
+	m.callback()
+	return nil
+}
+
+func (m mockRW) ReadPendingTraces(f func(traceID string, rootReceived time.Time)) error {
+	m.callback()
+	return nil
+}
+
 func (m mockRW) DeleteTraceEvent(traceID, id string) error {
 	m.callback()
 	return nil
@@ -103,6 +124,20 @@ func TestStorageLimitReadWriter(t *testing.T) {
 			} else {
 				assert.Error(t, err)
 			}
+			err = rw.WriteTraceInteresting("foo")
+			if tt.wantCalled {
+				assert.NoError(t, err)
+				assert.Equal(t, 6, callCount)
+			} else {
+				assert.Error(t, err)
+			}
+			err = rw.WriteTracePending("foo", time.Now())
+			if tt.wantCalled {
+				assert.NoError(t, err)
+				assert.Equal(t, 7, callCount)
+			} else {
+				assert.Error(t, err)
+			}
 		})
 	}
 
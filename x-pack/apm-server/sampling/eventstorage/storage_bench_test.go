@@ -52,6 +52,10 @@ func BenchmarkWriteTransaction(b *testing.B) {
 			name:  "proto_codec",
 			codec: eventstorage.ProtobufCodec{},
 		},
+		{
+			name:  "zstd_codec",
+			codec: newZstdCodec(b),
+		},
 		{
 			// This tests the eventstorage performance without
 			// JSON encoding. This would be the theoretical
@@ -126,6 +130,10 @@ func BenchmarkReadEvents(b *testing.B) {
 			name:  "proto_codec",
 			codec: eventstorage.ProtobufCodec{},
 		},
+		{
+			name:  "zstd_codec",
+			codec: newZstdCodec(b),
+		},
 		{
 			// This tests the eventstorage performance without
 			// JSON encoding. This would be the theoretical
@@ -258,6 +266,14 @@ func BenchmarkIsTraceSampled(b *testing.B) {
 	bench("unknown", unknownTraceUUID.String(), true, false)
 }
 
+func newZstdCodec(tb testing.TB) *eventstorage.ZstdCodec {
+	codec, err := eventstorage.NewZstdCodec()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return codec
+}
+
 type nopCodec struct{}
 
 func (nopCodec) DecodeEvent(data []byte, event *modelpb.APMEvent) error { return nil }
@@ -65,6 +65,8 @@ const (
 
 type StorageManagerOptions func(*StorageManager)
 
+// WithCodec configures the Codec used for encoding and decoding events.
+// The default is ProtobufCodec.
 func WithCodec(codec Codec) StorageManagerOptions {
 	return func(sm *StorageManager) {
 		sm.codec = codec
@@ -534,6 +536,65 @@ func (sm *StorageManager) NewReadWriter(storageLimit uint64, diskUsageThreshold
 	return rw
 }
 
+// Status holds a snapshot of the state of a StorageManager.
+type Status struct {
+	// CurrentPartitionID holds the ID of the partition to which
+	// new entries are written.
+	CurrentPartitionID int `json:"current_partition_id"`
+
+	// ActivePartitionIDs holds the IDs of the partitions from which
+	// entries are read, starting with the current partition.
+	ActivePartitionIDs []int `json:"active_partition_ids"`
+
+	// DBSize holds the cached disk usage of the databases in bytes.
+	DBSize uint64 `json:"db_size"`
+
+	// DiskUsed and DiskTotal hold the cached disk usage statistics of
+	// the filesystem holding the databases, in bytes. Both are zero if
+	// disk usage could not be obtained.
+	DiskUsed  uint64 `json:"disk_used"`
+	DiskTotal uint64 `json:"disk_total"`
+
+	// StorageLimit and DiskUsageThreshold hold the configured storage
+	// limit in bytes (zero means unlimited), and disk usage threshold
+	// as a fraction of DiskTotal.
+	StorageLimit       uint64  `json:"storage_limit"`
+	DiskUsageThreshold float64 `json:"disk_usage_threshold"`
+
+	// LimitReached reports whether writes are being rejected due to the
+	// effective storage limit or disk usage threshold being reached.
+	LimitReached bool `json:"limit_reached"`
+}
+
+// Status returns a snapshot of the state of the StorageManager, with limits
+// as configured by the most recent call to NewReadWriter.
+func (sm *StorageManager) Status() Status {
+	status := Status{
+		DBSize:             sm.dbSize(),
+		DiskUsed:           sm.cachedDiskStat.used.Load(),
+		DiskTotal:          sm.cachedDiskStat.total.Load(),
+		StorageLimit:       sm.configuredStorageLimit.Load(),
+		DiskUsageThreshold: float64(sm.configuredDiskUsageThreshold.Load()) / configuredDiskUsageThresholdMultiplier,
+	}
+	sm.partitioner.CurrentIDFunc(func(id int) {
+		status.CurrentPartitionID = id
+	})
+	for id := range sm.partitioner.ActiveIDs() {
+		status.ActivePartitionIDs = append(status.ActivePartitionIDs, id)
+	}
+	// Mirror the limits enforced by the read writers created by NewReadWriter.
+	switch {
+	case status.StorageLimit > 0:
+		status.LimitReached = status.DBSize >= status.StorageLimit
+	case sm.getDiskUsageFailed.Load():
+		status.LimitReached = status.DBSize >= dbStorageLimitFallback
+	default:
+		threshold := uint64(float64(status.DiskTotal) * status.DiskUsageThreshold)
+		status.LimitReached = threshold != 0 && status.DiskUsed >= threshold
+	}
+	return status
+}
+
 // wrapNonNilErr only wraps an error with format if the error is not nil.
 func wrapNonNilErr(format string, err error) error {
 	if err == nil {
@@ -144,6 +144,30 @@ func TestStorageManager_partitionID(t *testing.T) {
 	assert.True(t, sampled)
 }
 
+func TestStorageManager_Status(t *testing.T) {
+	sm := newStorageManager(t,
+		eventstorage.WithGetDBSize(func() uint64 { return 5 }),
+		eventstorage.WithGetDiskUsage(func() (eventstorage.DiskUsage, error) {
+			return eventstorage.DiskUsage{UsedBytes: 20, TotalBytes: 100}, nil
+		}),
+	)
+	sm.NewReadWriter(10, 0.5)
+	assert.Equal(t, eventstorage.Status{
+		CurrentPartitionID: 0,
+		ActivePartitionIDs: []int{0, 2},
+		DBSize:             5,
+		DiskUsed:           20,
+		DiskTotal:          100,
+		StorageLimit:       10,
+		DiskUsageThreshold: 0.5,
+	}, sm.Status())
+
+	require.NoError(t, sm.RotatePartitions())
+	status := sm.Status()
+	assert.Equal(t, 1, status.CurrentPartitionID)
+	assert.Equal(t, []int{1, 0}, status.ActivePartitionIDs)
+}
+
 func TestStorageManager_DiskUsage(t *testing.T) {
 	stopping := make(chan struct{})
 	defer close(stopping)
@@ -331,6 +355,7 @@ func TestStorageManager_StorageLimitDiskUsageThreshold(t *testing.T) {
 			} else {
 				assert.NoError(t, err)
 			}
+			assert.Equal(t, tc.wantErr != nil, sm.Status().LimitReached)
 
 			close(stopping)
 			<-done
@@ -0,0 +1,98 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package eventstorage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/elastic/apm-data/model/modelpb"
+)
+
+const (
+	// encodingPrefix is the first byte of events encoded by ZstdCodec,
+	// followed by an encoding version byte.
+	//
+	// Protobuf-encoded events never begin with a zero byte, as zero is
+	// not a valid field number, so events encoded by ProtobufCodec can
+	// be distinguished from those encoded by ZstdCodec, and each codec
+	// can decode events encoded by the other.
+	encodingPrefix byte = 0
+
+	// encodingVersionZstd identifies zstd-compressed, protobuf-encoded
+	// events. The zstd frame header identifies the dictionary, if any.
+	encodingVersionZstd byte = 1
+)
+
+// ZstdCodec is an implementation of Codec, compressing protobuf-encoded
+// events with zstd, optionally using dictionaries trained on representative
+// events.
+//
+// Encoded events are prefixed with a zero byte and an encoding version.
+// Events without the prefix are decoded as protobuf, so ZstdCodec can
+// read events stored in existing databases by ProtobufCodec.
+type ZstdCodec struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// NewZstdCodec returns a new ZstdCodec.
+//
+// If dicts are specified, they must be zstd dictionaries with unique IDs,
+// such as those created by `zstd --train`. Events are encoded using the
+// last dictionary, and decoded using the dictionary identified in their
+// zstd frame header, so events encoded using earlier dictionaries remain
+// readable for as long as those dictionaries are specified.
+func NewZstdCodec(dicts ...[]byte) (*ZstdCodec, error) {
+	encoderOptions := []zstd.EOption{zstd.WithEncoderLevel(zstd.SpeedDefault)}
+	decoderOptions := []zstd.DOption{zstd.WithDecoderConcurrency(0)}
+	if len(dicts) > 0 {
+		encoderOptions = append(encoderOptions, zstd.WithEncoderDict(dicts[len(dicts)-1]))
+		decoderOptions = append(decoderOptions, zstd.WithDecoderDicts(dicts...))
+	}
+	encoder, err := zstd.NewWriter(nil, encoderOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+	}
+	decoder, err := zstd.NewReader(nil, decoderOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+	}
+	return &ZstdCodec{encoder: encoder, decoder: decoder}, nil
+}
+
+// DecodeEvent decodes data into event. Data is decompressed if it was
+// encoded by ZstdCodec, and otherwise decoded as protobuf.
+func (c *ZstdCodec) DecodeEvent(data []byte, event *modelpb.APMEvent) error {
+	if len(data) == 0 || data[0] != encodingPrefix {
+		return event.UnmarshalVT(data)
+	}
+	if len(data) < 2 {
+		return errors.New("missing event encoding version")
+	}
+	switch version := data[1]; version {
+	case encodingVersionZstd:
+		decoded, err := c.decoder.DecodeAll(data[2:], nil)
+		if err != nil {
+			return fmt.Errorf("failed to decompress event: %w", err)
+		}
+		return event.UnmarshalVT(decoded)
+	default:
+		return fmt.Errorf("unsupported event encoding version %d", version)
+	}
+}
+
+// EncodeEvent encodes event as protobuf, compressed with zstd.
+func (c *ZstdCodec) EncodeEvent(event *modelpb.APMEvent) ([]byte, error) {
+	data, err := event.MarshalVT()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 2, 2+len(data))
+	out[0], out[1] = encodingPrefix, encodingVersionZstd
+	return c.encoder.EncodeAll(data, out), nil
+}
@@ -0,0 +1,196 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package eventstorage_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/eventstorage"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestZstdCodec(t *testing.T) {
+	codec, err := eventstorage.NewZstdCodec()
+	require.NoError(t, err)
+
+	event := makeTransaction("transaction_id", "trace_id")
+	data, err := codec.EncodeEvent(event)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 1}, data[:2])
+
+	var decoded modelpb.APMEvent
+	require.NoError(t, codec.DecodeEvent(data, &decoded))
+	assert.Empty(t, cmp.Diff(event, &decoded, protocmp.Transform()))
+
+	// Events encoded by ProtobufCodec can be decoded.
+	data, err = eventstorage.ProtobufCodec{}.EncodeEvent(event)
+	require.NoError(t, err)
+	decoded = modelpb.APMEvent{}
+	require.NoError(t, codec.DecodeEvent(data, &decoded))
+	assert.Empty(t, cmp.Diff(event, &decoded, protocmp.Transform()))
+
+	err = codec.DecodeEvent([]byte{0}, &decoded)
+	assert.EqualError(t, err, "missing event encoding version")
+	err = codec.DecodeEvent([]byte{0, 255}, &decoded)
+	assert.EqualError(t, err, "unsupported event encoding version 255")
+	err = codec.DecodeEvent([]byte{0, 1, 2, 3}, &decoded)
+	assert.ErrorContains(t, err, "failed to decompress event")
+}
+
+func TestZstdCodecDictionaries(t *testing.T) {
+	dict1 := buildZstdDictionary(t, 1)
+	dict2 := buildZstdDictionary(t, 2)
+
+	noDictCodec, err := eventstorage.NewZstdCodec()
+	require.NoError(t, err)
+	codec1, err := eventstorage.NewZstdCodec(dict1)
+	require.NoError(t, err)
+	codec2, err := eventstorage.NewZstdCodec(dict1, dict2)
+	require.NoError(t, err)
+
+	event := makeTransaction("transaction_id", "trace_id")
+	noDictData, err := noDictCodec.EncodeEvent(event)
+	require.NoError(t, err)
+	data1, err := codec1.EncodeEvent(event)
+	require.NoError(t, err)
+	assert.Less(t, len(data1), len(noDictData))
+
+	// Events encoded with earlier dictionaries, or without a dictionary,
+	// remain readable.
+	for _, data := range [][]byte{noDictData, data1} {
+		var decoded modelpb.APMEvent
+		require.NoError(t, codec2.DecodeEvent(data, &decoded))
+		assert.Empty(t, cmp.Diff(event, &decoded, protocmp.Transform()))
+	}
+
+	// Events encoded with an unknown dictionary cannot be decoded.
+	data2, err := codec2.EncodeEvent(event)
+	require.NoError(t, err)
+	var decoded modelpb.APMEvent
+	err = codec1.DecodeEvent(data2, &decoded)
+	assert.ErrorContains(t, err, "failed to decompress event")
+}
+
+func TestProtobufCodecDecodesZstd(t *testing.T) {
+	dict := buildZstdDictionary(t, 1)
+	noDictCodec, err := eventstorage.NewZstdCodec()
+	require.NoError(t, err)
+	dictCodec, err := eventstorage.NewZstdCodec(dict)
+	require.NoError(t, err)
+
+	event := makeTransaction("transaction_id", "trace_id")
+	noDictData, err := noDictCodec.EncodeEvent(event)
+	require.NoError(t, err)
+	dictData, err := dictCodec.EncodeEvent(event)
+	require.NoError(t, err)
+
+	// Without a ZstdCodec, events encoded without a dictionary
+	// are decoded, but those encoded with a dictionary are not.
+	var decoded modelpb.APMEvent
+	require.NoError(t, eventstorage.ProtobufCodec{}.DecodeEvent(noDictData, &decoded))
+	assert.Empty(t, cmp.Diff(event, &decoded, protocmp.Transform()))
+	err = eventstorage.ProtobufCodec{}.DecodeEvent(dictData, &decoded)
+	assert.ErrorContains(t, err, "failed to decompress event")
+
+	codec := eventstorage.ProtobufCodec{Zstd: dictCodec}
+	for _, data := range [][]byte{noDictData, dictData} {
+		decoded = modelpb.APMEvent{}
+		require.NoError(t, codec.DecodeEvent(data, &decoded))
+		assert.Empty(t, cmp.Diff(event, &decoded, protocmp.Transform()))
+	}
+
+	// Events are still encoded as protobuf.
+	data, err := codec.EncodeEvent(event)
+	require.NoError(t, err)
+	expected, err := event.MarshalVT()
+	require.NoError(t, err)
+	assert.Equal(t, expected, data)
+}
+
+func TestStorageManagerProtobufCodecExistingZstdEvents(t *testing.T) {
+	dir := t.TempDir()
+	traceID := "trace_id"
+
+	// Write an event with ZstdCodec, and reopen the database with
+	// the default codec, which should read the existing event.
+	codec, err := eventstorage.NewZstdCodec()
+	require.NoError(t, err)
+	sm := newStorageManagerNoCleanup(t, dir, logptest.NewTestingLogger(t, ""), eventstorage.WithCodec(codec))
+	require.NoError(t, newUnlimitedReadWriter(sm).WriteTraceEvent(traceID, "transaction_id", makeTransaction("transaction_id", traceID)))
+	require.NoError(t, sm.Close())
+
+	sm = newStorageManagerNoCleanup(t, dir, logptest.NewTestingLogger(t, ""))
+	defer sm.Close()
+	var batch modelpb.Batch
+	require.NoError(t, newUnlimitedReadWriter(sm).ReadTraceEvents(traceID, &batch))
+	require.Len(t, batch, 1)
+	assert.Equal(t, "transaction_id", batch[0].Transaction.Id)
+}
+
+func TestStorageManagerZstdCodecExistingEvents(t *testing.T) {
+	dir := t.TempDir()
+	traceID := "trace_id"
+	event := makeTransaction("transaction_id", traceID)
+
+	// Write an event with the default codec, and reopen the database
+	// with ZstdCodec, which should read the existing event.
+	sm := newStorageManagerNoCleanup(t, dir, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, newUnlimitedReadWriter(sm).WriteTraceEvent(traceID, "transaction_id", event))
+	require.NoError(t, sm.Close())
+
+	codec, err := eventstorage.NewZstdCodec()
+	require.NoError(t, err)
+	sm = newStorageManagerNoCleanup(t, dir, logptest.NewTestingLogger(t, ""), eventstorage.WithCodec(codec))
+	defer sm.Close()
+	rw := newUnlimitedReadWriter(sm)
+	require.NoError(t, rw.WriteTraceEvent(traceID, "span_id", &modelpb.APMEvent{
+		Span: &modelpb.Span{Id: "span_id"},
+	}))
+
+	var batch modelpb.Batch
+	require.NoError(t, rw.ReadTraceEvents(traceID, &batch))
+	var ids []string
+	for _, event := range batch {
+		if event.Transaction != nil {
+			ids = append(ids, event.Transaction.Id)
+		} else {
+			ids = append(ids, event.Span.Id)
+		}
+	}
+	assert.ElementsMatch(t, []string{"transaction_id", "span_id"}, ids)
+}
+
+// buildZstdDictionary builds a zstd dictionary with the given ID,
+// trained on protobuf-encoded transactions.
+func buildZstdDictionary(tb testing.TB, id uint32) []byte {
+	var samples [][]byte
+	var history []byte
+	for i := 0; i < 100; i++ {
+		event := makeTransaction(fmt.Sprintf("transaction_%d", i), fmt.Sprintf("trace_%d", i))
+		data, err := event.MarshalVT()
+		require.NoError(tb, err)
+		samples = append(samples, data)
+		if i < 10 {
+			history = append(history, data...)
+		}
+	}
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: samples,
+		History:  history,
+		Offsets:  [3]int{1, 4, 8},
+		Level:    zstd.SpeedDefault,
+	})
+	require.NoError(tb, err)
+	return dict
+}
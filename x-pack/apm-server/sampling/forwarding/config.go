@@ -0,0 +1,118 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package forwarding
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/transport"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// Config holds configuration for Forwarder.
+type Config struct {
+	// Next holds the modelpb.BatchProcessor which processes events forwarded
+	// by other servers, and events which could not be forwarded.
+	Next modelpb.BatchProcessor
+
+	// MeterProvider holds a metric.MeterProvider that can be used for
+	// creating metrics.
+	MeterProvider metric.MeterProvider
+
+	// Logger is used for logging forwarding errors, which occur asynchronously.
+	Logger *logp.Logger
+
+	// ListenAddress holds the host:port on which to receive events
+	// forwarded by other servers.
+	ListenAddress string
+
+	// AdvertiseAddress holds the host:port by which other servers address
+	// this server. It must match the address this server is discovered by,
+	// i.e. one of Peers or an address resolved from DNSName.
+	AdvertiseAddress string
+
+	// Peers holds the static host:port addresses of other servers.
+	Peers []string
+
+	// DNSName, if non-empty, holds a host:port whose host is resolved to
+	// server addresses every DiscoveryInterval, in addition to Peers.
+	DNSName string
+
+	// DiscoveryInterval holds the time between DNSName lookups.
+	DiscoveryInterval time.Duration
+
+	// HandoffPeriod holds the amount of time after a change in membership
+	// during which events of traces first seen before the change continue
+	// to be routed to their previous owner, if it is still a member. This
+	// should be at least as long as typical traces, so that traces in
+	// progress are not split between servers.
+	HandoffPeriod time.Duration
+
+	// BatchSize holds the maximum number of events forwarded to a server
+	// in each request.
+	BatchSize int
+
+	// FlushInterval holds the maximum amount of time events are buffered
+	// before being forwarded.
+	FlushInterval time.Duration
+
+	// QueueSize holds the maximum number of events buffered for each server.
+	// Events which cannot be buffered are processed locally.
+	QueueSize int
+
+	// MaxRetries holds the maximum number of times a failed request is
+	// retried before its events are processed locally.
+	MaxRetries int
+
+	// Transport holds the transport security configuration for receiving
+	// events from, and forwarding events to, other servers.
+	Transport transport.Config
+}
+
+// Validate validates the configuration.
+func (config Config) Validate() error {
+	if config.Next == nil {
+		return errors.New("Next unspecified")
+	}
+	if config.MeterProvider == nil {
+		return errors.New("MeterProvider unspecified")
+	}
+	if config.ListenAddress == "" {
+		return errors.New("ListenAddress unspecified")
+	}
+	if config.AdvertiseAddress == "" {
+		return errors.New("AdvertiseAddress unspecified")
+	}
+	if config.DNSName != "" {
+		if _, _, err := net.SplitHostPort(config.DNSName); err != nil {
+			return fmt.Errorf("DNSName invalid: %w", err)
+		}
+	}
+	if config.DiscoveryInterval <= 0 {
+		return errors.New("DiscoveryInterval unspecified or negative")
+	}
+	if config.HandoffPeriod < 0 {
+		return errors.New("HandoffPeriod negative")
+	}
+	if config.BatchSize <= 0 {
+		return errors.New("BatchSize unspecified or negative")
+	}
+	if config.FlushInterval <= 0 {
+		return errors.New("FlushInterval unspecified or negative")
+	}
+	if config.QueueSize <= 0 {
+		return errors.New("QueueSize unspecified or negative")
+	}
+	if config.MaxRetries < 0 {
+		return errors.New("MaxRetries negative")
+	}
+	return nil
+}
@@ -0,0 +1,475 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+// Package forwarding provides a modelpb.BatchProcessor which routes trace
+// events between APM Servers, so that all events of a trace are processed
+// by the same server.
+package forwarding
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-server/internal/logs"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+const (
+	loggerRateLimit = time.Minute
+
+	// minBackoff and maxBackoff bound the exponential backoff between
+	// retries of failed requests, and attempts to listen.
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+// Forwarder is a modelpb.BatchProcessor which routes events to the APM Server
+// owning their trace ID on a consistent-hash ring of servers, so that each trace
+// is tail-sampled by exactly one server.
+//
+// Forwarder is intended to be placed in a processor chain before the tail
+// sampler. Events owned by other servers are removed from the batch, and
+// forwarded asynchronously in batches over gRPC; the remaining events continue
+// through the chain. Events forwarded by other servers are processed by the
+// configured Next processor, which should consist of the rest of the chain, as
+// are events which cannot be forwarded after retries. The latter rely on the
+// sharing of sampling decisions between servers.
+//
+// Membership is discovered from static addresses and/or by periodically
+// resolving a DNS name. When membership changes, events of traces which this
+// server first saw before the change continue to be routed to their previous
+// owner for a handoff period, if it is still a member, so that traces in
+// progress are not split between servers. Traces first seen since the change
+// are routed to their new owner immediately.
+//
+// Forwarded events have already been authenticated, rate limited, filtered by
+// IP, and audited by the server which received them from agents. The gRPC
+// service is secured as configured by Transport: without TLS or a secret token
+// it is unauthenticated and unencrypted, and should only be exposed on a
+// private network. Rejected requests are logged.
+type Forwarder struct {
+	config            Config
+	logger            *logp.Logger
+	rateLimitedLogger *logp.Logger
+	routing           atomic.Pointer[routing]
+	firstSeen         *firstSeenTraces
+	now               func() time.Time
+
+	forwarded metric.Int64Counter
+	received  metric.Int64Counter
+	fallback  metric.Int64Counter
+
+	// sendersMu protects senders, and guards against sending to a
+	// sender's queue after it has been closed.
+	sendersMu sync.RWMutex
+	senders   map[string]*sender
+	closed    bool
+	sendersWG sync.WaitGroup
+
+	stopMu   sync.Mutex
+	stopping chan struct{}
+	stopped  chan struct{}
+}
+
+// NewForwarder returns a new Forwarder. Initially, the ring consists
+// of this server and the static peers; DNS discovery begins when Run
+// is called.
+func NewForwarder(config Config) (*Forwarder, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid forwarding config: %w", err)
+	}
+	logger := config.Logger.Named(logs.Sampling)
+	meter := config.MeterProvider.Meter("github.com/elastic/apm-server/x-pack/apm-server/sampling/forwarding")
+	f := &Forwarder{
+		config:            config,
+		logger:            logger,
+		rateLimitedLogger: logger.WithOptions(logs.WithRateLimit(loggerRateLimit)),
+		now:               time.Now,
+		senders:           make(map[string]*sender),
+		stopping:          make(chan struct{}),
+		stopped:           make(chan struct{}),
+	}
+	f.forwarded, _ = meter.Int64Counter("apm-server.sampling.tail.forwarding.events.forwarded")
+	f.received, _ = meter.Int64Counter("apm-server.sampling.tail.forwarding.events.received")
+	f.fallback, _ = meter.Int64Counter("apm-server.sampling.tail.forwarding.events.fallback")
+	f.routing.Store(&routing{current: newHashRing(f.members(nil))})
+	if config.HandoffPeriod > 0 {
+		f.firstSeen = newFirstSeenTraces(config.HandoffPeriod, f.now())
+	}
+	return f, nil
+}
+
+// ProcessBatch removes events owned by other servers from the batch,
+// queuing them to be forwarded. Events owned by this server, and events
+// without a trace ID, are left in the batch.
+func (f *Forwarder) ProcessBatch(ctx context.Context, batch *modelpb.Batch) error {
+	select {
+	case <-f.stopping:
+		// Process all events locally after Stop is called.
+		return nil
+	default:
+	}
+
+	f.sendersMu.RLock()
+	defer f.sendersMu.RUnlock()
+	if f.closed {
+		return nil
+	}
+
+	routing := f.routing.Load()
+	now := f.now()
+	events := *batch
+	for i := 0; i < len(events); i++ {
+		traceID := events[i].GetTrace().GetId()
+		if traceID == "" {
+			continue
+		}
+		firstSeen := now
+		if f.firstSeen != nil {
+			firstSeen = f.firstSeen.observe(traceID, now)
+		}
+		owner := routing.owner(traceID, firstSeen, now)
+		if owner == f.config.AdvertiseAddress {
+			continue
+		}
+		data, err := events[i].MarshalVT()
+		if err != nil {
+			return err
+		}
+		if !f.enqueue(owner, data) {
+			// The owner's queue is full, so process the event locally.
+			f.fallback.Add(context.Background(), 1)
+			continue
+		}
+		f.forwarded.Add(context.Background(), 1)
+		n := len(events)
+		events[i], events[n-1] = events[n-1], events[i]
+		events = events[:n-1]
+		i--
+	}
+	*batch = events
+	return nil
+}
+
+// enqueue queues an encoded event to be forwarded to addr, returning false
+// if the queue is full. enqueue must be called with sendersMu read-locked.
+func (f *Forwarder) enqueue(addr string, event []byte) bool {
+	s, ok := f.senders[addr]
+	if !ok {
+		// Upgrade to a write lock to create the sender.
+		f.sendersMu.RUnlock()
+		s = f.getOrCreateSender(addr)
+		f.sendersMu.RLock()
+		if s == nil || f.senders[addr] != s {
+			// The sender was closed while unlocked.
+			return false
+		}
+	}
+	select {
+	case s.queue <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *Forwarder) getOrCreateSender(addr string) *sender {
+	f.sendersMu.Lock()
+	defer f.sendersMu.Unlock()
+	if f.closed {
+		return nil
+	}
+	if s, ok := f.senders[addr]; ok {
+		return s
+	}
+	s := &sender{
+		forwarder: f,
+		addr:      addr,
+		queue:     make(chan []byte, f.config.QueueSize),
+	}
+	f.senders[addr] = s
+	f.sendersWG.Add(1)
+	go func() {
+		defer f.sendersWG.Done()
+		s.run()
+	}()
+	return s
+}
+
+// closeSenders closes the queues of senders whose addresses keep does not
+// return true for, or all senders if keep is nil, after which no more senders
+// are created. Closed senders forward their queued events and then exit.
+func (f *Forwarder) closeSenders(keep func(addr string) bool) {
+	f.sendersMu.Lock()
+	defer f.sendersMu.Unlock()
+	for addr, s := range f.senders {
+		if keep != nil && keep(addr) {
+			continue
+		}
+		close(s.queue)
+		delete(f.senders, addr)
+	}
+	if keep == nil {
+		f.closed = true
+	}
+}
+
+// forward processes events forwarded by another server.
+func (f *Forwarder) forward(ctx context.Context, req *forwardRequest) error {
+	batch, err := decodeEvents(req.events)
+	if err != nil {
+		return err
+	}
+	f.received.Add(context.Background(), int64(len(batch)))
+	return f.config.Next.ProcessBatch(ctx, &batch)
+}
+
+// processLocally processes encoded events which could not be forwarded.
+func (f *Forwarder) processLocally(events [][]byte) {
+	f.fallback.Add(context.Background(), int64(len(events)))
+	batch, err := decodeEvents(events)
+	if err == nil {
+		err = f.config.Next.ProcessBatch(context.Background(), &batch)
+	}
+	if err != nil {
+		f.rateLimitedLogger.With(logp.Error(err)).Warn("failed to process events locally after forwarding failed")
+	}
+}
+
+func decodeEvents(events [][]byte) (modelpb.Batch, error) {
+	batch := make(modelpb.Batch, len(events))
+	for i, data := range events {
+		event := &modelpb.APMEvent{}
+		if err := event.UnmarshalVT(data); err != nil {
+			return nil, err
+		}
+		batch[i] = event
+	}
+	return batch, nil
+}
+
+// Stop stops the forwarder. Events subsequently passed to ProcessBatch are
+// processed locally, and queued events are forwarded before Run returns.
+func (f *Forwarder) Stop(ctx context.Context) error {
+	f.stopMu.Lock()
+	select {
+	case <-f.stopped:
+	case <-f.stopping:
+		// already stopped or stopping
+	default:
+		close(f.stopping)
+	}
+	f.stopMu.Unlock()
+
+	// Wait for Run to return.
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-f.stopped:
+	}
+	return nil
+}
+
+// Run runs the forwarder, receiving events forwarded by other servers and
+// periodically discovering members, until Stop is called.
+func (f *Forwarder) Run() error {
+	defer func() {
+		f.stopMu.Lock()
+		defer f.stopMu.Unlock()
+		select {
+		case <-f.stopped:
+		default:
+			close(f.stopped)
+		}
+	}()
+
+	server := grpc.NewServer(append(
+		f.config.Transport.ServerOptions(f.rateLimitedLogger),
+		grpc.ForceServerCodec(forwardCodec{}),
+	)...)
+	server.RegisterService(&forwardServiceDesc, f)
+	serveCtx, cancelServe := context.WithCancel(context.Background())
+	defer cancelServe()
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- f.serve(serveCtx, server) }()
+
+	ticker := time.NewTicker(f.config.DiscoveryInterval)
+	defer ticker.Stop()
+	f.discover()
+	var result error
+loop:
+	for {
+		select {
+		case <-f.stopping:
+			break loop
+		case err := <-serveErr:
+			result = fmt.Errorf("failed to receive forwarded events: %w", err)
+			break loop
+		case <-ticker.C:
+			f.discover()
+		}
+	}
+
+	// Forward any queued events, and wait for in-flight
+	// requests from other servers to be processed.
+	f.closeSenders(nil)
+	f.sendersWG.Wait()
+	cancelServe()
+	server.GracefulStop()
+	return result
+}
+
+// serve listens on ListenAddress and receives forwarded events until
+// server is stopped, retrying with exponential backoff if listening fails.
+func (f *Forwarder) serve(ctx context.Context, server *grpc.Server) error {
+	backoff := minBackoff
+	for {
+		listener, err := net.Listen("tcp", f.config.ListenAddress)
+		if err == nil {
+			if err := server.Serve(listener); err != grpc.ErrServerStopped {
+				return err
+			}
+			return nil
+		}
+		f.rateLimitedLogger.With(logp.Error(err)).Warn("failed to listen for forwarded events, retrying")
+		if !sleep(ctx, backoff) {
+			return nil
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// discover updates the ring with the currently discovered members, and
+// closes senders to servers which are no longer members.
+func (f *Forwarder) discover() {
+	var resolved []string
+	if f.config.DNSName != "" {
+		host, port, _ := net.SplitHostPort(f.config.DNSName)
+		ctx, cancel := context.WithTimeout(context.Background(), f.config.DiscoveryInterval)
+		hosts, err := net.DefaultResolver.LookupHost(ctx, host)
+		cancel()
+		if err != nil {
+			// Keep the existing members.
+			f.rateLimitedLogger.With(logp.Error(err)).Warnf("failed to resolve %q", host)
+			return
+		}
+		for _, host := range hosts {
+			resolved = append(resolved, net.JoinHostPort(host, port))
+		}
+	}
+	old := f.routing.Load()
+	updated := old.update(f.members(resolved), f.now(), f.config.HandoffPeriod)
+	if updated == old {
+		return
+	}
+	f.routing.Store(updated)
+	f.logger.Infof("forwarding ring changed: %d members", len(updated.current.members))
+	f.closeSenders(func(addr string) bool {
+		return updated.current.has(addr) || (updated.previous != nil && updated.previous.has(addr))
+	})
+}
+
+// members returns the ring members: this server, the static peers,
+// and the given resolved addresses.
+func (f *Forwarder) members(resolved []string) []string {
+	members := make([]string, 0, 1+len(f.config.Peers)+len(resolved))
+	members = append(members, f.config.AdvertiseAddress)
+	members = append(members, f.config.Peers...)
+	return append(members, resolved...)
+}
+
+// sender forwards queued events to a single server.
+type sender struct {
+	forwarder *Forwarder
+	addr      string
+	queue     chan []byte
+}
+
+// run forwards queued events in batches of up to BatchSize, or every
+// FlushInterval, until the queue is closed and drained.
+func (s *sender) run() {
+	f := s.forwarder
+	conn, err := grpc.NewClient(s.addr, append(
+		f.config.Transport.DialOptions(s.addr),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(forwardCodec{})),
+	)...)
+	if err != nil {
+		f.rateLimitedLogger.With(logp.Error(err)).Warnf("failed to create client for %s", s.addr)
+		for event := range s.queue {
+			f.processLocally([][]byte{event})
+		}
+		return
+	}
+	defer conn.Close()
+
+	ticker := time.NewTicker(f.config.FlushInterval)
+	defer ticker.Stop()
+	events := make([][]byte, 0, f.config.BatchSize)
+	flush := func() {
+		if len(events) != 0 {
+			s.send(conn, events)
+			events = make([][]byte, 0, f.config.BatchSize)
+		}
+	}
+	for {
+		select {
+		case event, ok := <-s.queue:
+			if !ok {
+				flush()
+				return
+			}
+			events = append(events, event)
+			if len(events) == f.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// send forwards events, retrying up to MaxRetries times with exponential
+// backoff, after which the events are processed locally.
+func (s *sender) send(conn *grpc.ClientConn, events [][]byte) {
+	f := s.forwarder
+	req := &forwardRequest{events: events}
+	backoff := minBackoff
+	var err error
+	for attempt := 0; attempt <= f.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			sleep(context.Background(), backoff)
+			backoff = min(backoff*2, maxBackoff)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), maxBackoff)
+		err = conn.Invoke(ctx, forwardMethod, req, &forwardResponse{})
+		cancel()
+		if err == nil {
+			return
+		}
+	}
+	f.rateLimitedLogger.With(logp.Error(err)).Warnf(
+		"failed to forward %d events to %s, processing locally", len(events), s.addr,
+	)
+	f.processLocally(events)
+}
+
+// sleep sleeps for d, returning false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
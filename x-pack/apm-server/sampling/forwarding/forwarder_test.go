@@ -0,0 +1,195 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package forwarding_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/elastic/apm-data/model/modelpb"
+	"github.com/elastic/apm-data/model/modelprocessor"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/forwarding"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/transport"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestForwarder(t *testing.T) {
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	var forwarders []modelpb.BatchProcessor
+	var processed []*recordingProcessor
+	for i, addr := range addrs {
+		next := &recordingProcessor{}
+		f := newForwarder(t, next, addr, addrs[1-i])
+		forwarders = append(forwarders, modelprocessor.Chained{f, next})
+		processed = append(processed, next)
+	}
+
+	// Send events for a number of traces to each server. All events of a
+	// trace should be processed by the same server, regardless of which
+	// server received them.
+	const traces = 100
+	for _, f := range forwarders {
+		var batch modelpb.Batch
+		for i := 0; i < traces; i++ {
+			batch = append(batch, &modelpb.APMEvent{
+				Trace:       &modelpb.Trace{Id: fmt.Sprintf("trace_%d", i)},
+				Transaction: &modelpb.Transaction{Id: fmt.Sprintf("transaction_%d", i)},
+			})
+		}
+		// Events without a trace ID are always processed locally.
+		batch = append(batch, &modelpb.APMEvent{Metricset: &modelpb.Metricset{Name: "metricset"}})
+		require.NoError(t, f.ProcessBatch(context.Background(), &batch))
+	}
+
+	assert.Eventually(t, func() bool {
+		return processed[0].len()+processed[1].len() == 2*traces+2
+	}, 10*time.Second, 10*time.Millisecond)
+
+	owners := make(map[string]int)
+	for i, p := range processed {
+		var metricsets int
+		for _, event := range p.events() {
+			if event.Metricset != nil {
+				metricsets++
+				continue
+			}
+			traceID := event.Trace.Id
+			if owner, ok := owners[traceID]; ok {
+				assert.Equal(t, owner, i, "trace %s split between servers", traceID)
+			}
+			owners[traceID] = i
+		}
+		assert.Equal(t, 1, metricsets)
+	}
+	assert.Len(t, owners, traces)
+	// Both servers should own some traces.
+	var counts [2]int
+	for _, owner := range owners {
+		counts[owner]++
+	}
+	assert.NotZero(t, counts[0])
+	assert.NotZero(t, counts[1])
+}
+
+func TestForwarderFallback(t *testing.T) {
+	// The peer is not running, so events owned by the
+	// peer are processed locally after retrying.
+	next := &recordingProcessor{}
+	f := newForwarder(t, next, freeAddr(t), freeAddr(t))
+
+	const traces = 100
+	var batch modelpb.Batch
+	for i := 0; i < traces; i++ {
+		batch = append(batch, &modelpb.APMEvent{
+			Trace: &modelpb.Trace{Id: fmt.Sprintf("trace_%d", i)},
+		})
+	}
+	require.NoError(t, f.ProcessBatch(context.Background(), &batch))
+	assert.Less(t, len(batch), traces)
+	require.NoError(t, next.ProcessBatch(context.Background(), &batch))
+	assert.Eventually(t, func() bool {
+		return next.len() == traces
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestForwarderSecretToken(t *testing.T) {
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	next := []*recordingProcessor{{}, {}}
+	forwarder := newForwarderTransport(t, next[0], transport.Config{SecretToken: "abc123"}, addrs[0], addrs[1])
+	newForwarderTransport(t, next[1], transport.Config{SecretToken: "xyz"}, addrs[1], addrs[0])
+
+	// Events are rejected by the peer, which requires a different
+	// secret token, so they are all processed locally.
+	const traces = 100
+	var batch modelpb.Batch
+	for i := 0; i < traces; i++ {
+		batch = append(batch, &modelpb.APMEvent{
+			Trace: &modelpb.Trace{Id: fmt.Sprintf("trace_%d", i)},
+		})
+	}
+	require.NoError(t, forwarder.ProcessBatch(context.Background(), &batch))
+	assert.Less(t, len(batch), traces)
+	require.NoError(t, next[0].ProcessBatch(context.Background(), &batch))
+	assert.Eventually(t, func() bool {
+		return next[0].len() == traces
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Zero(t, next[1].len())
+}
+
+func newForwarder(t testing.TB, next modelpb.BatchProcessor, addr string, peers ...string) *forwarding.Forwarder {
+	return newForwarderTransport(t, next, transport.Config{}, addr, peers...)
+}
+
+func newForwarderTransport(
+	t testing.TB, next modelpb.BatchProcessor, transportConfig transport.Config, addr string, peers ...string,
+) *forwarding.Forwarder {
+	f, err := forwarding.NewForwarder(forwarding.Config{
+		Next:              next,
+		MeterProvider:     metricnoop.NewMeterProvider(),
+		Logger:            logptest.NewTestingLogger(t, ""),
+		ListenAddress:     addr,
+		AdvertiseAddress:  addr,
+		Peers:             peers,
+		DiscoveryInterval: time.Minute,
+		HandoffPeriod:     time.Minute,
+		BatchSize:         10,
+		FlushInterval:     10 * time.Millisecond,
+		QueueSize:         1000,
+		MaxRetries:        1,
+		Transport:         transportConfig,
+	})
+	require.NoError(t, err)
+	runDone := make(chan error, 1)
+	go func() { runDone <- f.Run() }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		assert.NoError(t, f.Stop(ctx))
+		assert.NoError(t, <-runDone)
+	})
+	return f
+}
+
+type recordingProcessor struct {
+	mu    sync.Mutex
+	batch modelpb.Batch
+}
+
+func (p *recordingProcessor) ProcessBatch(ctx context.Context, batch *modelpb.Batch) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, event := range *batch {
+		p.batch = append(p.batch, event.CloneVT())
+	}
+	return nil
+}
+
+func (p *recordingProcessor) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.batch)
+}
+
+func (p *recordingProcessor) events() modelpb.Batch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.batch
+}
+
+// freeAddr returns a loopback address with a port that is not in use.
+func freeAddr(t testing.TB) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+	return lis.Addr().String()
+}
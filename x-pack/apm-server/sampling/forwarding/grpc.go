@@ -0,0 +1,104 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package forwarding
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	forwardServiceName = "elastic.apm.sampling.Forwarder"
+	forwardMethod      = "/" + forwardServiceName + "/Forward"
+)
+
+// forwardRequest holds a batch of protobuf-encoded modelpb.APMEvents.
+type forwardRequest struct {
+	events [][]byte
+}
+
+// forwardResponse is the empty response to a forwardRequest.
+type forwardResponse struct{}
+
+// forwardCodec is a gRPC codec which encodes forwardRequests as a sequence
+// of length-prefixed events, which have already been encoded by the sender.
+// This avoids the need for generated protobuf code for the request.
+type forwardCodec struct{}
+
+func (forwardCodec) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case *forwardRequest:
+		var size int
+		for _, event := range v.events {
+			size += binary.MaxVarintLen64 + len(event)
+		}
+		data := make([]byte, 0, size)
+		for _, event := range v.events {
+			data = binary.AppendUvarint(data, uint64(len(event)))
+			data = append(data, event...)
+		}
+		return data, nil
+	case *forwardResponse:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unexpected message type %T", v)
+}
+
+func (forwardCodec) Unmarshal(data []byte, v any) error {
+	switch v := v.(type) {
+	case *forwardRequest:
+		for len(data) > 0 {
+			n, size := binary.Uvarint(data)
+			if size <= 0 || n > uint64(len(data)-size) {
+				return errors.New("invalid event length")
+			}
+			data = data[size:]
+			v.events = append(v.events, data[:n:n])
+			data = data[n:]
+		}
+		return nil
+	case *forwardResponse:
+		return nil
+	}
+	return fmt.Errorf("unexpected message type %T", v)
+}
+
+func (forwardCodec) Name() string { return "apm-events" }
+
+// forwardServer is the handler type for forwardServiceDesc.
+type forwardServer interface {
+	forward(ctx context.Context, req *forwardRequest) error
+}
+
+var forwardServiceDesc = grpc.ServiceDesc{
+	ServiceName: forwardServiceName,
+	HandlerType: (*forwardServer)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Forward",
+		Handler:    forwardHandler,
+	}},
+}
+
+func forwardHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	var req forwardRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		if err := srv.(forwardServer).forward(ctx, req.(*forwardRequest)); err != nil {
+			return nil, err
+		}
+		return &forwardResponse{}, nil
+	}
+	if interceptor == nil {
+		return handler(ctx, &req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: forwardMethod}
+	return interceptor(ctx, &req, info, handler)
+}
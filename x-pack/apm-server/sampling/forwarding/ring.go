@@ -0,0 +1,174 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package forwarding
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// ringVirtualNodes is the number of points on the ring for each member,
+// which evens out the distribution of trace IDs between members.
+const ringVirtualNodes = 128
+
+// hashRing is a consistent-hash ring, assigning trace IDs to members such
+// that a change in membership moves only the trace IDs owned by members
+// which left, or which are taken over by members which joined.
+type hashRing struct {
+	members []string // sorted
+	points  []uint64 // sorted
+	owners  []string // owners[i] owns points[i]
+}
+
+func newHashRing(members []string) *hashRing {
+	r := &hashRing{members: slices.Clone(members)}
+	slices.Sort(r.members)
+	r.members = slices.Compact(r.members)
+	type point struct {
+		hash  uint64
+		owner string
+	}
+	points := make([]point, 0, len(r.members)*ringVirtualNodes)
+	for _, member := range r.members {
+		for i := 0; i < ringVirtualNodes; i++ {
+			points = append(points, point{
+				hash:  xxhash.Sum64String(member + "#" + strconv.Itoa(i)),
+				owner: member,
+			})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].owner < points[j].owner
+		}
+		return points[i].hash < points[j].hash
+	})
+	r.points = make([]uint64, len(points))
+	r.owners = make([]string, len(points))
+	for i, p := range points {
+		r.points[i] = p.hash
+		r.owners[i] = p.owner
+	}
+	return r
+}
+
+// owner returns the member owning traceID: the owner of the first point
+// on the ring at or after the trace ID's hash.
+func (r *hashRing) owner(traceID string) string {
+	hash := xxhash.Sum64String(traceID)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// has reports whether member is a member of the ring.
+func (r *hashRing) has(member string) bool {
+	_, found := slices.BinarySearch(r.members, member)
+	return found
+}
+
+// routing holds the current ring, and the previous ring during handoff.
+type routing struct {
+	current *hashRing
+
+	// previous, if non-nil, holds the ring which was current until
+	// membership changed at changed. Until handoffUntil, events of
+	// traces first seen before changed are routed to their owner in
+	// the previous ring if it is still a member.
+	previous     *hashRing
+	changed      time.Time
+	handoffUntil time.Time
+}
+
+// owner returns the member to which events for traceID, first seen by this
+// server at firstSeen, should be routed. During handoff, traces first seen
+// before membership changed are routed to their previous owner if it is
+// still a member, so that traces in progress are not split between servers,
+// while traces first seen since are routed to their current owner.
+func (r *routing) owner(traceID string, firstSeen, now time.Time) string {
+	if r.previous != nil && now.Before(r.handoffUntil) && firstSeen.Before(r.changed) {
+		if owner := r.previous.owner(traceID); r.current.has(owner) {
+			return owner
+		}
+	}
+	return r.current.owner(traceID)
+}
+
+// update returns a new routing with the given members, handing off from
+// the effective ring at now for handoffPeriod. If members are unchanged,
+// update returns r.
+func (r *routing) update(members []string, now time.Time, handoffPeriod time.Duration) *routing {
+	current := newHashRing(members)
+	if slices.Equal(current.members, r.current.members) {
+		return r
+	}
+	previous, changed := r.current, now
+	if r.previous != nil && now.Before(r.handoffUntil) {
+		// Membership changed again during handoff. Continue handing
+		// off from the ring that owned traces before the first change,
+		// whose owners are still members.
+		previous, changed = r.previous, r.changed
+	}
+	return &routing{
+		current:      current,
+		previous:     previous,
+		changed:      changed,
+		handoffUntil: now.Add(handoffPeriod),
+	}
+}
+
+// firstSeenTraces records the times at which trace IDs were first seen
+// by this server, for routing during handoff. Trace IDs are held for at
+// least retention after they were last seen, in two generations which
+// are rotated every retention period.
+type firstSeenTraces struct {
+	retention time.Duration
+
+	mu       sync.Mutex
+	rotated  time.Time
+	current  map[uint64]int64
+	previous map[uint64]int64
+}
+
+func newFirstSeenTraces(retention time.Duration, now time.Time) *firstSeenTraces {
+	return &firstSeenTraces{
+		retention: retention,
+		rotated:   now,
+		current:   make(map[uint64]int64),
+		previous:  make(map[uint64]int64),
+	}
+}
+
+// observe records traceID as seen at now, and returns the
+// time at which it was first seen.
+func (s *firstSeenTraces) observe(traceID string, now time.Time) time.Time {
+	hash := xxhash.Sum64String(traceID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if since := now.Sub(s.rotated); since >= s.retention {
+		s.previous, s.current = s.current, make(map[uint64]int64, len(s.current))
+		if since >= 2*s.retention {
+			clear(s.previous)
+		}
+		s.rotated = now
+	}
+	if firstSeen, ok := s.current[hash]; ok {
+		return time.Unix(0, firstSeen)
+	}
+	firstSeen, ok := s.previous[hash]
+	if !ok {
+		firstSeen = now.UnixNano()
+	}
+	// Keep traces which are still active in the current generation.
+	s.current[hash] = firstSeen
+	return time.Unix(0, firstSeen)
+}
@@ -0,0 +1,91 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package forwarding
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashRingDistribution(t *testing.T) {
+	members := []string{"a:8202", "b:8202", "c:8202"}
+	ring := newHashRing(members)
+	const traces = 30000
+	counts := make(map[string]int)
+	for i := 0; i < traces; i++ {
+		counts[ring.owner(fmt.Sprintf("trace_%d", i))]++
+	}
+	for _, member := range members {
+		assert.InDelta(t, traces/len(members), counts[member], traces*0.05, member)
+	}
+}
+
+func TestHashRingMembershipChange(t *testing.T) {
+	before := newHashRing([]string{"a:8202", "b:8202", "c:8202"})
+	after := newHashRing([]string{"c:8202", "a:8202", "b:8202", "d:8202"})
+	var moved int
+	const traces = 10000
+	for i := 0; i < traces; i++ {
+		traceID := fmt.Sprintf("trace_%d", i)
+		if owner := after.owner(traceID); owner != before.owner(traceID) {
+			// Only trace IDs taken over by the new member move.
+			assert.Equal(t, "d:8202", owner)
+			moved++
+		}
+	}
+	assert.InDelta(t, traces/4, moved, traces*0.05)
+}
+
+func TestRoutingHandoff(t *testing.T) {
+	now := time.Now()
+	before := now.Add(-time.Second)
+	r := &routing{current: newHashRing([]string{"a:8202", "b:8202"})}
+	assert.Same(t, r, r.update([]string{"b:8202", "a:8202"}, now, time.Minute))
+
+	joined := r.update([]string{"a:8202", "b:8202", "c:8202"}, now, time.Minute)
+	left := joined.update([]string{"a:8202", "c:8202"}, now.Add(time.Second), time.Minute)
+	for i := 0; i < 1000; i++ {
+		traceID := fmt.Sprintf("trace_%d", i)
+		// During handoff, traces first seen before membership changed
+		// are routed to their previous owner while it remains a member.
+		previousOwner := r.current.owner(traceID)
+		assert.Equal(t, previousOwner, joined.owner(traceID, before, now))
+		if previousOwner == "a:8202" {
+			assert.Equal(t, previousOwner, left.owner(traceID, before, now))
+		} else {
+			assert.Equal(t, left.current.owner(traceID), left.owner(traceID, before, now))
+		}
+
+		// Traces first seen since membership changed are
+		// routed to their current owner immediately.
+		assert.Equal(t, joined.current.owner(traceID), joined.owner(traceID, now, now))
+		assert.Equal(t, left.current.owner(traceID), left.owner(traceID, now, now))
+
+		// After handoff, traces are routed to their current owner.
+		later := now.Add(2 * time.Minute)
+		assert.Equal(t, joined.current.owner(traceID), joined.owner(traceID, before, later))
+		assert.Equal(t, left.current.owner(traceID), left.owner(traceID, before, later))
+	}
+}
+
+func TestFirstSeenTraces(t *testing.T) {
+	now := time.Unix(0, time.Now().UnixNano())
+	s := newFirstSeenTraces(time.Minute, now)
+	assert.Equal(t, now, s.observe("a", now))
+	assert.Equal(t, now, s.observe("a", now.Add(time.Second)))
+
+	// Traces which are still active are retained across rotations.
+	assert.Equal(t, now.Add(time.Second), s.observe("b", now.Add(time.Second)))
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, now, s.observe("a", now.Add(time.Duration(i)*time.Minute)))
+	}
+
+	// Traces which have not been seen for at least two rotations are forgotten.
+	later := now.Add(5 * time.Minute)
+	assert.Equal(t, later, s.observe("b", later))
+}
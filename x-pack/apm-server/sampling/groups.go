@@ -9,9 +9,11 @@ import (
 	"errors"
 	"math"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 
 	"github.com/elastic/apm-data/model/modelpb"
@@ -30,6 +32,10 @@ type traceGroups struct {
 	// exponentially weighted moving average ingest rate for each trace group.
 	ingestRateDecayFactor float64
 
+	// interval holds the tail-sampling interval, used for converting
+	// throughput targets to a number of traces per interval.
+	interval time.Duration
+
 	// maxDynamicServiceGroups holds the maximum number of dynamic service groups
 	// to maintain. Once this is reached, no new dynamic service groups will
 	// be created, and events may be dropped.
@@ -39,15 +45,35 @@ type traceGroups struct {
 	// of dynamic service groups.
 	numDynamicServiceGroupsCounter metric.Int64UpDownCounter
 
+	// sampledTracesCounter, if non-nil, is used for reporting the number
+	// of traces sampled by each trace group in finalizeSampledTraces. This
+	// is used for shadow policies, whose sampling decisions have no effect.
+	sampledTracesCounter metric.Int64Counter
+
 	mu                      sync.RWMutex
 	policyGroups            []policyGroup
 	numDynamicServiceGroups int
+
+	// policiesVersion is incremented by updatePolicies, so policy groups
+	// matched without holding mu can be checked for replacement.
+	policiesVersion int
+
+	// draining holds the trace groups of policies removed by updatePolicies.
+	// Their reservoirs are finalized, and the groups discarded, by the next
+	// call to finalizeSampledTraces.
+	draining []*traceGroup
+
+	// forced holds the IDs of traces whose root transactions were not
+	// admitted to a reservoir, but which must be sampled as they have
+	// been marked as interesting. forced is reset by finalizeSampledTraces.
+	forced []string
 }
 
 type policyGroup struct {
-	policy  Policy
-	g       *traceGroup            // nil for catch-all
-	dynamic map[string]*traceGroup // nil for static
+	policy     Policy
+	conditions []conditionMatcher
+	g          *traceGroup            // nil for catch-all
+	dynamic    map[string]*traceGroup // nil for static
 }
 
 func (g *policyGroup) match(transactionEvent *modelpb.APMEvent) bool {
@@ -63,6 +89,20 @@ func (g *policyGroup) match(transactionEvent *modelpb.APMEvent) bool {
 	if g.policy.TraceName != "" && g.policy.TraceName != transactionEvent.Transaction.Name {
 		return false
 	}
+	if g.policy.MinTraceDuration != 0 || g.policy.MaxTraceDuration != 0 {
+		duration := time.Duration(transactionEvent.GetEvent().GetDuration())
+		if duration < g.policy.MinTraceDuration {
+			return false
+		}
+		if g.policy.MaxTraceDuration != 0 && duration >= g.policy.MaxTraceDuration {
+			return false
+		}
+	}
+	for i := range g.conditions {
+		if !g.conditions[i].match(transactionEvent) {
+			return false
+		}
+	}
 	return true
 }
 
@@ -71,34 +111,183 @@ func newTraceGroups(
 	policies []Policy,
 	maxDynamicServiceGroups int,
 	ingestRateDecayFactor float64,
+	interval time.Duration,
+) *traceGroups {
+	return newTraceGroupsWithMetricPrefix(
+		meter, "apm-server.sampling.tail.", policies,
+		maxDynamicServiceGroups, ingestRateDecayFactor, interval,
+	)
+}
+
+// newShadowTraceGroups returns traceGroups for evaluating shadow policies.
+// Their metrics are named with the prefix "apm-server.sampling.tail.shadow.",
+// and the number of traces that each trace group would have sampled is
+// reported by finalizeSampledTraces.
+func newShadowTraceGroups(
+	meter metric.Meter,
+	policies []Policy,
+	maxDynamicServiceGroups int,
+	ingestRateDecayFactor float64,
+	interval time.Duration,
+) *traceGroups {
+	const metricPrefix = "apm-server.sampling.tail.shadow."
+	groups := newTraceGroupsWithMetricPrefix(
+		meter, metricPrefix, policies,
+		maxDynamicServiceGroups, ingestRateDecayFactor, interval,
+	)
+	groups.sampledTracesCounter, _ = meter.Int64Counter(metricPrefix + "traces.sampled")
+	return groups
+}
+
+func newTraceGroupsWithMetricPrefix(
+	meter metric.Meter,
+	metricPrefix string,
+	policies []Policy,
+	maxDynamicServiceGroups int,
+	ingestRateDecayFactor float64,
+	interval time.Duration,
 ) *traceGroups {
-	numDynamicServiceGroupsCounter, _ := meter.Int64UpDownCounter("apm-server.sampling.tail.dynamic_service_groups")
+	numDynamicServiceGroupsCounter, _ := meter.Int64UpDownCounter(metricPrefix + "dynamic_service_groups")
 	groups := &traceGroups{
 		ingestRateDecayFactor:          ingestRateDecayFactor,
+		interval:                       interval,
 		maxDynamicServiceGroups:        maxDynamicServiceGroups,
 		numDynamicServiceGroupsCounter: numDynamicServiceGroupsCounter,
 		policyGroups:                   make([]policyGroup, len(policies)),
 	}
 	for i, policy := range policies {
-		pg := policyGroup{policy: policy}
+		groups.policyGroups[i] = newPolicyGroup(policy)
 		if policy.ServiceName != "" {
-			pg.g = newTraceGroup(policy.SampleRate)
-		} else {
-			pg.dynamic = make(map[string]*traceGroup)
+			groups.policyGroups[i].g = newTraceGroup(policy, interval)
 		}
-		groups.policyGroups[i] = pg
 	}
+	_, _ = meter.Float64ObservableGauge(
+		metricPrefix+"sample_rate",
+		metric.WithFloat64Callback(groups.observeSampleRates),
+	)
 	return groups
 }
 
+// newPolicyGroup returns a policyGroup for policy. If the policy specifies
+// a service name, the caller must set the policyGroup's trace group.
+func newPolicyGroup(policy Policy) policyGroup {
+	pg := policyGroup{policy: policy}
+	for _, condition := range policy.Conditions {
+		pg.conditions = append(pg.conditions, newConditionMatcher(condition))
+	}
+	if policy.ServiceName == "" {
+		pg.dynamic = make(map[string]*traceGroup)
+	}
+	return pg
+}
+
+// updatePolicies atomically replaces the policies of the trace groups.
+//
+// Trace groups of existing policies with the same criteria as an updated
+// policy are retained, with the updated policy's sampling parameters, so
+// that their ingest rates and sampling reservoirs are carried over. Trace
+// groups of removed policies are drained by the next finalizeSampledTraces
+// call, sampling the traces in their reservoirs according to the removed
+// policies.
+func (g *traceGroups) updatePolicies(policies []Policy) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	old := g.policyGroups
+	retained := make([]bool, len(old))
+	var numDynamicServiceGroups int
+	g.policyGroups = make([]policyGroup, len(policies))
+	for i, policy := range policies {
+		pg := newPolicyGroup(policy)
+		for j := range old {
+			if retained[j] || !old[j].policy.PolicyCriteria.equal(policy.PolicyCriteria) {
+				continue
+			}
+			retained[j] = true
+			if old[j].g != nil {
+				old[j].g.setPolicy(policy, g.interval)
+				pg.g = old[j].g
+			}
+			for serviceName, group := range old[j].dynamic {
+				group.setPolicy(policy, g.interval)
+				pg.dynamic[serviceName] = group
+				numDynamicServiceGroups++
+			}
+			break
+		}
+		if pg.g == nil && pg.dynamic == nil {
+			pg.g = newTraceGroup(policy, g.interval)
+		}
+		g.policyGroups[i] = pg
+	}
+	for j, pg := range old {
+		if retained[j] {
+			continue
+		}
+		if pg.g != nil {
+			g.draining = append(g.draining, pg.g)
+		}
+		for _, group := range pg.dynamic {
+			g.draining = append(g.draining, group)
+		}
+	}
+	if n := numDynamicServiceGroups - g.numDynamicServiceGroups; n != 0 {
+		g.numDynamicServiceGroupsCounter.Add(context.Background(), int64(n))
+	}
+	g.numDynamicServiceGroups = numDynamicServiceGroups
+	g.policiesVersion++
+}
+
+// observeSampleRates observes the effective sample rate of each trace group,
+// identified by the index of its policy and its service name.
+func (g *traceGroups) observeSampleRates(_ context.Context, o metric.Float64Observer) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	observe := func(policyIndex int, serviceName string, group *traceGroup) {
+		group.mu.Lock()
+		samplingFraction := group.samplingFraction
+		group.mu.Unlock()
+		o.Observe(samplingFraction, traceGroupAttributes(policyIndex, serviceName))
+	}
+	for i, pg := range g.policyGroups {
+		if pg.g != nil {
+			observe(i, pg.policy.ServiceName, pg.g)
+			continue
+		}
+		for serviceName, group := range pg.dynamic {
+			observe(i, serviceName, group)
+		}
+	}
+	return nil
+}
+
+// traceGroupAttributes returns metric attributes identifying a trace group
+// by the index of its policy and its service name.
+func traceGroupAttributes(policyIndex int, serviceName string) metric.MeasurementOption {
+	return metric.WithAttributes(
+		attribute.Int("policy.index", policyIndex),
+		attribute.String("service.name", serviceName),
+	)
+}
+
 // traceGroup represents a single trace group, including a measurement of the
 // observed ingest rate, a trace ID weighted random sampling reservoir.
 type traceGroup struct {
-	// samplingFraction holds the configured fraction of traces in this
-	// trace group to sample, as a fraction in the range (0,1).
-	samplingFraction float64
+	// targetTracesPerInterval, if non-zero, holds the target number of
+	// traces in this trace group to sample per tail sampling interval.
+	// samplingFraction is then derived from ingestRate by each
+	// finalizeSampledTraces call, and bounded by minSamplingFraction
+	// and maxSamplingFraction.
+	targetTracesPerInterval float64
+	minSamplingFraction     float64
+	maxSamplingFraction     float64
 
 	mu sync.Mutex
+	// rng is used for sampling completed traces, and by reservoir.
+	rng *rand.Rand
+	// samplingFraction holds the fraction of traces in this trace group
+	// to sample, in the range [0,1]. This is fixed unless the group has
+	// a throughput target.
+	samplingFraction float64
 	// reservoir holds a random sample of root transactions observed
 	// for this trace group, weighted by duration.
 	reservoir *weightedRandomSample
@@ -113,13 +302,32 @@ type traceGroup struct {
 	ingestRate float64
 }
 
-func newTraceGroup(samplingFraction float64) *traceGroup {
-	return &traceGroup{
-		samplingFraction: samplingFraction,
-		reservoir: newWeightedRandomSample(
-			rand.New(rand.NewSource(time.Now().UnixNano())),
-			minReservoirSize,
-		),
+func newTraceGroup(policy Policy, interval time.Duration) *traceGroup {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	g := &traceGroup{
+		rng:       rng,
+		reservoir: newWeightedRandomSample(rng, minReservoirSize),
+	}
+	g.setPolicy(policy, interval)
+	return g
+}
+
+// setPolicy sets the group's sampling parameters from policy. The group's
+// ingest rate, if known, is used to derive the sampling fraction for a
+// throughput target; until it is known, as many traces as permitted are
+// sampled.
+func (g *traceGroup) setPolicy(policy Policy, interval time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.samplingFraction = policy.SampleRate
+	g.targetTracesPerInterval = 0
+	g.minSamplingFraction = 0
+	g.maxSamplingFraction = 0
+	if policy.TargetTracesPerSecond > 0 {
+		g.targetTracesPerInterval = policy.TargetTracesPerSecond * interval.Seconds()
+		g.minSamplingFraction = policy.MinSampleRate
+		g.maxSamplingFraction = policy.MaxSampleRate
+		g.samplingFraction = g.targetSamplingFraction()
 	}
 }
 
@@ -129,30 +337,64 @@ func newTraceGroup(samplingFraction float64) *traceGroup {
 // If the transaction is not admitted due to the transaction group limit
 // having been reached, sampleTrace will return errTooManyTraceGroups.
 func (g *traceGroups) sampleTrace(transactionEvent *modelpb.APMEvent) (bool, error) {
+	sampled, _, err := g.sampleTraceEvicting(transactionEvent)
+	return sampled, err
+}
+
+// sampleTraceEvicting is like sampleTrace, but additionally returns the ID
+// of the trace evicted from the full sampling reservoir to admit the root
+// transaction, if any. The evicted trace will not be sampled unless it is
+// recorded with forceSampleTrace.
+func (g *traceGroups) sampleTraceEvicting(transactionEvent *modelpb.APMEvent) (sampled bool, evicted string, _ error) {
+	group, err := g.getTraceGroup(transactionEvent)
+	if err != nil {
+		return false, "", err
+	}
+	sampled, evicted = group.sampleTrace(transactionEvent)
+	return sampled, evicted, nil
+}
+
+// trackTrace returns the trace group for the root transaction, counting it
+// towards the group's total without admitting it to the sampling reservoir.
+// This is used when sampling decisions are made on trace completion, with
+// traceGroup.sampleCompletedTrace.
+//
+// If the transaction's trace group cannot be created due to the transaction
+// group limit having been reached, trackTrace will return errTooManyTraceGroups.
+func (g *traceGroups) trackTrace(transactionEvent *modelpb.APMEvent) (*traceGroup, error) {
 	group, err := g.getTraceGroup(transactionEvent)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	return group.sampleTrace(transactionEvent)
+	group.mu.Lock()
+	defer group.mu.Unlock()
+	group.total++
+	return group, nil
 }
 
 func (g *traceGroups) getTraceGroup(transactionEvent *modelpb.APMEvent) (*traceGroup, error) {
-	var pg *policyGroup
-	for i := range g.policyGroups {
-		if g.policyGroups[i].match(transactionEvent) {
-			pg = &g.policyGroups[i]
-			break
+	g.mu.RLock()
+	pg := g.matchPolicyGroup(transactionEvent)
+	version := g.policiesVersion
+	if pg == nil || pg.g != nil {
+		defer g.mu.RUnlock()
+		if pg == nil {
+			return nil, errNoMatchingPolicy
 		}
-	}
-	if pg == nil {
-		return nil, errNoMatchingPolicy
-	}
-	if pg.g != nil {
 		return pg.g, nil
 	}
+	g.mu.RUnlock()
 
 	g.mu.Lock()
 	defer g.mu.Unlock()
+	if g.policiesVersion != version {
+		// The policies were updated after matching: match again.
+		if pg = g.matchPolicyGroup(transactionEvent); pg == nil {
+			return nil, errNoMatchingPolicy
+		} else if pg.g != nil {
+			return pg.g, nil
+		}
+	}
 
 	group, ok := pg.dynamic[transactionEvent.GetService().GetName()]
 	if !ok {
@@ -161,45 +403,133 @@ func (g *traceGroups) getTraceGroup(transactionEvent *modelpb.APMEvent) (*traceG
 		}
 		g.numDynamicServiceGroups++
 		g.numDynamicServiceGroupsCounter.Add(context.Background(), 1)
-		group = newTraceGroup(pg.policy.SampleRate)
+		group = newTraceGroup(pg.policy, g.interval)
 		pg.dynamic[transactionEvent.GetService().GetName()] = group
 	}
 	return group, nil
 }
 
-func (g *traceGroup) sampleTrace(transactionEvent *modelpb.APMEvent) (bool, error) {
-	if g.samplingFraction == 0 {
-		return false, nil
+// matchPolicyGroup returns the first policy group matching the root
+// transaction, or nil if there is none. This must be called with mu held.
+func (g *traceGroups) matchPolicyGroup(transactionEvent *modelpb.APMEvent) *policyGroup {
+	for i := range g.policyGroups {
+		if g.policyGroups[i].match(transactionEvent) {
+			return &g.policyGroups[i]
+		}
 	}
+	return nil
+}
+
+func (g *traceGroup) sampleTrace(transactionEvent *modelpb.APMEvent) (sampled bool, evicted string) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
+	if g.samplingFraction == 0 {
+		return false, ""
+	}
 	g.total++
 	return g.reservoir.Sample(
 		time.Duration(transactionEvent.GetEvent().GetDuration()).Seconds(),
 		transactionEvent.GetTrace().GetId(),
-	), nil
+	)
+}
+
+// status returns the status of each trace group, in policy order. Dynamic
+// groups of the same policy are ordered by service name.
+func (g *traceGroups) status() []TraceGroupStatus {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var groups []TraceGroupStatus
+	for i, pg := range g.policyGroups {
+		if pg.g != nil {
+			groups = append(groups, pg.g.status(i, pg.policy))
+			continue
+		}
+		serviceNames := make([]string, 0, len(pg.dynamic))
+		for serviceName := range pg.dynamic {
+			serviceNames = append(serviceNames, serviceName)
+		}
+		sort.Strings(serviceNames)
+		for _, serviceName := range serviceNames {
+			status := pg.dynamic[serviceName].status(i, pg.policy)
+			status.ServiceName = serviceName
+			groups = append(groups, status)
+		}
+	}
+	return groups
+}
+
+func (g *traceGroup) status(policyIndex int, policy Policy) TraceGroupStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return TraceGroupStatus{
+		PolicyIndex:   policyIndex,
+		Policy:        policy,
+		ServiceName:   policy.ServiceName,
+		SampleRate:    g.samplingFraction,
+		ReservoirSize: g.reservoir.Size(),
+		ReservoirLen:  g.reservoir.Len(),
+		Total:         g.total,
+		IngestRate:    g.ingestRate,
+	}
+}
+
+// forceSampleTrace records traceID to be sampled by the next call to
+// finalizeSampledTraces, regardless of reservoir sampling.
+func (g *traceGroups) forceSampleTrace(traceID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.forced = append(g.forced, traceID)
+}
+
+// sampleCompletedTrace reports whether a completed trace in the group should
+// be sampled. Each completed trace is sampled independently, with a probability
+// equal to the group's sampling fraction, so that traces are not favoured by
+// the order in which they complete.
+func (g *traceGroup) sampleCompletedTrace() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.samplingFraction == 0 {
+		return false
+	}
+	return g.rng.Float64() < g.samplingFraction
 }
 
 // finalizeSampledTraces locks the groups, appends their current trace IDs to
 // traceIDs, and returns the extended slice. On return the groups' sampling
 // reservoirs will be reset.
 //
+// Trace IDs recorded by forceSampleTrace, and those sampled from the reservoirs
+// of trace groups being drained after updatePolicies, are appended. If isForced
+// is non-nil, trace IDs that would be removed from a reservoir to limit it to
+// the desired sampling fraction are kept if isForced returns true for them.
+//
 // If the maximum number of groups has been reached, then any dynamically
 // created groups with the minimum reservoir size (low ingest or sampling rate)
 // may be removed. These groups may also be removed if they have seen no
 // activity in this interval.
-func (g *traceGroups) finalizeSampledTraces(traceIDs []string) []string {
+func (g *traceGroups) finalizeSampledTraces(traceIDs []string, isForced func(traceID string) bool) []string {
 	g.mu.Lock()
 	defer g.mu.Unlock()
+	traceIDs = append(traceIDs, g.forced...)
+	g.forced = g.forced[:0]
+	for i, group := range g.draining {
+		_, traceIDs = group.finalizeSampledTraces(traceIDs, g.ingestRateDecayFactor, isForced)
+		g.draining[i] = nil
+	}
+	g.draining = g.draining[:0]
 	maxDynamicServiceGroupsReached := g.numDynamicServiceGroups == g.maxDynamicServiceGroups
-	for _, pg := range g.policyGroups {
+	for i, pg := range g.policyGroups {
 		if pg.g != nil {
-			_, traceIDs = pg.g.finalizeSampledTraces(traceIDs, g.ingestRateDecayFactor)
+			n := len(traceIDs)
+			_, traceIDs = pg.g.finalizeSampledTraces(traceIDs, g.ingestRateDecayFactor, isForced)
+			g.recordSampledTraces(i, pg.policy.ServiceName, len(traceIDs)-n)
 			continue
 		}
 		for serviceName, group := range pg.dynamic {
 			var total int
-			total, traceIDs = group.finalizeSampledTraces(traceIDs, g.ingestRateDecayFactor)
+			n := len(traceIDs)
+			total, traceIDs = group.finalizeSampledTraces(traceIDs, g.ingestRateDecayFactor, isForced)
+			g.recordSampledTraces(i, serviceName, len(traceIDs)-n)
 			if (maxDynamicServiceGroupsReached || total == 0) && group.reservoir.Size() == minReservoirSize {
 				g.numDynamicServiceGroups--
 				g.numDynamicServiceGroupsCounter.Add(context.Background(), -1)
@@ -210,10 +540,21 @@ func (g *traceGroups) finalizeSampledTraces(traceIDs []string) []string {
 	return traceIDs
 }
 
+// recordSampledTraces reports the number of traces sampled by a trace group,
+// if sampledTracesCounter is non-nil.
+func (g *traceGroups) recordSampledTraces(policyIndex int, serviceName string, n int) {
+	if g.sampledTracesCounter == nil || n == 0 {
+		return
+	}
+	g.sampledTracesCounter.Add(context.Background(), int64(n), traceGroupAttributes(policyIndex, serviceName))
+}
+
 // finalizeSampledTraces appends the group's current trace IDs to traceIDs, and
 // returns total of the group and the extended slice.
 // On return the groups' sampling reservoirs will be reset.
-func (g *traceGroup) finalizeSampledTraces(traceIDs []string, ingestRateDecayFactor float64) (int, []string) {
+func (g *traceGroup) finalizeSampledTraces(
+	traceIDs []string, ingestRateDecayFactor float64, isForced func(traceID string) bool,
+) (int, []string) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
@@ -225,6 +566,9 @@ func (g *traceGroup) finalizeSampledTraces(traceIDs []string, ingestRateDecayFac
 		g.ingestRate *= 1 - ingestRateDecayFactor
 		g.ingestRate += ingestRateDecayFactor * float64(g.total)
 	}
+	if g.targetTracesPerInterval > 0 {
+		g.samplingFraction = g.targetSamplingFraction()
+	}
 	desiredTotal := int(math.Ceil(g.samplingFraction * float64(g.total)))
 	g.total = 0
 
@@ -232,7 +576,9 @@ func (g *traceGroup) finalizeSampledTraces(traceIDs []string, ingestRateDecayFac
 		// The reservoir is larger than the desired fraction of the
 		// observed total number of traces in this interval. Pop the
 		// lowest weighted traces to limit to the desired total.
-		g.reservoir.Pop()
+		if traceID := g.reservoir.Pop(); isForced != nil && isForced(traceID) {
+			traceIDs = append(traceIDs, traceID)
+		}
 	}
 	traceIDs = append(traceIDs, g.reservoir.Values()...)
 
@@ -246,3 +592,14 @@ func (g *traceGroup) finalizeSampledTraces(traceIDs []string, ingestRateDecayFac
 	g.reservoir.Resize(newReservoirSize)
 	return oldTotal, traceIDs
 }
+
+// targetSamplingFraction returns the fraction of traces to sample in order to
+// sample targetTracesPerInterval traces at the current ingest rate, bounded by
+// minSamplingFraction and maxSamplingFraction.
+func (g *traceGroup) targetSamplingFraction() float64 {
+	if g.ingestRate == 0 {
+		return g.maxSamplingFraction
+	}
+	fraction := g.targetTracesPerInterval / g.ingestRate
+	return math.Max(g.minSamplingFraction, math.Min(fraction, g.maxSamplingFraction))
+}
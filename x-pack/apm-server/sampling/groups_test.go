@@ -5,6 +5,7 @@
 package sampling
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
@@ -14,6 +15,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 
 	"github.com/elastic/apm-data/model/modelpb"
 )
@@ -65,7 +68,7 @@ func TestTraceGroupsPolicies(t *testing.T) {
 		policy.ServiceName = ""
 		policies = append(policies, policy)
 	}
-	groups := newTraceGroups(noop.Meter{}, policies, 1000, 1.0)
+	groups := newTraceGroups(noop.Meter{}, policies, 1000, 1.0, time.Minute)
 
 	assertSampleRate := func(sampleRate float64, serviceName, serviceEnvironment, traceOutcome, traceName string) {
 		tx := makeTransaction(serviceName, serviceEnvironment, traceOutcome, traceName)
@@ -75,7 +78,7 @@ func TestTraceGroupsPolicies(t *testing.T) {
 				t.Fatal(err)
 			}
 		}
-		sampled := groups.finalizeSampledTraces(nil)
+		sampled := groups.finalizeSampledTraces(nil, nil)
 		assert.Len(t, sampled, int(sampleRate*N))
 	}
 
@@ -87,13 +90,89 @@ func TestTraceGroupsPolicies(t *testing.T) {
 	}
 }
 
+func TestTraceGroupsPolicyConditions(t *testing.T) {
+	policies := []Policy{{
+		SampleRate:     1.0,
+		PolicyCriteria: PolicyCriteria{MinTraceDuration: 2 * time.Second},
+	}, {
+		SampleRate: 0.5,
+		PolicyCriteria: PolicyCriteria{Conditions: []Condition{
+			{Field: "labels.tenant", Operator: OperatorEquals, Value: "gold"},
+			{Field: "transaction.name", Operator: OperatorGlob, Value: "GET /api/*"},
+		}},
+	}, {
+		SampleRate: 0.4,
+		PolicyCriteria: PolicyCriteria{Conditions: []Condition{
+			{Field: "http.response.status_code", Operator: OperatorGreaterThanOrEqual, Number: 500},
+		}},
+	}, {
+		SampleRate: 0.3,
+		PolicyCriteria: PolicyCriteria{
+			MaxTraceDuration: time.Millisecond,
+			Conditions: []Condition{
+				{Field: "numeric_labels.attempt", Operator: OperatorEquals, Value: "1", Negate: true},
+			},
+		},
+	}, {
+		SampleRate: 0.2,
+		PolicyCriteria: PolicyCriteria{Conditions: []Condition{
+			{Field: "service.version", Operator: OperatorRegex, Value: `^1\.`},
+		}},
+	}, {
+		SampleRate: 0.1,
+	}}
+	groups := newTraceGroups(noop.Meter{}, policies, 1000, 1.0, time.Minute)
+
+	makeTransaction := func(duration time.Duration, name string, statusCode uint32) *modelpb.APMEvent {
+		return &modelpb.APMEvent{
+			Service:     &modelpb.Service{Name: "service", Version: "2.0.0"},
+			Event:       &modelpb.Event{Duration: uint64(duration)},
+			Trace:       &modelpb.Trace{Id: uuid.Must(uuid.NewV4()).String()},
+			Transaction: &modelpb.Transaction{Type: "type", Name: name, Id: uuid.Must(uuid.NewV4()).String()},
+			Http:        &modelpb.HTTP{Response: &modelpb.HTTPResponse{StatusCode: statusCode}},
+		}
+	}
+	assertSampleRate := func(sampleRate float64, tx *modelpb.APMEvent) {
+		t.Helper()
+		const N = 1000
+		for i := 0; i < N; i++ {
+			if _, err := groups.sampleTrace(tx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sampled := groups.finalizeSampledTraces(nil, nil)
+		assert.Len(t, sampled, int(sampleRate*N))
+	}
+
+	assertSampleRate(1.0, makeTransaction(2*time.Second, "GET /", 200))
+
+	gold := makeTransaction(time.Second, "GET /api/users", 200)
+	gold.Labels = map[string]*modelpb.LabelValue{"tenant": {Value: "gold"}}
+	assertSampleRate(0.5, gold)
+	gold.Transaction.Name = "GET /"
+	assertSampleRate(0.1, gold)
+
+	assertSampleRate(0.4, makeTransaction(time.Second, "GET /", 503))
+	assertSampleRate(0.1, makeTransaction(time.Second, "GET /", 404))
+
+	// Negated conditions are satisfied when the field is not set.
+	fast := makeTransaction(time.Microsecond, "GET /", 200)
+	assertSampleRate(0.3, fast)
+	fast.NumericLabels = map[string]*modelpb.NumericLabelValue{"attempt": {Value: 1}}
+	assertSampleRate(0.1, fast)
+
+	v1 := makeTransaction(time.Second, "GET /", 200)
+	v1.Service.Version = "1.2.3"
+	assertSampleRate(0.2, v1)
+}
+
 func TestTraceGroupsMax(t *testing.T) {
 	const (
 		maxDynamicServices    = 100
 		ingestRateCoefficient = 1.0
 	)
 	policies := []Policy{{SampleRate: 1.0}}
-	groups := newTraceGroups(noop.Meter{}, policies, maxDynamicServices, ingestRateCoefficient)
+	groups := newTraceGroups(noop.Meter{}, policies, maxDynamicServices, ingestRateCoefficient, time.Minute)
 
 	for i := 0; i < maxDynamicServices; i++ {
 		serviceName := fmt.Sprintf("service_group_%d", i)
@@ -132,7 +211,7 @@ func TestTraceGroupReservoirResize(t *testing.T) {
 		ingestRateCoefficient = 0.75
 	)
 	policies := []Policy{{SampleRate: 0.2}}
-	groups := newTraceGroups(noop.Meter{}, policies, maxDynamicServices, ingestRateCoefficient)
+	groups := newTraceGroups(noop.Meter{}, policies, maxDynamicServices, ingestRateCoefficient, time.Minute)
 
 	sendTransactions := func(n int) {
 		for i := 0; i < n; i++ {
@@ -148,7 +227,7 @@ func TestTraceGroupReservoirResize(t *testing.T) {
 
 	// All groups start out with a reservoir size of 1000.
 	sendTransactions(10000)
-	assert.Len(t, groups.finalizeSampledTraces(nil), 1000) // initial reservoir size
+	assert.Len(t, groups.finalizeSampledTraces(nil, nil), 1000) // initial reservoir size
 
 	// We sent 10000 initially, and we send 20000 each subsequent iteration.
 	// The number of sampled trace IDs will converge on 4000 (0.2*20000).
@@ -163,7 +242,7 @@ func TestTraceGroupReservoirResize(t *testing.T) {
 		4000,
 	} {
 		sendTransactions(20000)
-		traces := groups.finalizeSampledTraces(nil)
+		traces := groups.finalizeSampledTraces(nil, nil)
 		assert.Len(t, traces, expected, "iteration %d expected len %d actual len %d", i, expected, len(traces))
 	}
 }
@@ -174,7 +253,7 @@ func TestTraceGroupReservoirResizeMinimum(t *testing.T) {
 		ingestRateCoefficient = 1.0
 	)
 	policies := []Policy{{SampleRate: 0.1}}
-	groups := newTraceGroups(noop.Meter{}, policies, maxDynamicServices, ingestRateCoefficient)
+	groups := newTraceGroups(noop.Meter{}, policies, maxDynamicServices, ingestRateCoefficient, time.Minute)
 
 	sendTransactions := func(n int) {
 		for i := 0; i < n; i++ {
@@ -189,15 +268,15 @@ func TestTraceGroupReservoirResizeMinimum(t *testing.T) {
 	}
 
 	sendTransactions(10000)
-	assert.Len(t, groups.finalizeSampledTraces(nil), 1000) // initial reservoir size
+	assert.Len(t, groups.finalizeSampledTraces(nil, nil), 1000) // initial reservoir size
 
 	// The reservoir would normally be resized to fit the desired sampling
 	// rate, but will never be resized to less than the minimum (1000).
 	sendTransactions(1000)
-	assert.Len(t, groups.finalizeSampledTraces(nil), 100)
+	assert.Len(t, groups.finalizeSampledTraces(nil, nil), 100)
 
 	sendTransactions(10000)
-	assert.Len(t, groups.finalizeSampledTraces(nil), 1000) // min reservoir size
+	assert.Len(t, groups.finalizeSampledTraces(nil, nil), 1000) // min reservoir size
 }
 
 func TestTraceGroupsRemoval(t *testing.T) {
@@ -210,7 +289,7 @@ func TestTraceGroupsRemoval(t *testing.T) {
 		{SampleRate: 0.5},
 		{PolicyCriteria: PolicyCriteria{ServiceName: "defined_later"}, SampleRate: 0.5},
 	}
-	groups := newTraceGroups(noop.Meter{}, policies, maxDynamicServices, ingestRateCoefficient)
+	groups := newTraceGroups(noop.Meter{}, policies, maxDynamicServices, ingestRateCoefficient, time.Minute)
 
 	for i := 0; i < 10000; i++ {
 		_, err := groups.sampleTrace(&modelpb.APMEvent{
@@ -249,7 +328,7 @@ func TestTraceGroupsRemoval(t *testing.T) {
 
 	// Finalizing should remove the "few" trace group, since its reservoir
 	// size is at the minimum, and the number of groups is at the maximum.
-	groups.finalizeSampledTraces(nil)
+	groups.finalizeSampledTraces(nil, nil)
 
 	// We should now be able to add another trace group.
 	_, err = groups.sampleTrace(&modelpb.APMEvent{
@@ -259,6 +338,199 @@ func TestTraceGroupsRemoval(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestTraceGroupsForced(t *testing.T) {
+	policies := []Policy{{SampleRate: 0.5}}
+	groups := newTraceGroups(noop.Meter{}, policies, 1000, 1.0, time.Minute)
+
+	var traceIDs []string
+	for i := 0; i < 10; i++ {
+		traceID := fmt.Sprintf("trace_%d", i)
+		traceIDs = append(traceIDs, traceID)
+		sampled, err := groups.sampleTrace(&modelpb.APMEvent{
+			Service:     &modelpb.Service{Name: "service_name"},
+			Trace:       &modelpb.Trace{Id: traceID},
+			Transaction: &modelpb.Transaction{Type: "type"},
+		})
+		require.NoError(t, err)
+		require.True(t, sampled)
+	}
+	groups.forceSampleTrace("forced")
+
+	// Traces popped from the reservoir are kept if they are forced.
+	sampled := groups.finalizeSampledTraces(nil, func(string) bool { return false })
+	assert.Len(t, sampled, 5+1)
+	assert.Equal(t, "forced", sampled[0])
+	assert.Subset(t, traceIDs, sampled[1:])
+
+	for _, traceID := range traceIDs {
+		_, err := groups.sampleTrace(&modelpb.APMEvent{
+			Service:     &modelpb.Service{Name: "service_name"},
+			Trace:       &modelpb.Trace{Id: traceID},
+			Transaction: &modelpb.Transaction{Type: "type"},
+		})
+		require.NoError(t, err)
+	}
+	sampled = groups.finalizeSampledTraces(nil, func(string) bool { return true })
+	assert.ElementsMatch(t, traceIDs, sampled)
+
+}
+
+func TestTraceGroupsSampleCompletedTrace(t *testing.T) {
+	policies := []Policy{{SampleRate: 0.1}}
+	groups := newTraceGroups(noop.Meter{}, policies, 1000, 1.0, time.Minute)
+	transaction := &modelpb.APMEvent{
+		Service:     &modelpb.Service{Name: "service_name"},
+		Transaction: &modelpb.Transaction{Type: "type"},
+	}
+
+	// Completed traces are sampled independently at random, so the
+	// sampled traces are not simply the first to complete.
+	const n = 10000
+	var sampled, sampledFirst int
+	for i := 0; i < n; i++ {
+		group, err := groups.trackTrace(transaction)
+		require.NoError(t, err)
+		if group.sampleCompletedTrace() {
+			sampled++
+			if i < n/10 {
+				sampledFirst++
+			}
+		}
+	}
+	assert.InDelta(t, n/10, sampled, 150)
+	assert.Less(t, sampledFirst, sampled/2)
+
+	// Tracked traces are not admitted to the reservoir,
+	// but are counted towards the group's ingest rate.
+	assert.Empty(t, groups.finalizeSampledTraces(nil, nil))
+	group, err := groups.trackTrace(transaction)
+	require.NoError(t, err)
+	assert.Equal(t, float64(n), group.ingestRate)
+}
+
+func TestTraceGroupsTargetThroughput(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+	policies := []Policy{{
+		PolicyCriteria:        PolicyCriteria{ServiceName: "capped"},
+		TargetTracesPerSecond: 1,
+		MinSampleRate:         0.1,
+		MaxSampleRate:         1,
+	}, {
+		TargetTracesPerSecond: 1,
+		MaxSampleRate:         0.5,
+	}}
+	groups := newTraceGroups(meter, policies, 1000, 1.0, 10*time.Second)
+
+	sendTransactions := func(serviceName string, n int) {
+		for i := 0; i < n; i++ {
+			_, err := groups.sampleTrace(&modelpb.APMEvent{
+				Service:     &modelpb.Service{Name: serviceName},
+				Event:       &modelpb.Event{Duration: uint64(time.Millisecond)},
+				Trace:       &modelpb.Trace{Id: uuid.Must(uuid.NewV4()).String()},
+				Transaction: &modelpb.Transaction{Type: "type"},
+			})
+			require.NoError(t, err)
+		}
+	}
+	sampleRates := func() map[string]float64 {
+		var rm metricdata.ResourceMetrics
+		require.NoError(t, reader.Collect(context.Background(), &rm))
+		rates := make(map[string]float64)
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			if m.Name != "apm-server.sampling.tail.sample_rate" {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Gauge[float64]).DataPoints {
+				serviceName, _ := dp.Attributes.Value("service.name")
+				rates[serviceName.AsString()] = dp.Value
+			}
+		}
+		return rates
+	}
+	assert.Equal(t, map[string]float64{"capped": 1}, sampleRates())
+
+	// 1 trace per second over a 10 second interval is 10 traces per interval.
+	sendTransactions("capped", 50)
+	sendTransactions("dynamic_low", 10)
+	sendTransactions("dynamic_high", 1000)
+	assert.Len(t, groups.finalizeSampledTraces(nil, nil), 10+5+10)
+	assert.Equal(t, map[string]float64{
+		"capped":       0.2,
+		"dynamic_low":  0.5,  // bounded by MaxSampleRate
+		"dynamic_high": 0.01, // no MinSampleRate
+	}, sampleRates())
+
+	// Bounded by MinSampleRate.
+	sendTransactions("capped", 1000)
+	assert.Len(t, groups.finalizeSampledTraces(nil, nil), 100)
+	assert.Equal(t, 0.1, sampleRates()["capped"])
+}
+
+func TestTraceGroupsUpdatePolicies(t *testing.T) {
+	policies := []Policy{{
+		PolicyCriteria: PolicyCriteria{ServiceName: "retained"},
+		SampleRate:     0.5,
+	}, {
+		PolicyCriteria: PolicyCriteria{ServiceName: "removed"},
+		SampleRate:     1,
+	}, {
+		SampleRate: 1,
+	}}
+	groups := newTraceGroups(noop.Meter{}, policies, 1000, 1.0, time.Minute)
+
+	sendTransactions := func(serviceName string, n int) {
+		for i := 0; i < n; i++ {
+			_, err := groups.sampleTrace(&modelpb.APMEvent{
+				Service:     &modelpb.Service{Name: serviceName},
+				Event:       &modelpb.Event{Duration: uint64(time.Millisecond)},
+				Trace:       &modelpb.Trace{Id: uuid.Must(uuid.NewV4()).String()},
+				Transaction: &modelpb.Transaction{Type: "type"},
+			})
+			require.NoError(t, err)
+		}
+	}
+	for _, serviceName := range []string{"retained", "removed", "dynamic"} {
+		sendTransactions(serviceName, 100)
+	}
+	assert.Len(t, groups.finalizeSampledTraces(nil, nil), 50+100+100)
+	for _, serviceName := range []string{"retained", "removed", "dynamic"} {
+		sendTransactions(serviceName, 10)
+	}
+
+	groups.updatePolicies([]Policy{{
+		PolicyCriteria: PolicyCriteria{ServiceName: "retained"},
+		SampleRate:     1,
+	}, {
+		PolicyCriteria: PolicyCriteria{ServiceName: "added"},
+		SampleRate:     1,
+	}, {
+		SampleRate: 0.5,
+	}})
+
+	// Groups with unchanged criteria retain their ingest rates,
+	// and take on the updated policies' sample rates.
+	status := groups.status()
+	require.Len(t, status, 3)
+	assert.Equal(t, "retained", status[0].ServiceName)
+	assert.Equal(t, 1.0, status[0].SampleRate)
+	assert.Equal(t, 100.0, status[0].IngestRate)
+	assert.Equal(t, 10, status[0].ReservoirLen)
+	assert.Equal(t, "added", status[1].ServiceName)
+	assert.Zero(t, status[1].IngestRate)
+	assert.Equal(t, "dynamic", status[2].ServiceName)
+	assert.Equal(t, 0.5, status[2].SampleRate)
+	assert.Equal(t, 100.0, status[2].IngestRate)
+	assert.Equal(t, 1, groups.numDynamicServiceGroups)
+
+	// The removed policy's group is drained by the next finalization,
+	// sampling traces according to the removed policy.
+	assert.Len(t, groups.finalizeSampledTraces(nil, nil), 10+10+5)
+	assert.Empty(t, groups.draining)
+	sendTransactions("removed", 10)
+	assert.Len(t, groups.finalizeSampledTraces(nil, nil), 5)
+}
+
 func TestTraceGroupsRemovalConcurrent(t *testing.T) {
 	// Ensure that trace groups removal does not race with sampleTrace
 	const (
@@ -268,7 +540,7 @@ func TestTraceGroupsRemovalConcurrent(t *testing.T) {
 	policies := []Policy{
 		{SampleRate: 1},
 	}
-	groups := newTraceGroups(noop.Meter{}, policies, maxDynamicServices, ingestRateCoefficient)
+	groups := newTraceGroups(noop.Meter{}, policies, maxDynamicServices, ingestRateCoefficient, time.Minute)
 
 	wg := sync.WaitGroup{}
 	wg.Add(2)
@@ -289,7 +561,7 @@ func TestTraceGroupsRemovalConcurrent(t *testing.T) {
 		wg.Done()
 	}()
 	go func() {
-		groups.finalizeSampledTraces(nil)
+		groups.finalizeSampledTraces(nil, nil)
 		wg.Done()
 	}()
 	wg.Wait()
@@ -301,7 +573,7 @@ func BenchmarkTraceGroups(b *testing.B) {
 		ingestRateCoefficient = 1.0
 	)
 	policies := []Policy{{SampleRate: 1.0}}
-	groups := newTraceGroups(noop.Meter{}, policies, maxDynamicServices, ingestRateCoefficient)
+	groups := newTraceGroups(noop.Meter{}, policies, maxDynamicServices, ingestRateCoefficient, time.Minute)
 
 	b.RunParallel(func(pb *testing.PB) {
 		// Transaction identifiers are different for each goroutine, simulating
@@ -0,0 +1,52 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package sampling
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/elastic/apm-data/model/modelpb"
+)
+
+// interestingEventMatcher matches events against an InterestingEvent.
+type interestingEventMatcher struct {
+	InterestingEvent
+	conditions []conditionMatcher
+	attributes metric.MeasurementOption
+}
+
+// newInterestingEventMatchers returns interestingEventMatchers for events,
+// which must have been validated.
+func newInterestingEventMatchers(events []InterestingEvent) []interestingEventMatcher {
+	matchers := make([]interestingEventMatcher, len(events))
+	for i, event := range events {
+		matchers[i] = interestingEventMatcher{
+			InterestingEvent: event,
+			attributes:       metric.WithAttributes(attribute.String("interesting_event.name", event.Name)),
+		}
+		for _, condition := range event.Conditions {
+			matchers[i].conditions = append(matchers[i].conditions, newConditionMatcher(condition))
+		}
+	}
+	return matchers
+}
+
+func (m *interestingEventMatcher) match(event *modelpb.APMEvent) bool {
+	if event.Type() != m.EventType {
+		return false
+	}
+	if m.MinDuration != 0 && time.Duration(event.GetEvent().GetDuration()) < m.MinDuration {
+		return false
+	}
+	for i := range m.conditions {
+		if !m.conditions[i].match(event) {
+			return false
+		}
+	}
+	return true
+}
@@ -45,6 +45,15 @@ type Processor struct {
 	logger            *logp.Logger
 	rateLimitedLogger *logp.Logger
 	groups            *traceGroups
+	interesting       []interestingEventMatcher
+
+	// shadowGroups holds trace groups for evaluating shadow policies.
+	// It has no policy groups if no shadow policies are configured.
+	shadowGroups *traceGroups
+
+	// pending holds traces awaiting completion, when sampling
+	// decisions are made on trace completion. Otherwise it is nil.
+	pending *pendingTraces
 
 	eventStore     eventstorage.RW
 	eventMetrics   eventMetrics
@@ -69,6 +78,8 @@ type eventMetrics struct {
 	sampled       metric.Int64Counter
 	headUnsampled metric.Int64Counter
 	failedWrites  metric.Int64Counter
+	interesting   metric.Int64Counter
+	forced        metric.Int64Counter
 }
 
 // NewProcessor returns a new Processor, for tail-sampling trace events.
@@ -86,12 +97,24 @@ func NewProcessor(params ProcessorParams) (*Processor, error) {
 		config:            config,
 		logger:            logger,
 		rateLimitedLogger: logger.WithOptions(logs.WithRateLimit(loggerRateLimit)),
-		groups:            newTraceGroups(meter, config.Policies, config.MaxDynamicServices, config.IngestRateDecayFactor),
-		eventStore:        config.Storage,
-		shardLock:         newShardLock(runtime.GOMAXPROCS(0)),
-		statusReporter:    statusreporterhelper.New(params.StatusReporter, params.Logger, "apm sampling processor"),
-		stopping:          make(chan struct{}),
-		stopped:           make(chan struct{}),
+		groups: newTraceGroups(
+			meter, config.Policies, config.MaxDynamicServices,
+			config.IngestRateDecayFactor, config.FlushInterval,
+		),
+		shadowGroups: newShadowTraceGroups(
+			meter, config.ShadowPolicies, config.MaxDynamicServices,
+			config.IngestRateDecayFactor, config.FlushInterval,
+		),
+		interesting:    newInterestingEventMatchers(config.InterestingEvents),
+		eventStore:     config.Storage,
+		shardLock:      newShardLock(runtime.GOMAXPROCS(0)),
+		statusReporter: statusreporterhelper.New(params.StatusReporter, params.Logger, "apm sampling processor"),
+		stopping:       make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}
+
+	if config.TraceIdleTimeout > 0 {
+		p.pending = newPendingTraces()
 	}
 
 	p.eventMetrics.processed, _ = meter.Int64Counter("apm-server.sampling.tail.events.processed")
@@ -100,10 +123,33 @@ func NewProcessor(params ProcessorParams) (*Processor, error) {
 	p.eventMetrics.sampled, _ = meter.Int64Counter("apm-server.sampling.tail.events.sampled")
 	p.eventMetrics.headUnsampled, _ = meter.Int64Counter("apm-server.sampling.tail.events.head_unsampled")
 	p.eventMetrics.failedWrites, _ = meter.Int64Counter("apm-server.sampling.tail.events.failed_writes")
+	p.eventMetrics.interesting, _ = meter.Int64Counter("apm-server.sampling.tail.events.interesting")
+	p.eventMetrics.forced, _ = meter.Int64Counter("apm-server.sampling.tail.traces.forced")
 
 	return p, nil
 }
 
+// UpdatePolicies atomically replaces the processor's tail-sampling policies
+// and shadow policies, without restarting the processor.
+//
+// Trace groups whose policy criteria are unchanged retain their ingest rates
+// and sampling reservoirs, and immediately take on the sampling parameters of
+// the updated policy. Traces in the reservoirs of removed policies' trace
+// groups are sampled according to those policies at the end of the current
+// tail-sampling interval.
+func (p *Processor) UpdatePolicies(policies, shadowPolicies []Policy) error {
+	config := p.config.LocalSamplingConfig
+	config.Policies = policies
+	config.ShadowPolicies = shadowPolicies
+	if err := config.validate(); err != nil {
+		return fmt.Errorf("invalid tail-sampling policies: %w", err)
+	}
+	p.groups.updatePolicies(policies)
+	p.shadowGroups.updatePolicies(shadowPolicies)
+	p.logger.Infof("updated tail-sampling policies: %d policies, %d shadow policies", len(policies), len(shadowPolicies))
+	return nil
+}
+
 // ProcessBatch tail-samples transactions and spans.
 //
 // Any events remaining in the batch after the processor returns
@@ -115,6 +161,10 @@ func NewProcessor(params ProcessorParams) (*Processor, error) {
 //
 // All other trace events will either be dropped (e.g. known to not
 // be tail-sampled), or stored for possible later publication.
+//
+// Errors and trace events matching any of the configured interesting
+// events mark their traces as interesting, causing them to be sampled
+// regardless of the outcome of reservoir sampling.
 func (p *Processor) ProcessBatch(ctx context.Context, batch *modelpb.Batch) error {
 	events := *batch
 	for i := 0; i < len(events); i++ {
@@ -128,6 +178,9 @@ func (p *Processor) ProcessBatch(ctx context.Context, batch *modelpb.Batch) erro
 		case modelpb.SpanEventType:
 			p.eventMetrics.processed.Add(context.Background(), 1)
 			report, stored, err = p.processSpan(event)
+		case modelpb.ErrorEventType:
+			p.processError(event)
+			continue
 		default:
 			continue
 		}
@@ -215,20 +268,33 @@ func (p *Processor) processTransaction(event *modelpb.APMEvent) (report, stored
 		return false, false, err
 	}
 
+	p.markInteresting(event)
 	if event.GetParentId() != "" {
 		// Non-root transaction: write to local storage while we wait
 		// for a sampling decision.
-		return false, true, p.eventStore.WriteTraceEvent(
+		return false, true, p.writeTraceEvent(
 			event.Trace.Id, event.Transaction.Id, event,
 		)
 	}
 
-	// Root transaction: apply reservoir sampling.
+	// Evaluate shadow policies for comparison only: their decisions,
+	// and any errors, do not affect the trace.
+	_, _ = p.shadowGroups.sampleTrace(event)
+
+	// Root transaction: apply reservoir sampling, or defer the sampling
+	// decision until trace completion.
 	//
 	// TODO(axw) we should skip reservoir sampling when the matching
 	// policy's sampling rate is 100%, immediately index the event
 	// and record the trace sampling decision.
-	reservoirSampled, err := p.groups.sampleTrace(event)
+	var reservoirSampled bool
+	var evicted string
+	var group *traceGroup
+	if p.pending != nil {
+		group, err = p.groups.trackTrace(event)
+	} else {
+		reservoirSampled, evicted, err = p.groups.sampleTraceEvicting(event)
+	}
 	if err == errTooManyTraceGroups {
 		// Too many trace groups, drop the transaction.
 		p.rateLimitedLogger.Warn(`
@@ -241,6 +307,34 @@ sampling policies without service name specified.
 		return false, false, err
 	}
 
+	if evicted != "" && p.isTraceInteresting(evicted) {
+		// The root transaction displaced an interesting trace from the
+		// sampling reservoir: force sampling of the evicted trace, as
+		// if it had not been admitted to the reservoir.
+		p.groups.forceSampleTrace(evicted)
+		p.eventMetrics.forced.Add(context.Background(), 1)
+	}
+	if group != nil {
+		// The sampling decision will be made on trace completion, so we
+		// write the transaction to storage until then, and record the
+		// trace as pending so that it can be recovered after a restart.
+		now := time.Now()
+		if p.pending.add(event.Trace.Id, group, now, now) {
+			if err := p.eventStore.WriteTracePending(event.Trace.Id, now); err != nil {
+				p.rateLimitedLogger.With(logp.Error(err)).Warn("failed to record pending trace")
+			}
+		}
+		return false, true, p.writeTraceEvent(event.Trace.Id, event.Transaction.Id, event)
+	}
+	if !reservoirSampled && p.isTraceInteresting(event.Trace.Id) {
+		// The root transaction was not admitted to the sampling reservoir,
+		// but the trace contains an interesting event: force sampling of
+		// the trace, and write the transaction to storage until the
+		// sampling decision is finalised.
+		p.groups.forceSampleTrace(event.Trace.Id)
+		p.eventMetrics.forced.Add(context.Background(), 1)
+		return false, true, p.writeTraceEvent(event.Trace.Id, event.Transaction.Id, event)
+	}
 	if !reservoirSampled {
 		// Write the non-sampling decision to storage to avoid further
 		// writes for the trace ID, and then drop the transaction.
@@ -254,7 +348,7 @@ sampling policies without service name specified.
 	// The root transaction was admitted to the sampling reservoir, so we
 	// can proceed to write the transaction to storage; we may index it later,
 	// after finalising the sampling decision.
-	return false, true, p.eventStore.WriteTraceEvent(event.Trace.Id, event.Transaction.Id, event)
+	return false, true, p.writeTraceEvent(event.Trace.Id, event.Transaction.Id, event)
 }
 
 func (p *Processor) processSpan(event *modelpb.APMEvent) (report, stored bool, _ error) {
@@ -265,7 +359,8 @@ func (p *Processor) processSpan(event *modelpb.APMEvent) (report, stored bool, _
 	if err != nil {
 		if err == eventstorage.ErrNotFound {
 			// Tail-sampling decision has not yet been made, write event to local storage.
-			return false, true, p.eventStore.WriteTraceEvent(event.Trace.Id, event.Span.Id, event)
+			p.markInteresting(event)
+			return false, true, p.writeTraceEvent(event.Trace.Id, event.Span.Id, event)
 		}
 		return false, false, err
 	}
@@ -276,6 +371,152 @@ func (p *Processor) processSpan(event *modelpb.APMEvent) (report, stored bool, _
 	return traceSampled, false, nil
 }
 
+// writeTraceEvent writes a trace event to local storage. When sampling
+// decisions are made on trace completion, the trace's activity time is
+// also updated in memory.
+func (p *Processor) writeTraceEvent(traceID, id string, event *modelpb.APMEvent) error {
+	if err := p.eventStore.WriteTraceEvent(traceID, id, event); err != nil {
+		return err
+	}
+	if p.pending != nil {
+		p.pending.touch(traceID, time.Now())
+	}
+	return nil
+}
+
+// decideCompletedTraces makes sampling decisions for pending traces which
+// have completed, or for all pending traces if all is true, and appends the
+// sampled trace IDs to traceIDs. Unsampled traces have their decisions
+// written to local storage, so that subsequent events are dropped.
+func (p *Processor) decideCompletedTraces(traceIDs []string, all bool) []string {
+	now := time.Now()
+	for _, t := range p.pending.list() {
+		if !all && !p.isTraceComplete(t, now) {
+			continue
+		}
+		p.pending.remove(t.traceID)
+		if t.group.sampleCompletedTrace() {
+			traceIDs = append(traceIDs, t.traceID)
+			continue
+		}
+		if p.isTraceInteresting(t.traceID) {
+			p.eventMetrics.forced.Add(context.Background(), 1)
+			traceIDs = append(traceIDs, t.traceID)
+			continue
+		}
+		p.shardLock.Lock(t.traceID)
+		if err := p.eventStore.WriteTraceSampled(t.traceID, false); err != nil {
+			p.rateLimitedLogger.With(logp.Error(err)).Warn("failed to write unsampled trace decision")
+		}
+		p.shardLock.Unlock(t.traceID)
+	}
+	return traceIDs
+}
+
+// isTraceComplete reports whether the pending trace is complete: either it
+// has been idle for TraceIdleTimeout, or TraceRootGracePeriod has elapsed
+// since its root transaction was received.
+func (p *Processor) isTraceComplete(t pendingTrace, now time.Time) bool {
+	if p.config.TraceRootGracePeriod > 0 && now.Sub(t.rootReceived) >= p.config.TraceRootGracePeriod {
+		return true
+	}
+	return now.Sub(t.lastActivity) >= p.config.TraceIdleTimeout
+}
+
+// recoverPendingTraces adds traces recorded as pending in storage, for which
+// no sampling decision has been recorded, to the pending traces. This recovers
+// traces which were pending when the server was last stopped or crashed. Their
+// idle time is measured from the time of recovery.
+func (p *Processor) recoverPendingTraces() {
+	now := time.Now()
+	var recovered int
+	err := p.eventStore.ReadPendingTraces(func(traceID string, rootReceived time.Time) {
+		if p.pending.has(traceID) {
+			return
+		}
+		if _, err := p.eventStore.IsTraceSampled(traceID); err != eventstorage.ErrNotFound {
+			return
+		}
+		// The trace group is derived from the stored root transaction.
+		var batch modelpb.Batch
+		if err := p.eventStore.ReadTraceEvents(traceID, &batch); err != nil {
+			p.rateLimitedLogger.With(logp.Error(err)).Warn("failed to read pending trace events")
+			return
+		}
+		for _, event := range batch {
+			if event.Type() != modelpb.TransactionEventType || event.GetParentId() != "" {
+				continue
+			}
+			group, err := p.groups.trackTrace(event)
+			if err != nil {
+				return
+			}
+			if p.pending.add(traceID, group, rootReceived, now) {
+				recovered++
+			}
+			return
+		}
+	})
+	if err != nil {
+		p.logger.With(logp.Error(err)).Warn("failed to read pending traces")
+	}
+	if recovered > 0 {
+		p.logger.Infof("recovered %d pending traces", recovered)
+	}
+}
+
+// processError marks the error's trace as interesting if the error matches
+// any of the configured interesting events, and the tail-sampling decision
+// has not yet been made. Errors are always reported.
+func (p *Processor) processError(event *modelpb.APMEvent) {
+	traceID := event.GetTrace().GetId()
+	if len(p.interesting) == 0 || traceID == "" {
+		return
+	}
+	p.shardLock.RLock(traceID)
+	defer p.shardLock.RUnlock(traceID)
+	if _, err := p.eventStore.IsTraceSampled(traceID); err != eventstorage.ErrNotFound {
+		if err != nil {
+			p.rateLimitedLogger.With(logp.Error(err)).Warn("failed to read trace sampling decision")
+		}
+		return
+	}
+	p.markInteresting(event)
+}
+
+// markInteresting marks the event's trace as interesting in local storage
+// if the event matches any of the configured interesting events. This must
+// be called with the trace's shard lock held.
+//
+// Failure to write the marker is logged, and does not affect processing of
+// the event: the trace is then subject to reservoir sampling as usual.
+func (p *Processor) markInteresting(event *modelpb.APMEvent) {
+	for i := range p.interesting {
+		if !p.interesting[i].match(event) {
+			continue
+		}
+		p.eventMetrics.interesting.Add(context.Background(), 1, p.interesting[i].attributes)
+		if err := p.eventStore.WriteTraceInteresting(event.Trace.Id); err != nil {
+			p.rateLimitedLogger.With(logp.Error(err)).Warn("failed to mark trace as interesting")
+		}
+		return
+	}
+}
+
+// isTraceInteresting reports whether the trace has been marked as interesting.
+// Errors reading the marker are logged, and treated as uninteresting.
+func (p *Processor) isTraceInteresting(traceID string) bool {
+	if len(p.interesting) == 0 {
+		return false
+	}
+	interesting, err := p.eventStore.IsTraceInteresting(traceID)
+	if err != nil {
+		p.rateLimitedLogger.With(logp.Error(err)).Warn("failed to read trace interesting marker")
+		return false
+	}
+	return interesting
+}
+
 // Stop stops the processor.
 // Note that the underlying StorageManager must be closed independently
 // to ensure writes are synced to disk.
@@ -328,20 +569,13 @@ func (p *Processor) Run() error {
 		bulkIndexerFlushInterval = p.config.FlushInterval
 	}
 
+	if p.pending != nil {
+		p.recoverPendingTraces()
+	}
+
 	initialSubscriberPosition := readSubscriberPosition(p.logger, p.config.DB)
 	subscriberPositions := make(chan pubsub.SubscriberPosition)
-	pubsub, err := pubsub.New(pubsub.Config{
-		ServerID:   p.config.UUID,
-		Client:     p.config.Elasticsearch,
-		DataStream: pubsub.DataStreamConfig(p.config.SampledTracesDataStream),
-		Logger:     p.logger,
-
-		// Issue pubsub subscriber search requests at twice the frequency
-		// of publishing, so each server observes each other's sampled
-		// trace IDs soon after they are published.
-		SearchInterval: p.config.FlushInterval / 2,
-		FlushInterval:  bulkIndexerFlushInterval,
-	})
+	pubsub, err := p.newPubsub(bulkIndexerFlushInterval)
 	if err != nil {
 		return err
 	}
@@ -396,7 +630,7 @@ func (p *Processor) Run() error {
 		)
 	})
 	g.Go(func() error {
-		// Publish locally sampled trace IDs to Elasticsearch. This is cancelled when
+		// Publish locally sampled trace IDs to other servers. This is cancelled when
 		// publishSampledTraceIDs is closed, after the final reservoir flush.
 		return pubsub.PublishSampledTraceIDs(gracefulContext, publishSampledTraceIDs)
 	})
@@ -412,9 +646,31 @@ func (p *Processor) Run() error {
 		defer close(publishSampledTraceIDs)
 		defer close(localSampledTraceIDs)
 
-		publishDecisions := func() error {
-			p.logger.Debug("finalizing local sampling reservoirs")
-			traceIDs = p.groups.finalizeSampledTraces(traceIDs)
+		// When sampling decisions are made on trace completion, check
+		// for completed traces more frequently than they can complete.
+		var completionTicks <-chan time.Time
+		if p.pending != nil {
+			interval := p.config.TraceIdleTimeout / 2
+			if grace := p.config.TraceRootGracePeriod / 2; grace > 0 && grace < interval {
+				interval = grace
+			}
+			completionTicker := time.NewTicker(interval)
+			defer completionTicker.Stop()
+			completionTicks = completionTicker.C
+		}
+
+		var isForced func(string) bool
+		if len(p.interesting) != 0 {
+			isForced = func(traceID string) bool {
+				if !p.isTraceInteresting(traceID) {
+					return false
+				}
+				p.eventMetrics.forced.Add(context.Background(), 1)
+				return true
+			}
+		}
+
+		sendDecisions := func() error {
 			if len(traceIDs) == 0 {
 				return nil
 			}
@@ -428,10 +684,29 @@ func (p *Processor) Run() error {
 			return nil
 		}
 
+		var shadowTraceIDs []string
+		publishDecisions := func() error {
+			p.logger.Debug("finalizing local sampling reservoirs")
+			traceIDs = p.groups.finalizeSampledTraces(traceIDs, isForced)
+			// Shadow decisions are reported as metrics, and discarded.
+			shadowTraceIDs = p.shadowGroups.finalizeSampledTraces(shadowTraceIDs[:0], nil)
+			return sendDecisions()
+		}
+
 		for {
 			select {
 			case <-p.stopping:
+				if p.pending != nil {
+					// Make decisions for all pending traces, complete
+					// or not, so they are published before stopping.
+					traceIDs = p.decideCompletedTraces(traceIDs, true)
+				}
 				return publishDecisions()
+			case <-completionTicks:
+				traceIDs = p.decideCompletedTraces(traceIDs, false)
+				if err := sendDecisions(); err != nil {
+					return err
+				}
 			case <-ticker.C:
 				if err := publishDecisions(); err != nil {
 					return err
@@ -533,6 +808,50 @@ func (p *Processor) Run() error {
 	return nil
 }
 
+// sampledTraceIDsPubsub publishes and subscribes to sampled trace IDs,
+// sharing sampling decisions with other APM Servers.
+type sampledTraceIDsPubsub interface {
+	PublishSampledTraceIDs(ctx context.Context, traceIDs <-chan string) error
+	SubscribeSampledTraceIDs(
+		ctx context.Context,
+		pos pubsub.SubscriberPosition,
+		traceIDs chan<- string,
+		positions chan<- pubsub.SubscriberPosition,
+	) error
+}
+
+// newPubsub returns a sampledTraceIDsPubsub which exchanges sampled trace IDs
+// directly with peers if configured, and through Elasticsearch otherwise.
+func (p *Processor) newPubsub(bulkIndexerFlushInterval time.Duration) (sampledTraceIDsPubsub, error) {
+	if p.config.Peer != nil {
+		return pubsub.NewPeer(pubsub.PeerConfig{
+			ServerID:          p.config.UUID,
+			ListenAddress:     p.config.Peer.ListenAddress,
+			Peers:             p.config.Peer.Peers,
+			DNSName:           p.config.Peer.DNSName,
+			DiscoveryInterval: p.config.Peer.DiscoveryInterval,
+			BatchSize:         p.config.Peer.BatchSize,
+			LogSize:           p.config.Peer.LogSize,
+			PositionTTL:       p.config.TTL,
+			Transport:         p.config.Peer.Transport,
+			Logger:            p.logger,
+			MeterProvider:     p.config.MeterProvider,
+		})
+	}
+	return pubsub.New(pubsub.Config{
+		ServerID:   p.config.UUID,
+		Client:     p.config.Elasticsearch,
+		DataStream: pubsub.DataStreamConfig(p.config.SampledTracesDataStream),
+		Logger:     p.logger,
+
+		// Issue pubsub subscriber search requests at twice the frequency
+		// of publishing, so each server observes each other's sampled
+		// trace IDs soon after they are published.
+		SearchInterval: p.config.FlushInterval / 2,
+		FlushInterval:  bulkIndexerFlushInterval,
+	})
+}
+
 func readSubscriberPosition(logger *logp.Logger, s *eventstorage.StorageManager) pubsub.SubscriberPosition {
 	var pos pubsub.SubscriberPosition
 	data, err := s.ReadSubscriberPosition()
@@ -419,6 +419,336 @@ func TestProcessLocalTailSamplingPolicyOrder(t *testing.T) {
 	}
 }
 
+func TestProcessLocalTailSamplingShadowPolicies(t *testing.T) {
+	tempdirConfig := newTempdirConfig(t)
+	config := tempdirConfig.Config
+	config.Policies = []sampling.Policy{{SampleRate: 0}}
+	config.ShadowPolicies = []sampling.Policy{{SampleRate: 0.5}}
+	config.FlushInterval = 10 * time.Millisecond
+	published := make(chan string)
+	config.Elasticsearch = pubsubtest.Client(pubsubtest.PublisherChan(published), nil)
+
+	processor, err := sampling.NewProcessor(sampling.ProcessorParams{
+		Config:         config,
+		Logger:         logptest.NewTestingLogger(t, ""),
+		StatusReporter: noopStatusReport{},
+	})
+	require.NoError(t, err)
+
+	const numTransactions = 100
+	events := make(modelpb.Batch, numTransactions)
+	for i := range events {
+		events[i] = &modelpb.APMEvent{
+			Service: &modelpb.Service{Name: "service_name"},
+			Trace:   &modelpb.Trace{Id: fmt.Sprintf("trace_%d", i)},
+			Event:   &modelpb.Event{Duration: uint64(123 * time.Millisecond)},
+			Transaction: &modelpb.Transaction{
+				Type:    "type",
+				Id:      fmt.Sprintf("transaction_%d", i),
+				Sampled: true,
+			},
+		}
+	}
+	require.NoError(t, processor.ProcessBatch(context.Background(), &events))
+	assert.Empty(t, events)
+
+	go processor.Run()
+	defer processor.Stop(context.Background())
+
+	// The shadow policy would sample 50% of traces, but only the
+	// active policy's decisions take effect.
+	var shadowSampled int64
+	assert.Eventually(t, func() bool {
+		shadowSampled += getSum(t, tempdirConfig.metricReader, "apm-server.sampling.tail.shadow.traces.sampled")
+		return shadowSampled == numTransactions/2
+	}, 10*time.Second, 10*time.Millisecond)
+	select {
+	case <-published:
+		t.Fatal("unexpected publication")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestProcessorUpdatePolicies(t *testing.T) {
+	config := newTempdirConfig(t).Config
+	config.Policies = []sampling.Policy{{SampleRate: 0}}
+	processor, err := sampling.NewProcessor(sampling.ProcessorParams{
+		Config:         config,
+		Logger:         logptest.NewTestingLogger(t, ""),
+		StatusReporter: noopStatusReport{},
+	})
+	require.NoError(t, err)
+
+	err = processor.UpdatePolicies([]sampling.Policy{{
+		PolicyCriteria: sampling.PolicyCriteria{ServiceName: "service_name"},
+		SampleRate:     1,
+	}}, nil)
+	assert.EqualError(t, err, "invalid tail-sampling policies: Policies does not contain a default (empty criteria) policy")
+
+	// Root transactions are sampled according to the updated policies.
+	require.NoError(t, processor.UpdatePolicies([]sampling.Policy{{SampleRate: 1}}, nil))
+	batch := modelpb.Batch{{
+		Service: &modelpb.Service{Name: "service_name"},
+		Trace:   &modelpb.Trace{Id: "trace_id"},
+		Event:   &modelpb.Event{Duration: uint64(time.Millisecond)},
+		Transaction: &modelpb.Transaction{
+			Type:    "type",
+			Id:      "transaction_id",
+			Sampled: true,
+		},
+	}}
+	require.NoError(t, processor.ProcessBatch(context.Background(), &batch))
+	groups := processor.TraceGroups()
+	require.Len(t, groups, 1)
+	assert.Equal(t, 1.0, groups[0].SampleRate)
+	assert.Equal(t, 1, groups[0].ReservoirLen)
+}
+
+func TestProcessLocalTailSamplingInterestingEvents(t *testing.T) {
+	config := newTempdirConfig(t).Config
+	config.Policies = []sampling.Policy{{SampleRate: 0}}
+	config.InterestingEvents = []sampling.InterestingEvent{{
+		Name:      "errors",
+		EventType: modelpb.ErrorEventType,
+	}, {
+		Name:        "slow_db",
+		EventType:   modelpb.SpanEventType,
+		MinDuration: time.Second,
+		Conditions:  []sampling.Condition{{Field: "span.type", Operator: sampling.OperatorEquals, Value: "db"}},
+	}}
+	config.FlushInterval = 10 * time.Millisecond
+	published := make(chan string)
+	config.Elasticsearch = pubsubtest.Client(pubsubtest.PublisherChan(published), nil)
+
+	processor, err := sampling.NewProcessor(sampling.ProcessorParams{
+		Config:         config,
+		Logger:         logptest.NewTestingLogger(t, ""),
+		StatusReporter: noopStatusReport{},
+	})
+	require.NoError(t, err)
+
+	makeTrace := func(traceID string, child *modelpb.APMEvent) modelpb.Batch {
+		child.Trace = &modelpb.Trace{Id: traceID}
+		child.ParentId = traceID
+		return modelpb.Batch{child, {
+			Trace: &modelpb.Trace{Id: traceID},
+			Event: &modelpb.Event{Duration: uint64(123 * time.Millisecond)},
+			Transaction: &modelpb.Transaction{
+				Type:    "type",
+				Id:      traceID,
+				Sampled: true,
+			},
+		}}
+	}
+	errorTrace := makeTrace("error_trace", &modelpb.APMEvent{
+		Error: &modelpb.Error{Id: "error_id"},
+	})
+	slowDBTrace := makeTrace("slow_db_trace", &modelpb.APMEvent{
+		Event: &modelpb.Event{Duration: uint64(2 * time.Second)},
+		Span:  &modelpb.Span{Type: "db", Id: "slow_db_span"},
+	})
+	fastDBTrace := makeTrace("fast_db_trace", &modelpb.APMEvent{
+		Event: &modelpb.Event{Duration: uint64(time.Millisecond)},
+		Span:  &modelpb.Span{Type: "db", Id: "fast_db_span"},
+	})
+	for _, batch := range []modelpb.Batch{errorTrace, slowDBTrace, fastDBTrace} {
+		err := processor.ProcessBatch(context.Background(), &batch)
+		require.NoError(t, err)
+		for _, event := range batch {
+			// Only errors are reported immediately.
+			assert.Equal(t, modelpb.ErrorEventType, event.Type())
+		}
+	}
+
+	go processor.Run()
+	defer processor.Stop(context.Background())
+
+	// The policy has a sample rate of zero, but the traces containing
+	// interesting events should be sampled.
+	var sampledTraceIDs []string
+	for i := 0; i < 2; i++ {
+		select {
+		case traceID := <-published:
+			sampledTraceIDs = append(sampledTraceIDs, traceID)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for publication")
+		}
+	}
+	assert.ElementsMatch(t, []string{"error_trace", "slow_db_trace"}, sampledTraceIDs)
+	select {
+	case traceID := <-published:
+		t.Fatalf("unexpected publication of %q", traceID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestProcessLocalTailSamplingInterestingEvicted(t *testing.T) {
+	config := newTempdirConfig(t).Config
+	config.Policies = []sampling.Policy{{SampleRate: 1}}
+	config.InterestingEvents = []sampling.InterestingEvent{{
+		Name:      "errors",
+		EventType: modelpb.ErrorEventType,
+	}}
+	config.FlushInterval = 10 * time.Millisecond
+	published := make(chan string)
+	config.Elasticsearch = pubsubtest.Client(pubsubtest.PublisherChan(published), nil)
+
+	processor, err := sampling.NewProcessor(sampling.ProcessorParams{
+		Config:         config,
+		Logger:         logptest.NewTestingLogger(t, ""),
+		StatusReporter: noopStatusReport{},
+	})
+	require.NoError(t, err)
+
+	// The interesting trace's root transaction is admitted to the
+	// reservoir, but has the lowest possible weight.
+	batch := modelpb.Batch{{
+		Trace:    &modelpb.Trace{Id: "interesting_trace"},
+		ParentId: "interesting_trace",
+		Error:    &modelpb.Error{Id: "error_id"},
+	}, {
+		Trace:       &modelpb.Trace{Id: "interesting_trace"},
+		Event:       &modelpb.Event{Duration: 1},
+		Transaction: &modelpb.Transaction{Type: "type", Id: "interesting_trace", Sampled: true},
+	}}
+	require.NoError(t, processor.ProcessBatch(context.Background(), &batch))
+
+	// Fill the reservoir with heavier traces, evicting the interesting trace.
+	batch = batch[:0]
+	for i := 0; i < 1000; i++ {
+		traceID := fmt.Sprintf("trace_%d", i)
+		batch = append(batch, &modelpb.APMEvent{
+			Trace:       &modelpb.Trace{Id: traceID},
+			Event:       &modelpb.Event{Duration: uint64(time.Hour)},
+			Transaction: &modelpb.Transaction{Type: "type", Id: traceID, Sampled: true},
+		})
+	}
+	require.NoError(t, processor.ProcessBatch(context.Background(), &batch))
+	groups := processor.TraceGroups()
+	require.Len(t, groups, 1)
+	assert.Equal(t, 1000, groups[0].ReservoirLen)
+
+	go processor.Run()
+	defer processor.Stop(context.Background())
+
+	// The evicted trace should be sampled, as it contains an interesting event.
+	timeout := time.After(10 * time.Second)
+	for found := false; !found; {
+		select {
+		case traceID := <-published:
+			found = traceID == "interesting_trace"
+		case <-timeout:
+			t.Fatal("timed out waiting for publication of interesting trace")
+		}
+	}
+	go func() {
+		for range published {
+		}
+	}()
+}
+
+func TestProcessLocalTailSamplingTraceCompletion(t *testing.T) {
+	config := newTempdirConfig(t).Config
+	config.Policies = []sampling.Policy{{SampleRate: 1}}
+	config.FlushInterval = time.Hour
+	config.TraceIdleTimeout = 50 * time.Millisecond
+	published := make(chan string)
+	config.Elasticsearch = pubsubtest.Client(pubsubtest.PublisherChan(published), nil)
+
+	processor, err := sampling.NewProcessor(sampling.ProcessorParams{
+		Config:         config,
+		Logger:         logptest.NewTestingLogger(t, ""),
+		StatusReporter: noopStatusReport{},
+	})
+	require.NoError(t, err)
+	go processor.Run()
+	defer processor.Stop(context.Background())
+
+	const numTraces = 10
+	for i := 0; i < numTraces; i++ {
+		traceID := fmt.Sprintf("trace_%d", i)
+		batch := modelpb.Batch{{
+			Trace:    &modelpb.Trace{Id: traceID},
+			Event:    &modelpb.Event{Duration: uint64(time.Millisecond)},
+			Span:     &modelpb.Span{Type: "type", Id: traceID + "_span"},
+			ParentId: traceID,
+		}, {
+			Trace: &modelpb.Trace{Id: traceID},
+			Event: &modelpb.Event{Duration: uint64(123 * time.Millisecond)},
+			Transaction: &modelpb.Transaction{
+				Type:    "type",
+				Id:      traceID,
+				Sampled: true,
+			},
+		}}
+		err := processor.ProcessBatch(context.Background(), &batch)
+		require.NoError(t, err)
+		assert.Empty(t, batch)
+	}
+
+	// Decisions are made once traces have been idle,
+	// long before the flush interval.
+	for i := 0; i < numTraces; i++ {
+		select {
+		case <-published:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for publication")
+		}
+	}
+	select {
+	case traceID := <-published:
+		t.Fatalf("unexpected publication of %q", traceID)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestProcessLocalTailSamplingTraceCompletionRecovery(t *testing.T) {
+	config := newTempdirConfig(t).Config
+	config.Policies = []sampling.Policy{{SampleRate: 1}}
+	config.FlushInterval = time.Hour
+	config.TraceIdleTimeout = 50 * time.Millisecond
+	published := make(chan string)
+	config.Elasticsearch = pubsubtest.Client(pubsubtest.PublisherChan(published), nil)
+
+	// Process a root transaction without running the processor,
+	// as if the server crashed before the trace was decided.
+	processor, err := sampling.NewProcessor(sampling.ProcessorParams{
+		Config:         config,
+		Logger:         logptest.NewTestingLogger(t, ""),
+		StatusReporter: noopStatusReport{},
+	})
+	require.NoError(t, err)
+	batch := modelpb.Batch{{
+		Trace: &modelpb.Trace{Id: "pending_trace"},
+		Event: &modelpb.Event{Duration: uint64(123 * time.Millisecond)},
+		Transaction: &modelpb.Transaction{
+			Type:    "type",
+			Id:      "pending_trace",
+			Sampled: true,
+		},
+	}}
+	require.NoError(t, processor.ProcessBatch(context.Background(), &batch))
+	assert.Empty(t, batch)
+
+	// A new processor using the same storage recovers
+	// the pending trace, and decides it once idle.
+	processor, err = sampling.NewProcessor(sampling.ProcessorParams{
+		Config:         config,
+		Logger:         logptest.NewTestingLogger(t, ""),
+		StatusReporter: noopStatusReport{},
+	})
+	require.NoError(t, err)
+	go processor.Run()
+	defer processor.Stop(context.Background())
+
+	select {
+	case traceID := <-published:
+		assert.Equal(t, "pending_trace", traceID)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for publication")
+	}
+}
+
 func TestProcessRemoteTailSampling(t *testing.T) {
 	tempdirConfig := newTempdirConfig(t)
 	config := tempdirConfig.Config
@@ -542,6 +872,22 @@ func (m errorRW) IsTraceSampled(traceID string) (bool, error) {
 	return false, eventstorage.ErrNotFound
 }
 
+func (m errorRW) WriteTraceInteresting(traceID string) error {
+	return m.err
+}
+
+func (m errorRW) IsTraceInteresting(traceID string) (bool, error) {
+	return false, m.err
+}
+
+func (m errorRW) WriteTracePending(traceID string, rootReceived time.Time) error {
+	return m.err
+}
+
+func (m errorRW) ReadPendingTraces(f func(traceID string, rootReceived time.Time)) error {
+	return m.err
+}
+
 func (m errorRW) DeleteTraceEvent(traceID, id string) error {
 	return m.err
 }
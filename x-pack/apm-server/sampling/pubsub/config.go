@@ -8,10 +8,15 @@ package pubsub
 import (
 	"errors"
 	"fmt"
+	"net"
 	"time"
 
+	"go.opentelemetry.io/otel/metric"
+
 	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-transport-go/v8/elastictransport"
+
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/transport"
 )
 
 // Config holds configuration for Pubsub.
@@ -54,6 +59,85 @@ type Config struct {
 	Logger *logp.Logger
 }
 
+// PeerConfig holds configuration for PeerPubsub.
+type PeerConfig struct {
+	// ServerID holds the APM Server's unique ID, used for identifying the
+	// server to peers, and for ignoring itself if discovered as a peer.
+	ServerID string
+
+	// ListenAddress holds the host:port on which to serve sampled trace IDs
+	// to peers.
+	ListenAddress string
+
+	// Peers holds the static host:port addresses of peers.
+	Peers []string
+
+	// DNSName, if non-empty, holds a host:port whose host is resolved to peer
+	// addresses every DiscoveryInterval, in addition to the static Peers.
+	DNSName string
+
+	// DiscoveryInterval holds the time between peer discovery DNS lookups.
+	DiscoveryInterval time.Duration
+
+	// BatchSize holds the maximum number of trace IDs sent to a peer in
+	// each message.
+	BatchSize int
+
+	// LogSize holds the number of recently published trace IDs retained
+	// in memory for peers to subscribe to. Peers which fall further behind
+	// will miss sampled trace IDs, which is logged and counted in a metric.
+	// Trace IDs which peers have not received are also lost if the server
+	// restarts.
+	LogSize int
+
+	// PositionTTL, if non-zero, holds the amount of time after which the
+	// position of a peer which is no longer discovered is forgotten. This
+	// should be at least as long as the TTL of events in local storage.
+	PositionTTL time.Duration
+
+	// Transport holds the transport security configuration for serving
+	// and subscribing to peers.
+	Transport transport.Config
+
+	// Logger is used for logging publish and subscribe operations -- particularly
+	// errors that occur asynchronously.
+	Logger *logp.Logger
+
+	// MeterProvider, if non-nil, is used for creating metrics.
+	MeterProvider metric.MeterProvider
+}
+
+// Validate validates the configuration.
+func (config PeerConfig) Validate() error {
+	if config.ServerID == "" {
+		return errors.New("ServerID unspecified")
+	}
+	if config.ListenAddress == "" {
+		return errors.New("ListenAddress unspecified")
+	}
+	if len(config.Peers) == 0 && config.DNSName == "" {
+		return errors.New("Peers and DNSName unspecified")
+	}
+	if config.DNSName != "" {
+		if _, _, err := net.SplitHostPort(config.DNSName); err != nil {
+			return fmt.Errorf("DNSName invalid: %w", err)
+		}
+	}
+	if config.DiscoveryInterval <= 0 {
+		return errors.New("DiscoveryInterval unspecified or negative")
+	}
+	if config.BatchSize <= 0 {
+		return errors.New("BatchSize unspecified or negative")
+	}
+	if config.LogSize <= 0 {
+		return errors.New("LogSize unspecified or negative")
+	}
+	if config.PositionTTL < 0 {
+		return errors.New("PositionTTL negative")
+	}
+	return nil
+}
+
 // DataStreamConfig holds data stream configuration for Pubsub.
 type DataStreamConfig struct {
 	// Type holds the data stream's type.
@@ -86,3 +86,47 @@ func TestConfigInvalid(t *testing.T) {
 		assert.EqualError(t, err, "invalid pubsub config: "+test.err)
 	}
 }
+
+func TestPeerConfigInvalid(t *testing.T) {
+	for _, test := range []struct {
+		config pubsub.PeerConfig
+		err    string
+	}{{
+		config: pubsub.PeerConfig{},
+		err:    "ServerID unspecified",
+	}, {
+		config: pubsub.PeerConfig{ServerID: "server_id"},
+		err:    "ListenAddress unspecified",
+	}, {
+		config: pubsub.PeerConfig{ServerID: "server_id", ListenAddress: "127.0.0.1:0"},
+		err:    "Peers and DNSName unspecified",
+	}, {
+		config: pubsub.PeerConfig{ServerID: "server_id", ListenAddress: "127.0.0.1:0", DNSName: "peers"},
+		err:    "DNSName invalid: address peers: missing port in address",
+	}, {
+		config: pubsub.PeerConfig{ServerID: "server_id", ListenAddress: "127.0.0.1:0", Peers: []string{"peer:8201"}},
+		err:    "DiscoveryInterval unspecified or negative",
+	}, {
+		config: pubsub.PeerConfig{
+			ServerID:          "server_id",
+			ListenAddress:     "127.0.0.1:0",
+			Peers:             []string{"peer:8201"},
+			DiscoveryInterval: time.Second,
+		},
+		err: "BatchSize unspecified or negative",
+	}, {
+		config: pubsub.PeerConfig{
+			ServerID:          "server_id",
+			ListenAddress:     "127.0.0.1:0",
+			Peers:             []string{"peer:8201"},
+			DiscoveryInterval: time.Second,
+			BatchSize:         1,
+		},
+		err: "LogSize unspecified or negative",
+	}} {
+		pubsub, err := pubsub.NewPeer(test.config)
+		require.Error(t, err)
+		require.Nil(t, pubsub)
+		assert.EqualError(t, err, "invalid pubsub config: "+test.err)
+	}
+}
@@ -0,0 +1,418 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"google.golang.org/grpc"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/apm-server/internal/logs"
+)
+
+const (
+	// peerMinBackoff and peerMaxBackoff bound the exponential backoff
+	// between attempts to subscribe to a peer.
+	peerMinBackoff = 500 * time.Millisecond
+	peerMaxBackoff = 30 * time.Second
+)
+
+// errSelfPeer is returned when a subscriber discovers that it has
+// subscribed to itself.
+var errSelfPeer = errors.New("subscribed to self")
+
+// PeerPubsub provides a means of publishing and subscribing to sampled trace IDs,
+// exchanging them directly between APM Servers over gRPC, without Elasticsearch.
+//
+// Each server serves its recently published trace IDs to subscribing peers.
+// Peers are discovered from a static list of addresses and/or by periodically
+// resolving a DNS name. Subscribers persist the sequence number of the last
+// trace ID observed from each peer address in SubscriberPosition, so they can
+// resume after restarting. The positions of peers which are no longer
+// discovered are forgotten after PositionTTL.
+//
+// The gRPC service is secured as configured by Transport. Without TLS or a
+// secret token, it is unauthenticated and unencrypted, and should only be
+// exposed on a private network.
+type PeerPubsub struct {
+	config PeerConfig
+	log    *peerLog
+}
+
+// NewPeer returns a new PeerPubsub which can publish and subscribe sampled
+// trace IDs, exchanging them directly with peers.
+//
+// Peers are served only while PublishSampledTraceIDs is running.
+func NewPeer(config PeerConfig) (*PeerPubsub, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid pubsub config: %w", err)
+	}
+	config.Logger = config.Logger.Named(logs.Sampling)
+	if config.MeterProvider == nil {
+		config.MeterProvider = metricnoop.NewMeterProvider()
+	}
+	return &PeerPubsub{
+		config: config,
+		log:    newPeerLog(config.ServerID, config.LogSize, config.BatchSize, config.Logger, config.MeterProvider),
+	}, nil
+}
+
+// PublishSampledTraceIDs receives trace IDs from the traceIDs channel,
+// serving them to subscribing peers. PublishSampledTraceIDs returns when
+// ctx is canceled, or traceIDs is closed.
+//
+// If ListenAddress is in use, for example by a previous processor which has
+// not yet stopped, listening is retried with exponential backoff. Trace IDs
+// published in the meantime are retained for peers. When traceIDs is closed,
+// connected peers are sent any remaining trace IDs before their streams are
+// ended.
+func (p *PeerPubsub) PublishSampledTraceIDs(ctx context.Context, traceIDs <-chan string) error {
+	server := grpc.NewServer(append(
+		p.config.Transport.ServerOptions(p.config.Logger),
+		grpc.ForceServerCodec(peerCodec{}),
+	)...)
+	server.RegisterService(&peerServiceDesc, p.log)
+	serveCtx, cancelServe := context.WithCancel(context.Background())
+	defer cancelServe()
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- p.serve(serveCtx, server) }()
+
+	var result error
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			if err := ctx.Err(); err != context.Canceled {
+				result = err
+			}
+			break loop
+		case err := <-serveErr:
+			result = fmt.Errorf("failed to serve peers: %w", err)
+			break loop
+		case id, ok := <-traceIDs:
+			if !ok {
+				break loop
+			}
+			p.log.append(id)
+		}
+	}
+
+	// Close the log so that streams end after sending the remaining
+	// trace IDs, and wait for them unless ctx is done.
+	cancelServe()
+	p.log.close()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		server.GracefulStop()
+	}()
+	select {
+	case <-ctx.Done():
+		server.Stop()
+	case <-stopped:
+	}
+	<-stopped
+	return result
+}
+
+// serve listens on ListenAddress and serves peers until server is stopped,
+// retrying with exponential backoff if listening fails.
+func (p *PeerPubsub) serve(ctx context.Context, server *grpc.Server) error {
+	backoff := peerMinBackoff
+	for {
+		listener, err := net.Listen("tcp", p.config.ListenAddress)
+		if err == nil {
+			if err := server.Serve(listener); err != grpc.ErrServerStopped {
+				return err
+			}
+			return nil
+		}
+		p.config.Logger.With(logp.Error(err)).Warnf("failed to listen for peers, retrying in %s", backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, peerMaxBackoff)
+	}
+}
+
+// SubscribeSampledTraceIDs subscribes to sampled trace IDs published by peers
+// after the given position, sending them to the traceIDs channel, and sending
+// the most recently observed position (on change) to the positions channel.
+//
+// Peers are rediscovered every DiscoveryInterval. Subscriptions to peers which
+// fail are retried with exponential backoff, resuming after the last observed
+// trace ID. If PositionTTL is non-zero, the positions of peers which have not
+// been discovered for PositionTTL are removed.
+func (p *PeerPubsub) SubscribeSampledTraceIDs(
+	ctx context.Context,
+	pos SubscriberPosition,
+	traceIDs chan<- string,
+	positions chan<- SubscriberPosition,
+) error {
+	ticker := time.NewTicker(p.config.DiscoveryInterval)
+	defer ticker.Stop()
+
+	// Only send positions on change.
+	var positionsOut chan<- SubscriberPosition
+	positionsOut = positions
+
+	type positionUpdate struct {
+		addr  string
+		seqno int64
+	}
+	updates := make(chan positionUpdate)
+
+	var wg sync.WaitGroup
+	subscriptions := make(map[string]context.CancelFunc)
+	// missingSince holds the time since which each peer with a position
+	// has not been discovered. After a restart, this is measured from the
+	// first discovery.
+	missingSince := make(map[string]time.Time)
+	defer func() {
+		for _, cancel := range subscriptions {
+			cancel()
+		}
+		wg.Wait()
+	}()
+	discover := func() {
+		addrs, err := p.discoverPeers(ctx)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				p.config.Logger.With(logp.Error(err)).Warn("error discovering peers")
+			}
+			// Keep the existing subscriptions.
+			return
+		}
+		for addr, cancel := range subscriptions {
+			if _, ok := addrs[addr]; !ok {
+				cancel()
+				delete(subscriptions, addr)
+			}
+		}
+		p.expirePositions(pos, addrs, missingSince, time.Now())
+		for addr := range addrs {
+			if _, ok := subscriptions[addr]; ok {
+				continue
+			}
+			subscriptionCtx, cancel := context.WithCancel(ctx)
+			subscriptions[addr] = cancel
+			after := pos.observedSeqnos[addr]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.subscribePeer(subscriptionCtx, addr, after, traceIDs, func(seqno int64) bool {
+					select {
+					case <-subscriptionCtx.Done():
+						return false
+					case updates <- positionUpdate{addr: addr, seqno: seqno}:
+						return true
+					}
+				})
+			}()
+		}
+	}
+
+	// Copy pos because it is mutated as trace IDs are observed,
+	// and as peers' positions expire.
+	pos = copyPosition(pos)
+	discover()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case positionsOut <- pos:
+			// Copy pos because it is mutated as trace IDs are observed.
+			pos = copyPosition(pos)
+			positionsOut = nil
+		case update := <-updates:
+			if _, ok := subscriptions[update.addr]; !ok {
+				// The peer is no longer discovered.
+				continue
+			}
+			if update.seqno > pos.observedSeqnos[update.addr] {
+				pos.observedSeqnos[update.addr] = update.seqno
+				positionsOut = positions
+			}
+		case <-ticker.C:
+			n := len(pos.observedSeqnos)
+			discover()
+			if len(pos.observedSeqnos) != n {
+				positionsOut = positions
+			}
+		}
+	}
+}
+
+// expirePositions removes the positions of peers which have not been
+// discovered for PositionTTL, given the currently discovered addrs.
+func (p *PeerPubsub) expirePositions(
+	pos SubscriberPosition,
+	addrs map[string]struct{},
+	missingSince map[string]time.Time,
+	now time.Time,
+) {
+	if p.config.PositionTTL <= 0 {
+		return
+	}
+	for addr := range missingSince {
+		if _, ok := pos.observedSeqnos[addr]; !ok {
+			delete(missingSince, addr)
+		}
+	}
+	for addr := range pos.observedSeqnos {
+		if _, ok := addrs[addr]; ok {
+			delete(missingSince, addr)
+			continue
+		}
+		since, ok := missingSince[addr]
+		if !ok {
+			missingSince[addr] = now
+		} else if now.Sub(since) >= p.config.PositionTTL {
+			delete(pos.observedSeqnos, addr)
+			delete(missingSince, addr)
+		}
+	}
+}
+
+// discoverPeers returns the set of static peer addresses, combined with
+// the addresses resolved from the configured DNS name.
+func (p *PeerPubsub) discoverPeers(ctx context.Context) (map[string]struct{}, error) {
+	addrs := make(map[string]struct{}, len(p.config.Peers))
+	for _, addr := range p.config.Peers {
+		addrs[addr] = struct{}{}
+	}
+	if p.config.DNSName == "" {
+		return addrs, nil
+	}
+	host, port, err := net.SplitHostPort(p.config.DNSName)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %q: %w", host, err)
+	}
+	for _, host := range hosts {
+		addrs[net.JoinHostPort(host, port)] = struct{}{}
+	}
+	return addrs, nil
+}
+
+// subscribePeer subscribes to trace IDs published by the peer at addr after
+// the given sequence number, retrying with exponential backoff until ctx is
+// canceled, or the peer is found to be this server.
+//
+// After each batch of trace IDs is sent to out, observed is called with the
+// batch's sequence number. If observed returns false, subscribePeer returns.
+func (p *PeerPubsub) subscribePeer(
+	ctx context.Context,
+	addr string,
+	after int64,
+	out chan<- string,
+	observed func(seqno int64) bool,
+) {
+	logger := p.config.Logger.With(logp.String("peer.address", addr))
+	backoff := peerMinBackoff
+	for {
+		seqno, err := p.streamPeer(ctx, addr, after, out, observed)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errSelfPeer) {
+			logger.Debug("ignoring self in discovered peers")
+			return
+		}
+		if seqno > after {
+			// Progress was made, so reset the backoff.
+			after = seqno
+			backoff = peerMinBackoff
+		}
+		if err != nil {
+			logger.With(logp.Error(err)).Warnf("error subscribing to peer, retrying in %s", backoff)
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, peerMaxBackoff)
+	}
+}
+
+// streamPeer opens a stream to the peer at addr, sending received trace IDs to
+// out until the stream ends or fails. streamPeer returns the sequence number of
+// the last trace ID observed.
+func (p *PeerPubsub) streamPeer(
+	ctx context.Context,
+	addr string,
+	after int64,
+	out chan<- string,
+	observed func(seqno int64) bool,
+) (int64, error) {
+	conn, err := grpc.NewClient(addr, append(
+		p.config.Transport.DialOptions(addr),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(peerCodec{})),
+	)...)
+	if err != nil {
+		return after, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := conn.NewStream(ctx, &peerServiceDesc.Streams[peerSubscribeStreamID], peerSubscribeMethod)
+	if err != nil {
+		return after, err
+	}
+	req := peerSubscribeRequest{ServerID: p.config.ServerID, AfterSeqno: after}
+	if err := stream.SendMsg(&req); err != nil {
+		return after, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return after, err
+	}
+	for first := true; ; first = false {
+		var msg peerTraceIDs
+		if err := stream.RecvMsg(&msg); err != nil {
+			if err == io.EOF {
+				// The peer is shutting down.
+				err = nil
+			}
+			return after, err
+		}
+		if first && msg.ServerID == p.config.ServerID {
+			return after, errSelfPeer
+		}
+		if len(msg.TraceIDs) == 0 {
+			continue
+		}
+		for _, traceID := range msg.TraceIDs {
+			select {
+			case <-ctx.Done():
+				return after, ctx.Err()
+			case out <- traceID:
+			}
+		}
+		after = msg.Seqno
+		if !observed(after) {
+			return after, ctx.Err()
+		}
+	}
+}
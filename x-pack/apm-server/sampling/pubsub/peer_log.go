@@ -0,0 +1,243 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+const (
+	peerServiceName       = "elastic.apm.sampling.Peer"
+	peerSubscribeMethod   = "/" + peerServiceName + "/Subscribe"
+	peerCodecContentType  = "json"
+	peerSubscribeStreamID = 0
+)
+
+// peerSubscribeRequest is sent by a subscriber when opening a stream.
+type peerSubscribeRequest struct {
+	// ServerID holds the subscriber's server ID.
+	ServerID string `json:"server_id"`
+
+	// AfterSeqno holds the sequence number of the last trace ID
+	// observed by the subscriber. Only trace IDs published with
+	// a greater sequence number will be sent.
+	AfterSeqno int64 `json:"after_seqno"`
+}
+
+// peerTraceIDs is sent by a publisher with a batch of sampled trace IDs.
+//
+// The first message of each stream identifies the publisher and may hold
+// no trace IDs.
+type peerTraceIDs struct {
+	// ServerID holds the publisher's server ID.
+	ServerID string `json:"server_id"`
+
+	// TraceIDs holds the sampled trace IDs, in order of publication.
+	TraceIDs []string `json:"trace_ids,omitempty"`
+
+	// Seqno holds the sequence number of the last trace ID in TraceIDs.
+	Seqno int64 `json:"seqno"`
+}
+
+// peerCodec is a gRPC codec which encodes messages as JSON, avoiding
+// the need for generated protobuf code for the small peer protocol.
+type peerCodec struct{}
+
+func (peerCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (peerCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (peerCodec) Name() string                       { return peerCodecContentType }
+
+// peerServer is the handler type for peerServiceDesc.
+type peerServer interface {
+	subscribe(ctx context.Context, req *peerSubscribeRequest, send func(*peerTraceIDs) error) error
+}
+
+var peerServiceDesc = grpc.ServiceDesc{
+	ServiceName: peerServiceName,
+	HandlerType: (*peerServer)(nil),
+	Streams: []grpc.StreamDesc{
+		peerSubscribeStreamID: {
+			StreamName:    "Subscribe",
+			Handler:       peerSubscribeHandler,
+			ServerStreams: true,
+		},
+	},
+}
+
+func peerSubscribeHandler(srv any, stream grpc.ServerStream) error {
+	var req peerSubscribeRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	return srv.(peerServer).subscribe(stream.Context(), &req, func(msg *peerTraceIDs) error {
+		return stream.SendMsg(msg)
+	})
+}
+
+// peerLog holds a bounded log of recently published sampled trace IDs,
+// which is served to subscribing peers.
+//
+// Sequence numbers are derived from the wall clock, so that they continue
+// to increase across restarts of the server, and subscribers may resume
+// from a persisted position without missing newly published trace IDs.
+// Within a process, sequence numbers strictly increase even if the clock
+// steps backwards. However, if the server restarts with its clock behind
+// the time of the last trace ID observed by a subscriber, the subscriber
+// will skip trace IDs published until the clock catches up. Servers are
+// expected to keep their clocks synchronized, e.g. with NTP.
+//
+// The log is held in memory only, so trace IDs which subscribers have not
+// received when the server restarts are lost. Subscribers which fall so far
+// behind that trace IDs they have not received are evicted also miss them;
+// this is logged and counted in the metric
+// `apm-server.sampling.tail.peer.log.missed`.
+type peerLog struct {
+	serverID  string
+	batchSize int
+	logger    *logp.Logger
+	missed    metric.Int64Counter
+
+	mu        sync.Mutex
+	entries   []peerLogEntry // ring buffer
+	next      int            // total number of entries appended
+	lastSeqno int64
+	// evictedSeqno holds the sequence number of the last evicted entry.
+	evictedSeqno int64
+	closed       bool
+	// notify is closed and replaced when an entry is appended,
+	// or when the log is closed.
+	notify chan struct{}
+}
+
+type peerLogEntry struct {
+	seqno   int64
+	traceID string
+}
+
+func newPeerLog(serverID string, size, batchSize int, logger *logp.Logger, mp metric.MeterProvider) *peerLog {
+	meter := mp.Meter("github.com/elastic/apm-server/x-pack/apm-server/sampling/pubsub")
+	missed, _ := meter.Int64Counter("apm-server.sampling.tail.peer.log.missed")
+	return &peerLog{
+		serverID:  serverID,
+		batchSize: batchSize,
+		logger:    logger,
+		missed:    missed,
+		entries:   make([]peerLogEntry, size),
+		notify:    make(chan struct{}),
+	}
+}
+
+// append appends traceID to the log, evicting the oldest entry if the
+// log is full.
+func (l *peerLog) append(traceID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	seqno := time.Now().UnixNano()
+	if seqno <= l.lastSeqno {
+		seqno = l.lastSeqno + 1
+	}
+	entry := &l.entries[l.next%len(l.entries)]
+	if l.next >= len(l.entries) {
+		l.evictedSeqno = entry.seqno
+	}
+	*entry = peerLogEntry{seqno: seqno, traceID: traceID}
+	l.next++
+	l.lastSeqno = seqno
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// close marks the log as closed. Subscribers are sent any remaining
+// entries, and then their streams are ended.
+func (l *peerLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.notify)
+	}
+}
+
+// read returns up to max trace IDs with a sequence number greater than
+// after, and the sequence number of the last one returned. If there are
+// no such trace IDs, read returns a channel which will be closed when
+// more are appended, and whether the log is closed.
+//
+// read also reports whether trace IDs with a sequence number greater than
+// after have been evicted. A zero after identifies a new subscriber, which
+// is not considered to have missed trace IDs published before it subscribed.
+func (l *peerLog) read(after int64, max int) (traceIDs []string, seqno int64, wait <-chan struct{}, closed, missed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	missed = after > 0 && after < l.evictedSeqno
+	first := l.next - len(l.entries)
+	if first < 0 {
+		first = 0
+	}
+	// Entries are ordered by sequence number, so we can binary search
+	// for the first entry with a greater sequence number than after.
+	i := first + sort.Search(l.next-first, func(i int) bool {
+		return l.entries[(first+i)%len(l.entries)].seqno > after
+	})
+	if i == l.next {
+		return nil, after, l.notify, l.closed, missed
+	}
+	n := min(l.next-i, max)
+	traceIDs = make([]string, n)
+	for j := range traceIDs {
+		entry := l.entries[(i+j)%len(l.entries)]
+		traceIDs[j] = entry.traceID
+		seqno = entry.seqno
+	}
+	return traceIDs, seqno, nil, false, missed
+}
+
+// subscribe sends batches of trace IDs published after req.AfterSeqno,
+// until ctx is canceled or the log is closed.
+func (l *peerLog) subscribe(ctx context.Context, req *peerSubscribeRequest, send func(*peerTraceIDs) error) error {
+	after := req.AfterSeqno
+	// Identify this server before sending any trace IDs, so
+	// subscribers can detect when they have subscribed to themselves.
+	if err := send(&peerTraceIDs{ServerID: l.serverID, Seqno: after}); err != nil {
+		return err
+	}
+	for {
+		traceIDs, seqno, wait, closed, missed := l.read(after, l.batchSize)
+		if missed {
+			l.missed.Add(ctx, 1)
+			l.logger.With(logp.String("peer.server_id", req.ServerID)).Warn(
+				"peer fell behind the log of sampled trace IDs, and missed evicted trace IDs; " +
+					"consider increasing log_size",
+			)
+		}
+		if len(traceIDs) != 0 {
+			if err := send(&peerTraceIDs{ServerID: l.serverID, TraceIDs: traceIDs, Seqno: seqno}); err != nil {
+				return err
+			}
+			after = seqno
+			continue
+		}
+		if closed {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wait:
+		}
+	}
+}
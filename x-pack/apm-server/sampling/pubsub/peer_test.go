@@ -0,0 +1,333 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package pubsub_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/pubsub"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/transport"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestPeerPubsub(t *testing.T) {
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	servers := make([]*pubsub.PeerPubsub, len(addrs))
+	for i, addr := range addrs {
+		servers[i] = newPeerPubsub(t, fmt.Sprintf("server_%d", i), addr, addrs)
+	}
+
+	published := make(chan string)
+	publishDone := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { publishDone <- servers[0].PublishSampledTraceIDs(ctx, published) }()
+
+	// Each server is a peer of itself, but it should not receive its own trace IDs.
+	ownTraceIDs, _, cancelOwn := newPeerSubscriber(t, servers[0], pubsub.SubscriberPosition{})
+	defer cancelOwn()
+
+	traceIDs, positions, cancelSubscriber := newPeerSubscriber(t, servers[1], pubsub.SubscriberPosition{})
+	expectPosition(t, positions) // initial position
+	for i := 0; i < 3; i++ {
+		published <- fmt.Sprintf("trace_%d", i)
+	}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, fmt.Sprintf("trace_%d", i), expectValue(t, traceIDs))
+	}
+	var pos pubsub.SubscriberPosition
+	for {
+		pos = expectPosition(t, positions)
+		var seqnos map[string]int64
+		data, err := json.Marshal(pos)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &seqnos))
+		if seqnos[addrs[0]] != 0 {
+			// Positions are persisted per peer address.
+			assert.Len(t, seqnos, 1)
+			break
+		}
+	}
+	cancelSubscriber()
+	expectNone(t, ownTraceIDs)
+
+	// Trace IDs published while the subscriber is stopped are received after
+	// it resumes from its persisted position.
+	published <- "trace_3"
+	traceIDs, _, cancelSubscriber = newPeerSubscriber(t, servers[1], pos)
+	defer cancelSubscriber()
+	assert.Equal(t, "trace_3", expectValue(t, traceIDs))
+	expectNone(t, traceIDs)
+	expectNone(t, ownTraceIDs)
+
+	close(published)
+	select {
+	case err := <-publishDone:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for publisher to stop")
+	}
+}
+
+func TestPeerPubsubLogSize(t *testing.T) {
+	addr := freeAddr(t)
+	reader := sdkmetric.NewManualReader()
+	publisher, err := pubsub.NewPeer(pubsub.PeerConfig{
+		ServerID:          "publisher",
+		ListenAddress:     addr,
+		Peers:             []string{addr},
+		DiscoveryInterval: time.Minute,
+		BatchSize:         2,
+		LogSize:           3,
+		Logger:            logptest.NewTestingLogger(t, ""),
+		MeterProvider:     sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+	})
+	require.NoError(t, err)
+
+	published := make(chan string)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go publisher.PublishSampledTraceIDs(ctx, published)
+	for i := 0; i < 5; i++ {
+		published <- fmt.Sprintf("trace_%d", i)
+	}
+
+	// Only the most recent LogSize trace IDs are retained for peers.
+	subscriber := newPeerPubsub(t, "subscriber", freeAddr(t), []string{addr})
+	traceIDs, _, cancelSubscriber := newPeerSubscriber(t, subscriber, pubsub.SubscriberPosition{})
+	defer cancelSubscriber()
+	for i := 2; i < 5; i++ {
+		assert.Equal(t, fmt.Sprintf("trace_%d", i), expectValue(t, traceIDs))
+	}
+	expectNone(t, traceIDs)
+	cancelSubscriber()
+
+	// New subscribers are not considered to have missed evicted trace IDs,
+	// but subscribers resuming from before the evicted trace IDs are.
+	missed := func() int64 {
+		var rm metricdata.ResourceMetrics
+		require.NoError(t, reader.Collect(context.Background(), &rm))
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				if m.Name == "apm-server.sampling.tail.peer.log.missed" {
+					return m.Data.(metricdata.Sum[int64]).DataPoints[0].Value
+				}
+			}
+		}
+		return 0
+	}
+	assert.Zero(t, missed())
+
+	var pos pubsub.SubscriberPosition
+	require.NoError(t, json.Unmarshal([]byte(`{"`+addr+`":1}`), &pos))
+	subscriber = newPeerPubsub(t, "resumed_subscriber", freeAddr(t), []string{addr})
+	traceIDs, _, cancelSubscriber = newPeerSubscriber(t, subscriber, pos)
+	defer cancelSubscriber()
+	for i := 2; i < 5; i++ {
+		assert.Equal(t, fmt.Sprintf("trace_%d", i), expectValue(t, traceIDs))
+	}
+	expectNone(t, traceIDs)
+	assert.Equal(t, int64(1), missed())
+}
+
+func TestPeerPubsubDNSDiscovery(t *testing.T) {
+	publisherAddr := freeAddr(t)
+	_, port, err := net.SplitHostPort(publisherAddr)
+	require.NoError(t, err)
+	publisher := newPeerPubsub(t, "publisher", publisherAddr, []string{publisherAddr})
+
+	published := make(chan string)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go publisher.PublishSampledTraceIDs(ctx, published)
+
+	subscriber, err := pubsub.NewPeer(pubsub.PeerConfig{
+		ServerID:          "subscriber",
+		ListenAddress:     freeAddr(t),
+		DNSName:           net.JoinHostPort("localhost", port),
+		DiscoveryInterval: 10 * time.Millisecond,
+		BatchSize:         10,
+		LogSize:           10,
+		Logger:            logptest.NewTestingLogger(t, ""),
+	})
+	require.NoError(t, err)
+	traceIDs, _, cancelSubscriber := newPeerSubscriber(t, subscriber, pubsub.SubscriberPosition{})
+	defer cancelSubscriber()
+
+	published <- "trace_id"
+	assert.Equal(t, "trace_id", expectValue(t, traceIDs))
+}
+
+func TestPeerPubsubRetry(t *testing.T) {
+	publisherAddr := freeAddr(t)
+	subscriber := newPeerPubsub(t, "subscriber", freeAddr(t), []string{publisherAddr})
+	traceIDs, _, cancelSubscriber := newPeerSubscriber(t, subscriber, pubsub.SubscriberPosition{})
+	defer cancelSubscriber()
+
+	// The subscriber retries until the publisher starts serving.
+	time.Sleep(100 * time.Millisecond)
+	publisher := newPeerPubsub(t, "publisher", publisherAddr, []string{publisherAddr})
+	published := make(chan string)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go publisher.PublishSampledTraceIDs(ctx, published)
+
+	published <- "trace_id"
+	assert.Equal(t, "trace_id", expectValue(t, traceIDs))
+}
+
+func TestPeerPubsubListenRetry(t *testing.T) {
+	// Occupy the publisher's address, as if by a previous processor which has not yet stopped.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	publisherAddr := lis.Addr().String()
+	publisher := newPeerPubsub(t, "publisher", publisherAddr, []string{publisherAddr})
+	published := make(chan string)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go publisher.PublishSampledTraceIDs(ctx, published)
+	published <- "trace_id"
+
+	subscriber := newPeerPubsub(t, "subscriber", freeAddr(t), []string{publisherAddr})
+	traceIDs, _, cancelSubscriber := newPeerSubscriber(t, subscriber, pubsub.SubscriberPosition{})
+	defer cancelSubscriber()
+	require.NoError(t, lis.Close())
+	assert.Equal(t, "trace_id", expectValue(t, traceIDs))
+}
+
+func TestPeerPubsubSecretToken(t *testing.T) {
+	publisherAddr := freeAddr(t)
+	publisher, err := pubsub.NewPeer(pubsub.PeerConfig{
+		ServerID:          "publisher",
+		ListenAddress:     publisherAddr,
+		Peers:             []string{publisherAddr},
+		DiscoveryInterval: time.Minute,
+		BatchSize:         10,
+		LogSize:           10,
+		Transport:         transport.Config{SecretToken: "abc123"},
+		Logger:            logptest.NewTestingLogger(t, ""),
+	})
+	require.NoError(t, err)
+	published := make(chan string)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go publisher.PublishSampledTraceIDs(ctx, published)
+
+	newSubscriber := func(serverID, secretToken string) <-chan string {
+		subscriber, err := pubsub.NewPeer(pubsub.PeerConfig{
+			ServerID:          serverID,
+			ListenAddress:     freeAddr(t),
+			Peers:             []string{publisherAddr},
+			DiscoveryInterval: time.Minute,
+			BatchSize:         10,
+			LogSize:           10,
+			Transport:         transport.Config{SecretToken: secretToken},
+			Logger:            logptest.NewTestingLogger(t, ""),
+		})
+		require.NoError(t, err)
+		traceIDs, _, cancelSubscriber := newPeerSubscriber(t, subscriber, pubsub.SubscriberPosition{})
+		t.Cleanup(cancelSubscriber)
+		return traceIDs
+	}
+	authorized := newSubscriber("authorized", "abc123")
+	unauthorized := newSubscriber("unauthorized", "xyz")
+
+	// Only subscribers presenting the secret token receive trace IDs.
+	published <- "trace_id"
+	assert.Equal(t, "trace_id", expectValue(t, authorized))
+	expectNone(t, unauthorized)
+}
+
+func TestPeerPubsubPositionTTL(t *testing.T) {
+	publisherAddr := freeAddr(t)
+	subscriber, err := pubsub.NewPeer(pubsub.PeerConfig{
+		ServerID:          "subscriber",
+		ListenAddress:     freeAddr(t),
+		Peers:             []string{publisherAddr},
+		DiscoveryInterval: 10 * time.Millisecond,
+		BatchSize:         10,
+		LogSize:           10,
+		PositionTTL:       50 * time.Millisecond,
+		Logger:            logptest.NewTestingLogger(t, ""),
+	})
+	require.NoError(t, err)
+
+	// The position of a peer which is no longer discovered is
+	// removed once it has not been discovered for PositionTTL.
+	var pos pubsub.SubscriberPosition
+	require.NoError(t, json.Unmarshal([]byte(`{"`+publisherAddr+`":1,"10.0.0.1:8201":2}`), &pos))
+	_, positions, cancelSubscriber := newPeerSubscriber(t, subscriber, pos)
+	defer cancelSubscriber()
+	for {
+		var seqnos map[string]int64
+		data, err := json.Marshal(expectPosition(t, positions))
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &seqnos))
+		if _, ok := seqnos["10.0.0.1:8201"]; !ok {
+			assert.Equal(t, map[string]int64{publisherAddr: 1}, seqnos)
+			break
+		}
+	}
+}
+
+func newPeerPubsub(t testing.TB, serverID, addr string, peers []string) *pubsub.PeerPubsub {
+	p, err := pubsub.NewPeer(pubsub.PeerConfig{
+		ServerID:          serverID,
+		ListenAddress:     addr,
+		Peers:             peers,
+		DiscoveryInterval: time.Minute,
+		BatchSize:         10,
+		LogSize:           100,
+		Logger:            logptest.NewTestingLogger(t, ""),
+	})
+	require.NoError(t, err)
+	return p
+}
+
+func newPeerSubscriber(
+	t testing.TB, p *pubsub.PeerPubsub, pos pubsub.SubscriberPosition,
+) (<-chan string, <-chan pubsub.SubscriberPosition, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	traceIDs := make(chan string)
+	positions := make(chan pubsub.SubscriberPosition)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.SubscribeSampledTraceIDs(ctx, pos, traceIDs, positions)
+	}()
+	return traceIDs, positions, func() {
+		cancel()
+		<-done
+	}
+}
+
+func expectPosition(t testing.TB, ch <-chan pubsub.SubscriberPosition) pubsub.SubscriberPosition {
+	t.Helper()
+	select {
+	case pos := <-ch:
+		return pos
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for position")
+	}
+	panic("unreachable")
+}
+
+// freeAddr returns a loopback address with a port that is not in use.
+func freeAddr(t testing.TB) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+	return lis.Addr().String()
+}
@@ -30,3 +30,21 @@ func TestResetReservoir(t *testing.T) {
 	res.Reset()
 	assert.Len(t, res.Values(), 0)
 }
+
+func TestSampleFullReservoir(t *testing.T) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	res := newWeightedRandomSample(rng, 2)
+	sampled, evicted := res.Sample(1e-9, "a")
+	assert.True(t, sampled)
+	assert.Empty(t, evicted)
+	sampled, evicted = res.Sample(1e9, "b")
+	assert.True(t, sampled)
+	assert.Empty(t, evicted)
+
+	// The reservoir is full: admitting "c" evicts "a",
+	// which has the lowest weight.
+	sampled, evicted = res.Sample(1e9, "c")
+	assert.True(t, sampled)
+	assert.Equal(t, "a", evicted)
+	assert.ElementsMatch(t, []string{"b", "c"}, res.Values())
+}
@@ -0,0 +1,164 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+// Package transport provides transport security and authentication for the
+// gRPC services which APM Servers expose to each other for tail-sampling.
+package transport
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"net"
+	"net/netip"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/elastic/apm-server/internal/netutil"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+)
+
+const (
+	authorizationHeader = "authorization"
+	bearerPrefix        = "Bearer "
+)
+
+var (
+	errUnauthenticated = status.Error(codes.Unauthenticated, "missing or invalid secret token")
+	errIPDenied        = status.Error(codes.PermissionDenied, "client IP denied")
+)
+
+// Config holds transport security configuration for gRPC services
+// exposed to, and consumed from, other APM Servers.
+//
+// The zero value serves and connects without TLS or authentication,
+// which is only appropriate on a private network.
+type Config struct {
+	// ServerTLS, if non-nil, holds the TLS configuration for serving other
+	// servers. Mutual TLS is enabled by requiring and verifying client
+	// certificates.
+	ServerTLS *tls.Config
+
+	// ClientTLS, if non-nil, holds the TLS configuration for connecting to
+	// other servers, including the client certificate for mutual TLS.
+	ClientTLS *tlscommon.TLSConfig
+
+	// SecretToken, if non-empty, holds a secret shared by the servers. It is
+	// sent with each request, and requests without it are rejected. TLS should
+	// also be configured, so the secret token is not sent in plaintext.
+	SecretToken string
+
+	// IPFilter, if non-nil, holds rules for the IP addresses of other
+	// servers which are allowed to connect.
+	IPFilter *netutil.IPFilter
+}
+
+// Secure reports whether connections are encrypted or authenticated.
+func (c Config) Secure() bool {
+	return c.ServerTLS != nil || c.SecretToken != ""
+}
+
+// ServerOptions returns the grpc.ServerOptions for serving other servers.
+// Requests which are rejected by IPFilter, or which do not hold SecretToken,
+// are logged with logger.
+func (c Config) ServerOptions(logger *logp.Logger) []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if c.ServerTLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(c.ServerTLS)))
+	}
+	if c.SecretToken != "" || c.IPFilter != nil {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(func(
+				ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+			) (any, error) {
+				if err := c.authorize(ctx, info.FullMethod, logger); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.ChainStreamInterceptor(func(
+				srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler,
+			) error {
+				if err := c.authorize(stream.Context(), info.FullMethod, logger); err != nil {
+					return err
+				}
+				return handler(srv, stream)
+			}),
+		)
+	}
+	return opts
+}
+
+// DialOptions returns the grpc.DialOptions for connecting to the
+// server at addr, whose host is verified against its certificate.
+func (c Config) DialOptions(addr string) []grpc.DialOption {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if c.ClientTLS != nil {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		opts[0] = grpc.WithTransportCredentials(credentials.NewTLS(c.ClientTLS.BuildModuleClientConfig(host)))
+	}
+	if c.SecretToken != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(secretToken(c.SecretToken)))
+	}
+	return opts
+}
+
+// authorize checks that the client's IP address is allowed by IPFilter,
+// and that the request's metadata holds the secret token.
+func (c Config) authorize(ctx context.Context, method string, logger *logp.Logger) error {
+	var clientAddr netip.AddrPort
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		clientAddr, _ = netip.ParseAddrPort(p.Addr.String())
+	}
+	err := c.authorizeClient(ctx, clientAddr.Addr().Unmap())
+	if err != nil {
+		logger.With(
+			logp.String("grpc.request.method", method),
+			logp.String("client.address", clientAddr.String()),
+			logp.Error(err),
+		).Warn("rejected request from server")
+	}
+	return err
+}
+
+func (c Config) authorizeClient(ctx context.Context, clientIP netip.Addr) error {
+	if !c.IPFilter.Allowed(clientIP) {
+		return errIPDenied
+	}
+	if c.SecretToken == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	want := []byte(bearerPrefix + c.SecretToken)
+	for _, value := range md.Get(authorizationHeader) {
+		if subtle.ConstantTimeCompare([]byte(value), want) == 1 {
+			return nil
+		}
+	}
+	return errUnauthenticated
+}
+
+// secretToken is a credentials.PerRPCCredentials which sends
+// a secret token with each request.
+type secretToken string
+
+func (t secretToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{authorizationHeader: bearerPrefix + string(t)}, nil
+}
+
+// RequireTransportSecurity returns false, so that a secret token
+// may be used without TLS, e.g. on a private network.
+func (secretToken) RequireTransportSecurity() bool {
+	return false
+}
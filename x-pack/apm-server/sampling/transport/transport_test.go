@@ -0,0 +1,152 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package transport_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/elastic/apm-server/internal/netutil"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/transport"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+)
+
+func TestSecretToken(t *testing.T) {
+	addr := serve(t, transport.Config{SecretToken: "abc123"})
+
+	for name, test := range map[string]struct {
+		token string
+		code  codes.Code
+	}{
+		"valid":   {token: "abc123", code: codes.OK},
+		"invalid": {token: "xyz", code: codes.Unauthenticated},
+		"missing": {token: "", code: codes.Unauthenticated},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := dial(t, addr, transport.Config{SecretToken: test.token})
+			_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+			assert.Equal(t, test.code, status.Code(err))
+
+			// Streams are also authenticated.
+			stream, err := client.Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+			require.NoError(t, err)
+			_, err = stream.Recv()
+			assert.Equal(t, test.code, status.Code(err))
+		})
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	for name, test := range map[string]struct {
+		allow []string
+		deny  []string
+		code  codes.Code
+	}{
+		"allowed":     {allow: []string{"127.0.0.0/8"}, code: codes.OK},
+		"not_allowed": {allow: []string{"10.0.0.0/8"}, code: codes.PermissionDenied},
+		"denied":      {deny: []string{"127.0.0.1"}, code: codes.PermissionDenied},
+	} {
+		t.Run(name, func(t *testing.T) {
+			filter, err := netutil.NewIPFilter(test.allow, test.deny)
+			require.NoError(t, err)
+			addr := serve(t, transport.Config{IPFilter: filter})
+			client := dial(t, addr, transport.Config{})
+			_, err = client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+			assert.Equal(t, test.code, status.Code(err))
+		})
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	serverCert, serverPool := newCertificate(t)
+	clientCert, clientPool := newCertificate(t)
+	addr := serve(t, transport.Config{ServerTLS: &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientPool,
+	}})
+
+	client := dial(t, addr, transport.Config{ClientTLS: &tlscommon.TLSConfig{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      serverPool,
+		Verification: tlscommon.VerifyFull,
+	}})
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.NoError(t, err)
+
+	// Clients without a trusted certificate are rejected.
+	client = dial(t, addr, transport.Config{ClientTLS: &tlscommon.TLSConfig{
+		RootCAs:      serverPool,
+		Verification: tlscommon.VerifyFull,
+	}})
+	_, err = client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// Plaintext clients are rejected.
+	client = dial(t, addr, transport.Config{})
+	_, err = client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func serve(t testing.TB, config transport.Config) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(config.ServerOptions(logptest.NewTestingLogger(t, ""))...)
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+func dial(t testing.TB, addr string, config transport.Config) grpc_health_v1.HealthClient {
+	conn, err := grpc.NewClient(addr, config.DialOptions(addr)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return grpc_health_v1.NewHealthClient(conn)
+}
+
+// newCertificate returns a new self-signed certificate for localhost,
+// and a certificate pool which trusts it.
+func newCertificate(t testing.TB) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}